@@ -2,15 +2,23 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"go-tg.com/internal/app"
+	"go-tg.com/internal/service"
 	"os"
 	"os/signal"
+	"syscall"
 )
 
 func main() {
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
-	if err := app.Run(ctx); err != nil {
-		panic(err)
+	// The first signal starts a graceful shutdown, a second one kills the process.
+	context.AfterFunc(ctx, cancel)
+	// Started by the Windows service control manager it runs as a service.
+	if err := service.Run(ctx, app.Run); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		cancel()
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,298 @@
+package app
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/updates"
+	updhook "github.com/gotd/td/telegram/updates/hook"
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	tgService "go-tg.com/internal/services/telegram"
+	"go-tg.com/internal/tracing"
+	"go.uber.org/zap"
+)
+
+// account is one Telegram client with its own session, updates state and
+// watcher. All accounts deliver through the same outputs.
+type account struct {
+	name  string
+	cfg   *config.Store
+	log   *zap.Logger
+	state *tgService.FileStateStorage
+	// handler dispatches the updates, behind the update log when it is enabled
+	// and the panic recovery.
+	handler telegram.UpdateHandler
+	logged  *loggedUpdates
+	invoker *clientInvoker
+	w       *watcher
+	health  *health
+	alerts  *alerter
+}
+
+// newAccounts sets up the configured accounts, or a single one from the
+// top-level config when there is no accounts list.
+func newAccounts(cfg *config.Store, log *zap.Logger, out *outputs) ([]*account, error) {
+	names := cfg.Load().AccountNames()
+	if len(names) == 0 {
+		// A tenant without accounts is one.
+		a, err := newAccount(cfg.Load().Tenant, cfg, log, out)
+		if err != nil {
+			return nil, err
+		}
+		return []*account{a}, nil
+	}
+
+	accounts := make([]*account, 0, len(names))
+	for _, name := range names {
+		accountCfg, _ := cfg.Account(name)
+		a, err := newAccount(name, accountCfg, log.With(zap.String("account", name)), out)
+		if err != nil {
+			return nil, errors.Wrapf(err, "account %s", name)
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+func newAccount(name string, cfg *config.Store, log *zap.Logger, out *outputs) (*account, error) {
+	if err := cfg.LoadChannels(); err != nil {
+		return nil, err
+	}
+	initialCfg := cfg.Load()
+
+	stateStorage, err := tgService.NewFileStateStorage(initialCfg.TgApp.StatePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open updates state")
+	}
+	stateStorage.OnChange = func(_ int64, state updates.State) {
+		metrics.GapsState.WithLabelValues(name, "pts").Set(float64(state.Pts))
+		metrics.GapsState.WithLabelValues(name, "qts").Set(float64(state.Qts))
+		metrics.GapsState.WithLabelValues(name, "seq").Set(float64(state.Seq))
+		metrics.GapsState.WithLabelValues(name, "date").Set(float64(state.Date))
+	}
+
+	d := tg.NewUpdateDispatcher()
+	invoker := &clientInvoker{}
+	w, err := newWatcher(cfg, log, tg.NewClient(invoker), out)
+	if err != nil {
+		return nil, err
+	}
+	w.register(d)
+	out.telegram.Register(name, w)
+
+	a := &account{
+		name:    name,
+		cfg:     cfg,
+		log:     log,
+		state:   stateStorage,
+		handler: &d,
+		invoker: invoker,
+		w:       w,
+		health:  &health{},
+	}
+	if dir := initialCfg.TgApp.UpdateLogDir; dir != "" {
+		updateLog, err := tgService.NewUpdateLog(dir)
+		if err != nil {
+			return nil, errors.Wrap(err, "open update log")
+		}
+		a.logged = &loggedUpdates{updates: updateLog, next: &d, log: log.Named("update-log")}
+		a.handler = a.logged
+	}
+	a.handler = recoveredUpdates{next: a.handler, alerts: out.alerts, tenant: initialCfg.Tenant, account: name, log: log}
+	a.alerts = out.alerts
+	return a, nil
+}
+
+// run connects a new client and handles updates until ctx is done or the
+// client stops. With backfill the history of the watched channels is sent
+// first. Buffered events are delivered once ctx is done.
+func (a *account) run(ctx context.Context, backfill historyRange, withBackfill bool) error {
+	initialCfg := a.cfg.Load()
+	h, w := a.health, a.w
+
+	gaps := updates.New(updates.Config{
+		Handler:      a.handler,
+		Logger:       a.log.Named("gaps"),
+		Storage:      a.state,
+		AccessHasher: a.state,
+	})
+	client, waiter, err := newClient(ctx, initialCfg, a.log, gaps,
+		updhook.UpdateHook(gaps.Handle),
+		a.alerts.floodWait(initialCfg.Tenant, a.name),
+	)
+	if err != nil {
+		return err
+	}
+	a.invoker.current.Store(client)
+
+	// The client outlives ctx until buffered events are delivered, albums may
+	// still need it to download media. It stops right away when ctx ends
+	// before the client is connected.
+	clientCtx, stopClient := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopClient()
+	var connected atomic.Bool
+	defer context.AfterFunc(ctx, func() {
+		if !connected.Load() {
+			stopClient()
+		}
+	})()
+
+	return waiter.Run(clientCtx, func(clientCtx context.Context) error {
+		return client.Run(clientCtx, func(clientCtx context.Context) error {
+			connected.Store(true)
+			if err := authorize(ctx, client, initialCfg.TgApp.Auth); err != nil {
+				return errors.Wrap(err, "auth")
+			}
+			h.authorized.Store(true)
+			h.sessionInvalid.Store(false)
+
+			// Stops the pings, discovery and the history fetch when this client does.
+			runCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			// Stops the client when the monitor finds the session revoked.
+			sessionCtx, invalidate := context.WithCancelCause(ctx)
+			defer invalidate(nil)
+			go h.monitor(runCtx, a.log.Named("health"), client, invalidate)
+
+			user, err := client.Self(ctx)
+			if err != nil {
+				return errors.Wrap(err, "call self")
+			}
+			w.self.Store(user.ID)
+
+			w.joinChannels(ctx)
+			if a.logged != nil {
+				if err := a.logged.replay(ctx); err != nil && ctx.Err() == nil {
+					a.log.Error("Replay update log", zap.Error(err))
+				}
+			}
+			go w.discoverChannels(runCtx)
+			go w.pollStats(runCtx)
+			go w.snapshotChannels(runCtx)
+
+			if withBackfill {
+				go func() {
+					err := w.fetchAndProcessMessages(runCtx, backfill)
+					if err != nil {
+						a.log.Error("fetch and process messages", zap.Error(err))
+					}
+				}()
+			}
+
+			err = gaps.Run(sessionCtx, client.API(), user.ID, updates.AuthOptions{
+				OnStart: func(ctx context.Context) {
+					h.gapsRunning.Store(true)
+					a.log.Info("Gaps started")
+				},
+			})
+			h.gapsRunning.Store(false)
+			h.authorized.Store(false)
+
+			if ctx.Err() != nil {
+				w.shutdown(clientCtx, initialCfg.Delivery.ShutdownTimeout)
+				return nil
+			}
+			if cause := context.Cause(sessionCtx); sessionInvalid(cause) {
+				return errors.Wrap(cause, "session")
+			}
+			return err
+		})
+	})
+}
+
+// clientInvoker forwards API calls to the current client, so the watcher
+// keeps working across client restarts.
+type clientInvoker struct {
+	current atomic.Pointer[telegram.Client]
+}
+
+func (i *clientInvoker) Invoke(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+	client := i.current.Load()
+	if client == nil {
+		return errors.New("client is not started")
+	}
+	return client.Invoke(ctx, input, output)
+}
+
+// register routes the updates of the account to the watcher.
+func (w *watcher) register(d tg.UpdateDispatcher) {
+	channels := w.channels
+
+	d.OnEditChannelMessage(traced("updateEditChannelMessage", func(ctx context.Context, e tg.Entities, update *tg.UpdateEditChannelMessage) error {
+		channels.Put(entityChannels(e)...)
+		w.rememberPeers(e)
+		return w.handleChannelMessage(ctx, update.GetMessage(), "editMessage")
+	}))
+	d.OnNewChannelMessage(traced("updateNewChannelMessage", func(ctx context.Context, e tg.Entities, update *tg.UpdateNewChannelMessage) error {
+		channels.Put(entityChannels(e)...)
+		w.rememberPeers(e)
+		return w.handleChannelMessage(ctx, update.GetMessage(), "newMessage")
+	}))
+	d.OnDeleteChannelMessages(traced("updateDeleteChannelMessages", func(ctx context.Context, e tg.Entities, update *tg.UpdateDeleteChannelMessages) error {
+		channels.Put(entityChannels(e)...)
+		return w.handleDeleteChannelMessages(ctx, update)
+	}))
+
+	// Private dialogs and basic groups.
+	d.OnNewMessage(traced("updateNewMessage", func(ctx context.Context, e tg.Entities, update *tg.UpdateNewMessage) error {
+		w.rememberPeers(e)
+		return w.handleMessage(ctx, e, update.GetMessage(), "newMessage")
+	}))
+	d.OnEditMessage(traced("updateEditMessage", func(ctx context.Context, e tg.Entities, update *tg.UpdateEditMessage) error {
+		w.rememberPeers(e)
+		return w.handleMessage(ctx, e, update.GetMessage(), "editMessage")
+	}))
+
+	d.OnPinnedChannelMessages(traced("updatePinnedChannelMessages", func(ctx context.Context, e tg.Entities, update *tg.UpdatePinnedChannelMessages) error {
+		channels.Put(entityChannels(e)...)
+		return w.handlePinnedMessages(ctx, e, &tg.PeerChannel{ChannelID: update.ChannelID}, update.Messages, update.Pinned)
+	}))
+	d.OnPinnedMessages(traced("updatePinnedMessages", func(ctx context.Context, e tg.Entities, update *tg.UpdatePinnedMessages) error {
+		return w.handlePinnedMessages(ctx, e, update.Peer, update.Messages, update.Pinned)
+	}))
+
+	// Posts scheduled in channels the account administers.
+	d.OnNewScheduledMessage(traced("updateNewScheduledMessage", func(ctx context.Context, e tg.Entities, update *tg.UpdateNewScheduledMessage) error {
+		channels.Put(entityChannels(e)...)
+		return w.handleScheduledMessage(ctx, e, update.Message)
+	}))
+	d.OnDeleteScheduledMessages(traced("updateDeleteScheduledMessages", func(ctx context.Context, e tg.Entities, update *tg.UpdateDeleteScheduledMessages) error {
+		channels.Put(entityChannels(e)...)
+		return w.handleDeleteScheduledMessages(ctx, e, update.Peer, update.Messages)
+	}))
+
+	d.OnMessagePoll(traced("updateMessagePoll", func(ctx context.Context, e tg.Entities, update *tg.UpdateMessagePoll) error {
+		return w.handlePollUpdate(ctx, update)
+	}))
+	d.OnMessageReactions(traced("updateMessageReactions", func(ctx context.Context, e tg.Entities, update *tg.UpdateMessageReactions) error {
+		channels.Put(entityChannels(e)...)
+		return w.handleReactionCounts(ctx, e, update.Peer, update.MsgID, event.ReactionCounts(update.Reactions.Results))
+	}))
+	// Bots get these instead of updateMessageReactions.
+	d.OnBotMessageReactions(traced("updateBotMessageReactions", func(ctx context.Context, e tg.Entities, update *tg.UpdateBotMessageReactions) error {
+		channels.Put(entityChannels(e)...)
+		return w.handleReactionCounts(ctx, e, update.Peer, update.MsgID, event.ReactionCounts(update.Reactions))
+	}))
+	d.OnBotMessageReaction(traced("updateBotMessageReaction", func(ctx context.Context, e tg.Entities, update *tg.UpdateBotMessageReaction) error {
+		channels.Put(entityChannels(e)...)
+		return w.handleBotReaction(ctx, e, update)
+	}))
+}
+
+// traced runs an update handler in a span named after the update, with the
+// spans of resolution, filtering, media download and delivery below it. A
+// panic of the handler becomes its error, see recoveredUpdates.
+func traced[U any](name string, h func(context.Context, tg.Entities, U) error) func(context.Context, tg.Entities, U) error {
+	return func(ctx context.Context, e tg.Entities, update U) (err error) {
+		ctx, span := tracing.Start(ctx, name)
+		defer func() { tracing.End(span, err) }()
+		defer recoverUpdate(name, update, &err)
+		return h(ctx, e, update)
+	}
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/delivery"
+	"go.uber.org/zap"
+)
+
+// maxAckBody bounds the request body of POST /ack.
+const maxAckBody = 1 << 20
+
+// ackAPI lets consumers acknowledge the events delivered by webhook sinks
+// of all tenants, see webhook.ack.
+type ackAPI struct {
+	cfg     *config.Store
+	fanouts []*delivery.Fanout
+	log     *zap.Logger
+}
+
+// handleAck serves POST /ack with {"keys": [...]}, the idempotency keys of
+// the events, and answers with the number of events acknowledged. Keys of
+// events acknowledged already or unknown are skipped.
+func (api ackAPI) handleAck(rw http.ResponseWriter, r *http.Request) {
+	cfg := api.cfg.Load()
+	want := cfg.Webhook.Ack.Token
+	if want == "" {
+		want = cfg.HTTP.AdminToken
+	}
+	if !hasToken(r, want) {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Keys []string `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAckBody)).Decode(&req); err != nil {
+		http.Error(rw, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	acked := 0
+	for _, key := range req.Keys {
+		for _, fanout := range api.fanouts {
+			n, err := fanout.Ack(key)
+			acked += n
+			if err != nil {
+				api.log.Error("Acknowledge event", zap.String("key", key), zap.Error(err))
+				http.Error(rw, "acknowledging failed", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	writeStatus(rw, true, map[string]int{"acknowledged": acked})
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/sink"
+	"go.uber.org/zap"
+)
+
+// responseActor performs the actions webhook responses ask for with the
+// account of webhook.actions, the ones not allowed there are dropped.
+type responseActor struct {
+	cfg     *config.Store
+	clients *sink.TelegramClients
+	log     *zap.Logger
+}
+
+func (r responseActor) Act(ctx context.Context, e *event.Event, a sink.Action) {
+	actions := r.cfg.Load().Webhook.Actions
+	log := r.log.With(zap.String("action", a.Action), zap.String("type", e.Type), zap.Int64("chat_id", e.ChannelID), zap.String("message_id", e.ExternalID))
+	if !slices.Contains(actions.Allow, a.Action) {
+		metrics.WebhookActions.WithLabelValues(a.Action, "denied").Inc()
+		log.Warn("Webhook action is not allowed")
+		return
+	}
+	client, ok := r.clients.Get(actions.Account)
+	if !ok {
+		metrics.WebhookActions.WithLabelValues(a.Action, "failed").Inc()
+		log.Warn("Webhook action skipped, the account is not connected yet")
+		return
+	}
+	if err := client.Act(ctx, e, a); err != nil {
+		metrics.WebhookActions.WithLabelValues(a.Action, "failed").Inc()
+		log.Error("Webhook action failed", zap.Error(err))
+		return
+	}
+	metrics.WebhookActions.WithLabelValues(a.Action, "done").Inc()
+	log.Debug("Webhook action done")
+}
+
+// Act reacts to or replies to the message of a message event. Private
+// dialogs are not supported, users can't be addressed without their access
+// hash.
+func (w *watcher) Act(ctx context.Context, e *event.Event, a sink.Action) error {
+	switch e.Type {
+	case "newMessage", "oldMessage", "editMessage", "comment":
+	default:
+		return fmt.Errorf("%s events have no message to act on", e.Type)
+	}
+	msgID, err := strconv.Atoi(e.ExternalID)
+	if len(e.MessageIDs) > 0 {
+		msgID, err = e.MessageIDs[0], nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "message ID")
+	}
+	peer, err := w.inputPeer(ctx, event.Chat{Type: e.ChatType, ID: e.ChannelID})
+	if err != nil {
+		return err
+	}
+
+	switch a.Action {
+	case "react":
+		if a.Emoji == "" {
+			return errors.New("react needs an emoji")
+		}
+		_, err = w.api.MessagesSendReaction(ctx, &tg.MessagesSendReactionRequest{
+			Peer:     peer,
+			MsgID:    msgID,
+			Reaction: []tg.ReactionClass{&tg.ReactionEmoji{Emoticon: a.Emoji}},
+		})
+		return err
+	case "reply":
+		if a.Text == "" {
+			return errors.New("reply needs a text")
+		}
+		_, err = w.api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+			Peer:     peer,
+			Message:  a.Text,
+			RandomID: rand.Int64(),
+			ReplyTo:  &tg.InputReplyToMessage{ReplyToMsgID: msgID},
+		})
+		return err
+	default:
+		return fmt.Errorf("unknown action %q", a.Action)
+	}
+}
+
+// inputPeer addresses a channel or basic group.
+func (w *watcher) inputPeer(ctx context.Context, chat event.Chat) (tg.InputPeerClass, error) {
+	switch chat.Type {
+	case "", config.PeerChannel:
+		channel, err := w.channels.Get(ctx, chat.ID)
+		if err != nil {
+			return nil, err
+		}
+		return channel.AsInputPeer(), nil
+	case config.PeerChat:
+		return &tg.InputPeerChat{ChatID: chat.ID}, nil
+	default:
+		return nil, fmt.Errorf("actions in %s chats are not supported", chat.Type)
+	}
+}
@@ -0,0 +1,125 @@
+package app
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/sink"
+)
+
+// rateWindow is how many minutes the message rate of a chat covers.
+const rateWindow = 5
+
+// chatActivity is the traffic of one chat: the messages in total and by
+// minute over the rate window, and when the latest one came.
+type chatActivity struct {
+	lastUpdate time.Time
+	messages   int64
+	minutes    [rateWindow]int
+	minute     int64 // unix minute of the latest bucket
+}
+
+// advance moves the window to now, emptying the minutes passed since.
+func (c *chatActivity) advance(now time.Time) {
+	minute := now.Unix() / 60
+	for m := c.minute + 1; m <= minute && m <= c.minute+rateWindow; m++ {
+		c.minutes[m%rateWindow] = 0
+	}
+	c.minute = max(c.minute, minute)
+}
+
+// activity tracks the messages received from the chats of an account, so
+// stalled channels stand out in the metrics and /debug/state.
+type activity struct {
+	tenant string
+
+	mux   sync.Mutex
+	chats map[int64]*chatActivity
+}
+
+func newActivity(tenant string) *activity {
+	return &activity{tenant: tenant, chats: map[int64]*chatActivity{}}
+}
+
+// seen counts a message of the chat received at now.
+func (a *activity) seen(chatID int64, now time.Time) {
+	channel := strconv.FormatInt(chatID, 10)
+	metrics.ChannelMessages.WithLabelValues(a.tenant, channel).Inc()
+	metrics.ChannelLastMessage.WithLabelValues(a.tenant, channel).Set(float64(now.Unix()))
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	c, ok := a.chats[chatID]
+	if !ok {
+		c = &chatActivity{}
+		a.chats[chatID] = c
+	}
+	c.advance(now)
+	c.minutes[c.minute%rateWindow]++
+	c.messages++
+	c.lastUpdate = now
+}
+
+// chatTraffic is the activity of a chat at some time, Rate is in messages
+// per minute over the rate window.
+type chatTraffic struct {
+	LastUpdate time.Time
+	Messages   int64
+	Rate       float64
+}
+
+func (a *activity) snapshot(now time.Time) map[int64]chatTraffic {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	chats := make(map[int64]chatTraffic, len(a.chats))
+	for id, c := range a.chats {
+		c.advance(now)
+		sum := 0
+		for _, n := range c.minutes {
+			sum += n
+		}
+		chats[id] = chatTraffic{LastUpdate: c.lastUpdate, Messages: c.messages, Rate: float64(sum) / rateWindow}
+	}
+	return chats
+}
+
+// deliveryLag observes how long messages take from their Telegram date to
+// the sinks of a tenant. History is left out, it is old by design.
+type deliveryLag struct {
+	tenant string
+
+	mux   sync.Mutex
+	chats map[int64]chatLag
+}
+
+// chatLag is the latest delivery of a message of a chat.
+type chatLag struct {
+	latency   time.Duration
+	delivered time.Time
+}
+
+func newDeliveryLag(tenant string) *deliveryLag {
+	return &deliveryLag{tenant: tenant, chats: map[int64]chatLag{}}
+}
+
+func (l *deliveryLag) DeliveryStatus(_ context.Context, u sink.StatusUpdate) {
+	if u.Status != sink.StatusDelivered || u.Posted.IsZero() || u.Type == "oldMessage" {
+		return
+	}
+	latency := max(u.Time.Sub(u.Posted), 0)
+	metrics.DeliveryLatency.WithLabelValues(l.tenant, strconv.FormatInt(u.ChannelID, 10)).Observe(latency.Seconds())
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.chats[u.ChannelID] = chatLag{latency: latency, delivered: u.Time}
+}
+
+func (l *deliveryLag) get(chatID int64) (chatLag, bool) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	lag, ok := l.chats[chatID]
+	return lag, ok
+}
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"go-tg.com/internal/config"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// maxChannelBody bounds the request body of POST /channels.
+const maxChannelBody = 64 << 10
+
+// channelsAPI adds and removes watched channels at runtime, the changes are
+// kept in tg_app.channels_path of the account. With several accounts
+// requests name theirs in the account query parameter.
+type channelsAPI struct {
+	token    string
+	accounts []*account
+	log      *zap.Logger
+	// join resolves and joins the channels of an account added by username
+	// or invite link.
+	join func(a *account)
+}
+
+type channelView struct {
+	Account    string   `json:"account,omitempty"`
+	Peer       string   `json:"peer"`
+	ID         int64    `json:"id,omitempty"`
+	Username   string   `json:"username,omitempty"`
+	Invite     string   `json:"invite,omitempty"`
+	Types      []string `json:"types,omitempty"`
+	Topics     []int    `json:"topics,omitempty"`
+	WebhookURL string   `json:"webhook_url,omitempty"`
+	// Added is set for channels added at runtime.
+	Added bool `json:"added,omitempty"`
+}
+
+func viewChannel(account string, cfg *config.Config, ch config.ChannelConfig) channelView {
+	return channelView{
+		Account:    account,
+		Peer:       ch.PeerType(),
+		ID:         ch.ID,
+		Username:   ch.NormalizedUsername(),
+		Invite:     ch.Invite,
+		Types:      ch.Types,
+		Topics:     ch.Topics,
+		WebhookURL: ch.WebhookUrl,
+		Added: slices.ContainsFunc(cfg.TgApp.Runtime.Added, func(added config.ChannelConfig) bool {
+			return added.String() == ch.String()
+		}),
+	}
+}
+
+// authorized passes requests with the admin token as bearer token to next.
+func (api channelsAPI) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return withToken(api.token, next)
+}
+
+// withToken passes requests with token as bearer token to next.
+func withToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if !hasToken(r, token) {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(rw, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(rw, r)
+	}
+}
+
+// withTokenIfSet is withToken for endpoints that are open without a token.
+func withTokenIfSet(token string, next http.HandlerFunc) http.HandlerFunc {
+	if token == "" {
+		return next
+	}
+	return withToken(token, next)
+}
+
+func hasToken(r *http.Request, want string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// account finds the account of a request, the only one when there is a
+// single account.
+func (api channelsAPI) account(rw http.ResponseWriter, r *http.Request) (*account, bool) {
+	name := r.URL.Query().Get("account")
+	if name == "" && len(api.accounts) == 1 {
+		return api.accounts[0], true
+	}
+	for _, a := range api.accounts {
+		if a.name == name {
+			return a, true
+		}
+	}
+	if name == "" {
+		http.Error(rw, "account is required", http.StatusBadRequest)
+	} else {
+		http.Error(rw, "unknown account", http.StatusNotFound)
+	}
+	return nil, false
+}
+
+// handleList serves GET /channels, the watched channels of the account or of
+// all accounts without the account parameter.
+func (api channelsAPI) handleList(rw http.ResponseWriter, r *http.Request) {
+	accounts := api.accounts
+	if r.URL.Query().Has("account") {
+		a, ok := api.account(rw, r)
+		if !ok {
+			return
+		}
+		accounts = []*account{a}
+	}
+	channels := []channelView{}
+	for _, a := range accounts {
+		cfg := a.cfg.Load()
+		for _, ch := range cfg.WatchedChannels() {
+			channels = append(channels, viewChannel(a.name, cfg, ch))
+		}
+	}
+	writeStatus(rw, true, map[string]any{"channels": channels})
+}
+
+// handleAdd serves POST /channels with a channel as in tg_app.channels in
+// JSON or YAML, 409 when it is watched already.
+func (api channelsAPI) handleAdd(rw http.ResponseWriter, r *http.Request) {
+	a, ok := api.account(rw, r)
+	if !ok {
+		return
+	}
+	var ch config.ChannelConfig
+	dec := yaml.NewDecoder(io.LimitReader(r.Body, maxChannelBody))
+	dec.KnownFields(true)
+	if err := dec.Decode(&ch); err != nil {
+		http.Error(rw, "bad channel: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var invalid config.ValidationError
+	switch err := a.cfg.AddChannel(ch); {
+	case errors.As(err, &invalid):
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, config.ErrChannelWatched):
+		http.Error(rw, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		api.log.Error("Add channel", zap.String("account", a.name), zap.Stringer("channel", ch), zap.Error(err))
+		http.Error(rw, "saving the channel failed", http.StatusInternalServerError)
+		return
+	}
+	api.log.Info("Channel added", zap.String("account", a.name), zap.Stringer("channel", ch))
+	if ch.ID == 0 || ch.Join {
+		api.join(a)
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusCreated)
+	writeStatus(rw, true, viewChannel(a.name, a.cfg.Load(), ch))
+}
+
+// handleRemove serves DELETE /channels/{id}, the id may be a username too.
+func (api channelsAPI) handleRemove(rw http.ResponseWriter, r *http.Request) {
+	a, ok := api.account(rw, r)
+	if !ok {
+		return
+	}
+	key := r.PathValue("id")
+	switch err := a.cfg.RemoveChannel(key); {
+	case errors.Is(err, config.ErrChannelNotWatched):
+		http.Error(rw, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		api.log.Error("Remove channel", zap.String("account", a.name), zap.String("channel", key), zap.Error(err))
+		http.Error(rw, "saving the change failed", http.StatusInternalServerError)
+		return
+	}
+	api.log.Info("Channel removed", zap.String("account", a.name), zap.String("channel", key))
+	rw.WriteHeader(http.StatusNoContent)
+}
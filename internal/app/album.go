@@ -0,0 +1,178 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/delivery"
+	"go-tg.com/internal/event"
+	"go.uber.org/zap"
+)
+
+type albumKey struct {
+	chatID    int64
+	groupedID int64
+}
+
+// album collects the parts of one grouped message.
+type album struct {
+	ctx         context.Context
+	watched     config.ChannelConfig
+	chat        event.Chat
+	messageType string
+	messages    []*tg.Message
+	acks        []func(ok bool) // of the updates of the parts
+	timer       *time.Timer
+}
+
+// albumBuffer holds album parts until the window after the first part
+// is over and hands the complete album to flush. On shutdown flushAll
+// sends whatever is still buffered.
+type albumBuffer struct {
+	flush func(ctx context.Context, a *album)
+
+	mux      sync.Mutex
+	pending  map[albumKey]*album
+	held     map[int64]int // checkpoints waiting for buffered albums by chat
+	flushing sync.WaitGroup
+}
+
+func newAlbumBuffer(flush func(ctx context.Context, a *album)) *albumBuffer {
+	return &albumBuffer{
+		flush:   flush,
+		pending: map[albumKey]*album{},
+		held:    map[int64]int{},
+	}
+}
+
+// checkpoint returns the message ID the checkpoint of a chat can advance
+// to. While an album with an earlier part is buffered it holds messageID
+// back until the album is sent and reports false.
+func (b *albumBuffer) checkpoint(chatID int64, messageID int) (int, bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	for key, a := range b.pending {
+		if key.chatID == chatID && a.messages[0].GetID() <= messageID {
+			b.held[chatID] = max(b.held[chatID], messageID)
+			return 0, false
+		}
+	}
+	messageID = max(messageID, b.held[chatID])
+	delete(b.held, chatID)
+	return messageID, true
+}
+
+func (b *albumBuffer) add(ctx context.Context, window time.Duration, watched config.ChannelConfig, chat event.Chat, msg *tg.Message, messageType string) {
+	groupedID, _ := msg.GetGroupedID()
+	key := albumKey{chatID: chat.ID, groupedID: groupedID}
+
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	if a, ok := b.pending[key]; ok {
+		a.messages = append(a.messages, msg)
+		a.acks = append(a.acks, delivery.DeferAck(ctx))
+		return
+	}
+	a := &album{
+		// The handler context ends with the update, the album is sent later.
+		ctx:         context.WithoutCancel(ctx),
+		watched:     watched,
+		chat:        chat,
+		messageType: messageType,
+		messages:    []*tg.Message{msg},
+		acks:        []func(ok bool){delivery.DeferAck(ctx)},
+	}
+	b.pending[key] = a
+	b.flushing.Add(1)
+	a.timer = time.AfterFunc(window, func() {
+		defer b.flushing.Done()
+		b.mux.Lock()
+		delete(b.pending, key)
+		b.mux.Unlock()
+
+		b.flush(a.ctx, a)
+	})
+}
+
+// len returns the number of albums waiting for their parts.
+func (b *albumBuffer) len() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return len(b.pending)
+}
+
+// flushAll sends every buffered album right away and waits for flushes
+// that are already running.
+func (b *albumBuffer) flushAll(ctx context.Context) {
+	var albums []*album
+	b.mux.Lock()
+	for key, a := range b.pending {
+		if a.timer.Stop() {
+			delete(b.pending, key)
+			albums = append(albums, a)
+		}
+	}
+	b.mux.Unlock()
+
+	for _, a := range albums {
+		b.flush(ctx, a)
+		b.flushing.Done()
+	}
+	b.flushing.Wait()
+}
+
+// bufferAlbum reports whether msg is an album part and was taken by the buffer.
+// Only new messages are aggregated, edits of single parts are sent as they are.
+func (w *watcher) bufferAlbum(ctx context.Context, cfg *config.Config, watched config.ChannelConfig, chat event.Chat, msg *tg.Message, messageType string) bool {
+	if _, ok := msg.GetGroupedID(); !ok || messageType != "newMessage" || cfg.Payload.AlbumWindow <= 0 {
+		return false
+	}
+	w.albums.add(ctx, cfg.Payload.AlbumWindow, watched, chat, msg, messageType)
+	return true
+}
+
+// flushAlbum sends the album and then acknowledges the updates of its parts
+// and advances the checkpoint of a channel past them.
+func (w *watcher) flushAlbum(ctx context.Context, a *album) {
+	err := w.sendBufferedAlbum(ctx, a)
+	for _, ack := range a.acks {
+		ack(err == nil)
+	}
+	if err == nil && a.chat.Type == config.PeerChannel {
+		last := 0
+		for _, msg := range a.messages {
+			last = max(last, msg.GetID())
+		}
+		w.advanceCheckpoint(a.chat.ID, last)
+	}
+}
+
+func (w *watcher) sendBufferedAlbum(ctx context.Context, a *album) error {
+	cfg := w.cfg.Load()
+
+	var captions []string
+	for _, msg := range a.messages {
+		if text := msg.GetMessage(); text != "" {
+			captions = append(captions, text)
+		}
+	}
+	if !w.passesFilter(ctx, a.watched, a.chat, strings.Join(captions, "\n\n"), a.messages[0]) {
+		w.log.Debug("Album filtered out", zap.Int64("chat_id", a.chat.ID), zap.Int("parts", len(a.messages)))
+		return nil
+	}
+
+	err := w.sendAlbum(ctx, cfg, cfg.WebhookUrlFor(a.watched), a.chat, a.messages, a.messageType)
+	if err != nil {
+		w.log.Error("Error sending album", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+	} else {
+		w.trackStats(cfg, a.watched, a.chat, a.messages[0], strings.Join(captions, "\n\n"), a.messageType)
+	}
+	w.log.Info("Album", zap.Int64("chat_id", a.chat.ID), zap.Int("parts", len(a.messages)))
+	return err
+}
@@ -0,0 +1,185 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/sink"
+	"go.uber.org/zap"
+)
+
+// Types of ops alerts.
+const (
+	alertDeliveryFailing = "deliveryFailing"
+	alertFloodWait       = "floodWait"
+	alertHandlerPanic    = "handlerPanic"
+)
+
+// opsAlert is the webhook body of an ops alert. Details holds the context
+// of its type: the failures in a row, the wait or the stack.
+type opsAlert struct {
+	Type    string         `json:"type"`
+	Tenant  string         `json:"tenant,omitempty"`
+	Account string         `json:"account,omitempty"`
+	Sink    string         `json:"sink,omitempty"`
+	Error   string         `json:"error"`
+	Details map[string]any `json:"details,omitempty"`
+	Time    time.Time      `json:"time"`
+}
+
+// subject tells apart the alerts the cooldown applies to.
+func (a opsAlert) subject() string {
+	return strings.Join([]string{a.Type, a.Tenant, a.Account, a.Sink}, "/")
+}
+
+// Text is the alert as a chat message, without the stack.
+func (a opsAlert) Text() string {
+	var where []string
+	if a.Tenant != "" {
+		where = append(where, "tenant "+a.Tenant)
+	}
+	if a.Account != "" {
+		where = append(where, "account "+a.Account)
+	}
+	if a.Sink != "" {
+		where = append(where, "sink "+a.Sink)
+	}
+	var text string
+	switch a.Type {
+	case alertDeliveryFailing:
+		text = fmt.Sprintf("Delivery failed %v times in a row", a.Details["failures"])
+	case alertFloodWait:
+		text = fmt.Sprintf("Telegram asked to wait %v (FLOOD_WAIT)", a.Details["wait"])
+	case alertHandlerPanic:
+		text = "Update handler panicked"
+	default:
+		text = a.Type
+	}
+	if len(where) > 0 {
+		text += " (" + strings.Join(where, ", ") + ")"
+	}
+	return text + ": " + a.Error
+}
+
+// alerter sends ops alerts to the alerts target, the same alert at most once
+// per cooldown. The hooks run on the delivery and update paths, so alerts
+// are sent in the background.
+type alerter struct {
+	cfg *config.Store
+	log *zap.Logger
+
+	mux  sync.Mutex
+	last map[string]time.Time
+}
+
+func newAlerter(cfg *config.Store, log *zap.Logger) *alerter {
+	return &alerter{cfg: cfg, log: log, last: make(map[string]time.Time)}
+}
+
+// send logs the alert and sends it unless the same one went out within the
+// cooldown.
+func (al *alerter) send(a opsAlert) {
+	c := al.cfg.Load()
+	now := time.Now()
+	al.mux.Lock()
+	if last, ok := al.last[a.subject()]; ok && now.Sub(last) < c.Alerts.Cooldown {
+		al.mux.Unlock()
+		metrics.Alerts.WithLabelValues(a.Type, "suppressed").Inc()
+		return
+	}
+	al.last[a.subject()] = now
+	al.mux.Unlock()
+
+	a.Time = now.UTC()
+	al.log.Warn("Ops alert",
+		zap.String("type", a.Type),
+		zap.String("tenant", a.Tenant),
+		zap.String("account", a.Account),
+		zap.String("sink", a.Sink),
+		zap.String("error", a.Error),
+	)
+	metrics.Alerts.WithLabelValues(a.Type, "sent").Inc()
+
+	dest := c.AlertDestination()
+	if dest == (config.AlertConfig{}) {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		var errs []error
+		if dest.WebhookURL != "" {
+			errs = append(errs, alertWebhook(ctx, al.cfg, dest.WebhookURL, a))
+		}
+		if dest.BotToken != "" {
+			errs = append(errs, alertBot(ctx, dest, a.Text()))
+		}
+		if err := errors.Join(errs...); err != nil {
+			al.log.Error("Send ops alert", zap.String("type", a.Type), zap.Error(err))
+		}
+	}()
+}
+
+// failingSink alerts when the deliveries to a sink fail DeliveryFailures
+// attempts in a row.
+type failingSink struct {
+	alerts   *alerter
+	tenant   string
+	sink     string
+	failures atomic.Int64
+}
+
+func (s *failingSink) DeliveryStatus(_ context.Context, u sink.StatusUpdate) {
+	switch u.Status {
+	case sink.StatusDelivered, sink.StatusAcknowledged:
+		s.failures.Store(0)
+	case sink.StatusFailed:
+		n := s.failures.Add(1)
+		if limit := s.alerts.cfg.Load().Alerts.DeliveryFailures; limit > 0 && n == int64(limit) {
+			s.alerts.send(opsAlert{
+				Type:    alertDeliveryFailing,
+				Tenant:  s.tenant,
+				Sink:    s.sink,
+				Error:   u.Error,
+				Details: map[string]any{"failures": n, "event_type": u.Type, "delivery_id": u.DeliveryID},
+			})
+		}
+	}
+}
+
+// floodWait alerts about FLOOD_WAIT errors of at least the FloodWait of the
+// config, before the waiter retries the call.
+func (al *alerter) floodWait(tenant, account string) telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			err := next.Invoke(ctx, input, output)
+			if wait, ok := tgerr.AsFloodWait(err); ok {
+				if limit := al.cfg.Load().Alerts.FloodWait; limit > 0 && wait >= limit {
+					details := map[string]any{"wait": wait.String()}
+					if t, ok := input.(interface{ TypeName() string }); ok {
+						details["method"] = t.TypeName()
+					}
+					al.send(opsAlert{
+						Type:    alertFloodWait,
+						Tenant:  tenant,
+						Account: account,
+						Error:   err.Error(),
+						Details: details,
+					})
+				}
+			}
+			return err
+		}
+	})
+}
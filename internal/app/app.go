@@ -1,237 +1,713 @@
 package app
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/go-faster/errors"
-	"github.com/gotd/td/session"
-	"github.com/gotd/td/telegram"
-	"github.com/gotd/td/telegram/auth"
-	"github.com/gotd/td/telegram/updates"
-	updhook "github.com/gotd/td/telegram/updates/hook"
 	"github.com/gotd/td/tg"
 	"go-tg.com/internal/config"
+	"go-tg.com/internal/delivery"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/filter"
+	"go-tg.com/internal/media"
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/processor"
+	"go-tg.com/internal/schedule"
+	"go-tg.com/internal/search"
 	tgService "go-tg.com/internal/services/telegram"
+	"go-tg.com/internal/sink"
+	"go-tg.com/internal/storage"
+	"go-tg.com/internal/stream"
+	"go-tg.com/internal/tracing"
+	"go-tg.com/internal/transcribe"
+	"go-tg.com/internal/translate"
+	"go-tg.com/internal/unfurl"
 	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
-	"net/http"
-	"strconv"
+	"golang.org/x/sync/errgroup"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
 )
 
-var allMessages = flag.Bool("all-messages", false, "Fetch and send all historical messages")
+// runWatcher watches the configured chats and forwards their messages.
+func runWatcher(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+	var backfill historyRange
+	allMessages := flags.Bool("all-messages", false, "Fetch and send all historical messages")
+	flags.IntVar(&backfill.From, "backfill-from", 0, "Re-send historical messages starting from this message ID (inclusive, newest bound), same as -max-id")
+	flags.IntVar(&backfill.To, "backfill-to", 0, "Re-send historical messages down to this message ID (inclusive, oldest bound), same as -min-id")
+	flags.BoolVar(&backfill.SinceLast, "since-last", false, "Send historical messages newer than the last processed one of each channel")
+	rangeFlags(flags, &backfill)
+	testWebhook := flags.Bool("test-webhook", false, "Send a single test payload to the webhook and exit")
+	dryRun := flags.Bool("dry-run", false, "Process messages as usual but log the payloads instead of sending them to the sinks")
+	configPath := configFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
 
-func Run(ctx context.Context) error {
-	flag.Parse()
-	cfg, err := config.Init()
-	if err != nil {
-		panic(err)
+	if err := backfill.validate(); err != nil {
+		return err
 	}
-	sessionStorage := &session.FileStorage{
-		Path: "./session.json",
+
+	validate := (*config.Config).Validate
+	if *testWebhook {
+		validate = nil
+	}
+	initialCfg, cfg, err := loadConfig(*configPath, validate)
+	if err != nil {
+		return err
 	}
 
-	log, _ := zap.NewDevelopment(zap.IncreaseLevel(zapcore.InfoLevel), zap.AddStacktrace(zapcore.FatalLevel))
+	log, err := newLogger(initialCfg.Log)
+	if err != nil {
+		return err
+	}
 	defer func() { _ = log.Sync() }()
 
-	d := tg.NewUpdateDispatcher()
-	gaps := updates.New(updates.Config{
-		Handler: d,
-		Logger:  log.Named("gaps"),
-	})
-
-	flow := auth.NewFlow(tgService.Terminal{}, auth.SendCodeOptions{})
-
-	client := telegram.NewClient(cfg.TgApp.AppId, cfg.TgApp.AppHash, telegram.Options{
-		SessionStorage: sessionStorage,
-		Logger:         log,
-		UpdateHandler:  gaps,
-		Middlewares: []telegram.Middleware{
-			updhook.UpdateHook(gaps.Handle),
-		},
-	})
+	var dash *dashboard
+	if initialCfg.HTTP.Listen != "" && initialCfg.HTTP.Dashboard.Enabled {
+		dash = newDashboard(initialCfg.HTTP.Dashboard)
+		log = log.WithOptions(zap.Hooks(dash.logged))
+	}
 
-	api := tg.NewClient(client)
+	if *testWebhook {
+		webhook, err := sink.NewWebhook(cfg, "")
+		if err != nil {
+			return errors.Wrap(err, "webhook")
+		}
+		return checkWebhook(ctx, log, webhook, initialCfg)
+	}
 
-	handleFuncEditMessage := func(ctx context.Context, e tg.Entities, update *tg.UpdateEditChannelMessage) error {
-		return handleEditChannelMessage(ctx, log, cfg, api, update)
+	flushTraces, err := tracing.Setup(ctx, initialCfg.Tracing, log.Named("tracing"))
+	if err != nil {
+		return errors.Wrap(err, "tracing")
 	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if err := flushTraces(flushCtx); err != nil {
+			log.Warn("Flush traces", zap.Error(err))
+		}
+	}()
 
-	handleFuncNewMessage := func(ctx context.Context, e tg.Entities, update *tg.UpdateNewChannelMessage) error {
-		return handleNewChannelMessage(ctx, log, cfg, api, update)
+	unlock, err := lockSessions(cfg)
+	if err != nil {
+		return err
 	}
+	defer unlock()
 
-	d.OnEditChannelMessage(handleFuncEditMessage)
-	d.OnNewChannelMessage(handleFuncNewMessage)
+	if *dryRun {
+		log.Warn("Dry run, payloads are logged instead of sent")
+	}
+	out, closeOutputs, err := newOutputs(ctx, cfg, log, *dryRun)
+	if err != nil {
+		return err
+	}
+	defer closeOutputs()
 
-	return client.Run(ctx, func(ctx context.Context) error {
-		if err := client.Auth().IfNecessary(ctx, flow); err != nil {
-			return errors.Wrap(err, "auth")
+	tenants, closeTenants, err := newTenants(cfg, log, out, *dryRun)
+	if err != nil {
+		return err
+	}
+	defer closeTenants()
+	metrics.RegisterQueueDepth(func() int { return queueDepth(tenants) })
+
+	accounts := allAccounts(tenants)
+	var bot *botSource
+	if initialCfg.BotAPI.Token != "" {
+		bot = newBotSource(initialCfg.BotAPI, accounts, log.Named("bot"))
+		go bot.run(ctx)
+	}
+	if initialCfg.HTTP.Listen != "" {
+		var archive *archiveAPI
+		if out.archive != nil {
+			archive = &archiveAPI{log: log.Named("http"), archive: out.archive, index: out.index}
 		}
-
-		user, err := client.Self(ctx)
-		if err != nil {
-			return errors.Wrap(err, "call self")
+		if dash != nil {
+			dash.accounts, dash.tenants = accounts, tenants
+			go dash.run(ctx, out.stream)
 		}
+		var admin *channelsAPI
+		if initialCfg.HTTP.AdminToken != "" {
+			admin = &channelsAPI{
+				token:    initialCfg.HTTP.AdminToken,
+				accounts: accounts,
+				log:      log.Named("admin"),
+				join:     func(a *account) { go a.w.joinChannels(ctx) },
+			}
+		}
+		var acks *ackAPI
+		if initialCfg.Webhook.Ack.Timeout > 0 {
+			acks = &ackAPI{cfg: cfg, fanouts: fanouts(tenants), log: log.Named("ack")}
+		}
+		var debug *debugAPI
+		if initialCfg.HTTP.Debug {
+			debug = &debugAPI{token: initialCfg.HTTP.AdminToken, started: time.Now(), tenants: tenants}
+		}
+		var botUpdates *botSource
+		if bot != nil && initialCfg.BotAPI.Mode == config.BotModeWebhook {
+			botUpdates = bot
+		}
+		go serveHTTP(ctx, log.Named("http"), initialCfg.HTTP.Listen, newHTTPMux(accountsHealth(accounts), accountsPins(accounts), archive, out.stream, dash, admin, acks, debug, botUpdates, initialCfg.HTTP.AdminToken))
+	}
+	if initialCfg.GRPC.Listen != "" {
+		go serveGRPC(ctx, log.Named("grpc"), initialCfg.GRPC.Listen, out.stream)
+	}
 
-		if *allMessages {
-			go func() {
-				err := fetchAndProcessMessages(ctx, log, cfg, api)
-				if err != nil {
-					log.Error("fetch and process messages", zap.Error(err))
-				}
-			}()
+	go watchConfig(ctx, log, cfg, func(*config.Config) {
+		for _, t := range tenants {
+			if err := reroute(t.out.outbox, t.cfg.Load()); err != nil {
+				t.log.Error("Apply sink settings", zap.Error(err))
+			}
 		}
+		// Channels added by invite link or with join need the client.
+		for _, a := range accounts {
+			go a.w.joinChannels(ctx)
+		}
+	})
 
-		return gaps.Run(ctx, client.API(), user.ID, updates.AuthOptions{
-			OnStart: func(ctx context.Context) {
-				log.Info("Gaps started")
-			},
+	for _, t := range tenants {
+		t.log.Info("Outbox", zap.Int("depth", t.out.outbox.Depth()))
+		go func() {
+			if err := t.out.outbox.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				t.log.Error("outbox", zap.Error(err))
+			}
+		}()
+	}
+
+	go notifyService(ctx, log, accounts)
+
+	backfillEnabled := *allMessages || backfill.enabled()
+	if len(accounts) == 1 {
+		return accounts[0].supervise(ctx, backfill, backfillEnabled)
+	}
+	g, gCtx := errgroup.WithContext(ctx)
+	for _, a := range accounts {
+		g.Go(func() error {
+			return errors.Wrapf(a.supervise(gCtx, backfill, backfillEnabled), "account %s", a.name)
 		})
-	})
+	}
+	return g.Wait()
+}
+
+// outputs are what the accounts deliver to and enrich events with: the
+// sinks behind their outboxes, each tenant has its own, the media storage,
+// archive and search index, the clients telegram sinks post with, the event
+// stream, rate limits, translator, transcriber and external processors,
+// the alerts and the delivery lag. Optional ones are nil when disabled.
+type outputs struct {
+	outbox      *delivery.Fanout
+	media       media.Storage
+	archive     *storage.Archive
+	index       *search.Index
+	telegram    *sink.TelegramClients
+	stream      *stream.Hub
+	throttle    *throttle
+	translator  *translate.Translator
+	transcriber *transcribe.Transcriber
+	processors  []externalProcessor
+	alerts      *alerter
+	lag         *deliveryLag
 }
 
-func getChannel(ctx context.Context, client *tg.Client, channelID int64) (*tg.Channel, error) {
-	inputChannel := &tg.InputChannel{
-		ChannelID:  channelID,
-		AccessHash: 0, // This will be updated with the correct access hash
+func newOutputs(ctx context.Context, cfg *config.Store, log *zap.Logger, dryRun bool) (*outputs, func(), error) {
+	initialCfg := cfg.Load()
+
+	telegram := sink.NewTelegramClients()
+	alerts := newAlerter(cfg, log.Named("alerts"))
+	lag := newDeliveryLag(initialCfg.Tenant)
+	var (
+		outbox  *delivery.Fanout
+		closers []func()
+		err     error
+	)
+	// Closed last, the sinks of all tenants share the HTTP transports.
+	closers = append(closers, sink.CloseIdleConnections)
+	// Tenants open their own sinks, see newTenants.
+	if len(initialCfg.Tenants) == 0 {
+		var closeSinks func()
+		if outbox, closeSinks, err = newFanout(cfg, log, telegram, alerts, lag, dryRun); err != nil {
+			return nil, nil, err
+		}
+		closers = append(closers, closeSinks)
+	}
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
 	}
 
-	channels, err := client.ChannelsGetChannels(ctx, []tg.InputChannelClass{inputChannel})
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch channel: %w", err)
+	out := &outputs{outbox: outbox, telegram: telegram, stream: stream.NewHub(), throttle: newThrottle(), alerts: alerts, lag: lag}
+	if initialCfg.Media.Download {
+		out.media, err = newMediaStorage(initialCfg.Media)
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrap(err, "media storage")
+		}
+	}
+	if initialCfg.Language.Translate.Provider != "" {
+		out.translator, err = translate.New(initialCfg.Language.Translate)
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrap(err, "translator")
+		}
+	}
+	if initialCfg.Transcription.URL != "" {
+		out.transcriber = transcribe.New(initialCfg.Transcription)
+	}
+	for _, pc := range initialCfg.Processors {
+		proc, err := processor.Open(ctx, pc)
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "processor %s", pc)
+		}
+		closers = append(closers, func() { _ = proc.Close() })
+		out.processors = append(out.processors, externalProcessor{name: pc.String(), proc: proc})
 	}
+	if initialCfg.Archive.Driver != "" {
+		archive, err := storage.Open(ctx, initialCfg.Archive.Driver, initialCfg.Archive.DSN)
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrap(err, "open archive")
+		}
+		closers = append(closers, func() { _ = archive.Close() })
+		out.archive = archive
+		if path := initialCfg.Archive.IndexPath; path != "" {
+			index, err := openIndex(ctx, archive, path, log.Named("search"))
+			if err != nil {
+				closeAll()
+				return nil, nil, errors.Wrap(err, "open search index")
+			}
+			closers = append(closers, func() { _ = index.Close() })
+			out.index = index
+		}
+	}
+	return out, closeAll, nil
+}
+
+// newWatcher opens what the message handlers of one account need, peer
+// cache, checkpoints and discovered channels, on top of the shared outputs.
+func newWatcher(cfg *config.Store, log *zap.Logger, api *tg.Client, out *outputs) (*watcher, error) {
+	initialCfg := cfg.Load()
 
-	if len(channels.GetChats()) == 0 {
-		return nil, fmt.Errorf("no channels found")
+	channels, err := tgService.NewChannelCache(api, initialCfg.TgApp.PeerCachePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open peer cache")
 	}
 
-	channel, ok := channels.GetChats()[0].(*tg.Channel)
-	if !ok {
-		return nil, errors.New("unexpected chat type")
+	checkpoints, err := tgService.NewCheckpoints(initialCfg.TgApp.CheckpointPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open checkpoints")
+	}
+	discovered, err := tgService.NewDiscovered(initialCfg.TgApp.DiscoveryPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "open discovered channels")
 	}
 
-	return channel, nil
+	w := &watcher{
+		log:         log,
+		cfg:         cfg,
+		api:         api,
+		channels:    channels,
+		outbox:      out.outbox,
+		stream:      out.stream,
+		throttle:    out.throttle,
+		translator:  out.translator,
+		transcriber: out.transcriber,
+		processors:  out.processors,
+		archive:     out.archive,
+		index:       out.index,
+		filters:     filter.NewCache(),
+		schedules:   schedule.NewCache(),
+		checkpoints: checkpoints,
+		discovered:  discovered,
+		invites:     newInviteLinks(),
+		reactions:   newRecentMap[messageKey, []event.Reaction](maxRecentMessages),
+		polls:       newRecentMap[int64, pollMessage](maxRecentMessages),
+		peerNames:   newRecentMap[peerKey, peerName](maxRecentMessages),
+		replies:     newRecentMap[messageKey, *event.Reply](maxRecentMessages),
+		texts:       newRecentMap[messageKey, string](maxRecentMessages),
+		hashes:      newRecentMap[messageKey, string](maxRecentMessages),
+		discussions: newRecentMap[int64, int64](maxRecentMessages),
+		posts:       newRecentMap[messageKey, int](maxRecentMessages),
+		pages:       newRecentMap[string, unfurl.Page](maxRecentMessages),
+		stickerSets: newRecentMap[int64, string](maxRecentMessages),
+		stats:       newRecentMap[messageKey, statsPost](max(initialCfg.Stats.MaxPosts, 1)),
+		scheduled:   newRecentMap[messageKey, scheduledPost](maxRecentMessages),
+		snapshots:   newRecentMap[int64, event.ChannelInfo](maxRecentMessages),
+		activity:    newActivity(initialCfg.Tenant),
+		pins:        newPinnedMessages(),
+	}
+	w.albums = newAlbumBuffer(w.flushAlbum)
+	w.pipeline = w.newPipeline()
+	log.Debug("Event pipeline", zap.Strings("stages", w.pipeline.Stages()))
+	if out.media != nil {
+		w.media = media.NewDownloader(api, out.media, initialCfg.Media.MaxSize)
+	}
+	if out.transcriber != nil {
+		w.voices = media.NewDownloader(api, nil, 0)
+	}
+	return w, nil
 }
 
-func handleEditChannelMessage(ctx context.Context, log *zap.Logger, cfg *config.Config, api *tg.Client, update *tg.UpdateEditChannelMessage) error {
-	msg, _ := update.GetMessage().(*tg.Message)
+// newFanout opens a sink and an outbox per configured output. Without a sinks
+// list the single sink section is used with the outbox in delivery.outbox_dir,
+// listed sinks get their own outbox in a sub-directory named after the sink.
+// A dry run logs the payloads instead and keeps its outboxes in a temporary
+// directory, so the pending events of the real sinks stay untouched.
+func newFanout(cfg *config.Store, log *zap.Logger, telegram *sink.TelegramClients, alerts *alerter, lag *deliveryLag, dryRun bool) (*delivery.Fanout, func(), error) {
+	c := cfg.Load()
+	sinks := c.Sinks
+	single := len(sinks) == 0
+	if single {
+		sinks = []config.SinkConfig{c.Sink}
+	}
 
-	ch, ok := msg.GetPeerID().(*tg.PeerChannel)
-	if !ok {
-		return errors.New("bad peerID")
+	var (
+		routes  []delivery.Route
+		outputs []sink.Sink
+		status  *sink.StatusWebhook
+		tempDir string
+	)
+	closeAll := func() {
+		for _, s := range outputs {
+			_ = s.Close()
+		}
+		if status != nil {
+			_ = status.Close()
+		}
+		if tempDir != "" {
+			_ = os.RemoveAll(tempDir)
+		}
 	}
-	channel, err := getChannel(ctx, api, ch.ChannelID)
-	if err != nil {
-		log.Error("get channel", zap.Error(err))
-		return err
+
+	outboxDir, dedupPath := c.Delivery.OutboxDir, c.Delivery.Dedup.Path
+	var (
+		bury       delivery.DeadLetter
+		deadOutput sink.Sink
+		err        error
+	)
+	if dryRun {
+		if tempDir, err = os.MkdirTemp("", "tg-watcher-dry-run-"); err != nil {
+			return nil, nil, errors.Wrap(err, "dry run outbox")
+		}
+		outboxDir, dedupPath = tempDir, ""
+	} else if bury, deadOutput, err = newDeadLetter(cfg, c.Delivery.DeadLetter); err != nil {
+		return nil, nil, errors.Wrap(err, "dead letter")
+	}
+	if deadOutput != nil {
+		outputs = append(outputs, deadOutput)
+	}
+	if url := c.Delivery.Status.URL; url != "" && !dryRun {
+		if status, err = sink.NewStatusWebhook(cfg, url, c.Delivery.Status.QueueSize); err != nil {
+			closeAll()
+			return nil, nil, errors.Wrap(err, "status webhook")
+		}
 	}
 
-	if channel.GetID() == cfg.TgApp.ChatForWatch {
-		text := msg.GetMessage()
-		err := sendMessage(text, cfg.TgApp.WebhookUrl, "editMessage", msg.GetID())
-		if err != nil {
-			log.Error("Error sending message", zap.Error(err))
+	var payloads media.Storage
+	if slices.Contains(c.Webhook.Oversize, config.OversizeExternalizeMedia) {
+		if payloads, err = newMediaStorage(c.Media); err != nil {
+			closeAll()
+			return nil, nil, errors.Wrap(err, "media storage for oversized payloads")
 		}
-		log.Info("Message", zap.Any("text", text))
 	}
 
-	return nil
-}
+	for _, sc := range sinks {
+		if sc.Type == "" {
+			sc.Type = "webhook"
+		}
+		name := sc.OutboxName()
 
-func handleNewChannelMessage(ctx context.Context, log *zap.Logger, cfg *config.Config, api *tg.Client, update *tg.UpdateNewChannelMessage) error {
-	msg, _ := update.GetMessage().(*tg.Message)
-	ch, ok := msg.GetPeerID().(*tg.PeerChannel)
-	if !ok {
-		return errors.New("bad peerID")
-	}
-	channel, err := getChannel(ctx, api, ch.ChannelID)
-	if err != nil {
-		log.Error("get channel", zap.Error(err))
-		return err
+		var out sink.Sink = sink.NewDryRun(log.Named("dry-run"), name)
+		if !dryRun {
+			out, err = newSink(cfg, sc, telegram)
+		}
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "create sink %s", name)
+		}
+		outputs = append(outputs, out)
+		if webhook, ok := out.(*sink.Webhook); ok {
+			webhook.SetActor(responseActor{cfg: cfg, clients: telegram, log: log.Named("actions")})
+			webhook.SetStorage(payloads)
+		}
+		if err := applyTemplate(out, sc); err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "sink %s", name)
+		}
+
+		dir := outboxDir
+		if !single {
+			dir = filepath.Join(dir, name)
+		}
+		retry := c.Delivery
+		if r := sc.Retry; r != nil {
+			if r.MaxAttempts != 0 {
+				retry.MaxAttempts = r.MaxAttempts
+			}
+			if r.InitialBackoff != 0 {
+				retry.InitialBackoff = r.InitialBackoff
+			}
+			if r.MaxBackoff != 0 {
+				retry.MaxBackoff = r.MaxBackoff
+			}
+		}
+		var dead delivery.DeadLetter
+		if bury != nil {
+			dead = func(ctx context.Context, l *delivery.Letter) error {
+				l.Sink = name
+				return bury(ctx, l)
+			}
+		}
+		outbox, err := delivery.NewOutbox(dir, out, delivery.RetryPolicy{
+			MaxAttempts:    retry.MaxAttempts,
+			InitialBackoff: retry.InitialBackoff,
+			MaxBackoff:     retry.MaxBackoff,
+			MaxAge:         sc.MaxAge,
+			DropExpired:    sc.Expired == config.ExpiredDrop,
+		}, delivery.BatchPolicy{
+			Size:     sc.Batch.Size,
+			Interval: sc.Batch.Interval,
+		}, dead, log.Named("outbox").With(zap.String("sink", name)))
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "open outbox of sink %s", name)
+		}
+		if status != nil {
+			outbox.Observe(sinkStatus{name: name, observer: status})
+		}
+		if c.Tenant != "" {
+			outbox.Observe(tenantStatus{tenant: c.Tenant, sink: name})
+		}
+		outbox.Observe(&failingSink{alerts: alerts, tenant: c.Tenant, sink: name})
+		outbox.Observe(lag)
+		if _, ok := out.(*sink.Webhook); ok && c.Webhook.Ack.Timeout > 0 {
+			outbox.RequireAck(c.Webhook.Ack.Timeout)
+		}
+
+		if err := event.CheckFields(sc.Fields); err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "fields of sink %s", name)
+		}
+		route := delivery.Route{Name: name, Outbox: outbox, TextFormat: sc.TextFormat, TextLimit: textLimit(sc), Fields: sc.Fields, Digest: delivery.DigestPolicy{
+			Interval:    sc.Digest.Interval,
+			MaxMessages: sc.Digest.MaxMessages,
+			TopKeywords: sc.Digest.TopKeywords,
+		}}
+		if !single {
+			if route.Match, err = routeMatch(sc); err != nil {
+				closeAll()
+				return nil, nil, errors.Wrapf(err, "filter of sink %s", name)
+			}
+		}
+		routes = append(routes, route)
 	}
 
-	if channel.GetID() == cfg.TgApp.ChatForWatch {
-		text := msg.GetMessage()
-		err := sendMessage(text, cfg.TgApp.WebhookUrl, "newMessage", msg.GetID())
+	var dedup *delivery.Dedup
+	if c.Delivery.Dedup.Window > 0 {
+		dedup, err = delivery.NewDedup(c.Delivery.Dedup.Window, dedupPath)
 		if err != nil {
-			log.Error("Error sending message", zap.Error(err))
+			closeAll()
+			return nil, nil, errors.Wrap(err, "open dedup window")
 		}
-		log.Info("Message", zap.Any("text", text))
 	}
 
-	return nil
+	pool := delivery.PoolPolicy{
+		Workers:    c.Delivery.Workers,
+		Queue:      c.Delivery.QueueSize,
+		DropOldest: c.Delivery.Backpressure == "drop_oldest",
+	}
+	fanout := delivery.NewFanout(log.Named("fanout"), dedup, pool, routes...)
+	fanout.SetRules(routingRules(c))
+	return fanout, closeAll, nil
+}
+
+// sinkStatus names the sink in the status updates of its outbox.
+type sinkStatus struct {
+	name     string
+	observer sink.StatusObserver
+}
+
+func (s sinkStatus) DeliveryStatus(ctx context.Context, u sink.StatusUpdate) {
+	u.Sink = s.name
+	s.observer.DeliveryStatus(ctx, u)
 }
 
-func fetchAndProcessMessages(ctx context.Context, log *zap.Logger, cfg *config.Config, api *tg.Client) error {
-	channel, err := getChannel(ctx, api, int64(cfg.TgApp.ChatForWatch))
+// tenantStatus counts the delivery steps of the events of a tenant.
+type tenantStatus struct {
+	tenant string
+	sink   string
+}
+
+func (s tenantStatus) DeliveryStatus(_ context.Context, u sink.StatusUpdate) {
+	metrics.TenantDeliveries.WithLabelValues(s.tenant, s.sink, string(u.Status)).Inc()
+}
+
+func textLimit(sc config.SinkConfig) delivery.TextLimit {
+	return delivery.TextLimit{MaxLength: sc.TextLength.Max, Split: sc.TextLength.Overflow == config.OverflowSplit}
+}
+
+func routeMatch(sc config.SinkConfig) (func(e *event.Event) bool, error) {
+	text, err := filter.New(sc.Filter)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return delivery.Matcher(sc.Types, sc.Channels, sc.Topics, text), nil
+}
 
-	peer := &tg.InputPeerChannel{
-		ChannelID:  channel.ID,
-		AccessHash: channel.AccessHash,
+// routingRules builds the routing rules of the fanout.
+func routingRules(c *config.Config) []delivery.Rule {
+	rules := make([]delivery.Rule, 0, len(c.Routing))
+	for i, r := range c.Routing {
+		cond := delivery.RuleCondition{
+			Types:         r.Types,
+			Channels:      r.Channels,
+			Media:         r.Media,
+			MediaTypes:    r.MediaTypes,
+			MinTextLength: r.MinTextLength,
+			MaxTextLength: r.MaxTextLength,
+		}
+		rules = append(rules, delivery.Rule{Name: r.RuleName(i), Match: cond.Match, Routes: r.Sinks})
 	}
+	return rules
+}
 
-	offsetID := 0
-	for {
-		messages, err := api.MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
-			Peer:     peer,
-			OffsetID: offsetID,
-			Limit:    100,
+// reroute applies the routing rules and the routing settings of reloaded
+// sinks: types, channels, topics, filter, text_format, text_length and fields.
+// Everything else of a sink needs a restart.
+func reroute(f *delivery.Fanout, c *config.Config) error {
+	f.SetRules(routingRules(c))
+	if len(c.Sinks) == 0 {
+		if err := event.CheckFields(c.Sink.Fields); err != nil {
+			return errors.Wrap(err, "fields of the sink")
+		}
+		f.Reroute(func(r delivery.Route) delivery.Route {
+			r.TextFormat, r.TextLimit, r.Fields = c.Sink.TextFormat, textLimit(c.Sink), c.Sink.Fields
+			return r
 		})
+		return nil
+	}
+
+	routes := map[string]delivery.Route{}
+	for _, sc := range c.Sinks {
+		name := sc.OutboxName()
+		match, err := routeMatch(sc)
 		if err != nil {
-			return err
+			return errors.Wrapf(err, "filter of sink %s", name)
 		}
-
-		history, ok := messages.(*tg.MessagesChannelMessages)
-		if !ok {
-			return errors.New("unexpected messages type")
+		if err := event.CheckFields(sc.Fields); err != nil {
+			return errors.Wrapf(err, "fields of sink %s", name)
 		}
+		routes[name] = delivery.Route{Match: match, TextFormat: sc.TextFormat, TextLimit: textLimit(sc), Fields: sc.Fields}
+	}
+	f.Reroute(func(r delivery.Route) delivery.Route {
+		if next, ok := routes[r.Name]; ok {
+			r.Match, r.TextFormat, r.TextLimit, r.Fields = next.Match, next.TextFormat, next.TextLimit, next.Fields
+		}
+		return r
+	})
+	return nil
+}
 
-		for _, message := range history.Messages {
-			msg, ok := message.(*tg.Message)
-			if !ok {
-				continue
-			}
-
-			text := msg.GetMessage()
-			err := sendMessage(text, cfg.TgApp.WebhookUrl, "oldMessage", msg.GetID())
+// newDeadLetter opens the dead-letter destination. Without one configured
+// failed events stay in the dead directories of the outboxes.
+func newDeadLetter(cfg *config.Store, dl config.DeadLetterConfig) (delivery.DeadLetter, sink.Sink, error) {
+	switch dl.Type {
+	case "":
+		return nil, nil, nil
+	case "file":
+		file, err := sink.NewFile(dl.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(_ context.Context, l *delivery.Letter) error { return file.Append(l) }, file, nil
+	case "webhook":
+		webhook, err := sink.NewWebhook(cfg, dl.URL)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(ctx context.Context, l *delivery.Letter) error {
+			body, err := json.Marshal(l)
 			if err != nil {
-				log.Error("Error sending message", zap.Error(err))
+				return err
 			}
-			log.Info("Message", zap.Any("text", text))
-		}
+			return webhook.Post(ctx, dl.URL, body)
+		}, webhook, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown dead letter type %q", dl.Type)
+	}
+}
 
-		if len(history.Messages) < 100 {
-			break
+func newSink(cfg *config.Store, sc config.SinkConfig, telegram *sink.TelegramClients) (sink.Sink, error) {
+	switch sc.Type {
+	case "webhook":
+		return sink.NewWebhook(cfg, sc.URL)
+	case "slack":
+		return sink.NewSlack(cfg, sc.URL)
+	case "discord":
+		return sink.NewDiscord(cfg, sc.URL, sc.Discord)
+	case "telegram":
+		return sink.NewTelegram(telegram, sc.Telegram)
+	case "kafka":
+		return sink.NewKafka(sc.Kafka)
+	case "nats":
+		return sink.NewNATS(sc.NATS)
+	case "amqp":
+		return sink.NewAMQP(sc.AMQP)
+	case "file":
+		if sc.Path == "" {
+			return nil, fmt.Errorf("file sink needs a path")
 		}
-
-		offsetID = history.Messages[len(history.Messages)-1].(*tg.Message).ID
+		return sink.NewFile(sc.Path)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", sc.Type)
 	}
-
-	return nil
 }
 
-func sendMessage(text string, webHookUrl string, messageType string, messageID int) error {
-	postBody, _ := json.Marshal(map[string]string{
-		"text":        text,
-		"type":        messageType,
-		"external_id": strconv.Itoa(messageID),
-	})
-	responseBody := bytes.NewBuffer(postBody)
-	resp, err := http.Post(webHookUrl, "application/json", responseBody)
-
+// applyTemplate sets the body template of the sink, if one is configured.
+func applyTemplate(out sink.Sink, sc config.SinkConfig) error {
+	text, name := sc.Template, "template"
+	if sc.TemplateFile != "" {
+		b, err := os.ReadFile(sc.TemplateFile)
+		if err != nil {
+			return errors.Wrap(err, "read template")
+		}
+		text, name = string(b), filepath.Base(sc.TemplateFile)
+	}
+	if text == "" {
+		return nil
+	}
+	templater, ok := out.(sink.Templater)
+	if !ok {
+		return fmt.Errorf("%s sink doesn't support templates", sc.Type)
+	}
+	t, err := event.ParseTemplate(name, text)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
+	templater.SetTemplate(t)
+	return nil
+}
 
-	if resp.StatusCode > 300 {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+func newMediaStorage(cfg config.MediaConfig) (media.Storage, error) {
+	switch cfg.Storage {
+	case "", "local":
+		return media.LocalStorage{Dir: cfg.LocalDir, BaseURL: cfg.BaseURL}, nil
+	case "s3":
+		return media.NewS3Storage(media.S3Options{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			UseSSL:    cfg.S3.UseSSL,
+			BaseURL:   cfg.BaseURL,
+		})
+	default:
+		return nil, fmt.Errorf("unknown media storage %q", cfg.Storage)
 	}
-	return nil
 }
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-tg.com/internal/search"
+	"go-tg.com/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// archiveAPI serves the archived messages so tools can read the history
+// without asking Telegram.
+type archiveAPI struct {
+	log     *zap.Logger
+	archive *storage.Archive
+	index   *search.Index
+}
+
+type archivedMessage struct {
+	ChannelID int64  `json:"channel_id"`
+	MessageID int    `json:"message_id"`
+	Text      string `json:"text"`
+	Date      int    `json:"date"`
+	EditDate  int    `json:"edit_date,omitempty"`
+	DeletedAt int    `json:"deleted_at,omitempty"`
+}
+
+type messagesPage struct {
+	Messages []archivedMessage `json:"messages"`
+	// Next is the before value of the next page, 0 on the last one.
+	Next int `json:"next,omitempty"`
+}
+
+// handleMessages serves GET /channels/{id}/messages, newest first. since and
+// until take unix seconds or RFC 3339, q searches the text for all of its
+// words, limit sizes the page and before continues after the message ID
+// given as next on the previous page.
+func (api archiveAPI) handleMessages(rw http.ResponseWriter, r *http.Request) {
+	q := storage.MessageQuery{Search: r.URL.Query().Get("q"), Limit: defaultPageSize}
+	var err error
+	if q.ChannelID, err = strconv.ParseInt(r.PathValue("id"), 10, 64); err != nil {
+		http.Error(rw, "bad channel id", http.StatusBadRequest)
+		return
+	}
+	params := []struct {
+		name  string
+		value *int
+		parse func(string) (int, error)
+	}{
+		{"since", &q.Since, parseTime},
+		{"until", &q.Until, parseTime},
+		{"before", &q.BeforeID, strconv.Atoi},
+		{"limit", &q.Limit, strconv.Atoi},
+	}
+	for _, p := range params {
+		raw := r.URL.Query().Get(p.name)
+		if raw == "" {
+			continue
+		}
+		if *p.value, err = p.parse(raw); err != nil || *p.value < 0 {
+			http.Error(rw, "bad "+p.name, http.StatusBadRequest)
+			return
+		}
+	}
+	if q.Limit == 0 || q.Limit > maxPageSize {
+		q.Limit = maxPageSize
+	}
+
+	messages, err := api.archive.Query(r.Context(), q)
+	if err != nil {
+		api.log.Error("Query archive", zap.Int64("channel_id", q.ChannelID), zap.Error(err))
+		http.Error(rw, "archive query failed", http.StatusInternalServerError)
+		return
+	}
+	page := messagesPage{Messages: make([]archivedMessage, 0, len(messages))}
+	for _, m := range messages {
+		page.Messages = append(page.Messages, archivedMessage{
+			ChannelID: m.ChannelID,
+			MessageID: m.MessageID,
+			Text:      m.Text,
+			Date:      m.Date,
+			EditDate:  m.EditDate,
+			DeletedAt: m.DeletedAt,
+		})
+	}
+	if len(messages) == q.Limit {
+		page.Next = messages[len(messages)-1].MessageID
+	}
+	writeStatus(rw, true, page)
+}
+
+// handleSearch serves GET /search, the archived messages of all channels
+// matching q, best match first. channel narrows it to one channel, since and
+// until take unix seconds or RFC 3339, limit sizes the page and offset skips
+// the hits of the previous pages.
+func (api archiveAPI) handleSearch(rw http.ResponseWriter, r *http.Request) {
+	q := search.Query{Text: r.URL.Query().Get("q"), Limit: defaultPageSize}
+	if q.Text == "" {
+		http.Error(rw, "missing q", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if raw := r.URL.Query().Get("channel"); raw != "" {
+		if q.ChannelID, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			http.Error(rw, "bad channel", http.StatusBadRequest)
+			return
+		}
+	}
+	params := []struct {
+		name  string
+		value *int
+		parse func(string) (int, error)
+	}{
+		{"since", &q.Since, parseTime},
+		{"until", &q.Until, parseTime},
+		{"limit", &q.Limit, strconv.Atoi},
+		{"offset", &q.Offset, strconv.Atoi},
+	}
+	for _, p := range params {
+		raw := r.URL.Query().Get(p.name)
+		if raw == "" {
+			continue
+		}
+		if *p.value, err = p.parse(raw); err != nil || *p.value < 0 {
+			http.Error(rw, "bad "+p.name, http.StatusBadRequest)
+			return
+		}
+	}
+	if q.Limit == 0 || q.Limit > maxPageSize {
+		q.Limit = maxPageSize
+	}
+
+	result, err := api.index.Search(q)
+	if err != nil {
+		api.log.Debug("Search archive", zap.String("q", q.Text), zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeStatus(rw, true, result)
+}
+
+// parseTime reads unix seconds or an RFC 3339 time.
+func parseTime(s string) (int, error) {
+	if unix, err := strconv.Atoi(s); err == nil {
+		return unix, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, err
+	}
+	return int(t.Unix()), nil
+}
@@ -0,0 +1,471 @@
+package app
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/botapi"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	// botRetryDelay is the pause after a failed getUpdates call or update.
+	botRetryDelay = 5 * time.Second
+	// maxBotUpdate bounds the request body of POST /bot.
+	maxBotUpdate = 1 << 20
+	// botSecretHeader carries bot_api.secret_token with webhook updates.
+	botSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+)
+
+// botSource receives the messages of channels with source bot through the
+// Bot API and hands them to the watchers of all accounts, each sends the
+// ones of its own channels.
+type botSource struct {
+	cfg      config.BotAPIConfig
+	client   *botapi.Client
+	watchers []*watcher
+	log      *zap.Logger
+}
+
+func newBotSource(cfg config.BotAPIConfig, accounts []*account, log *zap.Logger) *botSource {
+	b := &botSource{cfg: cfg, client: botapi.New(cfg.APIURL, cfg.Token), log: log}
+	for _, a := range accounts {
+		b.watchers = append(b.watchers, a.w)
+	}
+	return b
+}
+
+// run polls for updates until ctx is done or, in webhook mode, registers
+// the webhook the updates arrive at.
+func (b *botSource) run(ctx context.Context) {
+	if b.cfg.Mode == config.BotModeWebhook {
+		for {
+			err := b.client.SetWebhook(ctx, b.cfg.WebhookURL, b.cfg.SecretToken)
+			if err == nil {
+				b.log.Info("Bot webhook set")
+				return
+			}
+			b.log.Error("Set bot webhook", zap.Error(err))
+			if !pause(ctx, botRetryDelay) {
+				return
+			}
+		}
+	}
+
+	// getUpdates fails while a webhook is set.
+	if err := b.client.DeleteWebhook(ctx); err != nil {
+		b.log.Warn("Delete bot webhook", zap.Error(err))
+	}
+	b.log.Info("Bot polling started")
+	var offset int64
+	for ctx.Err() == nil {
+		updates, err := b.client.GetUpdates(ctx, offset, b.cfg.PollTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			metrics.BotUpdates.WithLabelValues("poll_failed").Inc()
+			b.log.Warn("Get bot updates", zap.Error(err))
+			pause(ctx, botRetryAfter(err))
+			continue
+		}
+		for _, u := range updates {
+			// A failed update is fetched again with the ones after it.
+			if err := b.handle(ctx, u); err != nil {
+				pause(ctx, botRetryDelay)
+				break
+			}
+			offset = u.UpdateID + 1
+		}
+	}
+}
+
+// botRetryAfter waits out the rate limit of the Bot API.
+func botRetryAfter(err error) time.Duration {
+	var apiErr *botapi.Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+	return botRetryDelay
+}
+
+// pause waits for d and tells whether ctx is still running.
+func pause(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// handleUpdate serves POST /bot, the webhook of the bot. Telegram sends the
+// update again when it failed.
+func (b *botSource) handleUpdate(rw http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(botSecretHeader)), []byte(b.cfg.SecretToken)) != 1 {
+		http.Error(rw, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var u botapi.Update
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBotUpdate)).Decode(&u); err != nil {
+		http.Error(rw, "bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := b.handle(r.Context(), u); err != nil {
+		http.Error(rw, "handling failed", http.StatusInternalServerError)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+// handle passes the message of u to every watcher.
+func (b *botSource) handle(ctx context.Context, u botapi.Update) error {
+	msg, edited := u.Sent()
+	if msg == nil {
+		return nil
+	}
+	var errs []error
+	for _, w := range b.watchers {
+		errs = append(errs, w.handleBotMessage(ctx, msg, edited))
+	}
+	if err := errors.Join(errs...); err != nil {
+		metrics.BotUpdates.WithLabelValues("failed").Inc()
+		b.log.Error("Handle bot update", zap.Int64("update_id", u.UpdateID), zap.Error(err))
+		return err
+	}
+	metrics.BotUpdates.WithLabelValues("handled").Inc()
+	return nil
+}
+
+// handleBotMessage sends a message the bot received in a channel or group
+// with source bot like handleMessage does for the user session. Media is
+// described but not downloaded and the parts of albums are sent one by one.
+// The Bot API has no history, deletions or view counters, so there are no
+// such events.
+func (w *watcher) handleBotMessage(ctx context.Context, bm *botapi.Message, edited bool) error {
+	cfg := w.cfg.Load()
+	chat, msg := fromBotMessage(bm)
+	watched, ok := cfg.FindPeer(chat.Type, chat.ID, chat.Username)
+	if !ok || !watched.ViaBot() {
+		return nil
+	}
+	messageType := "newMessage"
+	if edited {
+		messageType = "editMessage"
+	}
+	if !watched.Accepts(messageType) {
+		return nil
+	}
+	metrics.MessagesReceived.WithLabelValues(messageType).Inc()
+	w.rememberPeers(botEntities(bm))
+	if reply := bm.ReplyToMessage; reply != nil {
+		_, replied := fromBotMessage(reply)
+		w.replies.swap(messageKey{chatID: chat.ID, messageID: reply.MessageID}, event.ReplyOf(replied))
+	}
+	w.activity.seen(chat.ID, time.Now())
+	edit := w.editOf(chat, msg, messageType, w.archiveMessage(ctx, chat, msg))
+	if w.unchanged(chat, msg, messageType) {
+		return nil
+	}
+	// The checkpoint moves past messages filtered out or in the outbox.
+	checkpoint := messageType == "newMessage" && chat.Type == config.PeerChannel
+	if !w.passesFilter(ctx, watched, chat, msg.GetMessage(), msg) {
+		w.log.Debug("Message filtered out", zap.String("chat_type", chat.Type), zap.Int64("chat_id", chat.ID), zap.Int("message_id", msg.GetID()))
+		if checkpoint {
+			w.advanceCheckpoint(chat.ID, msg.GetID())
+		}
+		return nil
+	}
+
+	e := event.FromMessage(cfg.Payload, chat, msg, messageType)
+	e.Edit = edit
+	w.describeForward(e)
+	w.describeReply(ctx, cfg, chat, msg, e)
+	err := w.deliver(ctx, cfg.WebhookUrlFor(watched), e)
+	if err != nil {
+		w.log.Error("Error sending message", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+	} else if checkpoint {
+		w.advanceCheckpoint(chat.ID, msg.GetID())
+	}
+	w.log.Info("Bot message", zap.String("chat_type", chat.Type), zap.Int64("chat_id", chat.ID), zap.Any("text", msg.GetMessage()))
+	return err
+}
+
+// botChat describes a Bot API chat with its MTProto ID.
+func botChat(c botapi.Chat) event.Chat {
+	chat := event.Chat{ID: c.PeerID(), Username: c.Username, Title: c.Title, Forum: c.IsForum}
+	switch c.Type {
+	case botapi.ChatChannel, botapi.ChatSupergroup:
+		chat.Type = config.PeerChannel
+	case botapi.ChatGroup:
+		chat.Type = config.PeerChat
+	default:
+		chat.Type = config.PeerUser
+	}
+	return chat
+}
+
+func botPeer(c botapi.Chat) tg.PeerClass {
+	switch c.Type {
+	case botapi.ChatChannel, botapi.ChatSupergroup:
+		return &tg.PeerChannel{ChannelID: c.PeerID()}
+	case botapi.ChatGroup:
+		return &tg.PeerChat{ChatID: c.PeerID()}
+	default:
+		return &tg.PeerUser{UserID: c.PeerID()}
+	}
+}
+
+// fromBotMessage turns a Bot API message into the MTProto message the user
+// session would have got, so the same filters, archive and events apply.
+func fromBotMessage(bm *botapi.Message) (event.Chat, *tg.Message) {
+	chat := botChat(bm.Chat)
+	text, entities := bm.Body()
+	msg := &tg.Message{
+		ID:      bm.MessageID,
+		PeerID:  botPeer(bm.Chat),
+		Date:    bm.Date,
+		Message: text,
+		Post:    bm.Chat.Type == botapi.ChatChannel,
+	}
+	if bm.EditDate != 0 {
+		msg.SetEditDate(bm.EditDate)
+	}
+	if len(entities) > 0 {
+		msg.SetEntities(botEntitiesOf(entities))
+	}
+	// Channel posts have no sender in MTProto.
+	switch {
+	case msg.Post:
+	case bm.SenderChat != nil:
+		msg.SetFromID(botPeer(*bm.SenderChat))
+	case bm.From != nil:
+		msg.SetFromID(&tg.PeerUser{UserID: bm.From.ID})
+	}
+	if bm.AuthorSignature != "" {
+		msg.SetPostAuthor(bm.AuthorSignature)
+	}
+	if id, err := strconv.ParseInt(bm.MediaGroupID, 10, 64); err == nil {
+		msg.SetGroupedID(id)
+	}
+	if header, ok := botReplyTo(bm); ok {
+		msg.SetReplyTo(header)
+	}
+	if fwd, ok := botForward(bm.ForwardOrigin); ok {
+		msg.SetFwdFrom(fwd)
+	}
+	if m, ok := botMedia(bm); ok {
+		msg.SetMedia(m)
+	}
+	return chat, msg
+}
+
+// botReplyTo returns the reply header of a reply or a message of a forum
+// topic. Replying to the start of the topic is no reply.
+func botReplyTo(bm *botapi.Message) (*tg.MessageReplyHeader, bool) {
+	topic := 0
+	if bm.IsTopicMessage {
+		topic = bm.MessageThreadID
+	}
+	reply := bm.ReplyToMessage
+	if reply != nil && reply.MessageID == topic {
+		reply = nil
+	}
+	h := &tg.MessageReplyHeader{ForumTopic: topic != 0}
+	switch {
+	case reply != nil:
+		h.SetReplyToMsgID(reply.MessageID)
+		if topic != 0 {
+			h.SetReplyToTopID(topic)
+		}
+	case topic != 0:
+		h.SetReplyToMsgID(topic)
+	default:
+		return nil, false
+	}
+	return h, true
+}
+
+func botForward(origin *botapi.MessageOrigin) (tg.MessageFwdHeader, bool) {
+	if origin == nil {
+		return tg.MessageFwdHeader{}, false
+	}
+	fwd := tg.MessageFwdHeader{Date: origin.Date}
+	switch {
+	case origin.Chat != nil:
+		fwd.SetFromID(botPeer(*origin.Chat))
+		if origin.MessageID != 0 {
+			fwd.SetChannelPost(origin.MessageID)
+		}
+	case origin.SenderChat != nil:
+		fwd.SetFromID(botPeer(*origin.SenderChat))
+	case origin.SenderUser != nil:
+		fwd.SetFromID(&tg.PeerUser{UserID: origin.SenderUser.ID})
+	case origin.SenderUserName != "":
+		fwd.SetFromName(origin.SenderUserName)
+	}
+	if origin.AuthorSignature != "" {
+		fwd.SetPostAuthor(origin.AuthorSignature)
+	}
+	return fwd, true
+}
+
+// botEntities are the users and chats of a message, for their names.
+func botEntities(bm *botapi.Message) tg.Entities {
+	e := tg.Entities{Users: map[int64]*tg.User{}, Chats: map[int64]*tg.Chat{}, Channels: map[int64]*tg.Channel{}}
+	addChat := func(c *botapi.Chat) {
+		if c == nil {
+			return
+		}
+		switch c.Type {
+		case botapi.ChatChannel, botapi.ChatSupergroup:
+			e.Channels[c.PeerID()] = &tg.Channel{ID: c.PeerID(), Title: c.Title, Username: c.Username}
+		case botapi.ChatGroup:
+			e.Chats[c.PeerID()] = &tg.Chat{ID: c.PeerID(), Title: c.Title}
+		}
+	}
+	addUser := func(u *botapi.User) {
+		if u != nil {
+			e.Users[u.ID] = &tg.User{ID: u.ID, FirstName: u.FirstName, LastName: u.LastName, Username: u.Username}
+		}
+	}
+	for m := bm; m != nil; m = m.ReplyToMessage {
+		addChat(&m.Chat)
+		addChat(m.SenderChat)
+		addUser(m.From)
+		if o := m.ForwardOrigin; o != nil {
+			addChat(o.Chat)
+			addChat(o.SenderChat)
+			addUser(o.SenderUser)
+		}
+	}
+	return e
+}
+
+func botEntitiesOf(entities []botapi.MessageEntity) []tg.MessageEntityClass {
+	res := make([]tg.MessageEntityClass, 0, len(entities))
+	for _, be := range entities {
+		offset, length := be.Offset, be.Length
+		var e tg.MessageEntityClass
+		switch be.Type {
+		case "mention":
+			e = &tg.MessageEntityMention{Offset: offset, Length: length}
+		case "hashtag":
+			e = &tg.MessageEntityHashtag{Offset: offset, Length: length}
+		case "cashtag":
+			e = &tg.MessageEntityCashtag{Offset: offset, Length: length}
+		case "bot_command":
+			e = &tg.MessageEntityBotCommand{Offset: offset, Length: length}
+		case "url":
+			e = &tg.MessageEntityURL{Offset: offset, Length: length}
+		case "email":
+			e = &tg.MessageEntityEmail{Offset: offset, Length: length}
+		case "phone_number":
+			e = &tg.MessageEntityPhone{Offset: offset, Length: length}
+		case "bold":
+			e = &tg.MessageEntityBold{Offset: offset, Length: length}
+		case "italic":
+			e = &tg.MessageEntityItalic{Offset: offset, Length: length}
+		case "underline":
+			e = &tg.MessageEntityUnderline{Offset: offset, Length: length}
+		case "strikethrough":
+			e = &tg.MessageEntityStrike{Offset: offset, Length: length}
+		case "spoiler":
+			e = &tg.MessageEntitySpoiler{Offset: offset, Length: length}
+		case "blockquote", "expandable_blockquote":
+			e = &tg.MessageEntityBlockquote{Offset: offset, Length: length}
+		case "code":
+			e = &tg.MessageEntityCode{Offset: offset, Length: length}
+		case "pre":
+			e = &tg.MessageEntityPre{Offset: offset, Length: length, Language: be.Language}
+		case "text_link":
+			e = &tg.MessageEntityTextURL{Offset: offset, Length: length, URL: be.URL}
+		case "text_mention":
+			var userID int64
+			if be.User != nil {
+				userID = be.User.ID
+			}
+			e = &tg.MessageEntityMentionName{Offset: offset, Length: length, UserID: userID}
+		case "custom_emoji":
+			id, _ := strconv.ParseInt(be.CustomEmojiID, 10, 64)
+			e = &tg.MessageEntityCustomEmoji{Offset: offset, Length: length, DocumentID: id}
+		default:
+			e = &tg.MessageEntityUnknown{Offset: offset, Length: length}
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// botMedia describes the media of a Bot API message as MTProto media
+// without file locations, they can't be downloaded by the user session.
+// Files are named after the message.
+func botMedia(bm *botapi.Message) (tg.MessageMediaClass, bool) {
+	id := int64(bm.MessageID)
+	if n := len(bm.Photo); n > 0 {
+		best := bm.Photo[n-1]
+		return &tg.MessageMediaPhoto{Photo: &tg.Photo{ID: id, Sizes: []tg.PhotoSizeClass{
+			&tg.PhotoSize{Type: "y", W: best.Width, H: best.Height, Size: int(best.FileSize)},
+		}}}, true
+	}
+	if s := bm.Sticker; s != nil {
+		mimeType := "image/webp"
+		switch {
+		case s.IsAnimated:
+			mimeType = "application/x-tgsticker"
+		case s.IsVideo:
+			mimeType = "video/webm"
+		}
+		sticker := &tg.DocumentAttributeSticker{Alt: s.Emoji, Stickerset: &tg.InputStickerSetEmpty{}}
+		if s.SetName != "" {
+			sticker.Stickerset = &tg.InputStickerSetShortName{ShortName: s.SetName}
+		}
+		return botDocument(id, mimeType, s.FileSize, sticker, &tg.DocumentAttributeImageSize{W: s.Width, H: s.Height}), true
+	}
+
+	var (
+		f     *botapi.File
+		attrs []tg.DocumentAttributeClass
+	)
+	switch {
+	case bm.Video != nil:
+		f = bm.Video
+		attrs = append(attrs, &tg.DocumentAttributeVideo{W: f.Width, H: f.Height, Duration: float64(f.Duration)})
+	case bm.VideoNote != nil:
+		f = bm.VideoNote
+		attrs = append(attrs, &tg.DocumentAttributeVideo{RoundMessage: true, W: f.Length, H: f.Length, Duration: float64(f.Duration)})
+	case bm.Animation != nil:
+		f = bm.Animation
+		attrs = append(attrs, &tg.DocumentAttributeVideo{W: f.Width, H: f.Height, Duration: float64(f.Duration)}, &tg.DocumentAttributeAnimated{})
+	case bm.Audio != nil:
+		f = bm.Audio
+		attrs = append(attrs, &tg.DocumentAttributeAudio{Duration: f.Duration})
+	case bm.Voice != nil:
+		f = bm.Voice
+		attrs = append(attrs, &tg.DocumentAttributeAudio{Voice: true, Duration: f.Duration})
+	case bm.Document != nil:
+		f = bm.Document
+	default:
+		return nil, false
+	}
+	if f.FileName != "" {
+		attrs = append(attrs, &tg.DocumentAttributeFilename{FileName: f.FileName})
+	}
+	return botDocument(id, f.MimeType, f.FileSize, attrs...), true
+}
+
+func botDocument(id int64, mimeType string, size int64, attrs ...tg.DocumentAttributeClass) *tg.MessageMediaDocument {
+	doc := &tg.MessageMediaDocument{}
+	doc.SetDocument(&tg.Document{ID: id, MimeType: mimeType, Size: size, Attributes: attrs})
+	return doc
+}
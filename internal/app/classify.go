@@ -0,0 +1,52 @@
+package app
+
+import (
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+)
+
+// Reasons a message of an update is skipped, counted in
+// tg_watcher_updates_skipped_total.
+const (
+	skipEmpty   = "empty"   // messageEmpty: deleted or inaccessible messages
+	skipService = "service" // service messages without an event: edited, in history or other actions
+	skipPeer    = "peer"    // a peer the update can't carry, e.g. a user in a channel update
+	skipUnknown = "unknown" // message types added to the schema later
+)
+
+// classified is the message of an update sorted out by its type, exactly
+// one of msg and service is set. messageType is the event type of msg,
+// edits of live locations are sent as liveLocation.
+type classified struct {
+	msg         *tg.Message
+	service     *tg.MessageService
+	messageType string
+}
+
+// classify sorts out every variant of a message of an update of
+// messageType: regular messages and new service messages are handled, all
+// other payloads are skipped and counted by reason.
+func classify(message tg.MessageClass, messageType string) (classified, bool) {
+	switch m := message.(type) {
+	case *tg.Message:
+		if messageType == "editMessage" && event.IsLiveLocation(m) {
+			messageType = "liveLocation"
+		}
+		return classified{msg: m, messageType: messageType}, true
+	case *tg.MessageService:
+		if messageType == "newMessage" {
+			return classified{service: m}, true
+		}
+		skipUpdate(skipService)
+	case *tg.MessageEmpty, nil:
+		skipUpdate(skipEmpty)
+	default:
+		skipUpdate(skipUnknown)
+	}
+	return classified{}, false
+}
+
+func skipUpdate(reason string) {
+	metrics.UpdatesSkipped.WithLabelValues(reason).Inc()
+}
@@ -0,0 +1,489 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/contrib/middleware/floodwait"
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/telegram/query"
+	"github.com/gotd/td/telegram/query/dialogs"
+	"github.com/gotd/td/tg"
+	"github.com/redis/go-redis/v9"
+	"go-tg.com/internal/config"
+	tgService "go-tg.com/internal/services/telegram"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const usage = `Usage: app <command> [flags]
+
+Commands:
+  run            watch the configured chats (default)
+  login          authorize the session interactively and exit
+  fetch          send the history of one channel through the sinks or to a file
+  replay         send archived messages of a channel to a sink again
+  search         search the archived messages of all channels
+  state export   write checkpoints, peer cache, dedup window and queues to a snapshot
+  state import   restore a snapshot on a new host, the watcher must be stopped
+  channels list  print joined channels and groups with their IDs
+
+Run "app <command> -h" for the flags of a command.
+`
+
+// Run executes the command given on the command line. Without a command
+// the watcher is started, so "app -all-messages" keeps working.
+func Run(ctx context.Context) error {
+	err := runCommand(ctx)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+func runCommand(ctx context.Context) error {
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	switch name {
+	case "run":
+		return runWatcher(ctx, args)
+	case "login":
+		return runLogin(ctx, args)
+	case "fetch":
+		return runFetch(ctx, args)
+	case "replay":
+		return runReplay(ctx, args)
+	case "search":
+		return runSearch(ctx, args)
+	case "state":
+		return runState(ctx, args)
+	case "channels":
+		return runChannels(ctx, args)
+	case "help":
+		fmt.Print(usage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// configFlag registers -config on a command. TG_CONFIG is used when the flag is not given.
+func configFlag(flags *flag.FlagSet) *string {
+	return flags.String("config", os.Getenv("TG_CONFIG"), "Path to the config file, by default ./config.yml if present, otherwise environment variables only")
+}
+
+func accountFlag(flags *flag.FlagSet) *string {
+	return flags.String("account", "", "Account to use, required when accounts are configured; <tenant> or <tenant>/<account> with tenants")
+}
+
+// forAccount narrows the config to the account picked with -account, with
+// tenants to the tenant named first.
+func forAccount(cfg *config.Store, name string) (*config.Config, *config.Store, error) {
+	if tenants := cfg.Load().TenantNames(); len(tenants) > 0 {
+		tenantName, accountName, _ := strings.Cut(name, "/")
+		tenant, ok := cfg.Tenant(tenantName)
+		if !ok {
+			return nil, nil, fmt.Errorf("-account must start with a tenant, one of: %s", strings.Join(tenants, ", "))
+		}
+		cfg, name = tenant, accountName
+	}
+	names := cfg.Load().AccountNames()
+	if len(names) == 0 {
+		if name != "" {
+			return nil, nil, errors.New("-account is set, but no accounts are configured")
+		}
+		return cfg.Load(), cfg, nil
+	}
+	account, ok := cfg.Account(name)
+	if !ok {
+		return nil, nil, fmt.Errorf("-account must be one of: %s", strings.Join(names, ", "))
+	}
+	return account.Load(), account, nil
+}
+
+// loadConfig reads the config and checks it with validate unless it is nil.
+func loadConfig(path string, validate func(*config.Config) error) (*config.Config, *config.Store, error) {
+	path = config.ResolvePath(path)
+	cfg, err := config.Init(path)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read config")
+	}
+	if validate != nil {
+		if err := validate(cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+	return cfg, config.NewStore(path, cfg), nil
+}
+
+// newLogger builds the logger from the log section: human readable console
+// lines or one JSON object per line for log collectors, written to stderr,
+// stdout or appended to a file.
+func newLogger(cfg config.LogConfig) (*zap.Logger, error) {
+	level, err := zapcore.ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, errors.Wrap(err, "log level")
+	}
+
+	zc := zap.NewDevelopmentConfig()
+	if cfg.Format == "json" {
+		zc = zap.NewProductionConfig()
+		zc.Sampling = nil
+		zc.EncoderConfig.TimeKey = "time"
+		zc.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+	}
+	zc.Level = zap.NewAtomicLevelAt(level)
+	if cfg.Output != "" {
+		zc.OutputPaths = []string{cfg.Output}
+	}
+
+	log, err := zc.Build(zap.AddStacktrace(zapcore.FatalLevel))
+	if err != nil {
+		return nil, errors.Wrap(err, "logger")
+	}
+	return log, nil
+}
+
+// authorize logs the client in unless the session is authorized already.
+// A configured bot token or phone makes the login non-interactive, a
+// configured 2FA password is used by the terminal login as well.
+func authorize(ctx context.Context, client *telegram.Client, cfg config.AuthConfig) error {
+	status, err := client.Auth().Status(ctx)
+	if err != nil {
+		return errors.Wrap(err, "auth status")
+	}
+	if status.Authorized {
+		return nil
+	}
+	if cfg.BotToken != "" {
+		if _, err := client.Auth().Bot(ctx, cfg.BotToken); err != nil {
+			return errors.Wrap(err, "bot login")
+		}
+		return nil
+	}
+	if cfg.Password != "" {
+		// A session whose login stopped at the 2FA step, e.g. by a restart,
+		// only needs the SRP password check, no new code.
+		if _, err := client.Auth().Password(ctx, cfg.Password); err == nil {
+			return nil
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	var user auth.UserAuthenticator = tgService.Terminal{PasswordText: cfg.Password}
+	if cfg.Phone != "" {
+		user = tgService.NonInteractive{
+			PhoneNumber:  cfg.Phone,
+			PasswordText: cfg.Password,
+			CodeText:     cfg.Code,
+			CodeFile:     cfg.CodeFile,
+		}
+	}
+	err = client.Auth().IfNecessary(ctx, auth.NewFlow(user, auth.SendCodeOptions{}))
+	if errors.Is(err, auth.ErrPasswordInvalid) {
+		return errors.Wrap(err, "2FA password rejected, check tg_app.auth.password")
+	}
+	return err
+}
+
+func newSessionStorage(ctx context.Context, cfg config.SessionConfig) (session.Storage, error) {
+	var storage session.Storage
+	switch cfg.Storage {
+	case "", "file":
+		storage = &session.FileStorage{Path: cfg.Path}
+	case "redis":
+		storage = tgService.NewRedisSessionStorage(&redis.Options{
+			Addr:     cfg.Redis.Addr,
+			Username: cfg.Redis.Username,
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		}, cfg.Key)
+	case "postgres":
+		pg, err := tgService.NewPostgresSessionStorage(ctx, cfg.PostgresDSN, cfg.Key)
+		if err != nil {
+			return nil, err
+		}
+		storage = pg
+	case "s3":
+		s3, err := tgService.NewS3SessionStorage(tgService.S3SessionOptions{
+			Endpoint:  cfg.S3.Endpoint,
+			Region:    cfg.S3.Region,
+			Bucket:    cfg.S3.Bucket,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			UseSSL:    cfg.S3.UseSSL,
+			Key:       cfg.Key,
+		})
+		if err != nil {
+			return nil, err
+		}
+		storage = s3
+	default:
+		return nil, fmt.Errorf("unknown session storage %q", cfg.Storage)
+	}
+
+	if cfg.EncryptionKey == "" {
+		return storage, nil
+	}
+	return tgService.NewEncryptedStorage(storage, cfg.EncryptionKey)
+}
+
+// newClient creates the Telegram client with flood wait handling and rate
+// limiting, extra middlewares run after them. The returned waiter must wrap
+// client.Run.
+func newClient(ctx context.Context, cfg *config.Config, log *zap.Logger, handler telegram.UpdateHandler, extra ...telegram.Middleware) (*telegram.Client, *floodwait.Waiter, error) {
+	sessionStorage, err := newSessionStorage(ctx, cfg.Session)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "session storage")
+	}
+
+	waiter := newFloodWaiter(cfg.TgApp.RateLimit, log.Named("floodwait"))
+	middlewares := []telegram.Middleware{waiter}
+	if retry := retryCalls(cfg.TgApp.RateLimit.Retry, log.Named("retry")); retry != nil {
+		middlewares = append(middlewares, retry)
+	}
+	if limiter := rateLimit(cfg.TgApp.RateLimit); limiter != nil {
+		middlewares = append(middlewares, limiter)
+	}
+	middlewares = append(middlewares, extra...)
+	middlewares = append(middlewares, countFloodWait())
+
+	resolver, err := newResolver(cfg.TgApp.Proxy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client := telegram.NewClient(cfg.TgApp.AppId, cfg.TgApp.AppHash, telegram.Options{
+		SessionStorage: sessionStorage,
+		Logger:         log,
+		UpdateHandler:  handler,
+		Middlewares:    middlewares,
+		Resolver:       resolver,
+	})
+	return client, waiter, nil
+}
+
+// runLogin only authorizes the session, so the watcher can later be started
+// without a terminal.
+func runLogin(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	configPath := configFlag(flags)
+	accountName := accountFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	_, cfg, err := loadConfig(*configPath, (*config.Config).ValidateClient)
+	if err != nil {
+		return err
+	}
+	initialCfg, _, err := forAccount(cfg, *accountName)
+	if err != nil {
+		return err
+	}
+	log, err := newLogger(initialCfg.Log)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = log.Sync() }()
+
+	unlock, err := lockSession(initialCfg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	client, waiter, err := newClient(ctx, initialCfg, log, nil)
+	if err != nil {
+		return err
+	}
+	return waiter.Run(ctx, func(ctx context.Context) error {
+		return client.Run(ctx, func(ctx context.Context) error {
+			if err := authorize(ctx, client, initialCfg.TgApp.Auth); err != nil {
+				return errors.Wrap(err, "auth")
+			}
+			user, err := client.Self(ctx)
+			if err != nil {
+				return errors.Wrap(err, "call self")
+			}
+			log.Info("Logged in", zap.Int64("user_id", user.ID), zap.String("username", user.Username))
+			return nil
+		})
+	})
+}
+
+// runFetch sends the history of a single channel through the configured sinks
+// or exports it to a file. Deliveries that fail stay in the outbox and are
+// retried by the next run.
+func runFetch(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	configPath := configFlag(flags)
+	channel := flags.String("channel", "", "Channel ID or @username")
+	accountName := accountFlag(flags)
+	var rng historyRange
+	flags.IntVar(&rng.From, "from", 0, "Newest message ID to send (inclusive), latest when 0, same as -max-id")
+	flags.IntVar(&rng.To, "to", 0, "Oldest message ID to send (inclusive), first when 0, same as -min-id")
+	rangeFlags(flags, &rng)
+	output := flags.String("output", "", "Write the history to this file instead of the sinks, CSV for a .csv extension, JSON lines otherwise")
+	send := flags.Bool("send", false, "With -output, deliver the history through the sinks as well")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *channel == "" {
+		return errors.New("-channel is required")
+	}
+	if *send && *output == "" {
+		return errors.New("-send requires -output")
+	}
+	if err := rng.validate(); err != nil {
+		return err
+	}
+
+	_, rootCfg, err := loadConfig(*configPath, (*config.Config).ValidateClient)
+	if err != nil {
+		return err
+	}
+	initialCfg, cfg, err := forAccount(rootCfg, *accountName)
+	if err != nil {
+		return err
+	}
+	log, err := newLogger(initialCfg.Log)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = log.Sync() }()
+
+	watched := config.ParseChannel(*channel)
+	if known, ok := initialCfg.FindChannel(watched.ID, watched.NormalizedUsername()); ok {
+		watched = known
+	} else if known, ok := initialCfg.FindInvite(watched.InviteHash()); ok {
+		watched = known
+	}
+
+	unlock, err := lockSession(initialCfg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	client, waiter, err := newClient(ctx, initialCfg, log, nil)
+	if err != nil {
+		return err
+	}
+	out, closeOutputs, err := newOutputs(ctx, cfg, log, false)
+	if err != nil {
+		return err
+	}
+	defer closeOutputs()
+	w, err := newWatcher(cfg, log, tg.NewClient(client), out)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		w.export, err = newHistoryExport(*output, *send)
+		if err != nil {
+			return errors.Wrap(err, "open output")
+		}
+		defer func() { _ = w.export.out.Close() }()
+	}
+
+	return waiter.Run(ctx, func(ctx context.Context) error {
+		return client.Run(ctx, func(ctx context.Context) error {
+			if err := authorize(ctx, client, initialCfg.TgApp.Auth); err != nil {
+				return errors.Wrap(err, "auth")
+			}
+			resolved, err := w.resolveChannel(ctx, watched)
+			if err != nil {
+				return err
+			}
+			if err := w.fetchChannelHistory(ctx, watched, resolved, rng); err != nil {
+				return errors.Wrapf(err, "fetch history of %s", watched)
+			}
+			w.outbox.Wait()
+			log.Info("Fetch done", zap.Stringer("channel", watched), zap.Int("outbox_depth", w.outbox.Depth()))
+			return nil
+		})
+	})
+}
+
+// runChannels lists joined channels and groups, so their IDs can be put into the config.
+func runChannels(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		return errors.New(`expected "channels list"`)
+	}
+	flags := flag.NewFlagSet("channels list", flag.ContinueOnError)
+	configPath := configFlag(flags)
+	accountName := accountFlag(flags)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	_, cfg, err := loadConfig(*configPath, (*config.Config).ValidateClient)
+	if err != nil {
+		return err
+	}
+	initialCfg, _, err := forAccount(cfg, *accountName)
+	if err != nil {
+		return err
+	}
+	log, err := newLogger(initialCfg.Log)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = log.Sync() }()
+
+	unlock, err := lockSession(initialCfg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	client, waiter, err := newClient(ctx, initialCfg, log, nil)
+	if err != nil {
+		return err
+	}
+	return waiter.Run(ctx, func(ctx context.Context) error {
+		return client.Run(ctx, func(ctx context.Context) error {
+			if err := authorize(ctx, client, initialCfg.TgApp.Auth); err != nil {
+				return errors.Wrap(err, "auth")
+			}
+
+			out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(out, "TYPE\tID\tUSERNAME\tTITLE")
+			err := query.GetDialogs(client.API()).BatchSize(100).ForEach(ctx, func(ctx context.Context, elem dialogs.Elem) error {
+				switch p := elem.Peer.(type) {
+				case *tg.InputPeerChannel:
+					ch, ok := elem.Entities.Channel(p.ChannelID)
+					if !ok {
+						return nil
+					}
+					kind := "channel"
+					if ch.Megagroup {
+						kind = "supergroup"
+					}
+					fmt.Fprintf(out, "%s\t%d\t%s\t%s\n", kind, ch.ID, ch.Username, ch.Title)
+				case *tg.InputPeerChat:
+					chat, ok := elem.Entities.Chat(p.ChatID)
+					if !ok {
+						return nil
+					}
+					fmt.Fprintf(out, "chat\t%d\t\t%s\n", chat.ID, chat.Title)
+				}
+				return nil
+			})
+			if err != nil {
+				return errors.Wrap(err, "list dialogs")
+			}
+			return out.Flush()
+		})
+	})
+}
@@ -0,0 +1,111 @@
+package app
+
+import (
+	"context"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// handleComment forwards new messages of the discussion group of a watched
+// channel as comments under the channel post they reply to. Copies of the
+// posts in the group are only remembered, other messages are ignored.
+func (w *watcher) handleComment(ctx context.Context, cfg *config.Config, group *tg.Channel, msg *tg.Message, messageType string) error {
+	if messageType != "newMessage" {
+		return nil
+	}
+	channel, watched, ok := w.linkedChannel(ctx, cfg, group)
+	if !ok || !watched.Accepts("comment") {
+		return nil
+	}
+	chat := event.ChannelChat(group)
+	if post, ok := event.ChannelPostOf(msg, channel.GetID()); ok {
+		w.posts.swap(messageKey{chatID: chat.ID, messageID: msg.GetID()}, post)
+		return nil
+	}
+	thread, ok := event.ThreadOf(msg)
+	if !ok {
+		return nil
+	}
+	post, ok := w.postOf(ctx, chat, channel, thread)
+	if !ok {
+		return nil
+	}
+
+	metrics.MessagesReceived.WithLabelValues("comment").Inc()
+	if !w.passesFilter(ctx, watched, chat, msg.GetMessage(), msg) {
+		w.log.Debug("Comment filtered out", zap.Int64("chat_id", chat.ID), zap.Int("message_id", msg.GetID()))
+		return nil
+	}
+	comment := &event.Comment{ChannelID: channel.GetID(), ChannelUsername: channel.Username, PostID: post}
+	if err := w.sendComment(ctx, cfg, cfg.WebhookUrlFor(watched), chat, msg, comment); err != nil {
+		w.log.Error("Error sending comment", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+	}
+	w.log.Info("Comment", zap.Int64("channel_id", channel.GetID()), zap.Int("post_id", post), zap.Any("text", msg.GetMessage()))
+	return nil
+}
+
+// linkedChannel returns the watched channel with comments that group is the
+// discussion group of. Telegram marks linked groups, each is looked up once.
+func (w *watcher) linkedChannel(ctx context.Context, cfg *config.Config, group *tg.Channel) (*tg.Channel, config.ChannelConfig, bool) {
+	if !group.Megagroup || !group.HasLink || !watchesComments(cfg) {
+		return nil, config.ChannelConfig{}, false
+	}
+	linkedID, ok := w.discussions.get(group.GetID())
+	if !ok {
+		full, err := w.api.ChannelsGetFullChannel(ctx, group.AsInput())
+		if err != nil {
+			w.log.Warn("Get linked channel of group", zap.Int64("chat_id", group.GetID()), zap.Error(w.channels.InvalidateOn(group.GetID(), err)))
+			return nil, config.ChannelConfig{}, false
+		}
+		if channelFull, ok := full.FullChat.(*tg.ChannelFull); ok {
+			linkedID, _ = channelFull.GetLinkedChatID()
+		}
+		w.discussions.swap(group.GetID(), linkedID)
+	}
+	if linkedID == 0 {
+		return nil, config.ChannelConfig{}, false
+	}
+	channel, err := w.channels.Get(ctx, linkedID)
+	if err != nil {
+		w.log.Warn("Get linked channel", zap.Int64("channel_id", linkedID), zap.Error(err))
+		return nil, config.ChannelConfig{}, false
+	}
+	watched, ok := w.findChannel(cfg, channel)
+	if !ok || !watched.Comments {
+		return nil, config.ChannelConfig{}, false
+	}
+	return channel, watched, true
+}
+
+// postOf returns the channel post whose copy in the discussion group starts
+// the thread, fetching the copy when it came before the start.
+func (w *watcher) postOf(ctx context.Context, group event.Chat, channel *tg.Channel, thread int) (int, bool) {
+	key := messageKey{chatID: group.ID, messageID: thread}
+	if post, ok := w.posts.get(key); ok {
+		return post, post != 0
+	}
+	found, err := w.getMessages(ctx, group, []int{thread})
+	if err != nil {
+		w.log.Warn("Fetch thread of comment", zap.Int64("chat_id", group.ID), zap.Int("message_id", thread), zap.Error(err))
+		return 0, false
+	}
+	post := 0
+	if len(found) > 0 {
+		post, _ = event.ChannelPostOf(found[0], channel.GetID())
+	}
+	w.posts.swap(key, post)
+	return post, post != 0
+}
+
+func watchesComments(cfg *config.Config) bool {
+	for _, ch := range cfg.WatchedChannels() {
+		if ch.Comments {
+			return true
+		}
+	}
+	return false
+}
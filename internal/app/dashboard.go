@@ -0,0 +1,198 @@
+package app
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/stream"
+	"go.uber.org/zap/zapcore"
+)
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+// maxDashboardText is how many characters of an event text the dashboard shows.
+const maxDashboardText = 280
+
+// dashboard serves the status page of the HTTP server: connection status of
+// the accounts, watched channels, the latest events of every chat, the
+// outbox depth and the latest warnings and errors. It only keeps the
+// events and log entries, the rest is read on request.
+type dashboard struct {
+	cfg      config.DashboardConfig
+	started  time.Time
+	accounts []*account
+	tenants  []*tenant
+
+	mux    sync.Mutex
+	chats  map[int64]*dashboardChat
+	errors []dashboardLog
+}
+
+type dashboardChat struct {
+	ID       int64            `json:"id"`
+	Type     string           `json:"type,omitempty"`
+	Username string           `json:"username,omitempty"`
+	Title    string           `json:"title,omitempty"`
+	Events   []dashboardEvent `json:"events"`
+}
+
+type dashboardEvent struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	ExternalID string    `json:"external_id"`
+	Text       string    `json:"text,omitempty"`
+}
+
+type dashboardLog struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Logger  string    `json:"logger,omitempty"`
+	Message string    `json:"message"`
+}
+
+type dashboardAccount struct {
+	Name     string             `json:"name,omitempty"`
+	Health   healthStatus       `json:"health"`
+	Alive    bool               `json:"alive"`
+	Ready    bool               `json:"ready"`
+	Channels []dashboardChannel `json:"channels"`
+}
+
+// dashboardChannel is a watched channel as configured, invite links are
+// left out.
+type dashboardChannel struct {
+	Peer     string `json:"peer"`
+	ID       int64  `json:"id,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+type dashboardStatus struct {
+	Started  time.Time          `json:"started"`
+	Accounts []dashboardAccount `json:"accounts"`
+	Queue    map[string]int     `json:"queue"`
+	Chats    []*dashboardChat   `json:"chats"`
+	Errors   []dashboardLog     `json:"errors"`
+}
+
+func newDashboard(cfg config.DashboardConfig) *dashboard {
+	return &dashboard{cfg: cfg, started: time.Now(), chats: map[int64]*dashboardChat{}}
+}
+
+// run records the events of the stream until ctx is done.
+func (d *dashboard) run(ctx context.Context, hub *stream.Hub) {
+	events, cancel := hub.Subscribe(stream.Filter{})
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-events:
+			d.record(e)
+		}
+	}
+}
+
+func (d *dashboard) record(e *event.Event) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	chat, ok := d.chats[e.ChannelID]
+	if !ok {
+		chat = &dashboardChat{ID: e.ChannelID}
+		d.chats[e.ChannelID] = chat
+	}
+	chat.Type = e.ChatType
+	if e.ChannelUsername != "" {
+		chat.Username = e.ChannelUsername
+	}
+	if e.ChannelTitle != "" {
+		chat.Title = e.ChannelTitle
+	}
+	text := []rune(e.Text)
+	if len(text) > maxDashboardText {
+		text = append(text[:maxDashboardText], '…')
+	}
+	chat.Events = appendRecent(chat.Events, dashboardEvent{
+		Time:       time.Now(),
+		Type:       e.Type,
+		ExternalID: e.ExternalID,
+		Text:       string(text),
+	}, d.cfg.RecentEvents)
+}
+
+// logged is a zap hook keeping warnings and errors.
+func (d *dashboard) logged(entry zapcore.Entry) error {
+	if entry.Level < zapcore.WarnLevel {
+		return nil
+	}
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.errors = appendRecent(d.errors, dashboardLog{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Logger:  entry.LoggerName,
+		Message: entry.Message,
+	}, d.cfg.RecentErrors)
+	return nil
+}
+
+// appendRecent appends v and drops the oldest entries over limit.
+func appendRecent[T any](list []T, v T, limit int) []T {
+	list = append(list, v)
+	if len(list) > limit {
+		list = slices.Delete(list, 0, len(list)-limit)
+	}
+	return list
+}
+
+func (d *dashboard) status() dashboardStatus {
+	s := dashboardStatus{Started: d.started, Queue: queueDepths(d.tenants)}
+	for _, a := range d.accounts {
+		da := dashboardAccount{
+			Name:   a.name,
+			Health: a.health.status(),
+			Alive:  a.health.alive(),
+			Ready:  a.health.ready(),
+		}
+		for _, ch := range a.cfg.Load().WatchedChannels() {
+			da.Channels = append(da.Channels, dashboardChannel{Peer: ch.PeerType(), ID: ch.ID, Username: ch.NormalizedUsername()})
+		}
+		s.Accounts = append(s.Accounts, da)
+	}
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	for _, chat := range d.chats {
+		c := *chat
+		c.Events = slices.Clone(chat.Events)
+		slices.Reverse(c.Events)
+		s.Chats = append(s.Chats, &c)
+	}
+	// Chats with the latest events first.
+	slices.SortFunc(s.Chats, func(a, b *dashboardChat) int {
+		return b.Events[0].Time.Compare(a.Events[0].Time)
+	})
+	s.Errors = slices.Clone(d.errors)
+	slices.Reverse(s.Errors)
+	return s
+}
+
+// handlePage serves GET /dashboard, the page polls /dashboard/status.
+func (d *dashboard) handlePage(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = rw.Write(dashboardPage)
+}
+
+// handleStatus serves GET /dashboard/status.
+func (d *dashboard) handleStatus(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(rw).Encode(d.status())
+}
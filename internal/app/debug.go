@@ -0,0 +1,131 @@
+package app
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// debugAPI serves the Go profiler and a dump of the internal state, to find
+// out about memory growth and stalls, see http.debug. Requests always need
+// the admin token.
+type debugAPI struct {
+	token   string
+	started time.Time
+	tenants []*tenant
+}
+
+type debugState struct {
+	Time       time.Time      `json:"time"`
+	Started    time.Time      `json:"started"`
+	Goroutines int            `json:"goroutines"`
+	Memory     debugMemory    `json:"memory"`
+	Queue      map[string]int `json:"queue"`
+	Accounts   []debugAccount `json:"accounts"`
+}
+
+type debugMemory struct {
+	HeapAlloc   uint64 `json:"heap_alloc"`
+	HeapInuse   uint64 `json:"heap_inuse"`
+	HeapObjects uint64 `json:"heap_objects"`
+	Sys         uint64 `json:"sys"`
+	NumGC       uint32 `json:"num_gc"`
+}
+
+type debugAccount struct {
+	Name        string         `json:"name,omitempty"`
+	Tenant      string         `json:"tenant,omitempty"`
+	Connected   bool           `json:"connected"`
+	CachedPeers int            `json:"cached_peers"`
+	Recent      map[string]int `json:"recent"`
+	Albums      int            `json:"albums"`
+	Chats       []debugChat    `json:"chats"`
+}
+
+// debugChat is a chat with the time of its latest message update, its
+// message rate per minute and the latency of its latest delivered message.
+type debugChat struct {
+	ID           int64      `json:"id"`
+	LastUpdate   time.Time  `json:"last_update"`
+	Messages     int64      `json:"messages"`
+	Rate         float64    `json:"rate_per_minute"`
+	Latency      float64    `json:"latency_seconds,omitempty"`
+	LastDelivery *time.Time `json:"last_delivery,omitempty"`
+}
+
+// register adds the debug endpoints to mux, behind the admin token.
+func (api debugAPI) register(mux *http.ServeMux) {
+	protect := func(h http.HandlerFunc) http.HandlerFunc {
+		return withToken(api.token, h)
+	}
+	mux.HandleFunc("/debug/pprof/", protect(pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", protect(pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", protect(pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", protect(pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", protect(pprof.Trace))
+	mux.HandleFunc("GET /debug/state", protect(api.handleState))
+}
+
+// handleState serves GET /debug/state.
+func (api debugAPI) handleState(rw http.ResponseWriter, _ *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	state := debugState{
+		Time:       time.Now(),
+		Started:    api.started,
+		Goroutines: runtime.NumGoroutine(),
+		Memory: debugMemory{
+			HeapAlloc:   mem.HeapAlloc,
+			HeapInuse:   mem.HeapInuse,
+			HeapObjects: mem.HeapObjects,
+			Sys:         mem.Sys,
+			NumGC:       mem.NumGC,
+		},
+		Queue:    queueDepths(api.tenants),
+		Accounts: []debugAccount{},
+	}
+	for _, t := range api.tenants {
+		for _, a := range t.accounts {
+			state.Accounts = append(state.Accounts, debugAccountOf(t, a))
+		}
+	}
+	writeStatus(rw, true, state)
+}
+
+func debugAccountOf(t *tenant, a *account) debugAccount {
+	w := a.w
+	da := debugAccount{
+		Name:        a.name,
+		Tenant:      t.name,
+		Connected:   a.health.connected.Load(),
+		CachedPeers: w.channels.Len(),
+		Recent: map[string]int{
+			"reactions":    w.reactions.len(),
+			"polls":        w.polls.len(),
+			"peer_names":   w.peerNames.len(),
+			"replies":      w.replies.len(),
+			"texts":        w.texts.len(),
+			"hashes":       w.hashes.len(),
+			"discussions":  w.discussions.len(),
+			"posts":        w.posts.len(),
+			"pages":        w.pages.len(),
+			"sticker_sets": w.stickerSets.len(),
+			"stats":        w.stats.len(),
+			"snapshots":    w.snapshots.len(),
+		},
+		Albums: w.albums.len(),
+		Chats:  []debugChat{},
+	}
+	for id, traffic := range w.activity.snapshot(time.Now()) {
+		chat := debugChat{ID: id, LastUpdate: traffic.LastUpdate, Messages: traffic.Messages, Rate: traffic.Rate}
+		if lag, ok := t.out.lag.get(id); ok {
+			chat.Latency, chat.LastDelivery = lag.latency.Seconds(), &lag.delivered
+		}
+		da.Chats = append(da.Chats, chat)
+	}
+	// Stalled chats first.
+	sort.Slice(da.Chats, func(i, j int) bool { return da.Chats[i].LastUpdate.Before(da.Chats[j].LastUpdate) })
+	return da
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/query"
+	"github.com/gotd/td/telegram/query/dialogs"
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+)
+
+const defaultDiscoveryInterval = 10 * time.Minute
+
+// discoverChannels looks for joined chats matching the discovery patterns
+// right away and then every interval until ctx is done. Patterns and the
+// interval may change on reload.
+func (w *watcher) discoverChannels(ctx context.Context) {
+	for {
+		cfg := w.cfg.Load()
+		if len(cfg.Discovery.Patterns) > 0 {
+			if err := w.discover(ctx, cfg); err != nil && ctx.Err() == nil {
+				w.log.Warn("Channel discovery failed", zap.Error(err))
+			}
+		}
+
+		interval := cfg.Discovery.Interval
+		if interval <= 0 {
+			interval = defaultDiscoveryInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// discover watches the joined channels and supergroups whose title matches
+// a pattern and that are not watched yet, and announces each of them.
+func (w *watcher) discover(ctx context.Context, cfg *config.Config) error {
+	titles, err := w.filters.Get(config.FilterConfig{IncludePatterns: cfg.Discovery.Patterns, CaseSensitive: cfg.Discovery.CaseSensitive})
+	if err != nil {
+		return errors.Wrap(err, "discovery patterns")
+	}
+	err = query.GetDialogs(w.api).BatchSize(100).ForEach(ctx, func(ctx context.Context, elem dialogs.Elem) error {
+		p, ok := elem.Peer.(*tg.InputPeerChannel)
+		if !ok {
+			return nil
+		}
+		channel, ok := elem.Entities.Channel(p.ChannelID)
+		if !ok || w.discovered.Has(channel.ID) || !titles.Match(channel.Title) {
+			return nil
+		}
+		if _, watched := w.findChannel(cfg, channel); watched {
+			return nil
+		}
+		w.channels.Put(channel)
+		if err := w.discovered.Add(channel.ID, channel.Title); err != nil {
+			return errors.Wrap(err, "save discovered channel")
+		}
+		metrics.ChannelsDiscovered.Inc()
+		w.log.Info("Channel discovered", zap.Int64("channel_id", channel.ID), zap.String("title", channel.Title))
+
+		watched, _ := w.discoveredChannel(cfg, channel.ID)
+		if !watched.Accepts("channelDiscovered") {
+			return nil
+		}
+		if err := w.deliver(ctx, cfg.WebhookUrlFor(watched), event.ChannelDiscovered(event.ChannelChat(channel))); err != nil {
+			w.log.Error("Error sending discovered channel", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+		}
+		return nil
+	})
+	return errors.Wrap(err, "list dialogs")
+}
+
+// discoveredChannel returns the settings of a channel found by discovery,
+// they apply while discovery is configured.
+func (w *watcher) discoveredChannel(cfg *config.Config, channelID int64) (config.ChannelConfig, bool) {
+	if len(cfg.Discovery.Patterns) == 0 || !w.discovered.Has(channelID) {
+		return config.ChannelConfig{}, false
+	}
+	watched := cfg.Discovery.Channel
+	watched.ID = channelID
+	return watched, true
+}
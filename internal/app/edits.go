@@ -0,0 +1,47 @@
+package app
+
+import (
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/storage"
+	"go.uber.org/zap"
+)
+
+// rememberText records the text a watched message arrived with and returns
+// the one it had before, so an edit can be compared against it without
+// the archive.
+func (w *watcher) rememberText(chat event.Chat, msg *tg.Message) (string, bool) {
+	return w.texts.swap(messageKey{chatID: chat.ID, messageID: msg.GetID()}, msg.GetMessage())
+}
+
+// editOf describes what an edit changed. The previous text comes from the
+// archived version or else from the messages seen since the start, edits of
+// messages seen neither way are sent without it.
+func (w *watcher) editOf(chat event.Chat, msg *tg.Message, messageType string, archived *storage.Message) *event.Edit {
+	previous, ok := w.rememberText(chat, msg)
+	if messageType != "editMessage" {
+		return nil
+	}
+	if archived != nil {
+		previous, ok = archived.Text, true
+	}
+	if !ok {
+		return nil
+	}
+	return event.EditOf(previous, msg.GetMessage())
+}
+
+// unchanged reports edits that left the text and media of a message as
+// they were, e.g. when only its reactions or views were updated. They are
+// suppressed. Edits of messages not seen since the start are sent.
+func (w *watcher) unchanged(chat event.Chat, msg *tg.Message, messageType string) bool {
+	hash := event.ContentHash(msg)
+	previous, ok := w.hashes.swap(messageKey{chatID: chat.ID, messageID: msg.GetID()}, hash)
+	if messageType != "editMessage" || !ok || previous != hash {
+		return false
+	}
+	metrics.MessagesSuppressed.WithLabelValues("unchanged").Inc()
+	w.log.Debug("Unchanged edit skipped", zap.Int64("chat_id", chat.ID), zap.Int("message_id", msg.GetID()))
+	return true
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/sink"
+)
+
+// historyExport writes fetched history to a local file, with send the
+// messages are delivered through the sinks as well.
+type historyExport struct {
+	out  sink.Sink
+	send bool
+}
+
+// newHistoryExport opens path as CSV for a .csv extension and as JSON lines
+// otherwise. Existing files are appended to.
+func newHistoryExport(path string, send bool) (*historyExport, error) {
+	var (
+		out sink.Sink
+		err error
+	)
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		out, err = sink.NewCSV(path)
+	} else {
+		out, err = sink.NewFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &historyExport{out: out, send: send}, nil
+}
+
+// exportMessage writes msg in the full payload format, so dates and authors
+// are part of the export regardless of payload.format.
+func (w *watcher) exportMessage(ctx context.Context, cfg *config.Config, chat event.Chat, msg *tg.Message) error {
+	payload := cfg.Payload
+	payload.Format = event.FormatFull
+	e := event.FromMessage(payload, chat, msg, "oldMessage")
+	w.describeForward(e)
+	return w.export.out.Send(ctx, "", e)
+}
@@ -0,0 +1,34 @@
+package app
+
+import (
+	"context"
+	"net"
+
+	watcherv1 "go-tg.com/api/watcher/v1"
+	"go-tg.com/internal/rpc"
+	"go-tg.com/internal/stream"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// serveGRPC runs the gRPC event stream until ctx is done.
+func serveGRPC(ctx context.Context, log *zap.Logger, addr string, hub *stream.Hub) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Error("gRPC server", zap.Error(err))
+		return
+	}
+	srv := grpc.NewServer()
+	watcherv1.RegisterEventsServer(srv, rpc.NewServer(hub))
+
+	go func() {
+		<-ctx.Done()
+		// Subscriptions never end on their own, so don't wait for them.
+		srv.Stop()
+	}()
+
+	log.Info("gRPC server started", zap.String("addr", addr))
+	if err := srv.Serve(lis); err != nil {
+		log.Error("gRPC server", zap.Error(err))
+	}
+}
@@ -0,0 +1,359 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/delivery"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/filter"
+	"go-tg.com/internal/media"
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/pipeline"
+	"go-tg.com/internal/schedule"
+	"go-tg.com/internal/search"
+	tgService "go-tg.com/internal/services/telegram"
+	"go-tg.com/internal/storage"
+	"go-tg.com/internal/stream"
+	"go-tg.com/internal/tracing"
+	"go-tg.com/internal/transcribe"
+	"go-tg.com/internal/translate"
+	"go-tg.com/internal/unfurl"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+func entityChannels(e tg.Entities) []*tg.Channel {
+	channels := make([]*tg.Channel, 0, len(e.Channels))
+	for _, ch := range e.Channels {
+		channels = append(channels, ch)
+	}
+	return channels
+}
+
+// watcher holds everything the update handlers need.
+type watcher struct {
+	log         *zap.Logger
+	cfg         *config.Store
+	api         *tg.Client
+	channels    *tgService.ChannelCache
+	outbox      *delivery.Fanout
+	pipeline    *pipeline.Pipeline
+	stream      *stream.Hub
+	throttle    *throttle
+	translator  *translate.Translator
+	transcriber *transcribe.Transcriber
+	processors  []externalProcessor
+	media       *media.Downloader
+	voices      *media.Downloader
+	filters     *filter.Cache
+	schedules   *schedule.Cache
+	archive     *storage.Archive
+	index       *search.Index
+	checkpoints *tgService.Checkpoints
+	discovered  *tgService.Discovered
+	albums      *albumBuffer
+	invites     *inviteLinks
+	reactions   *recentMap[messageKey, []event.Reaction]
+	polls       *recentMap[int64, pollMessage]
+	peerNames   *recentMap[peerKey, peerName]
+	replies     *recentMap[messageKey, *event.Reply]
+	texts       *recentMap[messageKey, string]
+	hashes      *recentMap[messageKey, string]
+	discussions *recentMap[int64, int64]
+	posts       *recentMap[messageKey, int]
+	pages       *recentMap[string, unfurl.Page]
+	stickerSets *recentMap[int64, string]
+	stats       *recentMap[messageKey, statsPost]
+	scheduled   *recentMap[messageKey, scheduledPost] // by scheduled ID
+	snapshots   *recentMap[int64, event.ChannelInfo]
+	activity    *activity
+	self        atomic.Int64 // user ID of the account once logged in
+	pins        *pinnedMessages
+	export      *historyExport
+}
+
+// passesFilter drops the messages of the account itself with
+// tg_app.skip_outgoing, applies the topics, author lists, sampling and text
+// filter of the channel, then the spam filter to the text and msg. A broken
+// pattern is logged and lets the message through so nothing is lost silently.
+func (w *watcher) passesFilter(ctx context.Context, watched config.ChannelConfig, chat event.Chat, text string, msg *tg.Message) bool {
+	_, span := tracing.Start(ctx, "filter")
+	defer span.End()
+
+	if w.cfg.Load().TgApp.SkipOutgoing && w.outgoing(msg) {
+		metrics.MessagesSuppressed.WithLabelValues("outgoing").Inc()
+		span.SetAttributes(attribute.Bool("outgoing", true), attribute.Bool("passed", false))
+		return false
+	}
+	if topic := event.TopicOf(chat, msg); !watched.AcceptsTopic(topic) {
+		span.SetAttributes(attribute.Int("topic", topic), attribute.Bool("passed", false))
+		return false
+	}
+	if id, username := w.authorOf(msg); !watched.FromUsers.Accepts(id, username) {
+		span.SetAttributes(attribute.Int64("author", id), attribute.Bool("passed", false))
+		return false
+	}
+	if !watched.Sample.Keeps(msg.GetID()) {
+		metrics.MessagesSuppressed.WithLabelValues("sampled").Inc()
+		span.SetAttributes(attribute.Bool("sampled", true), attribute.Bool("passed", false))
+		return false
+	}
+	f, err := w.filters.Get(watched.Filter)
+	if err != nil {
+		w.log.Error("Bad filter", zap.Stringer("channel", watched), zap.Error(err))
+		return true
+	}
+	passed := f.Match(text)
+	if spam := w.cfg.Load().Spam; passed && spam.Enabled {
+		if reason := w.filters.Spam(spam).Check(text, mediaType(msg)); reason != "" {
+			metrics.MessagesSuppressed.WithLabelValues(reason).Inc()
+			span.SetAttributes(attribute.String("spam", reason))
+			passed = false
+		}
+	}
+	span.SetAttributes(attribute.Bool("passed", passed))
+	return passed
+}
+
+// mediaType returns the type of the media of msg, empty without media.
+func mediaType(msg *tg.Message) string {
+	if m := media.Describe(msg); m != nil {
+		return m.Type
+	}
+	return ""
+}
+
+// archiveMessage stores the message if the archive is enabled, and its
+// text in the search index when that is too, and returns the version stored
+// before, if any. Failures are only logged, the archive must not hold back
+// delivery. The text is stored redacted.
+func (w *watcher) archiveMessage(ctx context.Context, chat event.Chat, msg *tg.Message) *storage.Message {
+	if w.archive == nil {
+		return nil
+	}
+	if r := w.redactor(); r != nil {
+		redacted := *msg
+		redacted.Message = r.Redact(msg.Message)
+		msg = &redacted
+	}
+	raw, err := json.Marshal(msg)
+	if err != nil {
+		w.log.Error("Encode message for archive", zap.Error(err))
+		return nil
+	}
+	editDate, _ := msg.GetEditDate()
+	prev, err := w.archive.Save(ctx, storage.Message{
+		ChannelID: chat.ID,
+		MessageID: msg.GetID(),
+		Text:      msg.GetMessage(),
+		Date:      msg.GetDate(),
+		EditDate:  editDate,
+		Raw:       raw,
+	})
+	if err != nil {
+		w.log.Error("Archive message", zap.Int64("chat_id", chat.ID), zap.Int("message_id", msg.GetID()), zap.Error(err))
+		return prev
+	}
+	if w.index != nil && (prev == nil || prev.Text != msg.GetMessage()) {
+		err := w.index.Add(search.Message{ChannelID: chat.ID, MessageID: msg.GetID(), Text: msg.GetMessage(), Date: msg.GetDate()})
+		if err != nil {
+			w.log.Error("Index message", zap.Int64("chat_id", chat.ID), zap.Int("message_id", msg.GetID()), zap.Error(err))
+		}
+	}
+	return prev
+}
+
+// advanceCheckpoint moves the checkpoint of a channel to messageID, held
+// back while earlier album parts are buffered.
+func (w *watcher) advanceCheckpoint(channelID int64, messageID int) {
+	messageID, ok := w.albums.checkpoint(channelID, messageID)
+	if !ok {
+		return
+	}
+	if err := w.checkpoints.Advance(channelID, messageID); err != nil {
+		w.log.Error("Save checkpoint", zap.Int64("channel_id", channelID), zap.Error(err))
+	}
+}
+
+func (w *watcher) handleChannelMessage(ctx context.Context, message tg.MessageClass, messageType string) error {
+	cfg := w.cfg.Load()
+
+	c, ok := classify(message, messageType)
+	if !ok {
+		return nil
+	}
+	if c.service != nil {
+		return w.handleChannelService(ctx, cfg, c.service)
+	}
+	msg, messageType := c.msg, c.messageType
+
+	ch, ok := msg.GetPeerID().(*tg.PeerChannel)
+	if !ok {
+		skipUpdate(skipPeer)
+		w.log.Debug("Channel update without a channel", zap.Int("message_id", msg.GetID()))
+		return nil
+	}
+	channel, err := w.channels.Get(ctx, ch.ChannelID)
+	if err != nil {
+		w.log.Error("get channel", zap.Error(err))
+		return err
+	}
+
+	watched, ok := w.findChannel(cfg, channel)
+	if !ok {
+		return w.handleComment(ctx, cfg, channel, msg, messageType)
+	}
+	if msg.FromScheduled && messageType == "newMessage" && !watched.ViaBot() {
+		w.publishScheduled(ctx, cfg, watched, event.ChannelChat(channel), msg)
+	}
+	// The bot sends these, see handleBotMessage.
+	if watched.ViaBot() || !watched.Accepts(messageType) {
+		return nil
+	}
+	metrics.MessagesReceived.WithLabelValues(messageType).Inc()
+	chat := event.ChannelChat(channel)
+	w.activity.seen(chat.ID, time.Now())
+	edit := w.editOf(chat, msg, messageType, w.archiveMessage(ctx, chat, msg))
+	w.rememberReactions(chat, msg)
+	w.rememberPoll(chat, msg)
+	if w.unchanged(chat, msg, messageType) {
+		return nil
+	}
+	// Buffered parts advance the checkpoint once the album is sent.
+	if w.bufferAlbum(ctx, cfg, watched, chat, msg, messageType) {
+		return nil
+	}
+	if !w.passesFilter(ctx, watched, chat, msg.GetMessage(), msg) {
+		w.log.Debug("Message filtered out", zap.Int64("channel_id", channel.GetID()), zap.Int("message_id", msg.GetID()))
+		if messageType == "newMessage" {
+			w.advanceCheckpoint(channel.GetID(), msg.GetID())
+		}
+		return nil
+	}
+
+	err = w.sendMessage(ctx, cfg, cfg.WebhookUrlFor(watched), chat, msg, messageType, edit)
+	if err != nil {
+		w.log.Error("Error sending message", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+	} else {
+		w.trackStats(cfg, watched, chat, msg, msg.GetMessage(), messageType)
+		if messageType == "newMessage" {
+			w.advanceCheckpoint(channel.GetID(), msg.GetID())
+		}
+	}
+	w.log.Info("Message", zap.Int64("channel_id", channel.GetID()), zap.Any("text", msg.GetMessage()))
+
+	// The event didn't make it into the outbox, the update stays in the
+	// update log.
+	return err
+}
+
+func (w *watcher) handleDeleteChannelMessages(ctx context.Context, update *tg.UpdateDeleteChannelMessages) error {
+	cfg := w.cfg.Load()
+
+	channel, err := w.channels.Get(ctx, update.ChannelID)
+	if err != nil {
+		w.log.Error("get channel", zap.Error(err))
+		return err
+	}
+
+	watched, ok := w.findChannel(cfg, channel)
+	if !ok || watched.ViaBot() || !watched.Accepts("deleteMessage") {
+		return nil
+	}
+	metrics.MessagesReceived.WithLabelValues("deleteMessage").Inc()
+	if w.archive != nil {
+		if err := w.archive.MarkDeleted(ctx, channel.GetID(), update.Messages); err != nil {
+			w.log.Error("Archive deletion", zap.Int64("channel_id", channel.GetID()), zap.Error(err))
+		}
+	}
+
+	err = w.sendDeletedMessages(ctx, cfg.WebhookUrlFor(watched), event.ChannelChat(channel), update.Messages)
+	if err != nil {
+		w.log.Error("Error sending deleted messages", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+	}
+	w.log.Info("Messages deleted", zap.Int64("channel_id", channel.GetID()), zap.Ints("ids", update.Messages))
+
+	// The event didn't make it into the outbox, the update stays in the
+	// update log.
+	return err
+}
+
+// messageChat describes the private dialog or basic group of a message
+// from the update entities. Entities can be missing for short updates,
+// then only the ID is known.
+func messageChat(e tg.Entities, msg *tg.Message) (event.Chat, bool) {
+	return peerChat(e, msg.GetPeerID())
+}
+
+func peerChat(e tg.Entities, peer tg.PeerClass) (event.Chat, bool) {
+	switch peer := peer.(type) {
+	case *tg.PeerUser:
+		if user, ok := e.Users[peer.UserID]; ok {
+			return event.UserChat(user), true
+		}
+		return event.Chat{Type: config.PeerUser, ID: peer.UserID}, true
+	case *tg.PeerChat:
+		if chat, ok := e.Chats[peer.ChatID]; ok {
+			return event.GroupChat(chat), true
+		}
+		return event.Chat{Type: config.PeerChat, ID: peer.ChatID}, true
+	default:
+		return event.Chat{}, false
+	}
+}
+
+// handleMessage handles messages of private dialogs and basic groups.
+// Their deletions are not forwarded: updateDeleteMessages carries only
+// message IDs without the chat they belonged to.
+func (w *watcher) handleMessage(ctx context.Context, e tg.Entities, message tg.MessageClass, messageType string) error {
+	cfg := w.cfg.Load()
+
+	c, ok := classify(message, messageType)
+	if !ok {
+		return nil
+	}
+	if c.service != nil {
+		return w.handleChatService(ctx, cfg, e, c.service)
+	}
+	msg, messageType := c.msg, c.messageType
+	chat, ok := messageChat(e, msg)
+	if !ok {
+		skipUpdate(skipPeer)
+		return nil
+	}
+
+	watched, ok := cfg.FindPeer(chat.Type, chat.ID, chat.Username)
+	if !ok || watched.ViaBot() || !watched.Accepts(messageType) {
+		return nil
+	}
+	metrics.MessagesReceived.WithLabelValues(messageType).Inc()
+	w.activity.seen(chat.ID, time.Now())
+	edit := w.editOf(chat, msg, messageType, w.archiveMessage(ctx, chat, msg))
+	w.rememberReactions(chat, msg)
+	w.rememberPoll(chat, msg)
+	if w.unchanged(chat, msg, messageType) {
+		return nil
+	}
+	if w.bufferAlbum(ctx, cfg, watched, chat, msg, messageType) {
+		return nil
+	}
+	if !w.passesFilter(ctx, watched, chat, msg.GetMessage(), msg) {
+		w.log.Debug("Message filtered out", zap.String("chat_type", chat.Type), zap.Int64("chat_id", chat.ID), zap.Int("message_id", msg.GetID()))
+		return nil
+	}
+
+	err := w.sendMessage(ctx, cfg, cfg.WebhookUrlFor(watched), chat, msg, messageType, edit)
+	if err != nil {
+		w.log.Error("Error sending message", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+	}
+	w.log.Info("Message", zap.String("chat_type", chat.Type), zap.Int64("chat_id", chat.ID), zap.Any("text", msg.GetMessage()))
+
+	// The event didn't make it into the outbox, the update stays in the
+	// update log.
+	return err
+}
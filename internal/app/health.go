@@ -0,0 +1,178 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gotd/td/telegram"
+	"go.uber.org/zap"
+)
+
+const (
+	pingInterval = 30 * time.Second
+	pingTimeout  = 10 * time.Second
+	// The connection is considered dead after this many missed pings.
+	maxMissedPings = 3
+	// Every this many pings the session is checked for being revoked.
+	authCheckPings = 10
+)
+
+// health tracks the state reported by /healthz and /readyz.
+type health struct {
+	started     atomic.Int64
+	lastPing    atomic.Int64
+	connected   atomic.Bool
+	authorized  atomic.Bool
+	gapsRunning atomic.Bool
+	// sessionInvalid is set once the session was found revoked, until the
+	// client logged in again.
+	sessionInvalid atomic.Bool
+}
+
+// Auth states of healthStatus.
+const (
+	authAuthorized   = "authorized"
+	authUnauthorized = "unauthorized"
+	authInvalid      = "session_invalid"
+)
+
+type healthStatus struct {
+	Connected   bool       `json:"connected"`
+	Authorized  bool       `json:"authorized"`
+	Auth        string     `json:"auth"`
+	GapsRunning bool       `json:"gaps_running"`
+	LastPing    *time.Time `json:"last_ping,omitempty"`
+}
+
+// monitor pings Telegram periodically so a silently dropped MTProto
+// connection is noticed by the liveness probe. Every authCheckPings pings
+// it checks the session too and calls invalidate once it was revoked, pings
+// go through with a revoked session.
+func (h *health) monitor(ctx context.Context, log *zap.Logger, client *telegram.Client, invalidate func(error)) {
+	h.started.Store(time.Now().Unix())
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for pings := 1; ; pings++ {
+		pingCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		err := client.Ping(pingCtx)
+		if err == nil && pings%authCheckPings == 0 {
+			if _, err := client.Self(pingCtx); sessionInvalid(err) {
+				log.Error("Session is no longer valid", zap.Error(err))
+				invalidate(err)
+			}
+		}
+		cancel()
+		if err == nil {
+			h.lastPing.Store(time.Now().Unix())
+			h.connected.Store(true)
+		} else if ctx.Err() == nil {
+			log.Warn("Ping failed", zap.Error(err))
+			h.connected.Store(false)
+		}
+
+		select {
+		case <-ctx.Done():
+			h.connected.Store(false)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *health) alive() bool {
+	started := h.started.Load()
+	if started == 0 {
+		// Still starting up, e.g. waiting for interactive login.
+		return true
+	}
+	last := h.lastPing.Load()
+	if last == 0 {
+		last = started
+	}
+	return time.Since(time.Unix(last, 0)) < maxMissedPings*pingInterval
+}
+
+func (h *health) ready() bool {
+	return h.connected.Load() && h.authorized.Load() && h.gapsRunning.Load()
+}
+
+func (h *health) status() healthStatus {
+	s := healthStatus{
+		Connected:   h.connected.Load(),
+		Authorized:  h.authorized.Load(),
+		Auth:        authUnauthorized,
+		GapsRunning: h.gapsRunning.Load(),
+	}
+	switch {
+	case s.Authorized:
+		s.Auth = authAuthorized
+	case h.sessionInvalid.Load():
+		s.Auth = authInvalid
+	}
+	if last := h.lastPing.Load(); last != 0 {
+		t := time.Unix(last, 0)
+		s.LastPing = &t
+	}
+	return s
+}
+
+func (h *health) handleHealthz(rw http.ResponseWriter, _ *http.Request) {
+	writeStatus(rw, h.alive(), h.status())
+}
+
+func (h *health) handleReadyz(rw http.ResponseWriter, _ *http.Request) {
+	writeStatus(rw, h.ready(), h.status())
+}
+
+// healthGroup reports several accounts, healthy and ready only when all of them are.
+type healthGroup map[string]*health
+
+func (g healthGroup) handleHealthz(rw http.ResponseWriter, _ *http.Request) {
+	ok, status := true, map[string]healthStatus{}
+	for name, h := range g {
+		ok = ok && h.alive()
+		status[name] = h.status()
+	}
+	writeStatus(rw, ok, status)
+}
+
+func (g healthGroup) handleReadyz(rw http.ResponseWriter, _ *http.Request) {
+	ok, status := true, map[string]healthStatus{}
+	for name, h := range g {
+		ok = ok && h.ready()
+		status[name] = h.status()
+	}
+	writeStatus(rw, ok, status)
+}
+
+// healthHandler serves /healthz and /readyz.
+type healthHandler interface {
+	handleHealthz(rw http.ResponseWriter, r *http.Request)
+	handleReadyz(rw http.ResponseWriter, r *http.Request)
+}
+
+// accountsHealth reports a single account as before, several as a group
+// keyed by account name.
+func accountsHealth(accounts []*account) healthHandler {
+	if len(accounts) == 1 {
+		return accounts[0].health
+	}
+	g := healthGroup{}
+	for _, a := range accounts {
+		g[a.name] = a.health
+	}
+	return g
+}
+
+func writeStatus(rw http.ResponseWriter, ok bool, status any) {
+	rw.Header().Set("Content-Type", "application/json")
+	if !ok {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(rw).Encode(status)
+}
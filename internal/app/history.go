@@ -0,0 +1,356 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/storage"
+	"go.uber.org/zap"
+)
+
+// historyRange limits a history fetch to a window of message IDs and dates.
+// History is paged newest first, so From is the upper ID bound and To the lower one,
+// FromDate the lower date bound and ToDate the upper one.
+// Zero means the bound is not set. SinceLast raises To above the channel checkpoint.
+// Limit stops the fetch of a channel after that many messages. Parallel
+// pages of a channel are fetched at once. Messages found unchanged in the
+// archive were sent by an earlier backfill or while watching and are
+// skipped unless ResendArchived is set.
+type historyRange struct {
+	From           int
+	To             int
+	FromDate       time.Time
+	ToDate         time.Time
+	Limit          int
+	SinceLast      bool
+	Parallel       int
+	ResendArchived bool
+}
+
+func (r historyRange) enabled() bool {
+	return r.From > 0 || r.To > 0 || !r.FromDate.IsZero() || !r.ToDate.IsZero() || r.Limit > 0 || r.SinceLast
+}
+
+func (r historyRange) validate() error {
+	if r.From < 0 || r.To < 0 {
+		return errors.New("backfill message IDs must be positive")
+	}
+	if r.From > 0 && r.To > 0 && r.From < r.To {
+		return fmt.Errorf("max-id (%d) must be greater than or equal to min-id (%d)", r.From, r.To)
+	}
+	if !r.FromDate.IsZero() && !r.ToDate.IsZero() && r.ToDate.Before(r.FromDate) {
+		return fmt.Errorf("to-date (%s) must not be before from-date (%s)", r.ToDate.Format(time.RFC3339), r.FromDate.Format(time.RFC3339))
+	}
+	if r.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+	if r.Parallel < 1 {
+		return errors.New("parallel must be at least 1")
+	}
+	return nil
+}
+
+// rangeFlags registers the history window flags shared by run and fetch.
+func rangeFlags(flags *flag.FlagSet, r *historyRange) {
+	flags.IntVar(&r.From, "max-id", 0, "Newest message ID to send (inclusive)")
+	flags.IntVar(&r.To, "min-id", 0, "Oldest message ID to send (inclusive)")
+	flags.Func("from-date", "Oldest message date to send, 2006-01-02 or RFC 3339", dateFlag(&r.FromDate, false))
+	flags.Func("to-date", "Newest message date to send, 2006-01-02 (the whole day) or RFC 3339", dateFlag(&r.ToDate, true))
+	flags.IntVar(&r.Limit, "limit", 0, "Send at most this many messages per channel, all when 0")
+	flags.IntVar(&r.Parallel, "parallel", 4, "History pages fetched at once, paced by tg_app.rate_limit; messages are sent in order regardless")
+	flags.BoolVar(&r.ResendArchived, "resend-archived", false, "Send historical messages found unchanged in the archive too, they are skipped by default")
+}
+
+// dateFlag parses a date or a timestamp. A plain date stands for the start
+// of the day or, with endOfDay, for its last second.
+func dateFlag(t *time.Time, endOfDay bool) func(string) error {
+	return func(value string) error {
+		if d, err := time.Parse(time.DateOnly, value); err == nil {
+			if endOfDay {
+				d = d.Add(24*time.Hour - time.Second)
+			}
+			*t = d
+			return nil
+		}
+		d, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return errors.Errorf("bad date %q, use 2006-01-02 or 2006-01-02T15:04:05Z07:00", value)
+		}
+		*t = d
+		return nil
+	}
+}
+
+func (w *watcher) fetchAndProcessMessages(ctx context.Context, rng historyRange) error {
+	for _, ch := range w.cfg.Load().WatchedChannels() {
+		if ch.PeerType() != config.PeerChannel || ch.ViaBot() {
+			continue
+		}
+		channel, err := w.resolveChannel(ctx, ch)
+		if err != nil {
+			return err
+		}
+		if !ch.Accepts("oldMessage") {
+			continue
+		}
+
+		r := rng
+		if r.SinceLast {
+			if last := w.checkpoints.Last(channel.ID); last >= r.To {
+				r.To = last + 1
+			}
+			if r.From > 0 && r.From < r.To {
+				w.log.Info("History is up to date", zap.Stringer("channel", ch), zap.Int("checkpoint", r.To-1))
+				continue
+			}
+		}
+		if err := w.fetchChannelHistory(ctx, ch, channel, r); err != nil {
+			return errors.Wrapf(err, "fetch history of %s", ch)
+		}
+	}
+	return nil
+}
+
+func (w *watcher) fetchChannelHistory(ctx context.Context, watched config.ChannelConfig, channel *tg.Channel, rng historyRange) error {
+	peer := &tg.InputPeerChannel{
+		ChannelID:  channel.ID,
+		AccessHash: channel.AccessHash,
+	}
+
+	// History goes newest first, the checkpoint is moved only once the whole
+	// range was processed so an interrupted fetch is repeated next time.
+	// Messages that didn't make it into the outbox keep it below them.
+	newest, failed := 0, 0
+	sent, skipped := 0, 0
+	// Exports without sending write every message.
+	skipArchived := !rng.ResendArchived && (w.export == nil || w.export.send)
+	// send handles a page, done is set once a bound of the range is reached.
+	send := func(history []tg.MessageClass) (done bool, err error) {
+		for _, message := range history {
+			if rng.To > 0 && message.GetID() < rng.To {
+				return true, nil
+			}
+
+			c, ok := classify(message, "oldMessage")
+			if !ok {
+				continue
+			}
+			msg := c.msg
+			if !rng.FromDate.IsZero() && int64(msg.Date) < rng.FromDate.Unix() {
+				return true, nil
+			}
+			if rng.Limit > 0 && sent >= rng.Limit {
+				return true, nil
+			}
+			newest = max(newest, msg.GetID())
+			metrics.MessagesReceived.WithLabelValues("oldMessage").Inc()
+			prev := w.archiveMessage(ctx, event.ChannelChat(channel), msg)
+			if skipArchived && w.archivedUnchanged(prev, msg) {
+				skipped++
+				continue
+			}
+			w.rememberText(event.ChannelChat(channel), msg)
+			if views, _ := msg.GetViews(); views < watched.Sample.MinViews {
+				metrics.MessagesSuppressed.WithLabelValues("min_views").Inc()
+				continue
+			}
+			if !w.passesFilter(ctx, watched, event.ChannelChat(channel), msg.GetMessage(), msg) {
+				continue
+			}
+			sent++
+
+			cfg := w.cfg.Load()
+			if w.export != nil {
+				if err := w.exportMessage(ctx, cfg, event.ChannelChat(channel), msg); err != nil {
+					return true, errors.Wrap(err, "export message")
+				}
+				if !w.export.send {
+					continue
+				}
+			}
+			err := w.sendMessage(ctx, cfg, cfg.WebhookUrlFor(watched), event.ChannelChat(channel), msg, "oldMessage", nil)
+			if err != nil {
+				failed = msg.GetID()
+				w.log.Error("Error sending message", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+			}
+			w.log.Info("Message", zap.Int64("channel_id", channel.GetID()), zap.Any("text", msg.GetMessage()))
+		}
+		return false, nil
+	}
+
+	offsetID := 0
+	if rng.From > 0 {
+		// OffsetID is exclusive, shift it by one to include the upper bound itself.
+		offsetID = rng.From + 1
+	}
+	offsetDate := 0
+	if !rng.ToDate.IsZero() {
+		// OffsetDate is exclusive as well, later pages continue from offsetID.
+		offsetDate = int(rng.ToDate.Unix()) + 1
+	}
+	for {
+		page := w.historyPage(ctx, channel, &tg.MessagesGetHistoryRequest{
+			Peer:       peer,
+			OffsetID:   offsetID,
+			OffsetDate: offsetDate,
+			Limit:      historyPageSize,
+		})
+		if page.err != nil {
+			return page.err
+		}
+		w.rememberHistoryPeers(page.messages)
+		done, err := send(page.history)
+		if err != nil {
+			return err
+		}
+		if done || len(page.history) < historyPageSize {
+			break
+		}
+
+		offsetID = page.history[len(page.history)-1].GetID()
+		offsetDate = 0
+		if rng.Parallel > 1 {
+			// The first page tells where the history starts, the rest is
+			// fetched by ID windows in parallel.
+			if err := w.sendHistoryWindows(ctx, channel, offsetID, max(rng.To, 1), rng.Parallel, send); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	if skipped > 0 {
+		w.log.Info("Skipped archived messages", zap.Int64("channel_id", channel.ID), zap.Int("count", skipped))
+	}
+	if w.export != nil && !w.export.send {
+		// Nothing was delivered, the next fetch must not skip these messages.
+		return nil
+	}
+	if failed > 0 {
+		w.log.Warn("Checkpoint kept below a message that was not sent", zap.Int64("channel_id", channel.ID), zap.Int("message_id", failed))
+		newest = min(newest, failed-1)
+	}
+	return w.checkpoints.Advance(channel.ID, newest)
+}
+
+// archivedUnchanged tells whether msg was in the archive already with the
+// same text and edit date, prev is what archiveMessage returned.
+func (w *watcher) archivedUnchanged(prev *storage.Message, msg *tg.Message) bool {
+	if prev == nil {
+		return false
+	}
+	text := msg.GetMessage()
+	if r := w.redactor(); r != nil {
+		// The archive keeps redacted texts.
+		text = r.Redact(text)
+	}
+	editDate, _ := msg.GetEditDate()
+	return prev.Text == text && prev.EditDate == editDate
+}
+
+// historyPageSize is the most messages a history request returns.
+const historyPageSize = 100
+
+// historyPage is a history response with its messages, newest first.
+type historyPage struct {
+	messages tg.MessagesMessagesClass
+	history  []tg.MessageClass
+	err      error
+}
+
+func (w *watcher) historyPage(ctx context.Context, channel *tg.Channel, req *tg.MessagesGetHistoryRequest) historyPage {
+	messages, err := w.api.MessagesGetHistory(ctx, req)
+	if err != nil {
+		return historyPage{err: w.channels.InvalidateOn(channel.ID, err)}
+	}
+	history, err := historyMessages(messages)
+	return historyPage{messages: messages, history: history, err: err}
+}
+
+// historyWindow is a history request for the IDs between minID and maxID,
+// both exclusive like in messages.getHistory.
+type historyWindow struct {
+	maxID, minID int
+}
+
+// historyWindows splits the IDs below below down to lowest into windows of
+// historyPageSize IDs, newest first. A window from top holds the IDs
+// top-historyPageSize to top-1.
+func historyWindows(below, lowest int) []historyWindow {
+	var windows []historyWindow
+	for top := below; top > lowest; top -= historyPageSize {
+		windows = append(windows, historyWindow{maxID: top, minID: max(top-historyPageSize-1, lowest-1)})
+	}
+	return windows
+}
+
+// sendHistoryWindows fetches the history below the message ID below down to
+// lowest in windows of historyPageSize IDs, parallel of them at once, and
+// hands them to send newest first, the same order a sequential fetch has.
+// Windows fetched ahead wait in a reorder buffer of about parallel pages,
+// the requests are paced by the rate limit of the client.
+func (w *watcher) sendHistoryWindows(ctx context.Context, channel *tg.Channel, below, lowest, parallel int, send func(history []tg.MessageClass) (bool, error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	peer := &tg.InputPeerChannel{ChannelID: channel.ID, AccessHash: channel.AccessHash}
+	pending := make(chan chan historyPage, parallel)
+	go func() {
+		defer close(pending)
+		for _, window := range historyWindows(below, lowest) {
+			result := make(chan historyPage, 1)
+			select {
+			case pending <- result:
+			case <-ctx.Done():
+				return
+			}
+			req := &tg.MessagesGetHistoryRequest{
+				Peer:  peer,
+				MaxID: window.maxID,
+				MinID: window.minID,
+				Limit: historyPageSize,
+			}
+			go func() { result <- w.historyPage(ctx, channel, req) }()
+		}
+	}()
+
+	for result := range pending {
+		var page historyPage
+		select {
+		case page = <-result:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if page.err != nil {
+			return page.err
+		}
+		w.rememberHistoryPeers(page.messages)
+		if done, err := send(page.history); done || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// historyMessages extracts messages from any history response variant.
+func historyMessages(messages tg.MessagesMessagesClass) ([]tg.MessageClass, error) {
+	switch m := messages.(type) {
+	case *tg.MessagesMessages:
+		return m.Messages, nil
+	case *tg.MessagesMessagesSlice:
+		return m.Messages, nil
+	case *tg.MessagesChannelMessages:
+		return m.Messages, nil
+	case *tg.MessagesMessagesNotModified:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected messages type %T", messages)
+	}
+}
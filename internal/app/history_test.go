@@ -0,0 +1,91 @@
+package app
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestHistoryMessages(t *testing.T) {
+	messages := []tg.MessageClass{&tg.Message{ID: 2}, &tg.Message{ID: 1}}
+	tests := []struct {
+		name     string
+		response tg.MessagesMessagesClass
+		want     []tg.MessageClass
+		wantErr  bool
+	}{
+		{name: "messages", response: &tg.MessagesMessages{Messages: messages}, want: messages},
+		{name: "slice", response: &tg.MessagesMessagesSlice{Count: 500, Messages: messages}, want: messages},
+		{name: "channel", response: &tg.MessagesChannelMessages{Pts: 10, Count: 500, Messages: messages}, want: messages},
+		{name: "not modified", response: &tg.MessagesMessagesNotModified{Count: 500}},
+		{name: "unsupported", response: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := historyMessages(tt.response)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("historyMessages() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("historyMessages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistoryWindows(t *testing.T) {
+	tests := []struct {
+		name          string
+		below, lowest int
+		want          []historyWindow
+	}{
+		{
+			name:  "from the first message",
+			below: 250, lowest: 1,
+			want: []historyWindow{{maxID: 250, minID: 149}, {maxID: 150, minID: 49}, {maxID: 50, minID: 0}},
+		},
+		{
+			name:  "one full window",
+			below: 101, lowest: 1,
+			want: []historyWindow{{maxID: 101, minID: 0}},
+		},
+		{
+			name:  "lowest inside a window",
+			below: 250, lowest: 120,
+			want: []historyWindow{{maxID: 250, minID: 149}, {maxID: 150, minID: 119}},
+		},
+		{
+			name:  "nothing below",
+			below: 120, lowest: 120,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := historyWindows(tt.below, tt.lowest)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("historyWindows(%d, %d) = %v, want %v", tt.below, tt.lowest, got, tt.want)
+			}
+
+			// The exclusive bounds cover every ID from lowest to below-1
+			// exactly once, at most historyPageSize per window.
+			seen := map[int]int{}
+			for _, w := range got {
+				if n := w.maxID - w.minID - 1; n > historyPageSize {
+					t.Errorf("window %v holds %d IDs", w, n)
+				}
+				for id := w.minID + 1; id < w.maxID; id++ {
+					seen[id]++
+				}
+			}
+			for id := tt.lowest; id < tt.below; id++ {
+				if seen[id] != 1 {
+					t.Errorf("ID %d is in %d windows", id, seen[id])
+				}
+			}
+			if len(seen) != max(tt.below-tt.lowest, 0) {
+				t.Errorf("windows hold %d IDs, want %d", len(seen), tt.below-tt.lowest)
+			}
+		})
+	}
+}
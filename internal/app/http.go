@@ -0,0 +1,76 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go-tg.com/internal/stream"
+	"go.uber.org/zap"
+)
+
+// newHTTPMux serves metrics, health checks, the pins and the event stream,
+// plus the archive when one is open, the dashboard when enabled and the
+// channel admin API with an admin token, the acknowledgments of webhook
+// events when they are required, the debug endpoints when enabled and the
+// webhook of the bot in its webhook mode. The event stream and the archive
+// need the admin token when there is one.
+func newHTTPMux(h healthHandler, pins pinsAPI, archive *archiveAPI, events *stream.Hub, dash *dashboard, admin *channelsAPI, acks *ackAPI, debug *debugAPI, bot *botSource, adminToken string) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", h.handleHealthz)
+	mux.HandleFunc("/readyz", h.handleReadyz)
+	mux.HandleFunc("GET /channels/{id}/pinned", pins.handleChannelPinned)
+	mux.HandleFunc("GET /stream", withTokenIfSet(adminToken, events.ServeHTTP))
+	if archive != nil {
+		mux.HandleFunc("GET /channels/{id}/messages", withTokenIfSet(adminToken, archive.handleMessages))
+		if archive.index != nil {
+			mux.HandleFunc("GET /search", withTokenIfSet(adminToken, archive.handleSearch))
+		}
+	}
+	if dash != nil {
+		mux.HandleFunc("GET /dashboard", dash.handlePage)
+		mux.HandleFunc("GET /dashboard/status", dash.handleStatus)
+	}
+	if admin != nil {
+		mux.HandleFunc("GET /channels", admin.authorized(admin.handleList))
+		mux.HandleFunc("POST /channels", admin.authorized(admin.handleAdd))
+		mux.HandleFunc("DELETE /channels/{id}", admin.authorized(admin.handleRemove))
+	}
+	if acks != nil {
+		mux.HandleFunc("POST /ack", acks.handleAck)
+	}
+	if debug != nil {
+		debug.register(mux)
+	}
+	if bot != nil {
+		mux.HandleFunc("POST /bot", bot.handleUpdate)
+	}
+	return mux
+}
+
+// serveHTTP runs the service HTTP server until ctx is done.
+func serveHTTP(ctx context.Context, log *zap.Logger, addr string, handler http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		// Requests end on shutdown, streams would hold it up otherwise.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Info("HTTP server started", zap.String("addr", addr))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("HTTP server", zap.Error(err))
+	}
+}
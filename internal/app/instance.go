@@ -0,0 +1,123 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/service"
+	"go.uber.org/zap"
+)
+
+// sessionLockPath is the lock file of the session of an account, next to
+// the session file or, with sessions kept elsewhere, the updates state.
+func sessionLockPath(c *config.Config) string {
+	if c.Session.Storage == "" || c.Session.Storage == "file" {
+		return c.Session.Path + ".lock"
+	}
+	return c.TgApp.StatePath + ".lock"
+}
+
+// lockSession keeps other watchers and commands off the session of the
+// account until release is called, two clients of one session break it.
+func lockSession(c *config.Config) (release func(), err error) {
+	lock, err := service.Lock(sessionLockPath(c))
+	if errors.Is(err, service.ErrLocked) {
+		return nil, errors.Wrap(err, "the session is used by another watcher or command")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() { _ = lock.Release() }, nil
+}
+
+// lockSessions locks the sessions of the accounts of every tenant.
+func lockSessions(root *config.Store) (release func(), err error) {
+	var releases []func()
+	release = func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+	for _, c := range accountConfigs(root) {
+		r, err := lockSession(c)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		releases = append(releases, r)
+	}
+	return release, nil
+}
+
+// accountConfigs returns the config of every account of every tenant.
+func accountConfigs(root *config.Store) []*config.Config {
+	scopes := []*config.Store{root}
+	if tenants := root.Load().TenantNames(); len(tenants) > 0 {
+		scopes = scopes[:0]
+		for _, name := range tenants {
+			tenant, _ := root.Tenant(name)
+			scopes = append(scopes, tenant)
+		}
+	}
+	var configs []*config.Config
+	for _, scope := range scopes {
+		names := scope.Load().AccountNames()
+		if len(names) == 0 {
+			configs = append(configs, scope.Load())
+			continue
+		}
+		for _, name := range names {
+			account, _ := scope.Account(name)
+			configs = append(configs, account.Load())
+		}
+	}
+	return configs
+}
+
+// notifyService tells the service manager once every account is ready, see
+// /readyz, pets the systemd watchdog while they are alive and tells it
+// about the shutdown.
+func notifyService(ctx context.Context, log *zap.Logger, accounts []*account) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for !accountsPass(accounts, (*health).ready) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+	service.Ready()
+	log.Info("Ready")
+
+	var watchdog <-chan time.Time
+	if interval := service.WatchdogInterval(); interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		watchdog = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			service.Stopping()
+			return
+		case <-watchdog:
+			// A stalled connection lets the watchdog restart the service.
+			if accountsPass(accounts, (*health).alive) {
+				service.Alive()
+			}
+		}
+	}
+}
+
+// accountsPass reports whether check passes for the health of every account.
+func accountsPass(accounts []*account, check func(h *health) bool) bool {
+	for _, a := range accounts {
+		if !check(a.health) {
+			return false
+		}
+	}
+	return true
+}
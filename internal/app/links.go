@@ -0,0 +1,68 @@
+package app
+
+import (
+	"context"
+	"net/url"
+
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/tracing"
+	"go-tg.com/internal/unfurl"
+	"go.uber.org/zap"
+)
+
+// unfurl adds the metadata of the linked pages on allowed domains to the
+// links of message events. Pages are remembered, so edits and repeated
+// links don't fetch them again. Failures are logged and leave the link bare.
+func (w *watcher) unfurl(ctx context.Context, e *event.Event) {
+	cfg := w.cfg.Load().Links
+	if !cfg.Unfurl || len(e.Links) == 0 {
+		return
+	}
+	switch e.Type {
+	case "newMessage", "oldMessage", "editMessage":
+	default:
+		return
+	}
+
+	unfurled := 0
+	for i := range e.Links {
+		if unfurled == cfg.MaxLinks {
+			return
+		}
+		link := &e.Links[i]
+		u, err := url.Parse(link.URL)
+		if err != nil || !unfurl.Allowed(cfg.Domains, u) {
+			continue
+		}
+		unfurled++
+		page, ok := w.pages.get(link.URL)
+		if !ok {
+			spanCtx, span := tracing.Start(ctx, "unfurl")
+			page, err = unfurl.Fetch(spanCtx, cfg, link.URL)
+			tracing.End(span, err)
+			if err != nil {
+				w.log.Debug("Unfurl link", zap.String("url", link.URL), zap.Error(err))
+				continue
+			}
+			w.pages.swap(link.URL, page)
+		}
+		link.Title = page.Title
+		link.Description = page.Description
+		link.Image = page.Image
+		link.SiteName = page.SiteName
+	}
+}
+
+// extract adds the addresses and tickers of the message text.
+func (w *watcher) extract(_ context.Context, e *event.Event) {
+	cfg := w.cfg.Load().Extract
+	if !cfg.Addresses && !cfg.Contracts && !cfg.Tickers {
+		return
+	}
+	switch e.Type {
+	case "newMessage", "oldMessage", "editMessage", "comment":
+	default:
+		return
+	}
+	e.Extracted = event.Extract(e.FullText(), event.Extractors{Addresses: cfg.Addresses, Contracts: cfg.Contracts, Tickers: cfg.Tickers})
+}
@@ -0,0 +1,54 @@
+package app
+
+import (
+	"context"
+
+	"github.com/gotd/contrib/middleware/floodwait"
+	"github.com/gotd/contrib/middleware/ratelimit"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// countFloodWait counts FLOOD_WAIT errors of every RPC call.
+func countFloodWait() telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			err := next.Invoke(ctx, input, output)
+			if _, ok := tgerr.AsFloodWait(err); ok {
+				metrics.FloodWaits.Inc()
+			}
+			return err
+		}
+	})
+}
+
+// newFloodWaiter retries calls that failed with FLOOD_WAIT after the requested
+// delay. Waits longer than MaxFloodWait are returned to the caller as errors.
+// The waiter must wrap client.Run with its own Run.
+func newFloodWaiter(cfg config.RateLimitConfig, log *zap.Logger) *floodwait.Waiter {
+	// WithCallback goes last, the other options drop the callback when cloning.
+	return floodwait.NewWaiter().
+		WithMaxWait(cfg.MaxFloodWait).
+		WithMaxRetries(cfg.MaxRetries).
+		WithCallback(func(ctx context.Context, wait floodwait.FloodWait) {
+			log.Warn("Flood wait", zap.Duration("duration", wait.Duration))
+		})
+}
+
+// rateLimit keeps RPC calls within the configured budget. Nil when disabled.
+func rateLimit(cfg config.RateLimitConfig) telegram.Middleware {
+	if cfg.RPS <= 0 {
+		return nil
+	}
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = 1
+	}
+	return ratelimit.New(rate.Limit(cfg.RPS), burst)
+}
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+)
+
+// The watcher is the account of telegram sinks, see sink.TelegramClient.
+
+func (w *watcher) API() *tg.Client {
+	return w.api
+}
+
+// ResolvePeer resolves "me" to Saved Messages and anything else as a
+// channel of the channels list: an ID, @username or invite link.
+func (w *watcher) ResolvePeer(ctx context.Context, target string) (tg.InputPeerClass, error) {
+	switch strings.ToLower(strings.TrimSpace(target)) {
+	case "me", "self":
+		return &tg.InputPeerSelf{}, nil
+	}
+	channel, err := w.resolveChannel(ctx, config.ParseChannel(target))
+	if err != nil {
+		return nil, err
+	}
+	return channel.AsInputPeer(), nil
+}
+
+// SourceMessages fetches the messages an event was made of, all messages of
+// an album.
+func (w *watcher) SourceMessages(ctx context.Context, e *event.Event) ([]*tg.Message, error) {
+	ids := e.MessageIDs
+	if len(ids) == 0 {
+		id, err := strconv.Atoi(e.ExternalID)
+		if err != nil {
+			return nil, nil
+		}
+		ids = []int{id}
+	}
+	chat := event.Chat{Type: e.ChatType, ID: e.ChannelID}
+	if chat.Type == "" {
+		chat.Type = config.PeerChannel
+	}
+	return w.getMessages(ctx, chat, ids)
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"strings"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/event"
+)
+
+type peerKey struct {
+	peerType string
+	id       int64
+}
+
+type peerName struct {
+	title    string
+	username string
+}
+
+// rememberPeers records names of the users and chats that came with updates,
+// forwarded messages only carry the ID of their source.
+func (w *watcher) rememberPeers(e tg.Entities) {
+	for id, ch := range e.Channels {
+		w.peerNames.swap(peerKey{"channel", id}, peerName{title: ch.Title, username: ch.Username})
+	}
+	for id, chat := range e.Chats {
+		w.peerNames.swap(peerKey{"chat", id}, peerName{title: chat.Title})
+	}
+	for id, user := range e.Users {
+		title := strings.TrimSpace(user.FirstName + " " + user.LastName)
+		w.peerNames.swap(peerKey{"user", id}, peerName{title: title, username: user.Username})
+	}
+}
+
+// rememberHistoryPeers records names of the chats and users of a history page.
+func (w *watcher) rememberHistoryPeers(messages tg.MessagesMessagesClass) {
+	page, ok := messages.(interface {
+		GetChats() []tg.ChatClass
+		GetUsers() []tg.UserClass
+	})
+	if !ok {
+		return
+	}
+	e := tg.Entities{Users: map[int64]*tg.User{}, Chats: map[int64]*tg.Chat{}, Channels: map[int64]*tg.Channel{}}
+	for _, chat := range page.GetChats() {
+		switch c := chat.(type) {
+		case *tg.Channel:
+			e.Channels[c.ID] = c
+		case *tg.Chat:
+			e.Chats[c.ID] = c
+		}
+	}
+	for _, user := range page.GetUsers() {
+		if u, ok := user.(*tg.User); ok {
+			e.Users[u.ID] = u
+		}
+	}
+	w.rememberPeers(e)
+}
+
+// describeForward adds the title and username of the forward source if it is known.
+func (w *watcher) describeForward(e *event.Event) {
+	if e.Forward == nil || e.Forward.From == nil {
+		return
+	}
+	if name, ok := w.peerNames.get(peerKey{e.Forward.From.Type, e.Forward.From.ID}); ok {
+		e.Forward.Title, e.Forward.Username = name.title, name.username
+	}
+}
+
+// outgoing tells whether the account sent msg itself: it is marked out or
+// the account is its author.
+func (w *watcher) outgoing(msg *tg.Message) bool {
+	if msg.Out {
+		return true
+	}
+	from, _ := msg.GetFromID()
+	user, ok := from.(*tg.PeerUser)
+	self := w.self.Load()
+	return ok && self != 0 && user.UserID == self
+}
+
+// authorOf returns the ID of the sender of msg and its username if it was
+// seen in the updates, 0 for anonymous channel posts. Messages of private
+// dialogs without a sender come from the other user.
+func (w *watcher) authorOf(msg *tg.Message) (int64, string) {
+	from, ok := msg.GetFromID()
+	if !ok {
+		from = msg.PeerID
+	}
+	var key peerKey
+	switch from := from.(type) {
+	case *tg.PeerUser:
+		key = peerKey{"user", from.UserID}
+	case *tg.PeerChannel:
+		key = peerKey{"channel", from.ChannelID}
+	default:
+		return 0, ""
+	}
+	name, _ := w.peerNames.get(key)
+	return key.id, name.username
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// pinnedMessages tracks the pinned messages of watched chats, the latest pin
+// last. A chat is unknown until a pin update arrives or its pin is fetched.
+type pinnedMessages struct {
+	mux   sync.Mutex
+	chats map[peerKey][]int
+}
+
+func newPinnedMessages() *pinnedMessages {
+	return &pinnedMessages{chats: map[peerKey][]int{}}
+}
+
+func (p *pinnedMessages) pin(key peerKey, ids ...int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	pins := slices.DeleteFunc(p.chats[key], func(id int) bool { return slices.Contains(ids, id) })
+	p.chats[key] = append(pins, ids...)
+}
+
+// unpin forgets the messages. Pins made before the start are not all known,
+// so once the known ones are gone the chat is unknown again.
+func (p *pinnedMessages) unpin(key peerKey, ids ...int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	pins := slices.DeleteFunc(p.chats[key], func(id int) bool { return slices.Contains(ids, id) })
+	if len(pins) == 0 {
+		delete(p.chats, key)
+		return
+	}
+	p.chats[key] = pins
+}
+
+// set records the current pin fetched from Telegram, 0 for none.
+func (p *pinnedMessages) set(key peerKey, id int) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if id == 0 {
+		p.chats[key] = []int{}
+		return
+	}
+	p.chats[key] = []int{id}
+}
+
+// current returns the latest pin of a chat, known is false when the chat
+// has to be asked.
+func (p *pinnedMessages) current(key peerKey) (id int, known bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	pins, known := p.chats[key]
+	if len(pins) == 0 {
+		return 0, known
+	}
+	return pins[len(pins)-1], true
+}
+
+// handlePinnedMessages tracks pins and unpins of watched chats and emits
+// messageUnpinned, pins are sent as messagePinned from their service message.
+func (w *watcher) handlePinnedMessages(ctx context.Context, e tg.Entities, peer tg.PeerClass, ids []int, pinned bool) error {
+	cfg := w.cfg.Load()
+
+	chat, watched, ok, err := w.watchedPeer(ctx, cfg, e, peer)
+	if err != nil {
+		w.log.Error("get chat", zap.Error(err))
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	key := peerKey{chat.Type, chat.ID}
+	if pinned {
+		w.pins.pin(key, ids...)
+		return nil
+	}
+	w.pins.unpin(key, ids...)
+
+	if !watched.Accepts("messageUnpinned") {
+		return nil
+	}
+	metrics.MessagesReceived.WithLabelValues("messageUnpinned").Inc()
+	if err := w.deliver(ctx, cfg.WebhookUrlFor(watched), event.Unpinned(chat, ids)); err != nil {
+		w.log.Error("Error sending unpinned messages", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+	}
+	w.log.Info("Messages unpinned", zap.Int64("chat_id", chat.ID), zap.Ints("ids", ids))
+	return nil
+}
+
+// pinnedMessage is the current pin of a channel as served by the HTTP API.
+type pinnedMessage struct {
+	ChannelID int64  `json:"channel_id"`
+	MessageID int    `json:"message_id"`
+	Text      string `json:"text"`
+	Date      int    `json:"date,omitempty"`
+	Link      string `json:"link,omitempty"`
+}
+
+// currentPin returns the latest pinned message of a watched channel, nil
+// when nothing is pinned. A channel without pin updates since the start is
+// asked for it.
+func (w *watcher) currentPin(ctx context.Context, channel *tg.Channel) (*pinnedMessage, error) {
+	chat := event.ChannelChat(channel)
+	key := peerKey{chat.Type, chat.ID}
+	id, known := w.pins.current(key)
+	if !known {
+		full, err := w.api.ChannelsGetFullChannel(ctx, channel.AsInput())
+		if err != nil {
+			return nil, w.channels.InvalidateOn(chat.ID, err)
+		}
+		if channelFull, ok := full.FullChat.(*tg.ChannelFull); ok {
+			id, _ = channelFull.GetPinnedMsgID()
+		}
+		w.pins.set(key, id)
+	}
+	if id == 0 {
+		return nil, nil
+	}
+
+	msgs, err := w.getMessages(ctx, chat, []int{id})
+	if err != nil || len(msgs) == 0 {
+		return nil, err
+	}
+	e := event.FromMessage(config.PayloadConfig{}, chat, msgs[0], "messagePinned")
+	return &pinnedMessage{
+		ChannelID: chat.ID,
+		MessageID: id,
+		Text:      msgs[0].GetMessage(),
+		Date:      msgs[0].Date,
+		Link:      e.Link(),
+	}, nil
+}
+
+// pinsAPI serves the current pins of the watched channels of all accounts.
+type pinsAPI []*watcher
+
+func accountsPins(accounts []*account) pinsAPI {
+	api := make(pinsAPI, 0, len(accounts))
+	for _, a := range accounts {
+		api = append(api, a.w)
+	}
+	return api
+}
+
+// handleChannelPinned serves GET /channels/{id}/pinned, 404 when the channel
+// is not watched or has no pinned message.
+func (api pinsAPI) handleChannelPinned(rw http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(rw, "bad channel id", http.StatusBadRequest)
+		return
+	}
+	for _, w := range api {
+		channel, err := w.channels.Get(r.Context(), id)
+		if err != nil {
+			continue
+		}
+		if _, ok := w.findChannel(w.cfg.Load(), channel); !ok {
+			continue
+		}
+		pin, err := w.currentPin(r.Context(), channel)
+		if err != nil {
+			w.log.Warn("Get pinned message", zap.Int64("channel_id", id), zap.Error(err))
+			http.Error(rw, "telegram request failed", http.StatusBadGateway)
+			return
+		}
+		if pin == nil {
+			http.Error(rw, "no pinned message", http.StatusNotFound)
+			return
+		}
+		writeStatus(rw, true, pin)
+		return
+	}
+	http.Error(rw, "channel is not watched", http.StatusNotFound)
+}
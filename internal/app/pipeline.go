@@ -0,0 +1,124 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/pipeline"
+	"go-tg.com/internal/processor"
+	"go.uber.org/zap"
+)
+
+// newPipeline registers the stages every event passes before the sinks.
+// Further stages are added here, each runs after the registered ones of
+// its kind.
+func (w *watcher) newPipeline() *pipeline.Pipeline {
+	p := pipeline.New(
+		pipeline.Func(pipeline.Filter, "throttle", w.throttleStage),
+		enrichStage("permalink", func(_ context.Context, e *event.Event) { e.Permalink = e.Link() }),
+		enrichStage("transcribe", w.transcribe),
+		enrichStage("localize", w.localize),
+		enrichStage("unfurl", w.unfurl),
+		enrichStage("extract", w.extract),
+		enrichStage("tag", func(_ context.Context, e *event.Event) { w.tag(e) }),
+		pipeline.Func(pipeline.Enrich, "scam", w.scamStage),
+		pipeline.Func(pipeline.Transform, "redact", w.redactStage),
+		pipeline.Func(pipeline.Deliver, "stream", func(_ context.Context, m *pipeline.Message) (bool, error) {
+			w.stream.Publish(m.Event)
+			return true, nil
+		}),
+		pipeline.Func(pipeline.Deliver, "outbox", w.outboxStage),
+	)
+	for _, ep := range w.processors {
+		p.Register(pipeline.Func(pipeline.Transform, ep.name, w.processorStage(ep)))
+	}
+	return p
+}
+
+// externalProcessor is a configured processor with its name for logs and metrics.
+type externalProcessor struct {
+	name string
+	proc processor.Processor
+}
+
+// processorStage passes the event as JSON to an external processor and
+// continues with the event it returns. A failing processor is logged and
+// the event goes on unchanged, so nothing is lost silently.
+func (w *watcher) processorStage(ep externalProcessor) func(ctx context.Context, m *pipeline.Message) (bool, error) {
+	return func(ctx context.Context, m *pipeline.Message) (bool, error) {
+		result := "failed"
+		defer func() { metrics.EventsProcessed.WithLabelValues(ep.name, result).Inc() }()
+
+		in, err := json.Marshal(m.Event)
+		if err != nil {
+			w.log.Error("Encode event for processor", zap.String("processor", ep.name), zap.Error(err))
+			return true, nil
+		}
+		out, err := ep.proc.Process(ctx, in)
+		if err != nil {
+			w.log.Warn("Processor failed, sending the event unchanged", zap.String("processor", ep.name), zap.String("type", m.Event.Type), zap.Int64("chat_id", m.Event.ChannelID), zap.Error(err))
+			return true, nil
+		}
+		if len(out) == 0 {
+			result = "dropped"
+			w.log.Debug("Event dropped by processor", zap.String("processor", ep.name), zap.String("type", m.Event.Type), zap.Int64("chat_id", m.Event.ChannelID))
+			return false, nil
+		}
+		e, err := m.Event.Decode(out)
+		if err != nil {
+			w.log.Warn("Bad event from processor, sending the event unchanged", zap.String("processor", ep.name), zap.Error(err))
+			return true, nil
+		}
+		result = "passed"
+		m.Event = e
+		return true, nil
+	}
+}
+
+// enrichStage makes a stage of an enrichment that never drops the event.
+func enrichStage(name string, enrich func(ctx context.Context, e *event.Event)) pipeline.Stage {
+	return pipeline.Func(pipeline.Enrich, name, func(ctx context.Context, m *pipeline.Message) (bool, error) {
+		enrich(ctx, m.Event)
+		return true, nil
+	})
+}
+
+// throttleStage drops events over the rate limit of their chat, history is
+// never limited.
+func (w *watcher) throttleStage(ctx context.Context, m *pipeline.Message) (bool, error) {
+	e := m.Event
+	if e.Type != "oldMessage" && !w.throttle.allow(ctx, w.cfg.Load().Throttle, e, w.sendDigest(m.Target)) {
+		w.log.Debug("Event throttled", zap.String("type", e.Type), zap.Int64("chat_id", e.ChannelID))
+		return false, nil
+	}
+	return true, nil
+}
+
+// outboxStage sends the event to the outbox, held there while the schedule
+// of the chat is closed.
+func (w *watcher) outboxStage(ctx context.Context, m *pipeline.Message) (bool, error) {
+	e := m.Event
+	if until := w.heldUntil(e); !until.IsZero() {
+		w.log.Debug("Event held by schedule", zap.String("type", e.Type), zap.Int64("chat_id", e.ChannelID), zap.Time("until", until))
+		metrics.EventsHeld.Inc()
+		return true, w.outbox.Hold(ctx, m.Target, e, until)
+	}
+	if err := w.outbox.Deliver(ctx, m.Target, e); err != nil {
+		return false, err
+	}
+	metrics.MessagesForwarded.WithLabelValues(e.Type).Inc()
+	return true, nil
+}
+
+// deliver runs e through the pipeline.
+func (w *watcher) deliver(ctx context.Context, target string, e *event.Event) error {
+	return w.pipeline.Run(ctx, &pipeline.Message{Target: target, Event: e})
+}
+
+// publish runs e through the pipeline after the filters, for events that
+// were let through already, e.g. throttle digests.
+func (w *watcher) publish(ctx context.Context, target string, e *event.Event) error {
+	return w.pipeline.RunFrom(ctx, pipeline.Enrich, &pipeline.Message{Target: target, Event: e})
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"context"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// pollMessage is where a poll was posted, updateMessagePoll carries only the poll ID.
+type pollMessage struct {
+	chat      event.Chat
+	messageID int
+	poll      tg.Poll
+}
+
+// rememberPoll records the poll of a watched message for later result updates.
+func (w *watcher) rememberPoll(chat event.Chat, msg *tg.Message) {
+	media, ok := msg.GetMedia()
+	if !ok {
+		return
+	}
+	if m, ok := media.(*tg.MessageMediaPoll); ok {
+		w.polls.swap(m.Poll.ID, pollMessage{chat: chat, messageID: msg.GetID(), poll: m.Poll})
+	}
+}
+
+// handlePollUpdate emits pollUpdated with new results of a poll seen before.
+// Polls of messages received before the start are unknown and skipped.
+func (w *watcher) handlePollUpdate(ctx context.Context, update *tg.UpdateMessagePoll) error {
+	cfg := w.cfg.Load()
+
+	pm, ok := w.polls.get(update.PollID)
+	if !ok {
+		return nil
+	}
+	if poll, ok := update.GetPoll(); ok {
+		pm.poll = poll
+		w.polls.swap(update.PollID, pm)
+	}
+
+	watched, ok, err := w.findChat(ctx, cfg, pm.chat)
+	if err != nil {
+		w.log.Error("get chat", zap.Error(err))
+		return err
+	}
+	if !ok || !watched.Accepts("pollUpdated") {
+		return nil
+	}
+	metrics.MessagesReceived.WithLabelValues("pollUpdated").Inc()
+
+	e := event.PollUpdated(pm.chat, pm.messageID, event.PollOf(pm.poll, update.Results))
+	if err := w.deliver(ctx, cfg.WebhookUrlFor(watched), e); err != nil {
+		w.log.Error("Error sending poll update", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+	}
+	w.log.Info("Poll updated", zap.Int64("chat_id", pm.chat.ID), zap.Int("message_id", pm.messageID), zap.Int("total_voters", update.Results.TotalVoters))
+	return nil
+}
+
+// findChat looks up the watched config of a chat known from an earlier update.
+func (w *watcher) findChat(ctx context.Context, cfg *config.Config, chat event.Chat) (config.ChannelConfig, bool, error) {
+	if chat.Type != config.PeerChannel {
+		watched, ok := cfg.FindPeer(chat.Type, chat.ID, chat.Username)
+		return watched, ok, nil
+	}
+	channel, err := w.channels.Get(ctx, chat.ID)
+	if err != nil {
+		return config.ChannelConfig{}, false, err
+	}
+	watched, ok := w.findChannel(cfg, channel)
+	return watched, ok, nil
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/dcs"
+	"go-tg.com/internal/config"
+	"golang.org/x/net/proxy"
+)
+
+// newResolver connects to Telegram through the configured proxy, nil keeps
+// the default direct connection.
+func newResolver(cfg config.ProxyConfig) (dcs.Resolver, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "socks5":
+		var auth *proxy.Auth
+		if cfg.Username != "" {
+			auth = &proxy.Auth{User: cfg.Username, Password: cfg.Password}
+		}
+		dialer, err := proxy.SOCKS5("tcp", cfg.Address, auth, proxy.Direct)
+		if err != nil {
+			return nil, errors.Wrap(err, "socks5 proxy")
+		}
+		contextDialer, ok := dialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, errors.New("socks5 dialer does not support contexts")
+		}
+		return dcs.Plain(dcs.PlainOptions{Dial: contextDialer.DialContext}), nil
+	case "mtproto":
+		secret, err := cfg.SecretBytes()
+		if err != nil {
+			return nil, errors.Wrap(err, "mtproto proxy")
+		}
+		resolver, err := dcs.MTProxy(cfg.Address, secret, dcs.MTProxyOptions{})
+		if err != nil {
+			return nil, errors.Wrap(err, "mtproto proxy")
+		}
+		return resolver, nil
+	default:
+		return nil, errors.Errorf("unknown proxy type %q", cfg.Type)
+	}
+}
@@ -0,0 +1,134 @@
+package app
+
+import (
+	"context"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// maxRecentMessages bounds how many messages reaction counts and polls are
+// kept for. The oldest ones are forgotten first.
+const maxRecentMessages = 10000
+
+type messageKey struct {
+	chatID    int64
+	messageID int
+}
+
+// diffReactions returns emojis whose count went up and down between prev and
+// next. For a message with unknown counts every reaction counts as added.
+func diffReactions(prev, next []event.Reaction) (added, removed []string) {
+	before := map[string]int{}
+	for _, r := range prev {
+		before[r.Emoji] = r.Count
+	}
+	for _, r := range next {
+		if r.Count > before[r.Emoji] {
+			added = append(added, r.Emoji)
+		} else if r.Count < before[r.Emoji] {
+			removed = append(removed, r.Emoji)
+		}
+		delete(before, r.Emoji)
+	}
+	for emoji, count := range before {
+		if count > 0 {
+			removed = append(removed, emoji)
+		}
+	}
+	return added, removed
+}
+
+// rememberReactions records the reactions a watched message arrived with,
+// so the next count update is compared against them.
+func (w *watcher) rememberReactions(chat event.Chat, msg *tg.Message) {
+	if reactions, ok := msg.GetReactions(); ok {
+		w.reactions.swap(messageKey{chatID: chat.ID, messageID: msg.GetID()}, event.ReactionCounts(reactions.Results))
+	}
+}
+
+// watchedPeer resolves the chat of an update and its watched config.
+func (w *watcher) watchedPeer(ctx context.Context, cfg *config.Config, e tg.Entities, peer tg.PeerClass) (event.Chat, config.ChannelConfig, bool, error) {
+	if p, ok := peer.(*tg.PeerChannel); ok {
+		channel, err := w.channels.Get(ctx, p.ChannelID)
+		if err != nil {
+			return event.Chat{}, config.ChannelConfig{}, false, err
+		}
+		watched, ok := w.findChannel(cfg, channel)
+		return event.ChannelChat(channel), watched, ok, nil
+	}
+	chat, ok := peerChat(e, peer)
+	if !ok {
+		return event.Chat{}, config.ChannelConfig{}, false, nil
+	}
+	watched, ok := cfg.FindPeer(chat.Type, chat.ID, chat.Username)
+	return chat, watched, ok, nil
+}
+
+// handleReactionCounts turns new reaction counts of a message into
+// reactionAdded and reactionRemoved events, one per changed reaction.
+func (w *watcher) handleReactionCounts(ctx context.Context, e tg.Entities, peer tg.PeerClass, messageID int, counts []event.Reaction) error {
+	cfg := w.cfg.Load()
+
+	chat, watched, ok, err := w.watchedPeer(ctx, cfg, e, peer)
+	if err != nil {
+		w.log.Error("get chat", zap.Error(err))
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	prev, _ := w.reactions.swap(messageKey{chatID: chat.ID, messageID: messageID}, counts)
+	added, removed := diffReactions(prev, counts)
+	w.sendReactions(ctx, cfg, watched, chat, messageID, "reactionAdded", added, counts, nil)
+	w.sendReactions(ctx, cfg, watched, chat, messageID, "reactionRemoved", removed, counts, nil)
+	return nil
+}
+
+// handleBotReaction handles the reaction change of a single user that bots
+// receive. It names the reactions but not the counts.
+func (w *watcher) handleBotReaction(ctx context.Context, e tg.Entities, update *tg.UpdateBotMessageReaction) error {
+	cfg := w.cfg.Load()
+
+	chat, watched, ok, err := w.watchedPeer(ctx, cfg, e, update.Peer)
+	if err != nil {
+		w.log.Error("get chat", zap.Error(err))
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	var before, after []event.Reaction
+	for _, r := range update.OldReactions {
+		before = append(before, event.Reaction{Emoji: event.ReactionEmoji(r), Count: 1})
+	}
+	for _, r := range update.NewReactions {
+		after = append(after, event.Reaction{Emoji: event.ReactionEmoji(r), Count: 1})
+	}
+	added, removed := diffReactions(before, after)
+	w.sendReactions(ctx, cfg, watched, chat, update.MsgID, "reactionAdded", added, nil, update.Actor)
+	w.sendReactions(ctx, cfg, watched, chat, update.MsgID, "reactionRemoved", removed, nil, update.Actor)
+	return nil
+}
+
+func (w *watcher) sendReactions(ctx context.Context, cfg *config.Config, watched config.ChannelConfig, chat event.Chat, messageID int, eventType string, emojis []string, counts []event.Reaction, actor tg.PeerClass) {
+	if len(emojis) == 0 || !watched.Accepts(eventType) {
+		return
+	}
+	for _, emoji := range emojis {
+		if emoji == "" {
+			continue
+		}
+		metrics.MessagesReceived.WithLabelValues(eventType).Inc()
+		ev := event.ReactionChanged(chat, messageID, eventType, emoji, counts, actor)
+		if err := w.deliver(ctx, cfg.WebhookUrlFor(watched), ev); err != nil {
+			w.log.Error("Error sending reaction", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+		}
+		w.log.Info("Reaction", zap.String("type", eventType), zap.Int64("chat_id", chat.ID), zap.Int("message_id", messageID), zap.String("emoji", emoji))
+	}
+}
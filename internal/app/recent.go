@@ -0,0 +1,68 @@
+package app
+
+import (
+	"maps"
+	"slices"
+	"sync"
+)
+
+// recentMap is a map that forgets its oldest keys beyond a size limit. It
+// keeps per-message state for updates that only refer to earlier messages.
+type recentMap[K comparable, V any] struct {
+	limit int
+
+	mux    sync.Mutex
+	values map[K]V
+	order  []K
+}
+
+func newRecentMap[K comparable, V any](limit int) *recentMap[K, V] {
+	return &recentMap[K, V]{limit: limit, values: map[K]V{}}
+}
+
+func (m *recentMap[K, V]) get(key K) (V, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// swap stores v under key and returns the previous value.
+func (m *recentMap[K, V]) swap(key K, v V) (V, bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	prev, ok := m.values[key]
+	if !ok {
+		m.order = append(m.order, key)
+		if len(m.order) > m.limit {
+			delete(m.values, m.order[0])
+			m.order = m.order[1:]
+		}
+	}
+	m.values[key] = v
+	return prev, ok
+}
+
+func (m *recentMap[K, V]) delete(key K) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if _, ok := m.values[key]; !ok {
+		return
+	}
+	delete(m.values, key)
+	m.order = slices.DeleteFunc(m.order, func(k K) bool { return k == key })
+}
+
+func (m *recentMap[K, V]) len() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return len(m.values)
+}
+
+// snapshot returns a copy of the values by key.
+func (m *recentMap[K, V]) snapshot() map[K]V {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return maps.Clone(m.values)
+}
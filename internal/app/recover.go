@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// handlerPanic is a panic of an update handler turned into an error, with
+// the update that caused it.
+type handlerPanic struct {
+	update string
+	value  any
+	stack  []byte
+	dump   string
+}
+
+func (p *handlerPanic) Error() string {
+	return fmt.Sprintf("%s handler panicked: %v", p.update, p.value)
+}
+
+func newHandlerPanic(name string, value, update any) *handlerPanic {
+	return &handlerPanic{update: name, value: value, stack: debug.Stack(), dump: fmt.Sprint(update)}
+}
+
+// recoverUpdate turns a panic into a handlerPanic error in err. It must be
+// deferred by the handler of update.
+func recoverUpdate(name string, update any, err *error) {
+	if p := recover(); p != nil {
+		*err = newHandlerPanic(name, p, update)
+	}
+}
+
+// handlerPanics splits err into the panics of update handlers and the
+// other errors.
+func handlerPanics(err error) (panics []*handlerPanic, other error) {
+	if err == nil {
+		return nil, nil
+	}
+	if p, ok := err.(*handlerPanic); ok {
+		return []*handlerPanic{p}, nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return nil, err
+	}
+	var others []error
+	for _, err := range joined.Unwrap() {
+		found, rest := handlerPanics(err)
+		panics = append(panics, found...)
+		if rest != nil {
+			others = append(others, rest)
+		}
+	}
+	return panics, errors.Join(others...)
+}
+
+// recoveredUpdates keeps the watcher alive when update handlers panic: the
+// panic is logged with the raw update, counted and alerted about, and the
+// other updates of the batch are still handled. Panics outside the
+// handlers are recovered too.
+type recoveredUpdates struct {
+	next    telegram.UpdateHandler
+	alerts  *alerter
+	tenant  string
+	account string
+	log     *zap.Logger
+}
+
+func (r recoveredUpdates) Handle(ctx context.Context, u tg.UpdatesClass) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = newHandlerPanic(u.TypeName(), p, u)
+		}
+		var panics []*handlerPanic
+		panics, err = handlerPanics(err)
+		for _, p := range panics {
+			r.report(p)
+		}
+	}()
+	return r.next.Handle(ctx, u)
+}
+
+func (r recoveredUpdates) report(p *handlerPanic) {
+	metrics.HandlerPanics.WithLabelValues(p.update).Inc()
+	r.log.Error("Update handler panicked",
+		zap.String("update", p.update),
+		zap.Any("panic", p.value),
+		zap.ByteString("stack", p.stack),
+		zap.String("dump", p.dump),
+	)
+	if !r.alerts.cfg.Load().Alerts.Panics {
+		return
+	}
+	r.alerts.send(opsAlert{
+		Type:    alertHandlerPanic,
+		Tenant:  r.tenant,
+		Account: r.account,
+		Error:   fmt.Sprint(p.value),
+		Details: map[string]any{"update": p.update, "stack": string(p.stack)},
+	})
+}
@@ -0,0 +1,33 @@
+package app
+
+import (
+	"context"
+
+	"go-tg.com/internal/filter"
+	"go-tg.com/internal/pipeline"
+	"go.uber.org/zap"
+)
+
+// redactor returns the redactor of the current config, nil when nothing is
+// redacted.
+func (w *watcher) redactor() *filter.Redactor {
+	cfg := w.cfg.Load().Redact
+	if !cfg.Enabled() {
+		return nil
+	}
+	r, err := w.filters.Redactor(cfg)
+	if err != nil {
+		w.log.Error("Bad redaction patterns", zap.Error(err))
+		return nil
+	}
+	return r
+}
+
+// redactStage masks sensitive data in the texts of events before processors
+// and sinks get them.
+func (w *watcher) redactStage(_ context.Context, m *pipeline.Message) (bool, error) {
+	if r := w.redactor(); r != nil {
+		m.Event.Redact(r.Redact)
+	}
+	return true, nil
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go-tg.com/internal/config"
+	"go.uber.org/zap"
+)
+
+// reloadDelay collects the burst of events an editor or a ConfigMap update
+// produces into one reload.
+const reloadDelay = 500 * time.Millisecond
+
+// watchConfig reloads the config on SIGHUP, whenever the config file changes
+// and every secrets.refresh, onReload is called with the new config after
+// every successful reload.
+func watchConfig(ctx context.Context, log *zap.Logger, cfg *config.Store, onReload func(c *config.Config)) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	defer signal.Stop(sig)
+
+	var (
+		changed <-chan fsnotify.Event
+		errs    <-chan error
+	)
+	if path := cfg.Path(); path != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			log.Warn("Config file is not watched, reload with SIGHUP", zap.Error(err))
+		} else {
+			defer watcher.Close()
+			// The directory is watched since editors and ConfigMaps replace
+			// the file instead of writing to it.
+			if err := watcher.Add(filepath.Dir(path)); err != nil {
+				log.Warn("Config file is not watched, reload with SIGHUP", zap.Error(err))
+			}
+			changed, errs = watcher.Events, watcher.Errors
+		}
+	}
+
+	timer := time.NewTimer(reloadDelay)
+	timer.Stop()
+	defer timer.Stop()
+
+	// With secrets.refresh the config is read again periodically and applied
+	// when it changed, usually since a secret was rotated.
+	var refresh <-chan time.Time
+	if interval := cfg.Load().Secrets.Refresh; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+
+	reload := func(refreshed bool) {
+		prev := cfg.Load()
+		ignored, err := cfg.Reload()
+		if err != nil {
+			log.Error("reload config", zap.Error(err))
+			return
+		}
+		if refreshed && reflect.DeepEqual(prev, cfg.Load()) {
+			return
+		}
+		for _, field := range ignored {
+			log.Warn("Config field can't be changed without restart, ignored", zap.String("field", field))
+		}
+		onReload(cfg.Load())
+		log.Info("Config reloaded")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sig:
+			reload(false)
+		case e, ok := <-changed:
+			if !ok {
+				changed = nil
+				continue
+			}
+			if configChanged(cfg.Path(), e) {
+				timer.Reset(reloadDelay)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			log.Warn("Watch config file", zap.Error(err))
+		case <-timer.C:
+			reload(false)
+		case <-refresh:
+			reload(true)
+		}
+	}
+}
+
+// configChanged reports whether e touches the config file. Kubernetes swaps
+// the ..data symlink of a mounted ConfigMap, the file itself stays untouched.
+func configChanged(path string, e fsnotify.Event) bool {
+	if !e.Has(fsnotify.Write) && !e.Has(fsnotify.Create) && !e.Has(fsnotify.Rename) {
+		return false
+	}
+	name := filepath.Base(e.Name)
+	return filepath.Clean(e.Name) == filepath.Clean(path) || name == "..data"
+}
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	tgService "go-tg.com/internal/services/telegram"
+	"go-tg.com/internal/sink"
+	"go-tg.com/internal/storage"
+	"go.uber.org/zap"
+)
+
+// replayPage is how many archived messages are read per query.
+const replayPage = 500
+
+// runReplay sends archived messages of a channel to one sink again, oldest
+// first. Outbox and dedup are bypassed so the events really reach the sink
+// a second time, the first failed delivery stops the replay.
+func runReplay(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	configPath := configFlag(flags)
+	accountName := accountFlag(flags)
+	channel := flags.String("channel", "", "Channel ID")
+	sinkName := flags.String("sink", "", "Sink to send to, required when a sinks list is configured")
+	var from, to time.Time
+	flags.Func("from-date", "Oldest message date to send, 2006-01-02 or RFC 3339", dateFlag(&from, false))
+	flags.Func("to-date", "Newest message date to send, 2006-01-02 (the whole day) or RFC 3339", dateFlag(&to, true))
+	since := flags.Duration("since", 0, "Send the messages of this last period, e.g. 24h, instead of -from-date")
+	limit := flags.Int("limit", 0, "Send at most this many messages, the newest ones, all when 0")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	channelID, err := strconv.ParseInt(*channel, 10, 64)
+	if err != nil || channelID <= 0 {
+		return errors.New("-channel must be a channel ID")
+	}
+	if *since < 0 || *limit < 0 {
+		return errors.New("-since and -limit must not be negative")
+	}
+	if *since > 0 {
+		if !from.IsZero() {
+			return errors.New("-since and -from-date are mutually exclusive")
+		}
+		from = time.Now().Add(-*since)
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return fmt.Errorf("to-date (%s) must not be before from-date (%s)", to.Format(time.RFC3339), from.Format(time.RFC3339))
+	}
+
+	_, rootCfg, err := loadConfig(*configPath, (*config.Config).Validate)
+	if err != nil {
+		return err
+	}
+	initialCfg, cfg, err := forAccount(rootCfg, *accountName)
+	if err != nil {
+		return err
+	}
+	log, err := newLogger(initialCfg.Log)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = log.Sync() }()
+
+	if initialCfg.Archive.Driver == "" {
+		return errors.New("replay needs the archive, archive.driver is not set")
+	}
+	sc, err := replaySink(initialCfg, *sinkName)
+	if err != nil {
+		return err
+	}
+	if sc.Type == "telegram" {
+		return errors.New("telegram sinks post through the running watcher and can't be replayed to")
+	}
+	out, err := newSink(cfg, sc, sink.NewTelegramClients())
+	if err != nil {
+		return errors.Wrapf(err, "create sink %s", sc.OutboxName())
+	}
+	defer func() { _ = out.Close() }()
+	if err := applyTemplate(out, sc); err != nil {
+		return errors.Wrapf(err, "sink %s", sc.OutboxName())
+	}
+
+	archive, err := storage.Open(ctx, initialCfg.Archive.Driver, initialCfg.Archive.DSN)
+	if err != nil {
+		return errors.Wrap(err, "open archive")
+	}
+	defer func() { _ = archive.Close() }()
+
+	q := storage.MessageQuery{ChannelID: channelID}
+	if !from.IsZero() {
+		q.Since = int(from.Unix())
+	}
+	if !to.IsZero() {
+		// Until is exclusive, a plain -to-date ends on the last second of the day.
+		q.Until = int(to.Unix()) + 1
+	}
+	messages, err := readArchive(ctx, archive, q, *limit)
+	if err != nil {
+		return err
+	}
+
+	chat := event.Chat{Type: config.PeerChannel, ID: channelID}
+	peers, err := tgService.NewChannelCache(nil, initialCfg.TgApp.PeerCachePath)
+	if err != nil {
+		return errors.Wrap(err, "open peer cache")
+	}
+	if known, ok := peers.Cached(channelID); ok {
+		chat = event.ChannelChat(known)
+	}
+	watched, ok := initialCfg.FindChannel(channelID, chat.Username)
+	if !ok {
+		watched = config.ChannelConfig{ID: channelID}
+	}
+	target := initialCfg.WebhookUrlFor(watched)
+
+	for i, m := range messages {
+		msg := &tg.Message{
+			ID:      m.MessageID,
+			Message: m.Text,
+			Date:    m.Date,
+			PeerID:  &tg.PeerChannel{ChannelID: channelID},
+		}
+		if m.EditDate > 0 {
+			msg.SetEditDate(m.EditDate)
+		}
+		e := event.FromMessage(initialCfg.Payload, chat, msg, "oldMessage")
+		if err := out.Send(ctx, target, e.Formatted(sc.TextFormat)); err != nil {
+			return errors.Wrapf(err, "send message %d, %d of %d replayed", m.MessageID, i, len(messages))
+		}
+	}
+	log.Info("Replay done",
+		zap.Int64("channel_id", channelID),
+		zap.String("sink", sc.OutboxName()),
+		zap.Int("sent", len(messages)),
+	)
+	return nil
+}
+
+// replaySink picks the sink by name from the sinks list or, without a list,
+// the single sink section.
+func replaySink(cfg *config.Config, name string) (config.SinkConfig, error) {
+	if len(cfg.Sinks) == 0 {
+		if name != "" {
+			return config.SinkConfig{}, errors.New("-sink is set, but no sinks list is configured")
+		}
+		sc := cfg.Sink
+		if sc.Type == "" {
+			sc.Type = "webhook"
+		}
+		return sc, nil
+	}
+
+	names := make([]string, 0, len(cfg.Sinks))
+	for _, sc := range cfg.Sinks {
+		if sc.OutboxName() == name {
+			if sc.Type == "" {
+				sc.Type = "webhook"
+			}
+			return sc, nil
+		}
+		names = append(names, sc.OutboxName())
+	}
+	return config.SinkConfig{}, fmt.Errorf("-sink must be one of: %s", strings.Join(names, ", "))
+}
+
+// readArchive pages through the archive newest first and returns the
+// messages that were not deleted, oldest first. A positive limit keeps the
+// newest ones.
+func readArchive(ctx context.Context, archive *storage.Archive, q storage.MessageQuery, limit int) ([]storage.Message, error) {
+	var messages []storage.Message
+	for {
+		q.Limit = replayPage
+		page, err := archive.Query(ctx, q)
+		if err != nil {
+			return nil, errors.Wrap(err, "query archive")
+		}
+		for _, m := range page {
+			if m.DeletedAt > 0 {
+				continue
+			}
+			messages = append(messages, m)
+			if limit > 0 && len(messages) == limit {
+				slices.Reverse(messages)
+				return messages, nil
+			}
+		}
+		if len(page) < replayPage {
+			break
+		}
+		q.BeforeID = page[len(page)-1].MessageID
+	}
+	slices.Reverse(messages)
+	return messages, nil
+}
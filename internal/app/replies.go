@@ -0,0 +1,90 @@
+package app
+
+import (
+	"context"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go.uber.org/zap"
+)
+
+// describeReply embeds the message e replies to when payload.include_reply is
+// on. Replies to other chats are skipped, a failed lookup only leaves it out.
+func (w *watcher) describeReply(ctx context.Context, cfg *config.Config, chat event.Chat, msg *tg.Message, e *event.Event) {
+	if !cfg.Payload.IncludeReply {
+		return
+	}
+	replyID, ok := event.ReplyToID(msg)
+	if !ok {
+		return
+	}
+
+	key := messageKey{chatID: chat.ID, messageID: replyID}
+	if reply, ok := w.replies.get(key); ok {
+		e.ReplyTo = reply
+		return
+	}
+	reply, err := w.fetchReply(ctx, chat, replyID)
+	if err != nil {
+		w.log.Warn("Fetch replied message", zap.Int64("chat_id", chat.ID), zap.Int("message_id", replyID), zap.Error(err))
+		return
+	}
+	if reply != nil {
+		w.replies.swap(key, reply)
+		e.ReplyTo = reply
+	}
+}
+
+func (w *watcher) fetchReply(ctx context.Context, chat event.Chat, messageID int) (*event.Reply, error) {
+	found, err := w.getMessages(ctx, chat, []int{messageID})
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	return event.ReplyOf(found[0]), nil
+}
+
+// getMessages fetches messages of a chat by ID. Deleted messages come back
+// as messageEmpty and are left out.
+func (w *watcher) getMessages(ctx context.Context, chat event.Chat, messageIDs []int) ([]*tg.Message, error) {
+	ids := make([]tg.InputMessageClass, 0, len(messageIDs))
+	for _, id := range messageIDs {
+		ids = append(ids, &tg.InputMessageID{ID: id})
+	}
+
+	var (
+		messages tg.MessagesMessagesClass
+		err      error
+	)
+	if chat.Type == config.PeerChannel {
+		channel, err := w.channels.Get(ctx, chat.ID)
+		if err != nil {
+			return nil, err
+		}
+		messages, err = w.api.ChannelsGetMessages(ctx, &tg.ChannelsGetMessagesRequest{Channel: channel.AsInput(), ID: ids})
+		if err != nil {
+			return nil, w.channels.InvalidateOn(chat.ID, err)
+		}
+	} else {
+		messages, err = w.api.MessagesGetMessages(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	found, err := historyMessages(messages)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[int]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+	var result []*tg.Message
+	for _, m := range found {
+		if msg, ok := m.(*tg.Message); ok && wanted[msg.GetID()] {
+			result = append(result, msg)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go.uber.org/zap"
+)
+
+// inviteLinks remembers which channel an invite hash resolved to, updates
+// only carry the channel ID.
+type inviteLinks struct {
+	mux      sync.RWMutex
+	channels map[string]int64
+}
+
+func newInviteLinks() *inviteLinks {
+	return &inviteLinks{channels: map[string]int64{}}
+}
+
+func (l *inviteLinks) put(hash string, channelID int64) {
+	l.mux.Lock()
+	l.channels[hash] = channelID
+	l.mux.Unlock()
+}
+
+func (l *inviteLinks) get(hash string) (int64, bool) {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	id, ok := l.channels[hash]
+	return id, ok
+}
+
+func (l *inviteLinks) hashes(channelID int64) []string {
+	l.mux.RLock()
+	defer l.mux.RUnlock()
+	var hashes []string
+	for hash, id := range l.channels {
+		if id == channelID {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes
+}
+
+// findChannel looks up the watched config of a channel by ID and username,
+// then among the channels configured by invite link and the discovered ones.
+func (w *watcher) findChannel(cfg *config.Config, channel *tg.Channel) (config.ChannelConfig, bool) {
+	if watched, ok := cfg.FindChannel(channel.GetID(), channel.Username); ok {
+		return watched, true
+	}
+	for _, hash := range w.invites.hashes(channel.GetID()) {
+		if watched, ok := cfg.FindInvite(hash); ok {
+			return watched, true
+		}
+	}
+	return w.discoveredChannel(cfg, channel.GetID())
+}
+
+// resolveChannel finds a configured channel by ID, public username or invite
+// link. With Join set the account joins the channel if it is not a member yet.
+func (w *watcher) resolveChannel(ctx context.Context, ch config.ChannelConfig) (*tg.Channel, error) {
+	switch {
+	case ch.ID != 0:
+		channel, err := w.channels.Get(ctx, ch.ID)
+		if err != nil {
+			return nil, err
+		}
+		return channel, w.join(ctx, ch, channel)
+	case ch.Invite != "":
+		return w.resolveInvite(ctx, ch)
+	}
+
+	resolved, err := w.api.ContactsResolveUsername(ctx, ch.NormalizedUsername())
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", ch, err)
+	}
+	for _, chat := range resolved.GetChats() {
+		if channel, ok := chat.(*tg.Channel); ok {
+			w.channels.Put(channel)
+			return channel, w.join(ctx, ch, channel)
+		}
+	}
+	return nil, fmt.Errorf("%s is not a channel", ch)
+}
+
+// join joins a public channel the account has left or never joined if the config asks for it.
+func (w *watcher) join(ctx context.Context, ch config.ChannelConfig, channel *tg.Channel) error {
+	if !ch.Join || !channel.Left {
+		return nil
+	}
+	if _, err := w.api.ChannelsJoinChannel(ctx, channel.AsInput()); err != nil {
+		return fmt.Errorf("failed to join %s: %w", ch, err)
+	}
+	w.log.Info("Joined channel", zap.Stringer("channel", ch), zap.Int64("channel_id", channel.GetID()))
+	return nil
+}
+
+func (w *watcher) resolveInvite(ctx context.Context, ch config.ChannelConfig) (*tg.Channel, error) {
+	hash := ch.InviteHash()
+	if id, ok := w.invites.get(hash); ok {
+		return w.channels.Get(ctx, id)
+	}
+
+	invite, err := w.api.MessagesCheckChatInvite(ctx, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check invite %s: %w", ch, err)
+	}
+
+	var chats []tg.ChatClass
+	if already, ok := invite.(*tg.ChatInviteAlready); ok {
+		chats = []tg.ChatClass{already.Chat}
+	} else {
+		if !ch.Join {
+			return nil, fmt.Errorf("not a member of %s, set join: true to join it", ch)
+		}
+		u, err := w.api.MessagesImportChatInvite(ctx, hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to join %s: %w", ch, err)
+		}
+		if updates, ok := u.(interface{ GetChats() []tg.ChatClass }); ok {
+			chats = updates.GetChats()
+		}
+	}
+
+	for _, chat := range chats {
+		if channel, ok := chat.(*tg.Channel); ok {
+			w.channels.Put(channel)
+			w.invites.put(hash, channel.GetID())
+			w.log.Info("Resolved invite", zap.Stringer("channel", ch), zap.Int64("channel_id", channel.GetID()), zap.String("title", channel.Title))
+			return channel, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not a channel", ch)
+}
+
+// joinChannels resolves channels configured by invite link and joins the
+// ones marked with join, so their updates arrive. Failures are logged.
+func (w *watcher) joinChannels(ctx context.Context) {
+	for _, ch := range w.cfg.Load().WatchedChannels() {
+		if ch.PeerType() != config.PeerChannel || (ch.Invite == "" && !ch.Join) {
+			continue
+		}
+		if _, err := w.resolveChannel(ctx, ch); err != nil && !errors.Is(err, context.Canceled) {
+			w.log.Error("Resolve channel", zap.Stringer("channel", ch), zap.Error(err))
+		}
+	}
+}
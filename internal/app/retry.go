@@ -0,0 +1,102 @@
+package app
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/pool"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// transientErrors are the RPC errors Telegram returns while it is busy or
+// under maintenance, the call may succeed when made again.
+var transientErrors = []string{
+	"RPC_CALL_FAIL",
+	"RPC_MCGET_FAIL",
+	"TIMEOUT",
+	"MSG_WAIT_FAILED",
+	"MSG_WAIT_TIMEOUT",
+	"WORKER_BUSY_TOO_LONG_RETRY",
+}
+
+// retryable reports whether a call that failed with err is worth making
+// again: internal server errors, timeouts and dead connections are, other
+// RPC errors and FLOOD_WAIT are not, the flood waiter takes care of those.
+func retryable(err error) bool {
+	if rpcErr, ok := tgerr.As(err); ok {
+		return rpcErr.Code >= 500 || rpcErr.Code == -503 || rpcErr.IsOneOf(transientErrors...)
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, pool.ErrConnDead) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.As(err, &netErr)
+}
+
+// retryBackoff doubles InitialBackoff with every failed attempt up to
+// MaxBackoff and takes off a random part of up to Jitter of it, so calls
+// that failed together are not made again together.
+func retryBackoff(rc config.CallRetryConfig, attempt int) time.Duration {
+	d := rc.InitialBackoff
+	for i := 1; i < attempt && d < rc.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, rc.MaxBackoff)
+	if spread := time.Duration(float64(d) * rc.Jitter); spread > 0 {
+		d -= rand.N(spread + 1)
+	}
+	return d
+}
+
+// retryCalls makes API calls that failed transiently again, see
+// retryable, up to MaxAttempts in total. Nil when disabled.
+func retryCalls(rc config.CallRetryConfig, log *zap.Logger) telegram.Middleware {
+	if rc.MaxAttempts <= 1 {
+		return nil
+	}
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			for attempt := 1; ; attempt++ {
+				err := next.Invoke(ctx, input, output)
+				if err == nil || attempt >= rc.MaxAttempts || !retryable(err) || ctx.Err() != nil {
+					return err
+				}
+				method := callName(input)
+				delay := retryBackoff(rc, attempt)
+				metrics.CallRetries.WithLabelValues(method).Inc()
+				log.Warn("Retrying API call",
+					zap.String("method", method),
+					zap.Int("attempt", attempt),
+					zap.Duration("delay", delay),
+					zap.Error(err),
+				)
+				select {
+				case <-ctx.Done():
+					return err
+				case <-time.After(delay):
+				}
+			}
+		}
+	})
+}
+
+// callName returns the TL type of a call, e.g. messages.getHistory.
+func callName(input bin.Encoder) string {
+	if t, ok := input.(interface{ TypeName() string }); ok {
+		return t.TypeName()
+	}
+	return "unknown"
+}
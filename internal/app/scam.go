@@ -0,0 +1,66 @@
+package app
+
+import (
+	"context"
+	"slices"
+
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/pipeline"
+	"go.uber.org/zap"
+)
+
+// scamStage sends a suspiciousMessage event besides new and edited messages
+// that look like scams, the message itself goes on as it is.
+func (w *watcher) scamStage(ctx context.Context, m *pipeline.Message) (bool, error) {
+	cfg := w.cfg.Load()
+	e := m.Event
+	if !cfg.Scam.Enabled {
+		return true, nil
+	}
+	switch e.Type {
+	case "newMessage", "editMessage", "comment":
+	default:
+		return true, nil
+	}
+	if watched, ok := w.watchedOf(cfg, e); !ok || !watched.Accepts("suspiciousMessage") {
+		return true, nil
+	}
+
+	scam, err := w.filters.Scam(cfg.Scam)
+	if err != nil {
+		w.log.Error("Bad scam patterns", zap.Error(err))
+		return true, nil
+	}
+	urls := make([]string, 0, len(e.Links))
+	for _, link := range e.Links {
+		urls = append(urls, link.URL)
+	}
+	found := scam.Check(e.FullText(), urls)
+	if len(found) == 0 {
+		return true, nil
+	}
+
+	findings := make([]event.Finding, 0, len(found))
+	reasons := make([]string, 0, len(found))
+	for _, f := range found {
+		findings = append(findings, event.Finding{Reason: f.Reason, Match: f.Match, Resembles: f.Resembles})
+		if !slices.Contains(reasons, f.Reason) {
+			reasons = append(reasons, f.Reason)
+			metrics.ScamFindings.WithLabelValues(f.Reason).Inc()
+		}
+	}
+	severity := cfg.Scam.Severity
+	if slices.Index(config.Severities, e.Severity) > slices.Index(config.Severities, severity) {
+		severity = e.Severity
+	}
+	w.log.Info("Suspicious message", zap.Int64("chat_id", e.ChannelID), zap.String("external_id", e.ExternalID), zap.Strings("reasons", reasons))
+
+	// The copy is enriched already.
+	metrics.MessagesReceived.WithLabelValues("suspiciousMessage").Inc()
+	if err := w.pipeline.RunFrom(ctx, pipeline.Transform, &pipeline.Message{Target: m.Target, Event: event.Suspicious(e, findings, severity)}); err != nil {
+		w.log.Error("Error sending suspicious message", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+	}
+	return true, nil
+}
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"time"
+
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go.uber.org/zap"
+)
+
+// heldUntil returns when the schedule of the chat of e opens again, the
+// zero time when e is forwarded right away. History is never held.
+func (w *watcher) heldUntil(e *event.Event) time.Time {
+	if e.Type == "oldMessage" {
+		return time.Time{}
+	}
+	watched, ok := w.watchedOf(w.cfg.Load(), e)
+	if !ok {
+		return time.Time{}
+	}
+	s, err := w.schedules.Get(watched.Schedule.Timezone, watched.Schedule.Windows, watched.Schedule.Quiet)
+	if err != nil {
+		w.log.Error("Bad schedule", zap.Stringer("channel", watched), zap.Error(err))
+		return time.Time{}
+	}
+	if s == nil {
+		return time.Time{}
+	}
+	now := time.Now()
+	next := s.Next(now)
+	if next.IsZero() {
+		w.log.Warn("Schedule never opens, forwarding right away", zap.Stringer("channel", watched))
+		return time.Time{}
+	}
+	if next.Equal(now) {
+		return time.Time{}
+	}
+	return next
+}
+
+// watchedOf returns the config of the chat of e, also of channels watched
+// by invite link or discovered. Comments belong to the channel of their post.
+func (w *watcher) watchedOf(cfg *config.Config, e *event.Event) (config.ChannelConfig, bool) {
+	chatType, id, username := e.ChatType, e.ChannelID, e.ChannelUsername
+	if e.Comment != nil {
+		chatType, id, username = config.PeerChannel, e.Comment.ChannelID, e.Comment.ChannelUsername
+	}
+	if watched, ok := cfg.FindPeer(chatType, id, username); ok {
+		return watched, true
+	}
+	if chatType != config.PeerChannel {
+		return config.ChannelConfig{}, false
+	}
+	for _, hash := range w.invites.hashes(id) {
+		if watched, ok := cfg.FindInvite(hash); ok {
+			return watched, true
+		}
+	}
+	return w.discoveredChannel(cfg, id)
+}
@@ -0,0 +1,138 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// publishMargin is how close to its date a scheduled post is deleted when
+// Telegram publishes it. Earlier deletions are cancellations.
+const publishMargin = time.Minute
+
+// scheduledPost is a post scheduled in a watched channel, by chat and
+// scheduled ID, kept until it is deleted or published.
+type scheduledPost struct {
+	text      string
+	date      int
+	published bool
+}
+
+// handleScheduledMessage emits scheduledMessage for posts scheduled in
+// watched channels, edited when the post was seen before. Only admins of a
+// channel get these updates.
+func (w *watcher) handleScheduledMessage(ctx context.Context, e tg.Entities, message tg.MessageClass) error {
+	msg, ok := message.(*tg.Message)
+	if !ok {
+		return nil
+	}
+	cfg := w.cfg.Load()
+
+	chat, watched, ok, err := w.watchedPeer(ctx, cfg, e, msg.PeerID)
+	if err != nil {
+		w.log.Error("get chat", zap.Error(err))
+		return err
+	}
+	if !ok || !watched.Accepts("scheduledMessage") {
+		return nil
+	}
+	key := messageKey{chatID: chat.ID, messageID: msg.GetID()}
+	prev, seen := w.scheduled.swap(key, scheduledPost{text: msg.GetMessage(), date: msg.Date})
+
+	status := event.ScheduledPending
+	if seen {
+		status = event.ScheduledEdited
+	}
+	ev := event.FromScheduled(cfg.Payload, chat, msg, status)
+	if seen && prev.text != msg.GetMessage() {
+		ev.Edit = event.EditOf(prev.text, msg.GetMessage())
+	}
+	if seen && prev.date != msg.Date {
+		ev.Scheduled.PreviousDate = prev.date
+	}
+	w.sendScheduled(ctx, cfg, watched, ev)
+	return nil
+}
+
+// publishScheduled emits scheduledMessage for msg, a post Telegram just
+// published from the schedule. It is matched to the scheduled post by its
+// text, the closest date first.
+func (w *watcher) publishScheduled(ctx context.Context, cfg *config.Config, watched config.ChannelConfig, chat event.Chat, msg *tg.Message) {
+	if !watched.Accepts("scheduledMessage") {
+		return
+	}
+	var (
+		match    messageKey
+		distance = -1
+	)
+	for key, post := range w.scheduled.snapshot() {
+		if key.chatID != chat.ID || post.published || post.text != msg.GetMessage() {
+			continue
+		}
+		d := post.date - msg.Date
+		if d < 0 {
+			d = -d
+		}
+		if distance < 0 || d < distance {
+			match, distance = key, d
+		}
+	}
+	scheduledID := 0
+	if distance >= 0 {
+		post, _ := w.scheduled.get(match)
+		post.published = true
+		w.scheduled.swap(match, post)
+		scheduledID = match.messageID
+	}
+	w.sendScheduled(ctx, cfg, watched, event.ScheduledPost(cfg.Payload, chat, msg, scheduledID))
+}
+
+// handleDeleteScheduledMessages emits scheduledMessage for cancelled posts.
+// Telegram deletes a scheduled post when it publishes it too, deletions of
+// published or due posts are left out, and so are those of posts scheduled
+// before the watcher saw them, which can't be told apart.
+func (w *watcher) handleDeleteScheduledMessages(ctx context.Context, e tg.Entities, peer tg.PeerClass, ids []int) error {
+	cfg := w.cfg.Load()
+
+	chat, watched, ok, err := w.watchedPeer(ctx, cfg, e, peer)
+	if err != nil {
+		w.log.Error("get chat", zap.Error(err))
+		return err
+	}
+	if !ok || !watched.Accepts("scheduledMessage") {
+		return nil
+	}
+	due := int(time.Now().Add(publishMargin).Unix())
+	for _, id := range ids {
+		key := messageKey{chatID: chat.ID, messageID: id}
+		post, ok := w.scheduled.get(key)
+		switch {
+		case !ok:
+		case post.published:
+			w.scheduled.delete(key)
+		case post.date <= due:
+			// Being published, its channel message may come next.
+		default:
+			w.scheduled.delete(key)
+			w.sendScheduled(ctx, cfg, watched, event.ScheduledDeletion(chat, id, post.text, post.date))
+		}
+	}
+	return nil
+}
+
+func (w *watcher) sendScheduled(ctx context.Context, cfg *config.Config, watched config.ChannelConfig, e *event.Event) {
+	metrics.MessagesReceived.WithLabelValues("scheduledMessage").Inc()
+	if err := w.deliver(ctx, cfg.WebhookUrlFor(watched), e); err != nil {
+		w.log.Error("Error sending scheduled message", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+	}
+	w.log.Info("Scheduled message",
+		zap.Int64("chat_id", e.ChannelID),
+		zap.String("status", e.Scheduled.Status),
+		zap.Int("scheduled_id", e.Scheduled.ScheduledID),
+	)
+}
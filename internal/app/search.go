@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/search"
+	"go-tg.com/internal/storage"
+	"go.uber.org/zap"
+)
+
+// indexBatch is how many archived messages are indexed at once when the
+// index is built.
+const indexBatch = 1000
+
+// openIndex opens the search index, building a new one from the archive.
+func openIndex(ctx context.Context, archive *storage.Archive, path string, log *zap.Logger) (*search.Index, error) {
+	index, created, err := search.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return index, nil
+	}
+
+	log.Info("Indexing the archive", zap.String("path", path))
+	started := time.Now()
+	batch, n := index.NewBatch(), 0
+	err = archive.Walk(ctx, func(m storage.Message) error {
+		if err := batch.Add(search.Message{ChannelID: m.ChannelID, MessageID: m.MessageID, Text: m.Text, Date: m.Date}); err != nil {
+			return err
+		}
+		n++
+		if batch.Size() < indexBatch {
+			return nil
+		}
+		return batch.Flush()
+	})
+	if err == nil {
+		err = batch.Flush()
+	}
+	if err != nil {
+		_ = index.Close()
+		// Built again on the next start instead of staying incomplete.
+		_ = os.RemoveAll(path)
+		return nil, errors.Wrap(err, "index archive")
+	}
+	log.Info("Archive indexed", zap.Int("messages", n), zap.Duration("took", time.Since(started)))
+	return index, nil
+}
+
+var markTags = strings.NewReplacer("<mark>", "[", "</mark>", "]")
+
+// runSearch prints the archived messages matching a query, best match
+// first. The running watcher holds the index, it is searched with GET
+// /search then.
+func runSearch(_ context.Context, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	configPath := configFlag(flags)
+	channel := flags.String("channel", "", "Search the messages of this channel ID only")
+	var from, to time.Time
+	flags.Func("from-date", "Oldest message date, 2006-01-02 or RFC 3339", dateFlag(&from, false))
+	flags.Func("to-date", "Newest message date, 2006-01-02 (the whole day) or RFC 3339", dateFlag(&to, true))
+	since := flags.Duration("since", 0, "Search the messages of this last period, e.g. 720h, instead of -from-date")
+	limit := flags.Int("limit", 20, "Print at most this many messages")
+	asJSON := flags.Bool("json", false, "Print the result as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: app search [flags] <query>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	q := search.Query{Text: strings.Join(flags.Args(), " "), Limit: *limit}
+	if q.Text == "" {
+		return errors.New("expected a query")
+	}
+	if *channel != "" {
+		var err error
+		if q.ChannelID, err = strconv.ParseInt(*channel, 10, 64); err != nil {
+			return errors.New("-channel must be a channel ID")
+		}
+	}
+	if *since < 0 || *limit <= 0 {
+		return errors.New("-since must not be negative and -limit must be positive")
+	}
+	if *since > 0 {
+		if !from.IsZero() {
+			return errors.New("-since and -from-date are mutually exclusive")
+		}
+		from = time.Now().Add(-*since)
+	}
+	if !from.IsZero() {
+		q.Since = int(from.Unix())
+	}
+	if !to.IsZero() {
+		q.Until = int(to.Unix()) + 1
+	}
+
+	cfg, _, err := loadConfig(*configPath, (*config.Config).Validate)
+	if err != nil {
+		return err
+	}
+	path := cfg.Archive.IndexPath
+	if path == "" {
+		return errors.New("search needs the index, archive.index_path is not set")
+	}
+	index, err := search.OpenReadOnly(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return errors.Errorf("no index at %s yet, it is built when the watcher starts", path)
+	case err != nil:
+		return errors.Wrapf(err, "open index %s, while the watcher runs use GET /search", path)
+	}
+	defer func() { _ = index.Close() }()
+
+	result, err := index.Search(q)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "DATE\tCHANNEL\tMESSAGE\tTEXT")
+	for _, h := range result.Hits {
+		text := h.Text
+		if len(h.Fragments) > 0 {
+			// The fragments are HTML with the matches in mark tags.
+			text = html.UnescapeString(markTags.Replace(h.Fragments[0]))
+		}
+		text = strings.Join(strings.Fields(text), " ")
+		date := time.Unix(int64(h.Date), 0).UTC().Format(time.DateTime)
+		fmt.Fprintf(out, "%s\t%d\t%d\t%s\n", date, h.ChannelID, h.MessageID, text)
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%d of %d matches\n", len(result.Hits), result.Total)
+	return nil
+}
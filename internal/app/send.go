@@ -0,0 +1,133 @@
+package app
+
+import (
+	"context"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/media"
+	"go-tg.com/internal/sink"
+	"go-tg.com/internal/tracing"
+	"go-tg.com/internal/translate"
+	"go.uber.org/zap"
+)
+
+func (w *watcher) sendMessage(ctx context.Context, cfg *config.Config, target string, chat event.Chat, msg *tg.Message, messageType string, edit *event.Edit) error {
+	e := event.FromMessage(cfg.Payload, chat, msg, messageType)
+	e.Edit = edit
+	w.describeForward(e)
+	w.describeReply(ctx, cfg, chat, msg, e)
+	w.describeSticker(ctx, e.Media)
+	if e.Media != nil {
+		w.downloadMedia(ctx, chat.ID, msg.GetID(), e.Media)
+	}
+	return w.deliver(ctx, target, e)
+}
+
+// sendComment sends a message of a discussion group as a comment under a
+// channel post.
+func (w *watcher) sendComment(ctx context.Context, cfg *config.Config, target string, group event.Chat, msg *tg.Message, comment *event.Comment) error {
+	e := event.FromMessage(cfg.Payload, group, msg, "comment")
+	e.Comment = comment
+	w.describeForward(e)
+	w.describeSticker(ctx, e.Media)
+	if e.Media != nil {
+		w.downloadMedia(ctx, group.ID, msg.GetID(), e.Media)
+	}
+	return w.deliver(ctx, target, e)
+}
+
+func (w *watcher) sendAlbum(ctx context.Context, cfg *config.Config, target string, chat event.Chat, msgs []*tg.Message, messageType string) error {
+	e := event.FromAlbum(cfg.Payload, chat, msgs, messageType)
+	w.describeForward(e)
+	for _, m := range e.Album {
+		w.downloadMedia(ctx, chat.ID, m.MessageID, m)
+	}
+	return w.deliver(ctx, target, e)
+}
+
+func (w *watcher) downloadMedia(ctx context.Context, chatID int64, msgID int, m *media.Media) {
+	if w.media == nil {
+		return
+	}
+	if err := w.media.Download(ctx, chatID, msgID, m); err != nil {
+		w.log.Warn("Media download failed, sending metadata only", zap.Int("message_id", msgID), zap.Error(err))
+	}
+}
+
+// localize sets the language of message texts and their translation. A
+// failed translation is logged and leaves it out.
+func (w *watcher) localize(ctx context.Context, e *event.Event) {
+	switch e.Type {
+	case "newMessage", "oldMessage", "editMessage":
+	default:
+		return
+	}
+	text := e.FullText()
+	if text == "" || (!w.cfg.Load().Language.Detect && w.translator == nil) {
+		return
+	}
+	e.Language, _ = translate.Detect(text)
+	if w.translator == nil || !w.translator.Needed(e.Language) {
+		return
+	}
+	ctx, span := tracing.Start(ctx, "translate")
+	translated, err := w.translator.Translate(ctx, text, e.Language)
+	tracing.End(span, err)
+	if err != nil {
+		w.log.Warn("Translation failed, sending the original only", zap.Int64("chat_id", e.ChannelID), zap.String("message_id", e.ExternalID), zap.Error(err))
+		return
+	}
+	e.TranslatedText = translated
+}
+
+// transcribe adds the text of voice notes. A failed download or
+// transcription is logged and leaves it out.
+func (w *watcher) transcribe(ctx context.Context, e *event.Event) {
+	m := e.Media
+	if w.transcriber == nil || m == nil || m.Type != "voice" || !w.transcriber.Accepts(m.Duration) {
+		return
+	}
+	ctx, span := tracing.Start(ctx, "transcribe")
+	audio, err := w.voices.Read(ctx, m)
+	if err == nil {
+		e.Transcript, err = w.transcriber.Transcribe(ctx, m.FileName, audio)
+	}
+	tracing.End(span, err)
+	if err != nil {
+		w.log.Warn("Transcription failed, sending the voice note without it", zap.Int64("chat_id", e.ChannelID), zap.String("message_id", e.ExternalID), zap.Error(err))
+	}
+}
+
+// tag sets the categories and severity of the tag rules the text matches.
+// Broken rules are logged and leave the event untagged.
+func (w *watcher) tag(e *event.Event) {
+	rules := w.cfg.Load().Tags
+	text := e.FullText()
+	if len(rules) == 0 || text == "" {
+		return
+	}
+	tagger, err := w.filters.Tagger(rules)
+	if err != nil {
+		w.log.Error("Bad tag rules", zap.Error(err))
+		return
+	}
+	e.Tags, e.Severity = tagger.Tag(text)
+}
+
+func (w *watcher) sendDeletedMessages(ctx context.Context, target string, chat event.Chat, messageIDs []int) error {
+	return w.deliver(ctx, target, event.Deleted(chat, messageIDs))
+}
+
+// checkWebhook sends one synthetic payload, bypassing the outbox so nothing is persisted.
+func checkWebhook(ctx context.Context, log *zap.Logger, webhook *sink.Webhook, cfg *config.Config) error {
+	e := event.FromMessage(cfg.Payload, event.Chat{}, &tg.Message{Message: "Test message from tg-message-watcher"}, "test")
+	if err := webhook.Send(ctx, "", e); err != nil {
+		log.Error("Webhook test failed", zap.String("url", cfg.TgApp.WebhookUrl), zap.Error(err))
+		return errors.Wrap(err, "test webhook")
+	}
+	log.Info("Webhook test succeeded", zap.String("url", cfg.TgApp.WebhookUrl))
+	return nil
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"context"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+)
+
+func (w *watcher) handleChannelService(ctx context.Context, cfg *config.Config, msg *tg.MessageService) error {
+	ch, ok := msg.GetPeerID().(*tg.PeerChannel)
+	if !ok {
+		skipUpdate(skipPeer)
+		w.log.Debug("Channel update without a channel", zap.Int("message_id", msg.GetID()))
+		return nil
+	}
+	channel, err := w.channels.Get(ctx, ch.ChannelID)
+	if err != nil {
+		w.log.Error("get channel", zap.Error(err))
+		return err
+	}
+
+	watched, ok := w.findChannel(cfg, channel)
+	if !ok {
+		return nil
+	}
+	defer w.advanceCheckpoint(channel.GetID(), msg.GetID())
+	w.sendService(ctx, cfg, watched, event.ChannelChat(channel), msg)
+	return nil
+}
+
+func (w *watcher) handleChatService(ctx context.Context, cfg *config.Config, e tg.Entities, msg *tg.MessageService) error {
+	chat, ok := peerChat(e, msg.GetPeerID())
+	if !ok {
+		skipUpdate(skipPeer)
+		return nil
+	}
+	watched, ok := cfg.FindPeer(chat.Type, chat.ID, chat.Username)
+	if !ok {
+		return nil
+	}
+	w.sendService(ctx, cfg, watched, chat, msg)
+	return nil
+}
+
+// sendService delivers pins, title and photo changes, joins and leaves.
+// Other service messages are skipped.
+func (w *watcher) sendService(ctx context.Context, cfg *config.Config, watched config.ChannelConfig, chat event.Chat, msg *tg.MessageService) {
+	ev, ok := event.FromService(chat, msg)
+	if !ok {
+		skipUpdate(skipService)
+		w.log.Debug("Service message skipped", zap.Int64("chat_id", chat.ID), zap.String("action", msg.Action.TypeName()))
+		return
+	}
+	if ev.Type == "messagePinned" && ev.Service.PinnedMessageID != 0 {
+		w.pins.pin(peerKey{chat.Type, chat.ID}, ev.Service.PinnedMessageID)
+	}
+	if !watched.Accepts(ev.Type) {
+		return
+	}
+	metrics.MessagesReceived.WithLabelValues(ev.Type).Inc()
+	if err := w.deliver(ctx, cfg.WebhookUrlFor(watched), ev); err != nil {
+		w.log.Error("Error sending service message", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+	}
+	w.log.Info("Service message", zap.String("type", ev.Type), zap.Int64("chat_id", chat.ID), zap.Int("message_id", msg.GetID()))
+}
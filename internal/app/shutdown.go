@@ -0,0 +1,27 @@
+package app
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// shutdown delivers what is still buffered after updates stopped: albums
+// waiting for their window, digests of throttled events and pending outbox
+// entries. It gives up after timeout, whatever is left stays in the outbox
+// for the next start. Checkpoints are written on every advance, so there is
+// nothing to flush.
+func (w *watcher) shutdown(ctx context.Context, timeout time.Duration) {
+	w.log.Info("Shutting down", zap.Int("outbox_depth", w.outbox.Depth()), zap.Duration("timeout", timeout))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	w.albums.flushAll(ctx)
+	w.throttle.flushAll(ctx)
+	if err := w.outbox.Drain(ctx); err != nil {
+		w.log.Warn("Outbox not drained, left for the next start", zap.Int("depth", w.outbox.Depth()), zap.Error(err))
+		return
+	}
+	w.log.Info("Outbox drained")
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/storage"
+	"go.uber.org/zap"
+)
+
+const defaultSnapshotsInterval = time.Hour
+
+// snapshotChannels records the metadata of the watched channels right away
+// and then every snapshots.interval until ctx is done.
+func (w *watcher) snapshotChannels(ctx context.Context) {
+	for {
+		cfg := w.cfg.Load()
+		if cfg.Snapshots.Enabled {
+			if err := w.snapshot(ctx, cfg); err != nil && ctx.Err() == nil {
+				w.log.Warn("Channel snapshots failed", zap.Error(err))
+			}
+		}
+
+		interval := cfg.Snapshots.Interval
+		if interval <= 0 {
+			interval = defaultSnapshotsInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// snapshot records the metadata of every watched channel and sends
+// channelChanged events for the ones that changed since the last snapshot.
+func (w *watcher) snapshot(ctx context.Context, cfg *config.Config) error {
+	var errs []error
+	for _, ch := range cfg.WatchedChannels() {
+		if ch.PeerType() != config.PeerChannel || ch.ViaBot() {
+			continue
+		}
+		channel, err := w.resolveChannel(ctx, ch)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := w.snapshotChannel(ctx, cfg, ch, channel); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (w *watcher) snapshotChannel(ctx context.Context, cfg *config.Config, watched config.ChannelConfig, channel *tg.Channel) error {
+	full, err := w.api.ChannelsGetFullChannel(ctx, channel.AsInput())
+	if err != nil {
+		return w.channels.InvalidateOn(channel.ID, err)
+	}
+	// The full channel comes with the current title and username.
+	for _, chat := range full.GetChats() {
+		if c, ok := chat.(*tg.Channel); ok && c.ID == channel.ID {
+			w.channels.Put(c)
+			channel = c
+		}
+	}
+	info := event.ChannelInfo{Title: channel.Title, Username: channel.Username}
+	if f, ok := full.FullChat.(*tg.ChannelFull); ok {
+		info.About = f.About
+		info.Participants, _ = f.GetParticipantsCount()
+	}
+
+	// The archive has the snapshot of the previous run after a restart.
+	prev, ok := w.snapshots.swap(channel.ID, info)
+	if w.archive != nil {
+		archived, err := w.archive.SaveChannelSnapshot(ctx, storage.ChannelSnapshot{
+			ChannelID:    channel.ID,
+			Title:        info.Title,
+			Username:     info.Username,
+			About:        info.About,
+			Participants: info.Participants,
+			TakenAt:      time.Now().Unix(),
+		})
+		if err != nil {
+			return err
+		}
+		if archived != nil {
+			prev = event.ChannelInfo{Title: archived.Title, Username: archived.Username, About: archived.About, Participants: archived.Participants}
+			ok = true
+		}
+	}
+	if !ok {
+		return nil
+	}
+	changed := info.Changes(prev)
+	if len(changed) == 0 {
+		return nil
+	}
+
+	w.log.Info("Channel changed", zap.Int64("channel_id", channel.ID), zap.Strings("changed", changed),
+		zap.String("title", info.Title), zap.String("previous_title", prev.Title))
+	if !watched.Accepts("channelChanged") {
+		return nil
+	}
+	metrics.MessagesReceived.WithLabelValues("channelChanged").Inc()
+	change := &event.ChannelChange{ChannelInfo: info, Previous: prev, Changed: changed}
+	if err := w.deliver(ctx, cfg.WebhookUrlFor(watched), event.ChannelChanged(event.ChannelChat(channel), change)); err != nil {
+		w.log.Error("Error sending channel change", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+	}
+	return nil
+}
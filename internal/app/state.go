@@ -0,0 +1,348 @@
+package app
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/config"
+)
+
+// snapshotVersion is the format of state snapshots, bumped on incompatible
+// changes.
+const snapshotVersion = 1
+
+// snapshotManifest is the first entry of a snapshot, Files lists the keys
+// of the state in it.
+type snapshotManifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Files     []string  `json:"files"`
+}
+
+const manifestName = "snapshot.json"
+
+// stateFile is a file or directory of the watcher state. Snapshots name it
+// by key, so importing puts it where the config of the new host wants it.
+type stateFile struct {
+	key  string
+	path string
+	dir  bool
+}
+
+// stateFiles lists the state of every tenant and account: the delivery
+// queues and dedup window, the updates state, checkpoints, peer cache and
+// update log. Sessions are left out, they are moved with their storage.
+func stateFiles(root *config.Store) []stateFile {
+	tenants := root.Load().TenantNames()
+	if len(tenants) == 0 {
+		return scopeStateFiles("", root)
+	}
+	var files []stateFile
+	for _, name := range tenants {
+		tenant, _ := root.Tenant(name)
+		files = append(files, scopeStateFiles("tenants/"+name+"/", tenant)...)
+	}
+	return files
+}
+
+func scopeStateFiles(prefix string, cfg *config.Store) []stateFile {
+	c := cfg.Load()
+	files := []stateFile{{key: prefix + "delivery/outbox", path: c.Delivery.OutboxDir, dir: true}}
+	if c.Delivery.Dedup.Path != "" {
+		files = append(files, stateFile{key: prefix + "delivery/dedup", path: c.Delivery.Dedup.Path})
+	}
+	names := c.AccountNames()
+	if len(names) == 0 {
+		return append(files, accountStateFiles(prefix+"account/", c.TgApp)...)
+	}
+	for _, name := range names {
+		account, _ := cfg.Account(name)
+		files = append(files, accountStateFiles(prefix+"accounts/"+name+"/", account.Load().TgApp)...)
+	}
+	return files
+}
+
+func accountStateFiles(prefix string, app config.TgAppConfig) []stateFile {
+	files := []stateFile{
+		{key: prefix + "updates_state", path: app.StatePath},
+		{key: prefix + "checkpoints", path: app.CheckpointPath},
+	}
+	if app.PeerCachePath != "" {
+		files = append(files, stateFile{key: prefix + "peer_cache", path: app.PeerCachePath})
+	}
+	if app.UpdateLogDir != "" {
+		files = append(files, stateFile{key: prefix + "update_log", path: app.UpdateLogDir, dir: true})
+	}
+	return files
+}
+
+// runState exports or imports the watcher state, the watcher must be
+// stopped for either.
+func runState(_ context.Context, args []string) error {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		return errors.New(`expected "state export" or "state import"`)
+	}
+	flags := flag.NewFlagSet("state "+args[0], flag.ContinueOnError)
+	configPath := configFlag(flags)
+	output := flags.String("o", "", `Snapshot file to write, "-" for stdout`)
+	force := flags.Bool("force", false, "Replace existing state on import")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	_, cfg, err := loadConfig(*configPath, (*config.Config).Validate)
+	if err != nil {
+		return err
+	}
+	// The watcher must be stopped, it holds the locks while it runs.
+	unlock, err := lockSessions(cfg)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	files := stateFiles(cfg)
+
+	if args[0] == "export" {
+		if *output == "" {
+			return errors.New("-o is required")
+		}
+		w := io.Writer(os.Stdout)
+		if *output != "-" {
+			f, err := os.Create(*output)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = f.Close() }()
+			w = f
+		}
+		keys, err := exportState(w, files)
+		if err != nil {
+			return errors.Wrap(err, "export state")
+		}
+		fmt.Fprintf(os.Stderr, "Exported %s\n", strings.Join(keys, ", "))
+		return nil
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("expected the snapshot file")
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	imported, skipped, err := importState(f, files, *force)
+	if err != nil {
+		return errors.Wrap(err, "import state")
+	}
+	fmt.Fprintf(os.Stderr, "Imported %s\n", strings.Join(imported, ", "))
+	if len(skipped) > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped, not configured here: %s\n", strings.Join(skipped, ", "))
+	}
+	return nil
+}
+
+// exportState writes the existing state files as a gzipped tar and returns
+// their keys.
+func exportState(w io.Writer, files []stateFile) ([]string, error) {
+	var present []stateFile
+	for _, f := range files {
+		if _, err := os.Stat(f.path); err == nil {
+			present = append(present, f)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	manifest := snapshotManifest{Version: snapshotVersion, CreatedAt: time.Now().UTC()}
+	for _, f := range present {
+		manifest.Files = append(manifest.Files, f.key)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTarFile(tw, manifestName, body); err != nil {
+		return nil, err
+	}
+	for _, f := range present {
+		if !f.dir {
+			if err := addTarFile(tw, f.key, f.path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err := filepath.WalkDir(f.path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(f.path, p)
+			if err != nil {
+				return err
+			}
+			return addTarFile(tw, f.key+"/"+filepath.ToSlash(rel), p)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest.Files, gz.Close()
+}
+
+func addTarFile(tw *tar.Writer, name, p string) error {
+	body, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	return writeTarFile(tw, name, body)
+}
+
+func writeTarFile(tw *tar.Writer, name string, body []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(body)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(body)
+	return err
+}
+
+// importState writes the state of a snapshot to the paths of files and
+// returns the imported keys and those not configured here. Existing state
+// is only replaced with force, directories are emptied first then.
+func importState(r io.Reader, files []stateFile, force bool) (imported, skipped []string, err error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	tr := tar.NewReader(gz)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifestName {
+		return nil, nil, errors.New("not a state snapshot")
+	}
+	var manifest snapshotManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, nil, errors.Wrap(err, "read manifest")
+	}
+	if manifest.Version != snapshotVersion {
+		return nil, nil, errors.Errorf("snapshot version %d is not supported", manifest.Version)
+	}
+
+	byKey := map[string]stateFile{}
+	for _, f := range files {
+		byKey[f.key] = f
+	}
+	for _, key := range manifest.Files {
+		f, ok := byKey[key]
+		if !ok {
+			skipped = append(skipped, key)
+			continue
+		}
+		imported = append(imported, key)
+		exists, err := stateExists(f)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case !exists:
+		case !force:
+			return nil, nil, errors.Errorf("%s exists at %s, use -force to replace it", key, f.path)
+		case f.dir:
+			if err := os.RemoveAll(f.path); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return imported, skipped, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		dest, ok, err := snapshotDest(byKey, hdr.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			continue
+		}
+		if err := writeStateFile(dest, tr); err != nil {
+			return nil, nil, errors.Wrapf(err, "write %s", hdr.Name)
+		}
+	}
+}
+
+// stateExists reports whether the file exists or the directory has files.
+func stateExists(f stateFile) (bool, error) {
+	if !f.dir {
+		_, err := os.Stat(f.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	entries, err := os.ReadDir(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return len(entries) > 0, err
+}
+
+// snapshotDest maps a snapshot entry to its path here, false for state not
+// configured here.
+func snapshotDest(byKey map[string]stateFile, name string) (string, bool, error) {
+	if f, ok := byKey[name]; ok && !f.dir {
+		return f.path, true, nil
+	}
+	for key := name; key != "."; key = path.Dir(key) {
+		f, ok := byKey[key]
+		if !ok || !f.dir {
+			continue
+		}
+		rel := filepath.FromSlash(strings.TrimPrefix(name, key+"/"))
+		if !filepath.IsLocal(rel) {
+			return "", false, errors.Errorf("bad snapshot entry %q", name)
+		}
+		return filepath.Join(f.path, rel), true, nil
+	}
+	return "", false, nil
+}
+
+// writeStateFile writes the file through a temporary file, so a failed
+// import leaves no partial file behind.
+func writeStateFile(dest string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".import-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
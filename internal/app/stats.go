@@ -0,0 +1,132 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// maxStatsIDs is the most messages one messages.getMessagesViews call takes.
+const maxStatsIDs = 100
+
+// statsPost is a channel post whose counters are polled, with the counters
+// last sent.
+type statsPost struct {
+	chat   event.Chat
+	text   string
+	posted time.Time
+	stats  event.Stats
+}
+
+// trackStats polls the counters of a channel post sent as newMessage from
+// now on, when stats are enabled and the channel accepts statsUpdated.
+// Albums are tracked by their first part with all captions as text.
+func (w *watcher) trackStats(cfg *config.Config, watched config.ChannelConfig, chat event.Chat, msg *tg.Message, text, messageType string) {
+	if !cfg.Stats.Enabled || chat.Type != config.PeerChannel || messageType != "newMessage" || !watched.Accepts("statsUpdated") {
+		return
+	}
+	w.stats.swap(messageKey{chatID: chat.ID, messageID: msg.GetID()}, statsPost{
+		chat:   chat,
+		text:   text,
+		posted: time.Unix(int64(msg.Date), 0),
+		stats:  event.StatsOf(msg),
+	})
+}
+
+// pollStats sends statsUpdated events with the new counters of the tracked
+// posts every stats.interval.
+func (w *watcher) pollStats(ctx context.Context) {
+	for {
+		interval := w.cfg.Load().Stats.Interval
+		if interval <= 0 {
+			interval = 15 * time.Minute
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		if cfg := w.cfg.Load(); cfg.Stats.Enabled {
+			if err := w.refreshStats(ctx, cfg); err != nil && ctx.Err() == nil {
+				w.log.Warn("Refresh post stats", zap.Error(err))
+			}
+		}
+	}
+}
+
+// refreshStats fetches the counters of the tracked posts per channel and
+// forgets posts older than stats.max_age.
+func (w *watcher) refreshStats(ctx context.Context, cfg *config.Config) error {
+	posts := w.stats.snapshot()
+	byChat := map[int64][]int{}
+	for key, post := range posts {
+		if time.Since(post.posted) > cfg.Stats.MaxAge {
+			w.stats.delete(key)
+			continue
+		}
+		byChat[key.chatID] = append(byChat[key.chatID], key.messageID)
+	}
+
+	var errs []error
+	for chatID, ids := range byChat {
+		slices.Sort(ids)
+		for len(ids) > 0 {
+			n := min(len(ids), maxStatsIDs)
+			if err := w.refreshChatStats(ctx, cfg, chatID, ids[:n], posts); err != nil {
+				errs = append(errs, err)
+			}
+			ids = ids[n:]
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (w *watcher) refreshChatStats(ctx context.Context, cfg *config.Config, chatID int64, ids []int, posts map[messageKey]statsPost) error {
+	channel, err := w.channels.Get(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	watched, ok := w.findChannel(cfg, channel)
+	if !ok || !watched.Accepts("statsUpdated") {
+		for _, id := range ids {
+			w.stats.delete(messageKey{chatID: chatID, messageID: id})
+		}
+		return nil
+	}
+
+	views, err := w.api.MessagesGetMessagesViews(ctx, &tg.MessagesGetMessagesViewsRequest{
+		Peer: channel.AsInputPeer(),
+		ID:   ids,
+	})
+	if err != nil {
+		return w.channels.InvalidateOn(chatID, err)
+	}
+	// The counters come in the order of the IDs.
+	for i, v := range views.Views {
+		if i >= len(ids) {
+			break
+		}
+		key := messageKey{chatID: chatID, messageID: ids[i]}
+		post := posts[key]
+		stats := event.MessageViewsStats(v)
+		if stats == post.stats {
+			continue
+		}
+		post.stats = stats
+		w.stats.swap(key, post)
+
+		metrics.MessagesReceived.WithLabelValues("statsUpdated").Inc()
+		if err := w.deliver(ctx, cfg.WebhookUrlFor(watched), event.StatsUpdated(post.chat, ids[i], post.text, &stats)); err != nil {
+			w.log.Error("Error sending post stats", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package app
+
+import (
+	"context"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/media"
+	"go.uber.org/zap"
+)
+
+// describeSticker fills in the name of the sticker set, cached by set ID.
+// A failed lookup only leaves the name out.
+func (w *watcher) describeSticker(ctx context.Context, m *media.Media) {
+	if m == nil || m.Sticker == nil {
+		return
+	}
+	set, ok := m.Sticker.Set()
+	if !ok {
+		return
+	}
+	if name, ok := w.stickerSets.get(m.Sticker.SetID); ok {
+		m.Sticker.SetName = name
+		return
+	}
+	res, err := w.api.MessagesGetStickerSet(ctx, &tg.MessagesGetStickerSetRequest{Stickerset: set})
+	if err != nil {
+		w.log.Warn("Get sticker set", zap.Int64("set_id", m.Sticker.SetID), zap.Error(err))
+		return
+	}
+	full, ok := res.(*tg.MessagesStickerSet)
+	if !ok {
+		return
+	}
+	m.Sticker.SetName = full.Set.ShortName
+	w.stickerSets.swap(m.Sticker.SetID, full.Set.ShortName)
+}
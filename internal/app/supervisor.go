@@ -0,0 +1,180 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/auth"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/sink"
+	"go.uber.org/zap"
+)
+
+// supervise runs the account and starts a new client whenever the previous
+// one stops, until ctx is done. Restarts wait a jittered backoff, a client
+// that ran longer than the longest backoff starts over from the first one.
+// The history is only fetched by the first client, later ones catch up on
+// missed updates from the saved updates state.
+func (a *account) supervise(ctx context.Context, backfill historyRange, withBackfill bool) error {
+	failures := 0
+	for {
+		started := time.Now()
+		err := a.run(ctx, backfill, withBackfill)
+		if ctx.Err() != nil {
+			return nil
+		}
+		withBackfill = false
+
+		sc := a.cfg.Load().Supervisor
+		if time.Since(started) > sc.MaxBackoff {
+			failures = 0
+		}
+		failures++
+		metrics.ClientRestarts.WithLabelValues(a.name).Inc()
+
+		delay := restartBackoff(sc, failures)
+		a.log.Error("Telegram client stopped, restarting",
+			zap.Error(err),
+			zap.Int("failures", failures),
+			zap.Duration("backoff", delay),
+		)
+		// A revoked session is reported once, the restarts log in again.
+		if sessionInvalid(err) && !a.health.sessionInvalid.Swap(true) {
+			metrics.SessionsInvalid.WithLabelValues(a.name).Inc()
+			if err := a.alert(ctx, sc.Alert, alertSessionInvalid, failures, err); err != nil {
+				a.log.Error("Send session alert", zap.Error(err))
+			}
+		}
+		if sc.AlertAfter > 0 && failures == sc.AlertAfter {
+			if err := a.alert(ctx, sc.Alert, alertClientFailing, failures, err); err != nil {
+				a.log.Error("Send restart alert", zap.Error(err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// restartBackoff doubles InitialBackoff with every failure up to MaxBackoff
+// and picks a random delay between half of it and all of it, so accounts
+// that failed together don't reconnect together.
+func restartBackoff(sc config.SupervisorConfig, failures int) time.Duration {
+	d := sc.InitialBackoff
+	if d <= 0 {
+		d = time.Second
+	}
+	for i := 1; i < failures && d < sc.MaxBackoff; i++ {
+		d *= 2
+	}
+	if sc.MaxBackoff > 0 && d > sc.MaxBackoff {
+		d = sc.MaxBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// Types of supervisor alerts.
+const (
+	alertClientFailing  = "clientFailing"
+	alertSessionInvalid = "sessionInvalid"
+)
+
+// supervisorAlert is the webhook body of a supervisor alert.
+type supervisorAlert struct {
+	Type     string    `json:"type"`
+	Account  string    `json:"account,omitempty"`
+	Failures int       `json:"failures"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+}
+
+// sessionInvalid reports whether err means the session was revoked or
+// expired or the account deactivated, the client has to log in again.
+func sessionInvalid(err error) bool {
+	return auth.IsUnauthorized(err)
+}
+
+// alert tells the configured destinations that the client keeps failing or
+// lost its session.
+func (a *account) alert(ctx context.Context, ac config.AlertConfig, kind string, failures int, cause error) error {
+	if cause == nil {
+		cause = errors.New("client stopped")
+	}
+	var errs []error
+	if ac.WebhookURL != "" {
+		errs = append(errs, alertWebhook(ctx, a.cfg, ac.WebhookURL, supervisorAlert{
+			Type:     kind,
+			Account:  a.name,
+			Failures: failures,
+			Error:    cause.Error(),
+			Time:     time.Now().UTC(),
+		}))
+	}
+	if ac.BotToken != "" {
+		name := "Telegram client"
+		if a.name != "" {
+			name = fmt.Sprintf("Telegram client of account %s", a.name)
+		}
+		text := fmt.Sprintf("%s stopped %d times in a row, last error: %v", name, failures, cause)
+		if kind == alertSessionInvalid {
+			text = fmt.Sprintf("%s lost its session and has to log in again: %v", name, cause)
+		}
+		errs = append(errs, alertBot(ctx, ac, text))
+	}
+	return errors.Join(errs...)
+}
+
+// alertWebhook posts the alert with the webhook headers and signature.
+func alertWebhook(ctx context.Context, cfg *config.Store, webhookURL string, alert any) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	webhook, err := sink.NewWebhook(cfg, webhookURL)
+	if err != nil {
+		return errors.Wrap(err, "webhook")
+	}
+	defer func() { _ = webhook.Close() }()
+	return webhook.Post(ctx, webhookURL, body)
+}
+
+// alertBot sends text to the chat with the Bot API. The watched client is
+// down at this point, so it can't be used to send the message itself.
+func alertBot(ctx context.Context, ac config.AlertConfig, text string) error {
+	body, err := json.Marshal(map[string]any{"chat_id": ac.ChatID, "text": text})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.telegram.org/bot"+ac.BotToken+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The URL holds the bot token, keep it out of the logs.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return errors.Wrap(urlErr.Err, "bot api request")
+		}
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("bot api responded with %s", resp.Status)
+	}
+	return nil
+}
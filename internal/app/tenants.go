@@ -0,0 +1,106 @@
+package app
+
+import (
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/delivery"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// tenant is a team sharing the process with others, see
+// config.TenantConfig: its accounts deliver to its own sinks. Without
+// tenants the whole config is a single unnamed one.
+type tenant struct {
+	name     string
+	log      *zap.Logger
+	cfg      *config.Store
+	out      *outputs
+	accounts []*account
+}
+
+// newTenants opens the sinks and accounts of every tenant, the other
+// outputs of out are shared.
+func newTenants(cfg *config.Store, log *zap.Logger, out *outputs, dryRun bool) ([]*tenant, func(), error) {
+	names := cfg.Load().TenantNames()
+	if len(names) == 0 {
+		accounts, err := newAccounts(cfg, log, out)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*tenant{{log: log, cfg: cfg, out: out, accounts: accounts}}, func() {}, nil
+	}
+
+	var closers []func()
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+	tenants := make([]*tenant, 0, len(names))
+	for _, name := range names {
+		tenantCfg, _ := cfg.Tenant(name)
+		tenantLog := log.With(zap.String("tenant", name))
+		lag := newDeliveryLag(name)
+		fanout, closeSinks, err := newFanout(tenantCfg, tenantLog, out.telegram, out.alerts, lag, dryRun)
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "tenant %s", name)
+		}
+		closers = append(closers, closeSinks)
+		metrics.RegisterTenantQueueDepth(name, fanout.Depth)
+
+		tenantOut := *out
+		tenantOut.outbox = fanout
+		tenantOut.lag = lag
+		accounts, err := newAccounts(tenantCfg, tenantLog, &tenantOut)
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "tenant %s", name)
+		}
+		tenants = append(tenants, &tenant{name: name, log: tenantLog, cfg: tenantCfg, out: &tenantOut, accounts: accounts})
+	}
+	return tenants, closeAll, nil
+}
+
+// allAccounts lists the accounts of all tenants.
+func allAccounts(tenants []*tenant) []*account {
+	var accounts []*account
+	for _, t := range tenants {
+		accounts = append(accounts, t.accounts...)
+	}
+	return accounts
+}
+
+// fanouts lists the sinks of all tenants.
+func fanouts(tenants []*tenant) []*delivery.Fanout {
+	fanouts := make([]*delivery.Fanout, 0, len(tenants))
+	for _, t := range tenants {
+		fanouts = append(fanouts, t.out.outbox)
+	}
+	return fanouts
+}
+
+// queueDepth sums the outbox depths of all tenants.
+func queueDepth(tenants []*tenant) int {
+	depth := 0
+	for _, t := range tenants {
+		depth += t.out.outbox.Depth()
+	}
+	return depth
+}
+
+// queueDepths returns the outbox depth of every sink, with tenants named
+// <tenant>/<sink>.
+func queueDepths(tenants []*tenant) map[string]int {
+	depths := map[string]int{}
+	for _, t := range tenants {
+		for name, depth := range t.out.outbox.Depths() {
+			if t.name != "" {
+				name = t.name + "/" + name
+			}
+			depths[name] = depth
+		}
+	}
+	return depths
+}
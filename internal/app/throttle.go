@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/delivery"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// maxThrottledMessages bounds the events listed in the digest of a chat,
+// the count goes on.
+const maxThrottledMessages = 100
+
+// throttle limits the events delivered per chat and of all chats together
+// with token buckets. It is shared by the accounts, so the global limit
+// holds for all of them. The limits follow the config on every event.
+type throttle struct {
+	mux      sync.Mutex
+	cfg      config.ThrottleConfig
+	global   *rate.Limiter
+	chats    map[int64]*chatThrottle
+	flushing sync.WaitGroup
+}
+
+type chatThrottle struct {
+	limiter *rate.Limiter
+	// overflow counts events over the limit, it picks the samples.
+	overflow int
+	digest   *pendingDigest
+}
+
+// pendingDigest collects the events over the limit of a chat until it is
+// sent with send. The updates of the events are acknowledged then.
+type pendingDigest struct {
+	ctx   context.Context
+	event *event.Event
+	send  func(ctx context.Context, e *event.Event) error
+	acks  []func(ok bool)
+	timer *time.Timer
+}
+
+func (d *pendingDigest) flush(ctx context.Context) {
+	err := d.send(ctx, d.event)
+	for _, ack := range d.acks {
+		ack(err == nil)
+	}
+}
+
+func newThrottle() *throttle {
+	return &throttle{chats: map[int64]*chatThrottle{}}
+}
+
+func newLimiter(perMinute float64, burst int) *rate.Limiter {
+	if perMinute == 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(perMinute/60), burst)
+}
+
+// allow tells whether e is delivered now. Events over the limit are
+// dropped, sampled or collected into a digest handed to send later.
+func (t *throttle) allow(ctx context.Context, cfg config.ThrottleConfig, e *event.Event, send func(ctx context.Context, e *event.Event) error) bool {
+	if cfg.PerMinute == 0 && cfg.GlobalPerMinute == 0 {
+		return true
+	}
+
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	if cfg != t.cfg {
+		t.cfg = cfg
+		t.global = newLimiter(cfg.GlobalPerMinute, cfg.GlobalBurst)
+		for _, c := range t.chats {
+			c.limiter = newLimiter(cfg.PerMinute, cfg.Burst)
+		}
+	}
+	c, ok := t.chats[e.ChannelID]
+	if !ok {
+		c = &chatThrottle{limiter: newLimiter(cfg.PerMinute, cfg.Burst)}
+		t.chats[e.ChannelID] = c
+	}
+	if c.limiter.Allow() && t.global.Allow() {
+		return true
+	}
+
+	metrics.EventsThrottled.WithLabelValues(cfg.Overflow).Inc()
+	switch cfg.Overflow {
+	case "sample":
+		c.overflow++
+		return c.overflow%cfg.SampleEvery == 0
+	case "digest":
+		if c.digest == nil {
+			c.digest = t.startDigest(ctx, cfg.DigestInterval, c, e, send)
+		}
+		c.digest.event.Digest.Add(e)
+		c.digest.acks = append(c.digest.acks, delivery.DeferAck(ctx))
+	}
+	return false
+}
+
+// startDigest sends the digest of the chat after interval. The caller
+// holds the lock.
+func (t *throttle) startDigest(ctx context.Context, interval time.Duration, c *chatThrottle, e *event.Event, send func(ctx context.Context, e *event.Event) error) *pendingDigest {
+	d := &pendingDigest{
+		// The handler context ends with the update, the digest is sent later.
+		ctx:   context.WithoutCancel(ctx),
+		event: event.NewDigest("throttled", e, maxThrottledMessages),
+		send:  send,
+	}
+	t.flushing.Add(1)
+	d.timer = time.AfterFunc(interval, func() {
+		defer t.flushing.Done()
+		t.mux.Lock()
+		c.digest = nil
+		t.mux.Unlock()
+
+		d.flush(d.ctx)
+	})
+	return d
+}
+
+// flushAll sends every pending digest right away and waits for digests
+// that are already being sent.
+func (t *throttle) flushAll(ctx context.Context) {
+	var digests []*pendingDigest
+	t.mux.Lock()
+	for _, c := range t.chats {
+		if c.digest != nil && c.digest.timer.Stop() {
+			digests = append(digests, c.digest)
+			c.digest = nil
+		}
+	}
+	t.mux.Unlock()
+
+	for _, d := range digests {
+		d.flush(ctx)
+		t.flushing.Done()
+	}
+	t.flushing.Wait()
+}
+
+// sendDigest delivers the events a chat sent over the rate limit as one.
+func (w *watcher) sendDigest(target string) func(ctx context.Context, e *event.Event) error {
+	return func(ctx context.Context, e *event.Event) error {
+		e.Text = fmt.Sprintf("%d events of %s over the rate limit", e.Digest.Count, e.ChatName())
+		err := w.publish(ctx, target, e)
+		if err != nil {
+			w.log.Error("Error sending digest", zap.Error(err), zap.Int("outbox_depth", w.outbox.Depth()))
+		}
+		return err
+	}
+}
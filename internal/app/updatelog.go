@@ -0,0 +1,128 @@
+package app
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/delivery"
+	"go-tg.com/internal/metrics"
+	tgService "go-tg.com/internal/services/telegram"
+	"go.uber.org/zap"
+)
+
+// loggedUpdates writes every update to the update log before next handles
+// it and acks it once next returned without an error and its events are in
+// the outboxes: events kept in memory, album parts and digests, defer the
+// ack until they are written, see delivery.DeferAck. Updates of a crashed
+// run are handled again by replay before new ones, so none is lost between
+// receiving and delivering it.
+type loggedUpdates struct {
+	updates *tgService.UpdateLog
+	next    telegram.UpdateHandler
+	log     *zap.Logger
+}
+
+func (h *loggedUpdates) Handle(ctx context.Context, u tg.UpdatesClass) error {
+	var b bin.Buffer
+	if err := u.Encode(&b); err != nil {
+		return errors.Wrap(err, "encode update")
+	}
+	id, err := h.updates.Append(b.Buf)
+	if err != nil {
+		// Handled anyway, it is only at risk until the outbox has it.
+		h.log.Error("Write update log", zap.Error(err))
+		return h.next.Handle(ctx, u)
+	}
+	return h.handle(ctx, id, u)
+}
+
+func (h *loggedUpdates) handle(ctx context.Context, id string, u tg.UpdatesClass) error {
+	p := &pendingUpdate{ack: func() {
+		if err := h.updates.Ack(id); err != nil {
+			h.log.Warn("Ack update log entry", zap.String("id", id), zap.Error(err))
+		}
+	}}
+	err := h.next.Handle(delivery.WithAck(ctx, p.deferAck), u)
+	p.handled(err == nil)
+	return err
+}
+
+// pendingUpdate acks an update once it was handled and the events it left
+// in memory are written.
+type pendingUpdate struct {
+	ack func()
+
+	mux      sync.Mutex
+	deferred int
+	done     bool
+	failed   bool
+}
+
+func (p *pendingUpdate) deferAck() func(ok bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.done && p.deferred == 0 {
+		// Acked already, events of a finished update are not waited for.
+		return func(bool) {}
+	}
+	p.deferred++
+	var once sync.Once
+	return func(ok bool) {
+		once.Do(func() { p.release(ok) })
+	}
+}
+
+func (p *pendingUpdate) release(ok bool) {
+	p.mux.Lock()
+	p.deferred--
+	p.failed = p.failed || !ok
+	acked := p.done && p.deferred == 0 && !p.failed
+	p.mux.Unlock()
+	if acked {
+		p.ack()
+	}
+}
+
+func (p *pendingUpdate) handled(ok bool) {
+	p.mux.Lock()
+	p.done = true
+	p.failed = p.failed || !ok
+	acked := p.deferred == 0 && !p.failed
+	p.mux.Unlock()
+	if acked {
+		p.ack()
+	}
+}
+
+// replay handles the updates left in the log by the previous run. Updates
+// that fail again stay for the next start.
+func (h *loggedUpdates) replay(ctx context.Context) error {
+	pending, err := h.updates.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	h.log.Info("Replaying unhandled updates", zap.Int("count", len(pending)))
+	for _, entry := range pending {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		u, err := tg.DecodeUpdates(&bin.Buffer{Buf: entry.Data})
+		if err != nil {
+			h.log.Error("Skip broken update log entry", zap.String("id", entry.ID), zap.Error(err))
+			continue
+		}
+		if err := h.handle(ctx, entry.ID, u); err != nil {
+			h.log.Warn("Replayed update failed", zap.String("id", entry.ID), zap.Error(err))
+			continue
+		}
+		metrics.UpdatesReplayed.Inc()
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+// Package botapi is a minimal client of the Telegram Bot API receiving the
+// messages of the chats a bot is a member of.
+package botapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AllowedUpdates are the update types the watcher asks for.
+var AllowedUpdates = []string{"message", "edited_message", "channel_post", "edited_channel_post"}
+
+// Client calls the Bot API with the token of a bot.
+type Client struct {
+	base string
+	http *http.Client
+}
+
+func New(apiURL, token string) *Client {
+	return &Client{
+		base: strings.TrimSuffix(apiURL, "/") + "/bot" + token + "/",
+		http: &http.Client{},
+	}
+}
+
+// Error is an unsuccessful response of the Bot API. RetryAfter is set when
+// the bot is rate limited.
+type Error struct {
+	Code        int
+	Description string
+	RetryAfter  time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("bot api: %d %s", e.Code, e.Description)
+}
+
+type response struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+// GetUpdates long polls for the updates after offset, waiting up to timeout
+// when there are none.
+func (c *Client) GetUpdates(ctx context.Context, offset int64, timeout time.Duration) ([]Update, error) {
+	var updates []Update
+	err := c.call(ctx, "getUpdates", map[string]any{
+		"offset":          offset,
+		"timeout":         int(timeout.Seconds()),
+		"allowed_updates": AllowedUpdates,
+	}, timeout, &updates)
+	return updates, err
+}
+
+// SetWebhook has Telegram post the updates to webhookURL with secret in
+// the X-Telegram-Bot-Api-Secret-Token header.
+func (c *Client) SetWebhook(ctx context.Context, webhookURL, secret string) error {
+	params := map[string]any{"url": webhookURL, "allowed_updates": AllowedUpdates}
+	if secret != "" {
+		params["secret_token"] = secret
+	}
+	return c.call(ctx, "setWebhook", params, 0, nil)
+}
+
+// DeleteWebhook removes the webhook, getUpdates fails while one is set.
+func (c *Client) DeleteWebhook(ctx context.Context) error {
+	return c.call(ctx, "deleteWebhook", map[string]any{}, 0, nil)
+}
+
+// call posts params to method and decodes the result into result unless
+// it is nil. wait extends the request timeout for long polling.
+func (c *Client) call(ctx context.Context, method string, params any, wait time.Duration, result any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, wait+30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// The URL holds the bot token, keep it out of the logs.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("bot api %s: %w", method, urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("bot api %s: %s: %w", method, resp.Status, err)
+	}
+	if !r.OK {
+		return &Error{Code: r.ErrorCode, Description: r.Description, RetryAfter: time.Duration(r.Parameters.RetryAfter) * time.Second}
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result, result)
+}
@@ -0,0 +1,161 @@
+package botapi
+
+// Update is one update of getUpdates or the webhook, with one of the
+// message fields set.
+type Update struct {
+	UpdateID          int64    `json:"update_id"`
+	Message           *Message `json:"message,omitempty"`
+	EditedMessage     *Message `json:"edited_message,omitempty"`
+	ChannelPost       *Message `json:"channel_post,omitempty"`
+	EditedChannelPost *Message `json:"edited_channel_post,omitempty"`
+}
+
+// Sent returns the message of u and whether it was edited, nil for other
+// updates.
+func (u Update) Sent() (msg *Message, edited bool) {
+	switch {
+	case u.Message != nil:
+		return u.Message, false
+	case u.ChannelPost != nil:
+		return u.ChannelPost, false
+	case u.EditedMessage != nil:
+		return u.EditedMessage, true
+	case u.EditedChannelPost != nil:
+		return u.EditedChannelPost, true
+	}
+	return nil, false
+}
+
+// Chat types.
+const (
+	ChatPrivate    = "private"
+	ChatGroup      = "group"
+	ChatSupergroup = "supergroup"
+	ChatChannel    = "channel"
+)
+
+type Chat struct {
+	ID       int64  `json:"id"`
+	Type     string `json:"type"`
+	Title    string `json:"title,omitempty"`
+	Username string `json:"username,omitempty"`
+	IsForum  bool   `json:"is_forum,omitempty"`
+}
+
+// channelIDShift is added to the IDs of channels and supergroups, negated,
+// in the Bot API.
+const channelIDShift = 1_000_000_000_000
+
+// PeerID returns the ID of the chat in the MTProto API the user session
+// sees: Bot API IDs of channels and groups are negative, of channels with
+// a -100 prefix.
+func (c Chat) PeerID() int64 {
+	switch c.Type {
+	case ChatChannel, ChatSupergroup:
+		return -c.ID - channelIDShift
+	case ChatGroup:
+		return -c.ID
+	default:
+		return c.ID
+	}
+}
+
+type User struct {
+	ID        int64  `json:"id"`
+	IsBot     bool   `json:"is_bot,omitempty"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name,omitempty"`
+	Username  string `json:"username,omitempty"`
+}
+
+type Message struct {
+	MessageID       int             `json:"message_id"`
+	MessageThreadID int             `json:"message_thread_id,omitempty"`
+	IsTopicMessage  bool            `json:"is_topic_message,omitempty"`
+	From            *User           `json:"from,omitempty"`
+	SenderChat      *Chat           `json:"sender_chat,omitempty"`
+	Chat            Chat            `json:"chat"`
+	Date            int             `json:"date"`
+	EditDate        int             `json:"edit_date,omitempty"`
+	AuthorSignature string          `json:"author_signature,omitempty"`
+	ForwardOrigin   *MessageOrigin  `json:"forward_origin,omitempty"`
+	ReplyToMessage  *Message        `json:"reply_to_message,omitempty"`
+	MediaGroupID    string          `json:"media_group_id,omitempty"`
+	Text            string          `json:"text,omitempty"`
+	Entities        []MessageEntity `json:"entities,omitempty"`
+	Caption         string          `json:"caption,omitempty"`
+	CaptionEntities []MessageEntity `json:"caption_entities,omitempty"`
+	Photo           []PhotoSize     `json:"photo,omitempty"`
+	Video           *File           `json:"video,omitempty"`
+	Animation       *File           `json:"animation,omitempty"`
+	Audio           *File           `json:"audio,omitempty"`
+	Voice           *File           `json:"voice,omitempty"`
+	VideoNote       *File           `json:"video_note,omitempty"`
+	Document        *File           `json:"document,omitempty"`
+	Sticker         *Sticker        `json:"sticker,omitempty"`
+}
+
+// Body returns the text of the message or the caption of its media with
+// their entities.
+func (m *Message) Body() (string, []MessageEntity) {
+	if m.Text != "" {
+		return m.Text, m.Entities
+	}
+	return m.Caption, m.CaptionEntities
+}
+
+// MessageOrigin is where a forwarded message comes from: a user, a hidden
+// user, a chat or a channel.
+type MessageOrigin struct {
+	Type            string `json:"type"`
+	Date            int    `json:"date"`
+	SenderUser      *User  `json:"sender_user,omitempty"`
+	SenderUserName  string `json:"sender_user_name,omitempty"`
+	SenderChat      *Chat  `json:"sender_chat,omitempty"`
+	Chat            *Chat  `json:"chat,omitempty"`
+	MessageID       int    `json:"message_id,omitempty"`
+	AuthorSignature string `json:"author_signature,omitempty"`
+}
+
+// MessageEntity is a formatted part of a text. Offset and Length are in
+// UTF-16 code units.
+type MessageEntity struct {
+	Type          string `json:"type"`
+	Offset        int    `json:"offset"`
+	Length        int    `json:"length"`
+	URL           string `json:"url,omitempty"`
+	User          *User  `json:"user,omitempty"`
+	Language      string `json:"language,omitempty"`
+	CustomEmojiID string `json:"custom_emoji_id,omitempty"`
+}
+
+type PhotoSize struct {
+	FileID   string `json:"file_id"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	FileSize int64  `json:"file_size,omitempty"`
+}
+
+// File is a video, animation, audio, voice message, video note or
+// document, with the fields of its kind.
+type File struct {
+	FileID   string `json:"file_id"`
+	FileName string `json:"file_name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	FileSize int64  `json:"file_size,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+	Length   int    `json:"length,omitempty"` // of video notes
+}
+
+type Sticker struct {
+	FileID     string `json:"file_id"`
+	Width      int    `json:"width"`
+	Height     int    `json:"height"`
+	Emoji      string `json:"emoji,omitempty"`
+	SetName    string `json:"set_name,omitempty"`
+	IsAnimated bool   `json:"is_animated,omitempty"`
+	IsVideo    bool   `json:"is_video,omitempty"`
+	FileSize   int64  `json:"file_size,omitempty"`
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RuntimeChannels are the changes made to the watched channels at runtime
+// through the admin API, kept in tg_app.channels_path across restarts.
+// Added channels are watched besides the configured ones, configured
+// channels with an ID or username in Removed are not.
+type RuntimeChannels struct {
+	Added   []ChannelConfig `yaml:"added,omitempty"`
+	Removed []string        `yaml:"removed,omitempty"`
+}
+
+func (r RuntimeChannels) empty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0
+}
+
+// removes reports whether ch is removed.
+func (r RuntimeChannels) removes(ch ChannelConfig) bool {
+	return slices.ContainsFunc(r.Removed, func(key string) bool { return ch.Is(key) })
+}
+
+// Is reports whether the channel has key as its ID or username.
+func (c ChannelConfig) Is(key string) bool {
+	if id, err := strconv.ParseInt(key, 10, 64); err == nil {
+		return c.ID != 0 && c.ID == id
+	}
+	username := strings.TrimPrefix(trimLinkHost(key), "@")
+	return username != "" && strings.EqualFold(c.NormalizedUsername(), username)
+}
+
+// ErrChannelWatched is returned for an added channel that is watched already.
+var ErrChannelWatched = errors.New("channel is watched already")
+
+// ErrChannelNotWatched is returned for a removed channel that is not watched.
+var ErrChannelNotWatched = errors.New("channel is not watched")
+
+// LoadChannels applies the runtime channel changes saved in
+// tg_app.channels_path, a missing file means there are none.
+func (s *Store) LoadChannels() error {
+	path := s.Load().TgApp.ChannelsPath
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read runtime channels file: %w", err)
+	}
+	var runtime RuntimeChannels
+	if err := yaml.Unmarshal(data, &runtime); err != nil {
+		return fmt.Errorf("decode runtime channels file: %w", err)
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.runtime = runtime
+	s.storeRuntime()
+	return nil
+}
+
+// AddChannel watches ch from now on.
+func (s *Store) AddChannel(ch ChannelConfig) error {
+	var p problems
+	validateChannel(&p, fmt.Sprintf("channel %s", ch), ch)
+	if err := p.err(); err != nil {
+		return err
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	cfg := s.Load()
+	for _, key := range []string{strconv.FormatInt(ch.ID, 10), ch.NormalizedUsername()} {
+		if _, ok := cfg.findWatched(key); ok {
+			return ErrChannelWatched
+		}
+	}
+	if ch.Invite != "" {
+		if _, ok := cfg.FindInvite(ch.InviteHash()); ok {
+			return ErrChannelWatched
+		}
+	}
+
+	// A removed configured channel stays removed, added again it is watched
+	// with the settings it is added with.
+	next := RuntimeChannels{
+		Added:   append(slices.Clone(s.runtime.Added), ch),
+		Removed: s.runtime.Removed,
+	}
+	return s.saveRuntime(next)
+}
+
+// RemoveChannel stops watching the channel with key as its ID or username.
+func (s *Store) RemoveChannel(key string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.Load().findWatched(key); !ok {
+		return ErrChannelNotWatched
+	}
+
+	next := RuntimeChannels{
+		Added:   slices.DeleteFunc(slices.Clone(s.runtime.Added), func(ch ChannelConfig) bool { return ch.Is(key) }),
+		Removed: slices.Clone(s.runtime.Removed),
+	}
+	if len(next.Added) == len(s.runtime.Added) {
+		next.Removed = append(next.Removed, key)
+	}
+	return s.saveRuntime(next)
+}
+
+// saveRuntime writes the runtime changes to tg_app.channels_path and applies
+// them, s.mux is held.
+func (s *Store) saveRuntime(next RuntimeChannels) error {
+	if path := s.Load().TgApp.ChannelsPath; path != "" {
+		data, err := yaml.Marshal(next)
+		if err != nil {
+			return err
+		}
+		if err := writeFile(path, data); err != nil {
+			return fmt.Errorf("write runtime channels file: %w", err)
+		}
+	}
+	s.runtime = next
+	s.storeRuntime()
+	return nil
+}
+
+// storeRuntime swaps in the current config with the runtime changes, s.mux
+// is held.
+func (s *Store) storeRuntime() {
+	next := *s.Load()
+	next.TgApp.Runtime = s.runtime
+	s.current.Store(&next)
+}
+
+func (c *Config) findWatched(key string) (ChannelConfig, bool) {
+	if key == "" || key == "0" {
+		return ChannelConfig{}, false
+	}
+	for _, ch := range c.WatchedChannels() {
+		if ch.Is(key) {
+			return ch, true
+		}
+	}
+	return ChannelConfig{}, false
+}
+
+// writeFile replaces the file at path atomically.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,271 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// Secret references, values of secret fields starting with one of these are
+// read from the provider instead of taken as they are:
+//
+//	file:/run/secrets/app_hash       the file content, trailing whitespace trimmed
+//	vault:secret/data/tg#app_hash    a field of a Vault secret, the path as in the HTTP API
+//	aws-sm:prod/tg#app_hash          a Secrets Manager secret, a key of it when it is JSON
+const (
+	secretFile  = "file:"
+	secretVault = "vault:"
+	secretAWS   = "aws-sm:"
+)
+
+// secretsTimeout bounds the provider requests of one resolution.
+const secretsTimeout = 30 * time.Second
+
+// resolveSecrets replaces the secret references in the fields tagged
+// secret:"true" and applies the *_file variants.
+func resolveSecrets(cfg *Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), secretsTimeout)
+	defer cancel()
+
+	r := &secretResolver{cfg: cfg.Secrets, cache: map[string]map[string]any{}}
+	// The Vault token can't come from Vault itself.
+	if strings.HasPrefix(r.cfg.Vault.Token, secretFile) {
+		token, err := r.resolve(ctx, r.cfg.Vault.Token)
+		if err != nil {
+			return fmt.Errorf("secrets.vault.token: %w", err)
+		}
+		r.cfg.Vault.Token = token
+		cfg.Secrets.Vault.Token = token
+	}
+
+	if err := fileVariants(&cfg.TgApp); err != nil {
+		return fmt.Errorf("tg_app.%w", err)
+	}
+	for i := range cfg.Accounts {
+		if err := fileVariants(&cfg.Accounts[i].TgApp); err != nil {
+			return fmt.Errorf("accounts[%d].tg_app.%w", i, err)
+		}
+	}
+	for i, t := range cfg.Tenants {
+		if err := fileVariants(&cfg.Tenants[i].TgApp); err != nil {
+			return fmt.Errorf("tenants[%d].tg_app.%w", i, err)
+		}
+		for j := range t.Accounts {
+			if err := fileVariants(&t.Accounts[j].TgApp); err != nil {
+				return fmt.Errorf("tenants[%d].accounts[%d].tg_app.%w", i, j, err)
+			}
+		}
+	}
+	return r.walk(ctx, reflect.ValueOf(cfg).Elem(), "")
+}
+
+// fileVariants reads app_hash_file and webhook_secret_file.
+func fileVariants(app *TgAppConfig) error {
+	for _, v := range []struct {
+		name  string
+		path  string
+		value *string
+	}{
+		{"app_hash_file", app.AppHashFile, &app.AppHash},
+		{"webhook_secret_file", app.WebhookSecretFile, &app.WebhookSecret},
+	} {
+		if v.path == "" {
+			continue
+		}
+		secret, err := readSecretFile(v.path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", v.name, err)
+		}
+		*v.value = secret
+	}
+	return nil
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), " \t\r\n"), nil
+}
+
+// secretResolver reads secret references, every Vault and Secrets Manager
+// secret once per resolution.
+type secretResolver struct {
+	cfg   SecretsConfig
+	cache map[string]map[string]any
+	aws   *secretsmanager.Client
+}
+
+// walk resolves the secret fields of v and the structs it holds, name is the
+// YAML path of v for errors.
+func (r *secretResolver) walk(ctx context.Context, v reflect.Value, name string) error {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return r.walk(ctx, v.Elem(), name)
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := r.walk(ctx, v.Index(i), fmt.Sprintf("%s[%d]", name, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if key == "" || key == "-" {
+				key = strings.ToLower(field.Name)
+			}
+			if name != "" {
+				key = name + "." + key
+			}
+			f := v.Field(i)
+			if field.Tag.Get("secret") == "true" && f.Kind() == reflect.String {
+				secret, err := r.resolve(ctx, f.String())
+				if err != nil {
+					return fmt.Errorf("%s: %w", key, err)
+				}
+				f.SetString(secret)
+				continue
+			}
+			if err := r.walk(ctx, f, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve returns the secret value references, other values as they are.
+func (r *secretResolver) resolve(ctx context.Context, value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretFile):
+		return readSecretFile(strings.TrimPrefix(value, secretFile))
+	case strings.HasPrefix(value, secretVault):
+		path, key, ok := strings.Cut(strings.TrimPrefix(value, secretVault), "#")
+		if !ok || key == "" {
+			return "", errors.New("vault reference needs a #field")
+		}
+		return r.field(ctx, value, path, key, r.vault)
+	case strings.HasPrefix(value, secretAWS):
+		id, key, ok := strings.Cut(strings.TrimPrefix(value, secretAWS), "#")
+		if !ok {
+			return r.awsSecret(ctx, id)
+		}
+		return r.field(ctx, value, id, key, r.awsJSON)
+	default:
+		return value, nil
+	}
+}
+
+// field returns key of the secret read by read from path.
+func (r *secretResolver) field(ctx context.Context, ref, path, key string, read func(ctx context.Context, path string) (map[string]any, error)) (string, error) {
+	cacheKey := strings.TrimSuffix(ref, "#"+key)
+	data, ok := r.cache[cacheKey]
+	if !ok {
+		var err error
+		if data, err = read(ctx, path); err != nil {
+			return "", err
+		}
+		r.cache[cacheKey] = data
+	}
+	switch value := data[key].(type) {
+	case string:
+		return value, nil
+	case nil:
+		return "", fmt.Errorf("secret %s has no field %q", path, key)
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+// vault reads a secret from the Vault HTTP API, the fields of KV version 2
+// secrets are unwrapped.
+func (r *secretResolver) vault(ctx context.Context, path string) (map[string]any, error) {
+	v := r.cfg.Vault
+	if v.Address == "" {
+		return nil, errors.New("vault reference without secrets.vault.address")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(v.Address, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("read vault secret %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("read vault secret %s: status %d: %q", path, resp.StatusCode, body)
+	}
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("decode vault secret %s: %w", path, err)
+	}
+	if inner, ok := secret.Data["data"].(map[string]any); ok {
+		if _, ok := secret.Data["metadata"]; ok {
+			return inner, nil
+		}
+	}
+	return secret.Data, nil
+}
+
+func (r *secretResolver) awsSecret(ctx context.Context, id string) (string, error) {
+	if r.aws == nil {
+		var opts []func(*awsconfig.LoadOptions) error
+		if region := r.cfg.AWS.Region; region != "" {
+			opts = append(opts, awsconfig.WithRegion(region))
+		}
+		cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+		if err != nil {
+			return "", fmt.Errorf("load aws config: %w", err)
+		}
+		r.aws = secretsmanager.NewFromConfig(cfg)
+	}
+	out, err := r.aws.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: &id})
+	if err != nil {
+		return "", fmt.Errorf("read aws secret %s: %w", id, err)
+	}
+	if out.SecretString == nil {
+		return string(out.SecretBinary), nil
+	}
+	return *out.SecretString, nil
+}
+
+// awsJSON reads a Secrets Manager secret holding a JSON object.
+func (r *secretResolver) awsJSON(ctx context.Context, id string) (map[string]any, error) {
+	value, err := r.awsSecret(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]any
+	if err := json.Unmarshal([]byte(value), &data); err != nil {
+		return nil, fmt.Errorf("aws secret %s is not a JSON object: %w", id, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,327 @@
+package config
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Store holds the active configuration and allows swapping it at runtime.
+// Handlers should take one snapshot via Load per update so they see a consistent config.
+type Store struct {
+	path    string
+	current atomic.Pointer[Config]
+
+	parent   *Store
+	mux      sync.Mutex
+	accounts map[string]*Store
+	tenants  map[string]*Store
+	runtime  RuntimeChannels
+}
+
+func NewStore(path string, cfg *Config) *Store {
+	s := &Store{path: path}
+	s.current.Store(cfg)
+	return s
+}
+
+func (s *Store) Load() *Config {
+	return s.current.Load()
+}
+
+// Account returns a store with the config of the named account, it follows
+// the reloads of s.
+func (s *Store) Account(name string) (*Store, bool) {
+	cfg, ok := s.Load().ForAccount(name)
+	if !ok {
+		return nil, false
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if account, ok := s.accounts[name]; ok {
+		return account, true
+	}
+	account := &Store{path: s.path, parent: s}
+	account.current.Store(cfg)
+	if s.accounts == nil {
+		s.accounts = map[string]*Store{}
+	}
+	s.accounts[name] = account
+	return account, true
+}
+
+// Tenant returns a store with the config of the named tenant, it follows
+// the reloads of s.
+func (s *Store) Tenant(name string) (*Store, bool) {
+	cfg, ok := s.Load().ForTenant(name)
+	if !ok {
+		return nil, false
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if tenant, ok := s.tenants[name]; ok {
+		return tenant, true
+	}
+	tenant := &Store{path: s.path, parent: s}
+	tenant.current.Store(cfg)
+	if s.tenants == nil {
+		s.tenants = map[string]*Store{}
+	}
+	s.tenants[name] = tenant
+	return tenant, true
+}
+
+// Path returns the config file, empty when only the environment is read.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Reload re-reads the config file and swaps the mutable parts in, an invalid
+// config is rejected and the running one stays active.
+// Fields that require a new Telegram session are kept from the running config,
+// their names are returned so the caller can warn about them.
+func (s *Store) Reload() (ignored []string, err error) {
+	if s.parent != nil {
+		return s.parent.Reload()
+	}
+
+	next := Config{}
+	if err := read(s.path, &next); err != nil {
+		return nil, err
+	}
+
+	prev := s.Load()
+	if next.TgApp.AppId != prev.TgApp.AppId {
+		ignored = append(ignored, "tg_app.app_id")
+		next.TgApp.AppId = prev.TgApp.AppId
+	}
+	if next.TgApp.AppHash != prev.TgApp.AppHash {
+		ignored = append(ignored, "tg_app.app_hash")
+		next.TgApp.AppHash = prev.TgApp.AppHash
+	}
+	if next.TgApp.StatePath != prev.TgApp.StatePath {
+		ignored = append(ignored, "tg_app.state_path")
+		next.TgApp.StatePath = prev.TgApp.StatePath
+	}
+	if next.TgApp.PeerCachePath != prev.TgApp.PeerCachePath {
+		ignored = append(ignored, "tg_app.peer_cache_path")
+		next.TgApp.PeerCachePath = prev.TgApp.PeerCachePath
+	}
+	if next.TgApp.CheckpointPath != prev.TgApp.CheckpointPath {
+		ignored = append(ignored, "tg_app.checkpoint_path")
+		next.TgApp.CheckpointPath = prev.TgApp.CheckpointPath
+	}
+	if next.TgApp.DiscoveryPath != prev.TgApp.DiscoveryPath {
+		ignored = append(ignored, "tg_app.discovery_path")
+		next.TgApp.DiscoveryPath = prev.TgApp.DiscoveryPath
+	}
+	if next.TgApp.UpdateLogDir != prev.TgApp.UpdateLogDir {
+		ignored = append(ignored, "tg_app.update_log_dir")
+		next.TgApp.UpdateLogDir = prev.TgApp.UpdateLogDir
+	}
+	if next.TgApp.ChannelsPath != prev.TgApp.ChannelsPath {
+		ignored = append(ignored, "tg_app.channels_path")
+		next.TgApp.ChannelsPath = prev.TgApp.ChannelsPath
+	}
+	if next.TgApp.RateLimit != prev.TgApp.RateLimit {
+		ignored = append(ignored, "tg_app.rate_limit")
+		next.TgApp.RateLimit = prev.TgApp.RateLimit
+	}
+	if next.TgApp.Proxy != prev.TgApp.Proxy {
+		ignored = append(ignored, "tg_app.proxy")
+		next.TgApp.Proxy = prev.TgApp.Proxy
+	}
+	if next.Delivery != prev.Delivery {
+		ignored = append(ignored, "delivery")
+		next.Delivery = prev.Delivery
+	}
+
+	if next.Media != prev.Media {
+		ignored = append(ignored, "media")
+		next.Media = prev.Media
+	}
+
+	if next.HTTP != prev.HTTP {
+		ignored = append(ignored, "http")
+		next.HTTP = prev.HTTP
+	}
+
+	if !reflect.DeepEqual(next.Language, prev.Language) {
+		ignored = append(ignored, "language")
+		next.Language = prev.Language
+	}
+
+	if next.Transcription != prev.Transcription {
+		ignored = append(ignored, "transcription")
+		next.Transcription = prev.Transcription
+	}
+
+	if next.GRPC != prev.GRPC {
+		ignored = append(ignored, "grpc")
+		next.GRPC = prev.GRPC
+	}
+
+	if next.Session != prev.Session {
+		ignored = append(ignored, "session")
+		next.Session = prev.Session
+	}
+
+	if next.BotAPI != prev.BotAPI {
+		ignored = append(ignored, "bot_api")
+		next.BotAPI = prev.BotAPI
+	}
+
+	if next.Log != prev.Log {
+		ignored = append(ignored, "log")
+		next.Log = prev.Log
+	}
+
+	if next.Stats.MaxPosts != prev.Stats.MaxPosts {
+		ignored = append(ignored, "stats.max_posts")
+		next.Stats.MaxPosts = prev.Stats.MaxPosts
+	}
+
+	if next.Secrets.Refresh != prev.Secrets.Refresh {
+		ignored = append(ignored, "secrets.refresh")
+		next.Secrets.Refresh = prev.Secrets.Refresh
+	}
+
+	if next.Tracing != prev.Tracing {
+		ignored = append(ignored, "tracing")
+		next.Tracing = prev.Tracing
+	}
+
+	if !reflect.DeepEqual(next.Processors, prev.Processors) {
+		ignored = append(ignored, "processors")
+		next.Processors = prev.Processors
+	}
+
+	if next.Archive != prev.Archive {
+		ignored = append(ignored, "archive")
+		next.Archive = prev.Archive
+	}
+
+	if next.Webhook.TLS != prev.Webhook.TLS {
+		ignored = append(ignored, "webhook.tls")
+		next.Webhook.TLS = prev.Webhook.TLS
+	}
+
+	if next.Webhook.Pool != prev.Webhook.Pool {
+		ignored = append(ignored, "webhook.pool")
+		next.Webhook.Pool = prev.Webhook.Pool
+	}
+
+	if next.Webhook.Ack.Timeout != prev.Webhook.Ack.Timeout {
+		ignored = append(ignored, "webhook.ack.timeout")
+		next.Webhook.Ack.Timeout = prev.Webhook.Ack.Timeout
+	}
+
+	if !reflect.DeepEqual(sinkOutput(next.Sink), sinkOutput(prev.Sink)) {
+		ignored = append(ignored, "sink")
+		next.Sink = prev.Sink
+	}
+
+	if !sameSinkOutputs(next.Sinks, prev.Sinks) {
+		ignored = append(ignored, "sinks")
+		next.Sinks = prev.Sinks
+	}
+
+	if !sameAccounts(next.Accounts, prev.Accounts) {
+		ignored = append(ignored, "accounts")
+		next.Accounts = prev.Accounts
+	}
+
+	if !sameTenants(next.Tenants, prev.Tenants) {
+		ignored = append(ignored, "tenants")
+		next.Tenants = prev.Tenants
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	next.TgApp.Runtime = s.runtime
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	s.swap(&next)
+	return ignored, nil
+}
+
+// swap stores cfg and passes the configs of the accounts and tenants on,
+// each with its runtime channel changes. s.mux is held.
+func (s *Store) swap(cfg *Config) {
+	s.current.Store(cfg)
+	for name, account := range s.accounts {
+		if ac, ok := cfg.ForAccount(name); ok {
+			account.mux.Lock()
+			ac.TgApp.Runtime = account.runtime
+			account.swap(ac)
+			account.mux.Unlock()
+		}
+	}
+	for name, tenant := range s.tenants {
+		if tc, ok := cfg.ForTenant(name); ok {
+			tenant.mux.Lock()
+			tc.TgApp.Runtime = tenant.runtime
+			tenant.swap(tc)
+			tenant.mux.Unlock()
+		}
+	}
+}
+
+// sinkOutput strips the routing settings of a sink, they can change on reload
+// while the rest of it needs a restart.
+func sinkOutput(sc SinkConfig) SinkConfig {
+	sc.TextFormat, sc.Types, sc.Channels, sc.Filter = "", nil, nil, FilterConfig{}
+	sc.TextLength, sc.Fields = TextLengthConfig{}, nil
+	return sc
+}
+
+func sameSinkOutputs(a, b []SinkConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !reflect.DeepEqual(sinkOutput(a[i]), sinkOutput(b[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameTenants compares tenants without the channels of them and their
+// accounts, only those can change on reload.
+func sameTenants(a, b []TenantConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if !sameAccounts(x.Accounts, y.Accounts) {
+			return false
+		}
+		x.TgApp.Channels, y.TgApp.Channels = nil, nil
+		x.Accounts, y.Accounts = nil, nil
+		if !reflect.DeepEqual(x, y) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameAccounts compares accounts without their channels, only the channels
+// can change on reload.
+func sameAccounts(a, b []AccountConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		x.TgApp.Channels, y.TgApp.Channels = nil, nil
+		if !reflect.DeepEqual(x, y) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,914 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"go-tg.com/internal/schedule"
+)
+
+// ValidationError lists every problem found in a config.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "invalid config:\n  - " + strings.Join(e, "\n  - ")
+}
+
+var appHashPattern = regexp.MustCompile(`^[0-9a-fA-F]{32}$`)
+
+// userPattern matches a user ID or a username with an optional "@".
+var userPattern = regexp.MustCompile(`^(-?[0-9]+|@?[A-Za-z][A-Za-z0-9_]{3,31})$`)
+
+var eventTypes = map[string]bool{
+	"newMessage":        true,
+	"editMessage":       true,
+	"oldMessage":        true,
+	"deleteMessage":     true,
+	"reactionAdded":     true,
+	"reactionRemoved":   true,
+	"pollUpdated":       true,
+	"statsUpdated":      true,
+	"messagePinned":     true,
+	"messageUnpinned":   true,
+	"scheduledMessage":  true,
+	"throttled":         true,
+	"comment":           true,
+	"channelDiscovered": true,
+	"channelChanged":    true,
+	"suspiciousMessage": true,
+	"liveLocation":      true,
+	"titleChanged":      true,
+	"photoChanged":      true,
+	"memberJoined":      true,
+	"memberLeft":        true,
+}
+
+type problems []string
+
+func (p *problems) add(format string, args ...any) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+func (p problems) err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return ValidationError(p)
+}
+
+// ValidateClient checks what is needed to connect to Telegram: the app
+// credentials and the session storage.
+func (c *Config) ValidateClient() error {
+	var p problems
+	c.validateLog(&p)
+	if len(c.Tenants) > 0 {
+		c.forEachTenant(&p, func(tc *Config) error { return tc.ValidateClient() })
+		return p.err()
+	}
+	if len(c.Accounts) == 0 {
+		c.validateClient(&p)
+	}
+	c.forEachAccount(&p, (*Config).validateClient)
+	return p.err()
+}
+
+// Validate checks the whole config of the watcher, including at least one
+// watched channel and a destination for its events.
+func (c *Config) Validate() error {
+	var p problems
+	if len(c.Tenants) > 0 {
+		c.validateTenants(&p)
+		return p.err()
+	}
+	channels := c.validateAccounts(&p)
+	c.validateLog(&p)
+	c.validateTags(&p)
+	c.validateScam(&p)
+	c.validateRedact(&p)
+	c.validateProcessors(&p)
+	c.validateLanguage(&p)
+	c.validateTranscription(&p)
+	c.validateLinks(&p)
+	c.validateThrottle(&p)
+	c.validateDiscovery(&p)
+	c.validateBotAPI(&p, channels)
+	if st := c.Stats; st.Enabled && (st.Interval <= 0 || st.MaxAge <= 0 || st.MaxPosts <= 0) {
+		p.add("stats.interval, max_age and max_posts must be positive")
+	}
+	if c.Snapshots.Enabled && c.Snapshots.Interval <= 0 {
+		p.add("snapshots.interval must be positive")
+	}
+	if c.Spam.MinLength < 0 {
+		p.add("spam.min_length must not be negative")
+	}
+	if c.Spam.MaxEmojiRatio < 0 || c.Spam.MaxEmojiRatio > 1 {
+		p.add("spam.max_emoji_ratio must be between 0 and 1")
+	}
+	if c.TgApp.WebhookUrl != "" {
+		validateURL(&p, "tg_app.webhook_url", c.TgApp.WebhookUrl)
+	}
+	if c.HTTP.Debug && c.HTTP.AdminToken == "" {
+		p.add("http.debug needs http.admin_token, the profiler and the state dump are not served without one")
+	}
+	if d := c.HTTP.Dashboard; d.Enabled && (d.RecentEvents <= 0 || d.RecentErrors <= 0) {
+		p.add("http.dashboard.recent_events and recent_errors must be positive")
+	}
+
+	if pool := c.Webhook.Pool; pool.MaxIdleConns < 0 || pool.MaxIdleConnsPerHost < 0 || pool.MaxConnsPerHost < 0 || pool.IdleConnTimeout < 0 {
+		p.add("webhook.pool: limits and idle_conn_timeout must not be negative")
+	}
+
+	c.validateActions(&p)
+
+	switch c.Webhook.Format {
+	case "", "native", "cloudevents":
+	default:
+		p.add("webhook.format: unknown format %q, use native or cloudevents", c.Webhook.Format)
+	}
+	switch c.Webhook.Compression {
+	case "", "gzip":
+	default:
+		p.add("webhook.compression: unknown compression %q, use gzip or leave it empty", c.Webhook.Compression)
+	}
+	if c.Webhook.MaxPayloadSize < 0 {
+		p.add("webhook.max_payload_size must not be negative")
+	}
+	switch {
+	case c.Webhook.Ack.Timeout < 0:
+		p.add("webhook.ack.timeout must not be negative")
+	case c.Webhook.Ack.Timeout > 0 && c.HTTP.Listen == "":
+		p.add("webhook.ack.timeout needs http.listen for the /ack endpoint")
+	case c.Webhook.Ack.Timeout > 0 && c.Webhook.Ack.Token == "" && c.HTTP.AdminToken == "":
+		p.add("webhook.ack.timeout needs webhook.ack.token or http.admin_token to authorize /ack")
+	}
+	for _, strategy := range c.Webhook.Oversize {
+		if strategy != OversizeExternalizeMedia && strategy != OversizeTruncateText {
+			p.add("webhook.oversize: unknown strategy %q, use %s or %s", strategy, OversizeExternalizeMedia, OversizeTruncateText)
+		}
+	}
+
+	switch c.Payload.Format {
+	case "", "compact", "full":
+	default:
+		p.add("payload.format: unknown format %q, use compact or full", c.Payload.Format)
+	}
+	if c.Delivery.MaxAttempts < 0 {
+		p.add("delivery.max_attempts must not be negative")
+	}
+	if c.Delivery.Workers < 0 || c.Delivery.QueueSize < 0 {
+		p.add("delivery.workers and delivery.queue_size must not be negative")
+	}
+	switch c.Delivery.Backpressure {
+	case "", "block", "drop_oldest":
+	default:
+		p.add("delivery.backpressure: unknown policy %q, use block or drop_oldest", c.Delivery.Backpressure)
+	}
+	if c.Delivery.Status.URL != "" {
+		validateURL(&p, "delivery.status.url", c.Delivery.Status.URL)
+		if c.Delivery.Status.QueueSize <= 0 {
+			p.add("delivery.status.queue_size must be positive")
+		}
+	}
+	if c.Delivery.Dedup.Window < 0 {
+		p.add("delivery.dedup.window must not be negative")
+	}
+	switch c.Delivery.DeadLetter.Type {
+	case "":
+	case "file":
+		if c.Delivery.DeadLetter.Path == "" {
+			p.add("delivery.dead_letter.path is required for the file dead letter")
+		}
+	case "webhook":
+		validateURL(&p, "delivery.dead_letter.url", c.Delivery.DeadLetter.URL)
+	default:
+		p.add("delivery.dead_letter.type: unknown type %q, use file or webhook", c.Delivery.DeadLetter.Type)
+	}
+
+	switch c.Media.Storage {
+	case "", "local":
+	case "s3":
+		if c.Media.S3.Bucket == "" {
+			p.add("media.s3.bucket is required for the s3 storage")
+		}
+	default:
+		p.add("media.storage: unknown storage %q, use local or s3", c.Media.Storage)
+	}
+
+	switch c.Archive.Driver {
+	case "":
+	case "sqlite", "postgres":
+		if c.Archive.DSN == "" {
+			p.add("archive.dsn is required for the %s archive", c.Archive.Driver)
+		}
+	default:
+		p.add("archive.driver: unknown driver %q, use sqlite or postgres", c.Archive.Driver)
+	}
+	if c.Archive.IndexPath != "" && c.Archive.Driver == "" {
+		p.add("archive.index_path needs the archive, archive.driver is not set")
+	}
+
+	if c.Supervisor.InitialBackoff < 0 || c.Supervisor.MaxBackoff < c.Supervisor.InitialBackoff {
+		p.add("supervisor.initial_backoff must not be negative or above max_backoff")
+	}
+	if c.Supervisor.AlertAfter < 0 {
+		p.add("supervisor.alert_after must not be negative")
+	}
+	if c.Supervisor.Alert.WebhookURL != "" {
+		validateURL(&p, "supervisor.alert.webhook_url", c.Supervisor.Alert.WebhookURL)
+	}
+	if (c.Supervisor.Alert.BotToken == "") != (c.Supervisor.Alert.ChatID == 0) {
+		p.add("supervisor.alert.bot_token and supervisor.alert.chat_id must be set together")
+	}
+	if c.Alerts.Target.WebhookURL != "" {
+		validateURL(&p, "alerts.target.webhook_url", c.Alerts.Target.WebhookURL)
+	}
+	if (c.Alerts.Target.BotToken == "") != (c.Alerts.Target.ChatID == 0) {
+		p.add("alerts.target.bot_token and alerts.target.chat_id must be set together")
+	}
+	if c.Alerts.DeliveryFailures < 0 || c.Alerts.FloodWait < 0 || c.Alerts.Cooldown < 0 {
+		p.add("alerts.delivery_failures, flood_wait and cooldown must not be negative")
+	}
+
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		p.add("tracing.sample_ratio must be between 0 and 1")
+	}
+
+	if len(c.Sinks) == 0 {
+		c.validateSink(&p, "sink", c.Sink, channels)
+	}
+	names := map[string]bool{}
+	for i, sc := range c.Sinks {
+		name := sc.OutboxName()
+		if names[name] || strings.ContainsAny(name, `/\.`) {
+			p.add("sinks[%d]: name %q must be unique and a valid directory name", i, name)
+		}
+		names[name] = true
+		c.validateSink(&p, fmt.Sprintf("sinks[%d] (%s)", i, name), sc, channels)
+	}
+	c.validateRouting(&p)
+
+	return p.err()
+}
+
+// validateAccounts checks the client and channels of every account, or of
+// the top-level config without accounts, and returns all watched channels.
+func (c *Config) validateAccounts(p *problems) []ChannelConfig {
+	if len(c.Accounts) == 0 {
+		c.validateClient(p)
+		return c.validateChannels(p)
+	}
+	if len(c.WatchedChannels()) > 0 {
+		p.add("tg_app.channels are not watched when accounts are set, move them to an account")
+	}
+
+	var all []ChannelConfig
+	names := map[string]bool{}
+	files := map[string]string{}
+	for i, a := range c.Accounts {
+		if a.Name == "" || names[a.Name] || strings.ContainsAny(a.Name, `/\`) {
+			p.add("accounts[%d]: name %q must be unique and a valid file name", i, a.Name)
+		}
+		names[a.Name] = true
+
+		for _, file := range c.account(a).stateFiles() {
+			if other, ok := files[file]; ok && file != "" {
+				p.add("accounts[%d] (%s): %s is used by account %s too", i, a.Name, file, other)
+			}
+			files[file] = a.Name
+		}
+	}
+	c.forEachAccount(p, func(ac *Config, p *problems) {
+		ac.validateClient(p)
+		all = append(all, ac.validateChannels(p)...)
+	})
+	return all
+}
+
+// forEachAccount runs check on the config of every account, its problems
+// are prefixed with the account.
+func (c *Config) forEachAccount(p *problems, check func(ac *Config, p *problems)) {
+	for i, a := range c.Accounts {
+		var ap problems
+		check(c.account(a), &ap)
+		for _, problem := range ap {
+			p.add("accounts[%d] (%s): %s", i, a.Name, problem)
+		}
+	}
+}
+
+// validateTenants checks the config of every tenant and that they keep
+// their state apart.
+func (c *Config) validateTenants(p *problems) {
+	if len(c.Accounts) > 0 || len(c.WatchedChannels()) > 0 {
+		p.add("tg_app.channels and accounts are not watched when tenants are set, move them to a tenant")
+	}
+
+	names := map[string]bool{}
+	files := map[string]string{}
+	accounts := map[string]string{}
+	for i, t := range c.Tenants {
+		if t.Name == "" || names[t.Name] || strings.ContainsAny(t.Name, `/\`) {
+			p.add("tenants[%d]: name %q must be unique and a valid file name", i, t.Name)
+		}
+		names[t.Name] = true
+
+		tc := c.tenant(t)
+		tenantFiles := append(tc.stateFiles(), tc.Delivery.OutboxDir, tc.Delivery.Dedup.Path)
+		for _, a := range t.Accounts {
+			tenantFiles = append(tenantFiles, tc.account(a).stateFiles()...)
+			// Accounts are told apart by name across tenants, e.g. by
+			// telegram sinks.
+			if other, ok := accounts[a.Name]; ok && a.Name != "" {
+				p.add("tenants[%d] (%s): account %s is in tenant %s too", i, t.Name, a.Name, other)
+			}
+			accounts[a.Name] = t.Name
+		}
+		for _, file := range tenantFiles {
+			if other, ok := files[file]; ok && file != "" && other != t.Name {
+				p.add("tenants[%d] (%s): %s is used by tenant %s too", i, t.Name, file, other)
+			}
+			files[file] = t.Name
+		}
+	}
+	c.forEachTenant(p, func(tc *Config) error { return tc.Validate() })
+}
+
+// forEachTenant runs check on the config of every tenant, the problems it
+// finds are prefixed with the tenant.
+func (c *Config) forEachTenant(p *problems, check func(tc *Config) error) {
+	for i, t := range c.Tenants {
+		var invalid ValidationError
+		if err := check(c.tenant(t)); errors.As(err, &invalid) {
+			for _, problem := range invalid {
+				p.add("tenants[%d] (%s): %s", i, t.Name, problem)
+			}
+		} else if err != nil {
+			p.add("tenants[%d] (%s): %s", i, t.Name, err)
+		}
+	}
+}
+
+// stateFiles are the files and the session an account keeps its state in.
+func (c *Config) stateFiles() []string {
+	app := c.TgApp
+	return []string{app.StatePath, app.PeerCachePath, app.CheckpointPath, app.DiscoveryPath, app.ChannelsPath, app.UpdateLogDir, c.sessionID()}
+}
+
+// sessionID tells apart where sessions are stored.
+func (c *Config) sessionID() string {
+	if c.Session.Storage == "" || c.Session.Storage == "file" {
+		return c.Session.Path
+	}
+	return c.Session.Storage + " session " + c.Session.Key
+}
+
+func (c *Config) validateChannels(p *problems) []ChannelConfig {
+	channels := c.WatchedChannels()
+	// Channels may all be removed at runtime.
+	if len(channels) == 0 && c.TgApp.Runtime.empty() {
+		p.add("no channels to watch, set tg_app.channels or TG_CHANNELS")
+	}
+	for i, ch := range channels {
+		name := fmt.Sprintf("channel %d (%s)", i+1, ch)
+		if ch.ID == 0 && ch.NormalizedUsername() == "" && ch.InviteHash() == "" {
+			name = fmt.Sprintf("channel %d", i+1)
+		}
+		validateChannel(p, name, ch)
+	}
+	return channels
+}
+
+// validateChannel checks a watched channel named name in problems.
+func validateChannel(p *problems, name string, ch ChannelConfig) {
+	if ch.ID == 0 && ch.NormalizedUsername() == "" && ch.InviteHash() == "" {
+		p.add("%s: id, username or invite is required", name)
+	}
+	switch ch.Peer {
+	case "", PeerChannel, PeerUser, PeerChat:
+	default:
+		p.add("%s: unknown peer %q, use channel, user or chat", name, ch.Peer)
+	}
+	if ch.PeerType() != PeerChannel && (ch.Invite != "" || ch.Join || ch.Comments) {
+		p.add("%s: invite, join and comments are only supported for channels", name)
+	}
+	validateTypes(p, name, ch.Types)
+	validateTopics(p, name, ch.Topics)
+	validateFilter(p, name, ch.Filter)
+	validateUsers(p, name+": from_users.allow", ch.FromUsers.Allow)
+	validateUsers(p, name+": from_users.block", ch.FromUsers.Block)
+	if _, err := schedule.Parse(ch.Schedule.Timezone, ch.Schedule.Windows, ch.Schedule.Quiet); err != nil {
+		p.add("%s: schedule: %v", name, err)
+	}
+	if ch.Sample.Every < 0 || ch.Sample.MinViews < 0 {
+		p.add("%s: sample.every and min_views must not be negative", name)
+	}
+	switch ch.Source {
+	case "", SourceUser:
+	case SourceBot:
+		if ch.PeerType() == PeerUser || ch.Invite != "" || ch.Join || ch.Comments {
+			p.add("%s: source bot supports channels and groups by id or username, without join and comments", name)
+		}
+	default:
+		p.add("%s: unknown source %q, use user or bot", name, ch.Source)
+	}
+	if ch.WebhookUrl != "" {
+		validateURL(p, name+": webhook_url", ch.WebhookUrl)
+	}
+}
+
+// validateBotAPI checks the bot_api section when channels use it.
+func (c *Config) validateBotAPI(p *problems, channels []ChannelConfig) {
+	if !slices.ContainsFunc(channels, ChannelConfig.ViaBot) {
+		return
+	}
+	b := c.BotAPI
+	if b.Token == "" {
+		p.add("bot_api.token is required for channels with source bot")
+	}
+	if b.APIURL != "" {
+		validateURL(p, "bot_api.api_url", b.APIURL)
+	}
+	switch b.Mode {
+	case "", BotModePolling:
+		if b.PollTimeout < 0 {
+			p.add("bot_api.poll_timeout must not be negative")
+		}
+	case BotModeWebhook:
+		if b.WebhookURL == "" {
+			p.add("bot_api.webhook_url is required in webhook mode")
+		} else {
+			validateURL(p, "bot_api.webhook_url", b.WebhookURL)
+		}
+		if b.SecretToken == "" {
+			p.add("bot_api.secret_token is required in webhook mode")
+		}
+		if c.HTTP.Listen == "" {
+			p.add("bot_api.mode webhook needs http.listen for the /bot endpoint")
+		}
+	default:
+		p.add("bot_api.mode: unknown mode %q, use polling or webhook", b.Mode)
+	}
+}
+
+func (c *Config) validateClient(p *problems) {
+	if c.TgApp.AppId <= 0 {
+		p.add("tg_app.app_id is required, get it on https://my.telegram.org/apps")
+	}
+	if c.TgApp.AppHash == "" {
+		p.add("tg_app.app_hash is required, get it on https://my.telegram.org/apps")
+	} else if !appHashPattern.MatchString(c.TgApp.AppHash) {
+		p.add("tg_app.app_hash must be 32 hex characters")
+	}
+
+	if r := c.TgApp.RateLimit.Retry; r.InitialBackoff < 0 || r.MaxBackoff < r.InitialBackoff {
+		p.add("tg_app.rate_limit.retry.initial_backoff must not be negative or above max_backoff")
+	}
+	if j := c.TgApp.RateLimit.Retry.Jitter; j < 0 || j > 1 {
+		p.add("tg_app.rate_limit.retry.jitter must be between 0 and 1")
+	}
+
+	switch c.TgApp.Proxy.Type {
+	case "":
+	case "socks5":
+		if c.TgApp.Proxy.Address == "" {
+			p.add("tg_app.proxy.address is required for the socks5 proxy")
+		}
+	case "mtproto":
+		if c.TgApp.Proxy.Address == "" {
+			p.add("tg_app.proxy.address is required for the mtproto proxy")
+		}
+		if _, err := c.TgApp.Proxy.SecretBytes(); c.TgApp.Proxy.Secret == "" || err != nil {
+			p.add("tg_app.proxy.secret must be the proxy secret in hex or base64")
+		}
+	default:
+		p.add("tg_app.proxy.type: unknown type %q, use socks5 or mtproto", c.TgApp.Proxy.Type)
+	}
+
+	switch c.Session.Storage {
+	case "", "file", "redis":
+	case "postgres":
+		if c.Session.PostgresDSN == "" {
+			p.add("session.postgres_dsn is required for the postgres session storage")
+		}
+	case "s3":
+		if c.Session.S3.Bucket == "" {
+			p.add("session.s3.bucket is required for the s3 session storage")
+		}
+	default:
+		p.add("session.storage: unknown storage %q, use file, redis, postgres or s3", c.Session.Storage)
+	}
+}
+
+func (c *Config) validateLog(p *problems) {
+	switch c.Log.Level {
+	case "", "debug", "info", "warn", "error":
+	default:
+		p.add("log.level: unknown level %q, use debug, info, warn or error", c.Log.Level)
+	}
+	switch c.Log.Format {
+	case "", "console", "json":
+	default:
+		p.add("log.format: unknown format %q, use console or json", c.Log.Format)
+	}
+}
+
+func (c *Config) validateLanguage(p *problems) {
+	t := c.Language.Translate
+	switch t.Provider {
+	case "":
+		return
+	case "libretranslate":
+		if t.URL == "" {
+			p.add("language.translate.url is required for libretranslate")
+		}
+	case "deepl":
+		if t.APIKey == "" {
+			p.add("language.translate.api_key is required for deepl")
+		}
+	default:
+		p.add("language.translate.provider: unknown provider %q, use libretranslate or deepl", t.Provider)
+	}
+	if t.URL != "" {
+		validateURL(p, "language.translate.url", t.URL)
+	}
+	if t.Target == "" {
+		p.add("language.translate.target is required")
+	}
+}
+
+func (c *Config) validateTranscription(p *problems) {
+	t := c.Transcription
+	if t.URL == "" {
+		return
+	}
+	validateURL(p, "transcription.url", t.URL)
+	if t.Model == "" {
+		p.add("transcription.model is required")
+	}
+	if t.MaxDuration < 0 {
+		p.add("transcription.max_duration must not be negative")
+	}
+	if t.Timeout <= 0 {
+		p.add("transcription.timeout must be positive")
+	}
+}
+
+func (c *Config) validateLinks(p *problems) {
+	if !c.Links.Unfurl {
+		return
+	}
+	if len(c.Links.Domains) == 0 {
+		p.add("links.domains is required to unfurl links")
+	}
+	if c.Links.MaxLinks < 0 {
+		p.add("links.max_links must not be negative")
+	}
+	if c.Links.Timeout <= 0 {
+		p.add("links.timeout must be positive")
+	}
+}
+
+func (c *Config) validateDiscovery(p *problems) {
+	d := c.Discovery
+	if len(d.Patterns) == 0 {
+		return
+	}
+	validateFilter(p, "discovery", FilterConfig{IncludePatterns: d.Patterns})
+	if d.Interval <= 0 {
+		p.add("discovery.interval must be positive")
+	}
+	ch := d.Channel
+	if ch.ID != 0 || ch.Username != "" || ch.Invite != "" || ch.Peer != "" {
+		p.add("discovery.channel: id, username, invite and peer are set by discovery")
+	}
+	validateTypes(p, "discovery.channel", ch.Types)
+	validateTopics(p, "discovery.channel", ch.Topics)
+	validateFilter(p, "discovery.channel", ch.Filter)
+	validateUsers(p, "discovery.channel: from_users.allow", ch.FromUsers.Allow)
+	validateUsers(p, "discovery.channel: from_users.block", ch.FromUsers.Block)
+	if _, err := schedule.Parse(ch.Schedule.Timezone, ch.Schedule.Windows, ch.Schedule.Quiet); err != nil {
+		p.add("discovery.channel: schedule: %v", err)
+	}
+	if ch.WebhookUrl != "" {
+		validateURL(p, "discovery.channel: webhook_url", ch.WebhookUrl)
+	}
+}
+
+func (c *Config) validateThrottle(p *problems) {
+	t := c.Throttle
+	if t.PerMinute < 0 || t.GlobalPerMinute < 0 {
+		p.add("throttle.per_minute and throttle.global_per_minute must not be negative")
+	}
+	if t.PerMinute == 0 && t.GlobalPerMinute == 0 {
+		return
+	}
+	if t.Burst < 1 || t.GlobalBurst < 1 {
+		p.add("throttle.burst and throttle.global_burst must be at least 1")
+	}
+	switch t.Overflow {
+	case "drop":
+	case "sample":
+		if t.SampleEvery < 1 {
+			p.add("throttle.sample_every must be at least 1")
+		}
+	case "digest":
+		if t.DigestInterval <= 0 {
+			p.add("throttle.digest_interval must be positive")
+		}
+	default:
+		p.add("throttle.overflow: unknown policy %q, use drop, sample or digest", t.Overflow)
+	}
+}
+
+func (c *Config) validateTags(p *problems) {
+	for i, r := range c.Tags {
+		name := fmt.Sprintf("tags[%d]", i)
+		if r.Category == "" && r.Severity == "" {
+			p.add("%s: category or severity is required", name)
+		}
+		if r.Severity != "" && !slices.Contains(Severities, r.Severity) {
+			p.add("%s: unknown severity %q, use %s", name, r.Severity, strings.Join(Severities, ", "))
+		}
+		if len(r.Keywords) == 0 && len(r.Patterns) == 0 {
+			p.add("%s: keywords or patterns are required", name)
+		}
+		for _, pattern := range r.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				p.add("%s: bad pattern %q: %v", name, pattern, err)
+			}
+		}
+	}
+}
+
+func (c *Config) validateRouting(p *problems) {
+	sinks := c.Sinks
+	if len(sinks) == 0 {
+		sinks = []SinkConfig{c.Sink}
+	}
+	for i, r := range c.Routing {
+		name := fmt.Sprintf("routing[%d]", i)
+		for _, t := range r.Types {
+			if !eventTypes[t] {
+				p.add("%s: unknown type %q", name, t)
+			}
+		}
+		if r.MinTextLength < 0 || r.MaxTextLength < 0 {
+			p.add("%s: text lengths must not be negative", name)
+		}
+		if r.MaxTextLength > 0 && r.MinTextLength > r.MaxTextLength {
+			p.add("%s: min_text_length is above max_text_length", name)
+		}
+		for _, s := range r.Sinks {
+			if !slices.ContainsFunc(sinks, func(sc SinkConfig) bool { return sc.OutboxName() == s }) {
+				p.add("%s: unknown sink %q", name, s)
+			}
+		}
+	}
+}
+
+func (c *Config) validateScam(p *problems) {
+	s := c.Scam
+	if !s.Enabled {
+		return
+	}
+	if s.MaxDistance < 0 {
+		p.add("scam.max_distance must not be negative")
+	}
+	if !slices.Contains(Severities, s.Severity) {
+		p.add("scam.severity: unknown severity %q, use %s", s.Severity, strings.Join(Severities, ", "))
+	}
+	for _, pattern := range slices.Concat(s.SeedPhrasePatterns, s.WalletPatterns) {
+		if _, err := regexp.Compile(pattern); err != nil {
+			p.add("scam: bad pattern %q: %v", pattern, err)
+		}
+	}
+}
+
+func (c *Config) validateRedact(p *problems) {
+	r := c.Redact
+	for _, name := range r.Builtin {
+		switch name {
+		case RedactPhones, RedactEmails, RedactAPIKeys:
+		default:
+			p.add("redact.builtin: unknown patterns %q, use phones, emails or api_keys", name)
+		}
+	}
+	for _, pattern := range r.Patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			p.add("redact: bad pattern %q: %v", pattern, err)
+		}
+	}
+	if len(utf16.Encode([]rune(r.Mask))) != 1 {
+		p.add("redact.mask must be a single character")
+	}
+}
+
+func (c *Config) validateActions(p *problems) {
+	a := c.Webhook.Actions
+	for _, action := range a.Allow {
+		switch action {
+		case "react", "reply":
+		default:
+			p.add("webhook.actions.allow: unknown action %q, use react or reply", action)
+		}
+	}
+	if a.Account != "" && !slices.Contains(c.AccountNames(), a.Account) {
+		p.add("webhook.actions.account %q is not one of the accounts", a.Account)
+	}
+}
+
+func (c *Config) validateProcessors(p *problems) {
+	names := map[string]bool{}
+	for i, pc := range c.Processors {
+		name := fmt.Sprintf("processors[%d]", i)
+		if pc.Name != "" {
+			if names[pc.Name] {
+				p.add("%s: duplicate name %q", name, pc.Name)
+			}
+			names[pc.Name] = true
+		}
+		switch pc.Type {
+		case "plugin", "wasm":
+		default:
+			p.add("%s: unknown type %q, use plugin or wasm", name, pc.Type)
+		}
+		if pc.Path == "" {
+			p.add("%s: path is required", name)
+		}
+		if pc.Timeout < 0 {
+			p.add("%s: timeout must not be negative", name)
+		}
+	}
+}
+
+func (c *Config) validateSink(p *problems, name string, sc SinkConfig, channels []ChannelConfig) {
+	switch sc.TextFormat {
+	case "", "plain", "markdown", "html":
+	default:
+		p.add("%s: unknown text_format %q, use plain, markdown or html", name, sc.TextFormat)
+	}
+	validateTypes(p, name, sc.Types)
+	validateTopics(p, name, sc.Topics)
+	validateFilter(p, name, sc.Filter)
+	if sc.Template != "" && sc.TemplateFile != "" {
+		p.add("%s: set either template or template_file", name)
+	}
+	if sc.Batch.Size < 0 {
+		p.add("%s: batch.size must not be negative", name)
+	}
+	if sc.Batch.Size > 1 && sc.Type != "" && sc.Type != "webhook" {
+		p.add("%s: batch is only supported by the webhook sink", name)
+	}
+	if sc.Digest.Interval < 0 || sc.Digest.MaxMessages < 0 || sc.Digest.TopKeywords < 0 {
+		p.add("%s: digest.interval, digest.max_messages and digest.top_keywords must not be negative", name)
+	}
+	if sc.MaxAge < 0 {
+		p.add("%s: max_age must not be negative", name)
+	}
+	switch sc.Expired {
+	case "", ExpiredDeadLetter, ExpiredDrop:
+	default:
+		p.add("%s: unknown expired %q, use dead_letter or drop", name, sc.Expired)
+	}
+	if sc.TextLength.Max < 0 {
+		p.add("%s: text_length.max must not be negative", name)
+	}
+	switch sc.TextLength.Overflow {
+	case "", OverflowTruncate, OverflowSplit:
+	default:
+		p.add("%s: unknown text_length.overflow %q, use truncate or split", name, sc.TextLength.Overflow)
+	}
+
+	switch sc.Type {
+	case "", "webhook":
+		if sc.URL != "" {
+			validateURL(p, name+": url", sc.URL)
+			return
+		}
+		if c.TgApp.WebhookUrl != "" {
+			return
+		}
+		for _, ch := range channels {
+			if ch.WebhookUrl == "" {
+				p.add("%s: no webhook URL for channel %s, set tg_app.webhook_url", name, ch)
+			}
+		}
+	case "slack":
+		if sc.URL == "" {
+			p.add("%s: url of the incoming webhook is required for the slack sink", name)
+		} else {
+			validateURL(p, name+": url", sc.URL)
+		}
+	case "discord":
+		if sc.URL == "" && len(sc.Discord.Channels) == 0 {
+			p.add("%s: url or discord.channels is required for the discord sink", name)
+		}
+		if sc.URL != "" {
+			validateURL(p, name+": url", sc.URL)
+		}
+		for id, url := range sc.Discord.Channels {
+			validateURL(p, fmt.Sprintf("%s: discord.channels[%d]", name, id), url)
+		}
+	case "telegram":
+		c.validateTelegramSink(p, name, sc, channels)
+	case "kafka":
+		if len(sc.Kafka.Brokers) == 0 {
+			p.add("%s: kafka.brokers is required", name)
+		}
+		if sc.Kafka.Topic == "" {
+			p.add("%s: kafka.topic is required", name)
+		}
+	case "nats":
+	case "amqp":
+		if sc.AMQP.Exchange == "" {
+			p.add("%s: amqp.exchange is required", name)
+		}
+	case "file":
+		if sc.Path == "" {
+			p.add("%s: path is required for the file sink", name)
+		}
+	default:
+		p.add("%s: unknown type %q, use webhook, slack, discord, telegram, kafka, nats, amqp or file", name, sc.Type)
+	}
+}
+
+func (c *Config) validateTelegramSink(p *problems, name string, sc SinkConfig, channels []ChannelConfig) {
+	ts := sc.Telegram
+	if ts.Chat == "" {
+		p.add("%s: telegram.chat is required for the telegram sink", name)
+	} else {
+		// Mirroring into a watched chat would mirror every post again.
+		target := ParseChannel(ts.Chat)
+		for _, ch := range channels {
+			if ch.PeerType() != PeerChannel {
+				continue
+			}
+			if (target.ID != 0 && target.ID == ch.ID) ||
+				(target.Username != "" && strings.EqualFold(target.NormalizedUsername(), ch.NormalizedUsername())) ||
+				(target.Invite != "" && target.InviteHash() == ch.InviteHash()) {
+				p.add("%s: telegram.chat %s is a watched channel", name, ts.Chat)
+				break
+			}
+		}
+	}
+	if ts.Account != "" && !slices.Contains(c.AccountNames(), ts.Account) {
+		p.add("%s: telegram.account %q is not one of the accounts", name, ts.Account)
+	}
+	if sc.Template != "" || sc.TemplateFile != "" {
+		p.add("%s: the telegram sink has no body template, use telegram.prefix", name)
+	}
+}
+
+func validateURL(p *problems, name, raw string) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		p.add("%s: %v", name, err)
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		p.add("%s: %q must start with http:// or https://", name, raw)
+		return
+	}
+	if u.Host == "" {
+		p.add("%s: %q has no host", name, raw)
+	}
+}
+
+func validateTypes(p *problems, name string, types []string) {
+	for _, t := range types {
+		if !eventTypes[t] {
+			p.add("%s: unknown type %q, use newMessage, editMessage, oldMessage, deleteMessage, reactionAdded, reactionRemoved, pollUpdated, messagePinned, messageUnpinned, scheduledMessage, titleChanged, photoChanged, memberJoined, memberLeft, throttled, comment, channelDiscovered or liveLocation", name, t)
+		}
+	}
+}
+
+func validateTopics(p *problems, name string, topics []int) {
+	for _, topic := range topics {
+		if topic <= 0 {
+			p.add("%s: topic %d must be positive, the General topic is 1", name, topic)
+		}
+	}
+}
+
+func validateUsers(p *problems, name string, users []string) {
+	for _, user := range users {
+		if !userPattern.MatchString(strings.TrimSpace(user)) {
+			p.add("%s: %q is neither a user ID nor a username", name, user)
+		}
+	}
+}
+
+func validateFilter(p *problems, name string, f FilterConfig) {
+	for _, patterns := range [][]string{f.IncludePatterns, f.ExcludePatterns} {
+		for _, pattern := range patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				p.add("%s: bad filter pattern %q: %v", name, pattern, err)
+			}
+		}
+	}
+}
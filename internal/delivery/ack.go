@@ -0,0 +1,23 @@
+package delivery
+
+import "context"
+
+type ackKey struct{}
+
+// WithAck makes DeferAck on ctx and its children call deferAck, it is set by
+// the source of the events, e.g. the update log, for every update.
+func WithAck(ctx context.Context, deferAck func() func(ok bool)) context.Context {
+	return context.WithValue(ctx, ackKey{}, deferAck)
+}
+
+// DeferAck keeps the source of the events of ctx from being acknowledged
+// while an event waits in memory, e.g. in an album or a digest, until the
+// returned function is called: ok once the event is in the outbox, false
+// when it is lost, so the source keeps it. It does nothing for contexts
+// without WithAck.
+func DeferAck(ctx context.Context) func(ok bool) {
+	if deferAck, ok := ctx.Value(ackKey{}).(func() func(ok bool)); ok {
+		return deferAck()
+	}
+	return func(bool) {}
+}
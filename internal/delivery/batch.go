@@ -0,0 +1,148 @@
+package delivery
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/sink"
+	"go.uber.org/zap"
+)
+
+// BatchPolicy groups entries of a sink that accepts batches: up to Size
+// entries of the same target are sent in one request, a smaller batch goes
+// out once its oldest entry waited for Interval. Size <= 1 disables batching.
+type BatchPolicy struct {
+	Size     int
+	Interval time.Duration
+}
+
+func (p BatchPolicy) enabled() bool {
+	return p.Size > 1
+}
+
+func (p BatchPolicy) interval() time.Duration {
+	if p.Interval <= 0 {
+		return pollInterval
+	}
+	return p.Interval
+}
+
+// runBatches sends batches until ctx is done. Entries of a target are sent
+// strictly in order: while the oldest one waits for its retry, the ones after
+// it wait too.
+func (o *Outbox) runBatches(ctx context.Context) error {
+	ticker := time.NewTicker(max(min(pollInterval, o.batch.interval()/2), 10*time.Millisecond))
+	defer ticker.Stop()
+
+	for {
+		o.expireAcks(ctx)
+		now := time.Now()
+		o.sendBatches(ctx, func(e *entry) bool { return !e.NextAttempt.After(now) }, false)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+func (o *Outbox) sendBatches(ctx context.Context, ready func(e *entry) bool, force bool) {
+	for _, batch := range o.claimBatches(ready, force) {
+		if ctx.Err() != nil {
+			for _, e := range batch {
+				o.release(e)
+			}
+			continue
+		}
+		if err := o.attemptBatch(ctx, batch); err != nil {
+			o.log.Warn("Batch delivery failed, will retry", zap.String("first_id", batch[0].ID), zap.Int("size", len(batch)), zap.Error(err))
+		}
+	}
+}
+
+// claimBatches marks the leading ready entries of every target as in flight
+// and splits them into batches of at most Size, oldest first. A batch smaller
+// than Size is held back until its oldest entry waited for Interval, unless
+// force is set.
+func (o *Outbox) claimBatches(ready func(e *entry) bool, force bool) [][]*entry {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	// Held entries and the ones waiting for an acknowledgment don't hold
+	// back the ones after them.
+	now := time.Now()
+	byTarget := map[string][]*entry{}
+	for _, e := range o.entries {
+		if !e.held(now) && !e.awaitingAck() {
+			byTarget[e.Target] = append(byTarget[e.Target], e)
+		}
+	}
+
+	var batches [][]*entry
+	for _, entries := range byTarget {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+		n := 0
+		for n < len(entries) && !o.inFlight[entries[n].ID] && ready(entries[n]) {
+			n++
+		}
+		for start := 0; start < n; start += o.batch.Size {
+			batch := entries[start:min(start+o.batch.Size, n)]
+			if len(batch) < o.batch.Size && !force && time.Since(batch[0].CreatedAt) < o.batch.interval() {
+				break
+			}
+			for _, e := range batch {
+				o.inFlight[e.ID] = true
+			}
+			batches = append(batches, batch)
+		}
+	}
+	sort.Slice(batches, func(i, j int) bool { return batches[i][0].ID < batches[j][0].ID })
+	return batches
+}
+
+// attemptBatch sends a batch in one request. The whole batch succeeds or
+// fails together, expired entries are left out of it.
+func (o *Outbox) attemptBatch(ctx context.Context, batch []*entry) error {
+	defer func() {
+		for _, e := range batch {
+			o.release(e)
+		}
+	}()
+
+	now := time.Now()
+	fresh := batch[:0:0]
+	for _, e := range batch {
+		if e.expired(o.policy.MaxAge, now) {
+			o.expire(ctx, e)
+			continue
+		}
+		fresh = append(fresh, e)
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	events := make([]*event.Event, len(fresh))
+	for i, e := range fresh {
+		events[i] = e.Event
+		o.report(ctx, e, sink.StatusDelivering, e.Attempts+1, "")
+	}
+	sendErr := o.batcher.SendBatch(ctx, fresh[0].Target, events)
+	if sendErr != nil {
+		for _, e := range fresh {
+			_ = o.fail(ctx, e, sendErr)
+		}
+		return sendErr
+	}
+	for _, e := range fresh {
+		o.report(ctx, e, sink.StatusDelivered, e.Attempts+1, "")
+		if err := o.delivered(e); err != nil {
+			o.log.Error("remove outbox entry", zap.String("id", e.ID), zap.Error(err))
+		}
+	}
+	return nil
+}
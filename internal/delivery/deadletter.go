@@ -0,0 +1,24 @@
+package delivery
+
+import (
+	"context"
+	"time"
+
+	"go-tg.com/internal/event"
+)
+
+// Letter is an event delivery gave up on, with the reason.
+type Letter struct {
+	ID        string       `json:"id"`
+	Sink      string       `json:"sink,omitempty"`
+	Target    string       `json:"target,omitempty"`
+	Event     *event.Event `json:"event"`
+	Attempts  int          `json:"attempts"`
+	Error     string       `json:"error"`
+	CreatedAt time.Time    `json:"created_at"`
+	FailedAt  time.Time    `json:"failed_at"`
+}
+
+// DeadLetter receives events that ran out of delivery attempts. When it fails
+// the event is kept in the dead directory of the outbox instead.
+type DeadLetter func(ctx context.Context, l *Letter) error
@@ -0,0 +1,109 @@
+package delivery
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// Dedup remembers delivered events for a window, so an event replayed by
+// gap recovery after a reconnect is not sent twice. With a path the window
+// survives restarts.
+type Dedup struct {
+	window time.Duration
+	path   string
+
+	mux     sync.Mutex
+	seen    map[string]time.Time
+	expired time.Time
+}
+
+func NewDedup(window time.Duration, path string) (*Dedup, error) {
+	d := &Dedup{window: window, path: path, seen: map[string]time.Time{}}
+	if path == "" {
+		return d, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read dedup file")
+	}
+	if len(data) == 0 {
+		return d, nil
+	}
+	if err := json.Unmarshal(data, &d.seen); err != nil {
+		return nil, errors.Wrap(err, "decode dedup file")
+	}
+	d.expire(time.Now())
+	return d, nil
+}
+
+// Seen reports whether key was delivered within the window and records it
+// otherwise.
+func (d *Dedup) Seen(key string) (bool, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	now := time.Now()
+	if at, ok := d.seen[key]; ok && now.Sub(at) < d.window {
+		return true, nil
+	}
+	d.expire(now)
+	d.seen[key] = now
+	return false, d.flush()
+}
+
+// Forget drops key from the window, for events recorded by Seen that could
+// not be written to the outboxes, so their replay is not dropped.
+func (d *Dedup) Forget(key string) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	delete(d.seen, key)
+	return d.flush()
+}
+
+// expire forgets keys older than the window, at most twice per window.
+func (d *Dedup) expire(now time.Time) {
+	if now.Sub(d.expired) < d.window/2 {
+		return
+	}
+	d.expired = now
+	for key, at := range d.seen {
+		if now.Sub(at) >= d.window {
+			delete(d.seen, key)
+		}
+	}
+}
+
+func (d *Dedup) flush() error {
+	if d.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(d.seen)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp dedup file")
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "write dedup file")
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path)
+}
@@ -0,0 +1,128 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go-tg.com/internal/event"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDigestMessages = 50
+	defaultDigestKeywords = 10
+)
+
+// DigestPolicy makes a route collect its events and deliver a single digest
+// event per target every Interval instead, listing up to MaxMessages events
+// and the TopKeywords most frequent words, 0 takes the defaults. Interval 0
+// disables digests.
+type DigestPolicy struct {
+	Interval    time.Duration
+	MaxMessages int
+	TopKeywords int
+}
+
+func (p DigestPolicy) enabled() bool {
+	return p.Interval > 0
+}
+
+func (p DigestPolicy) maxMessages() int {
+	if p.MaxMessages <= 0 {
+		return defaultDigestMessages
+	}
+	return p.MaxMessages
+}
+
+func (p DigestPolicy) topKeywords() int {
+	if p.TopKeywords <= 0 {
+		return defaultDigestKeywords
+	}
+	return p.TopKeywords
+}
+
+// digester collects the events of a route by target. Collected events are
+// only in memory until the digest is written to the outbox, the sources of
+// the events are acknowledged then, see DeferAck.
+type digester struct {
+	policy DigestPolicy
+
+	mux     sync.Mutex
+	pending map[string]*event.Event
+	acks    map[string][]func(ok bool)
+}
+
+func newDigester(policy DigestPolicy) *digester {
+	return &digester{policy: policy, pending: map[string]*event.Event{}, acks: map[string][]func(ok bool){}}
+}
+
+func (d *digester) add(target string, e *event.Event, ack func(ok bool)) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	digest, ok := d.pending[target]
+	if !ok {
+		digest = event.NewDigest("digest", nil, d.policy.maxMessages())
+		d.pending[target] = digest
+	}
+	digest.Digest.Add(e)
+	d.acks[target] = append(d.acks[target], ack)
+}
+
+// take returns the digests collected by target with the acks of their
+// events and starts new ones.
+func (d *digester) take() (map[string]*event.Event, map[string][]func(ok bool)) {
+	d.mux.Lock()
+	pending, acks := d.pending, d.acks
+	d.pending, d.acks = map[string]*event.Event{}, map[string][]func(ok bool){}
+	d.mux.Unlock()
+
+	for _, e := range pending {
+		e.Digest.SetKeywords(d.policy.topKeywords())
+		e.Text = fmt.Sprintf("%d events", e.Digest.Count)
+		if e.Digest.Count == 1 {
+			e.Text = "1 event"
+		}
+	}
+	return pending, acks
+}
+
+// runDigests writes the digests of a route to its outbox every interval
+// until ctx is done.
+func (f *Fanout) runDigests(ctx context.Context, name string, d *digester) error {
+	ticker := time.NewTicker(d.policy.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			f.flushDigest(ctx, name, d)
+		}
+	}
+}
+
+// flushDigest writes the collected digests of a route to its outbox and
+// queues their first attempts.
+func (f *Fanout) flushDigest(ctx context.Context, name string, d *digester) {
+	var route *Route
+	for _, r := range f.current() {
+		if r.Name == name {
+			route = &r
+			break
+		}
+	}
+	pending, acks := d.take()
+	for target, digest := range pending {
+		e, err := route.Outbox.put(ctx, target, digest, time.Time{})
+		for _, ack := range acks[target] {
+			ack(err == nil)
+		}
+		if err != nil {
+			f.log.Error("Write digest to outbox, its events are lost", zap.String("sink", name), zap.Int("count", digest.Digest.Count), zap.Error(err))
+			continue
+		}
+		f.submit(ctx, job{ctx: ctx, route: *route, entry: e})
+	}
+}
@@ -0,0 +1,252 @@
+package delivery
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/filter"
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// Route is one output of a Fanout: events accepted by Match go to Outbox
+// with the text rendered in TextFormat and fit into TextLimit and only the
+// payload Fields when set, or into digests with Digest.
+type Route struct {
+	Name       string
+	Outbox     *Outbox
+	Match      func(e *event.Event) bool
+	TextFormat string
+	TextLimit  TextLimit
+	Fields     []string
+	Digest     DigestPolicy
+}
+
+// TextLimit truncates texts longer than MaxLength runes, or with Split
+// sends them as several events, see event.Event.Limited.
+type TextLimit struct {
+	MaxLength int
+	Split     bool
+}
+
+// Fanout delivers every event to all matching routes, narrowed down by the
+// routing rules when one matches it. Each route has its own
+// outbox, so a slow or failing sink only delays its own queue. First attempts
+// are made by a pool of workers, so callers only wait for the outbox write.
+type Fanout struct {
+	mu       sync.RWMutex
+	routes   []Route
+	rules    []Rule
+	log      *zap.Logger
+	inFlight sync.WaitGroup
+	dedup    *Dedup
+	pool     PoolPolicy
+	jobs     chan job
+	digests  map[string]*digester
+}
+
+// NewFanout creates a fanout over routes, dedup may be nil to send every event.
+func NewFanout(log *zap.Logger, dedup *Dedup, pool PoolPolicy, routes ...Route) *Fanout {
+	f := &Fanout{routes: routes, log: log, dedup: dedup, digests: map[string]*digester{}}
+	for _, r := range routes {
+		if r.Digest.enabled() {
+			f.digests[r.Name] = newDigester(r.Digest)
+		}
+	}
+	f.startPool(pool)
+	return f
+}
+
+// Deliver persists the event in the outbox of every matching route and queues
+// the first attempts, events delivered before within the dedup window are
+// dropped. An error means the event could not be written to an outbox.
+func (f *Fanout) Deliver(ctx context.Context, target string, ev *event.Event) error {
+	return f.deliver(ctx, target, ev, time.Time{})
+}
+
+// Hold is Deliver with the first attempts made by the retry loops once
+// until has come. Routes with digests collect the event right away.
+func (f *Fanout) Hold(ctx context.Context, target string, ev *event.Event, until time.Time) error {
+	return f.deliver(ctx, target, ev, until)
+}
+
+func (f *Fanout) deliver(ctx context.Context, target string, ev *event.Event, heldUntil time.Time) (err error) {
+	ctx, span := tracing.Start(ctx, "deliver", attribute.String("event.type", ev.Type))
+	defer func() { tracing.End(span, err) }()
+
+	if f.dedup != nil {
+		key := ev.DedupKey()
+		seen, saveErr := f.dedup.Seen(key)
+		if saveErr != nil {
+			f.log.Warn("Save dedup window", zap.Error(saveErr))
+		}
+		if seen {
+			metrics.EventsDeduplicated.WithLabelValues(ev.Type).Inc()
+			span.SetAttributes(attribute.Bool("duplicate", true))
+			f.log.Debug("Duplicate event dropped", zap.String("key", key))
+			return nil
+		}
+		// The update stays in the update log when a write fails, its
+		// replay must not be taken for a duplicate.
+		defer func() {
+			if err == nil {
+				return
+			}
+			if forgetErr := f.dedup.Forget(key); forgetErr != nil {
+				f.log.Warn("Save dedup window", zap.Error(forgetErr))
+			}
+		}()
+	}
+
+	if ev.IdempotencyKey == "" {
+		ev.IdempotencyKey = ev.Key()
+	}
+
+	rule, ruled := f.ruleOf(ev)
+	if ruled {
+		metrics.EventsRouted.WithLabelValues(rule.Name).Inc()
+		span.SetAttributes(attribute.String("rule", rule.Name))
+	}
+	for _, r := range f.current() {
+		if ruled && !contains(rule.Routes, r.Name) {
+			continue
+		}
+		if r.Match != nil && !r.Match(ev) {
+			continue
+		}
+		if d, ok := f.digests[r.Name]; ok {
+			d.add(target, ev.Formatted(r.TextFormat), DeferAck(ctx))
+			continue
+		}
+		out := ev.Formatted(r.TextFormat).Selected(r.Fields)
+		for _, part := range out.Limited(r.TextLimit.MaxLength, r.TextLimit.Split) {
+			e, err := r.Outbox.put(ctx, target, part, heldUntil)
+			if err != nil {
+				return errors.Wrapf(err, "write outbox entry of sink %s", r.Name)
+			}
+			if !heldUntil.IsZero() {
+				continue
+			}
+			f.submit(ctx, job{ctx: ctx, route: r, entry: e})
+		}
+	}
+	return nil
+}
+
+// Ack acknowledges the delivered events with the idempotency key on every
+// route that waits for acknowledgments and returns how many were pending.
+func (f *Fanout) Ack(key string) (int, error) {
+	n := 0
+	for _, r := range f.current() {
+		acked, err := r.Outbox.Ack(key)
+		if err != nil {
+			return n, errors.Wrapf(err, "ack on sink %s", r.Name)
+		}
+		n += acked
+	}
+	return n, nil
+}
+
+// Wait waits for the queued first attempts.
+func (f *Fanout) Wait() {
+	f.inFlight.Wait()
+}
+
+// Run retries pending entries of all routes until ctx is done.
+func (f *Fanout) Run(ctx context.Context) error {
+	g, ctx := errgroup.WithContext(ctx)
+	for _, r := range f.current() {
+		g.Go(func() error { return r.Outbox.Run(ctx) })
+	}
+	for name, d := range f.digests {
+		g.Go(func() error { return f.runDigests(ctx, name, d) })
+	}
+	return g.Wait()
+}
+
+// Drain writes the collected digests, waits for the first attempts queued
+// by Deliver and then drains the outbox of every route, see Outbox.Drain.
+func (f *Fanout) Drain(ctx context.Context) error {
+	for name, d := range f.digests {
+		f.flushDigest(ctx, name, d)
+	}
+	f.inFlight.Wait()
+	g, ctx := errgroup.WithContext(ctx)
+	for _, r := range f.current() {
+		g.Go(func() error { return r.Outbox.Drain(ctx) })
+	}
+	return g.Wait()
+}
+
+// Depth returns the number of pending entries over all routes.
+func (f *Fanout) Depth() int {
+	n := 0
+	for _, r := range f.current() {
+		n += r.Outbox.Depth()
+	}
+	return n
+}
+
+// Depths returns the number of pending entries of every route by name.
+func (f *Fanout) Depths() map[string]int {
+	depths := map[string]int{}
+	for _, r := range f.current() {
+		depths[r.Name] = r.Outbox.Depth()
+	}
+	return depths
+}
+
+// Reroute replaces the routes with the results of update, used to apply new
+// Match, TextFormat, TextLimit and Fields settings on reload. Outboxes must stay the same.
+func (f *Fanout) Reroute(update func(r Route) Route) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	routes := make([]Route, len(f.routes))
+	for i, r := range f.routes {
+		routes[i] = update(r)
+	}
+	f.routes = routes
+}
+
+func (f *Fanout) current() []Route {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.routes
+}
+
+// Matcher builds a route filter from event types, channel IDs, forum topic
+// IDs and a text filter. Empty lists accept everything, events without a
+// topic do not match a topic list; the text filter is not applied to
+// deletions, reactions and service events since they carry no message text.
+func Matcher(types []string, channels []int64, topics []int, text *filter.Filter) func(e *event.Event) bool {
+	return func(e *event.Event) bool {
+		if len(types) > 0 && !contains(types, e.Type) {
+			return false
+		}
+		if len(channels) > 0 && !contains(channels, e.ChannelID) {
+			return false
+		}
+		if len(topics) > 0 && !contains(topics, e.TopicID) {
+			return false
+		}
+		if text != nil && e.Type != "deleteMessage" && e.Reaction == "" && e.Service == nil && !text.Match(e.Text) {
+			return false
+		}
+		return true
+	}
+}
+
+func contains[T comparable](list []T, v T) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,611 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/sink"
+	"go.uber.org/zap"
+)
+
+const (
+	entryExt     = ".json"
+	deadDir      = "dead"
+	pollInterval = time.Second
+)
+
+// RetryPolicy controls how failed deliveries are retried.
+// MaxAttempts <= 0 retries forever.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// MaxAge gives up on entries not delivered within it, they are buried
+	// or, with DropExpired, removed. 0 never gives up.
+	MaxAge      time.Duration
+	DropExpired bool
+}
+
+func (p RetryPolicy) backoff(attempts int) time.Duration {
+	d := p.InitialBackoff
+	if d <= 0 {
+		d = time.Second
+	}
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return d
+}
+
+type entry struct {
+	ID          string          `json:"id"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Target      string          `json:"target"`
+	Event       *event.Event    `json:"event,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"` // written by older versions instead of Event
+	Attempts    int             `json:"attempts,omitempty"`
+	NextAttempt time.Time       `json:"next_attempt,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	// HeldUntil delays the first attempt, also across restarts.
+	HeldUntil time.Time `json:"held_until,omitempty"`
+	// AckDeadline is set once the entry was delivered to a sink that
+	// waits for acknowledgments, it is sent again when none came by then.
+	AckDeadline time.Time `json:"ack_deadline,omitempty"`
+}
+
+func (e *entry) held(now time.Time) bool {
+	return e.HeldUntil.After(now)
+}
+
+func (e *entry) awaitingAck() bool {
+	return !e.AckDeadline.IsZero()
+}
+
+// expired tells whether e waited longer than maxAge, counted from the end
+// of its hold for held entries.
+func (e *entry) expired(maxAge time.Duration, now time.Time) bool {
+	if maxAge <= 0 {
+		return false
+	}
+	since := e.CreatedAt
+	if e.HeldUntil.After(since) {
+		since = e.HeldUntil
+	}
+	return now.Sub(since) > maxAge
+}
+
+// Outbox is a durable at-least-once queue: every payload is written to disk
+// before the first delivery attempt and removed only once it was acknowledged.
+// Failed payloads are retried by Run with exponential backoff; once MaxAttempts
+// is exhausted they are handed to the dead letter or, without one or when it
+// fails, moved to the dead-letter directory. Every step is reported to the
+// status observers, the sink among them when it is one.
+type Outbox struct {
+	dir       string
+	sink      sink.Sink
+	policy    RetryPolicy
+	batch     BatchPolicy
+	batcher   sink.BatchSink
+	wake      chan struct{}
+	dead      DeadLetter
+	log       *zap.Logger
+	observers []sink.StatusObserver
+	// ackTimeout keeps delivered entries until they are acknowledged, 0
+	// removes them once the sink accepted them.
+	ackTimeout time.Duration
+
+	seq atomic.Uint64
+	// mux guards the entries, also the fields of an entry changed by the
+	// attempt that claimed it.
+	mux      sync.Mutex
+	entries  map[string]*entry
+	inFlight map[string]bool
+}
+
+// NewOutbox opens the outbox in dir. With batch enabled the sink must
+// implement sink.BatchSink, see BatchPolicy.
+func NewOutbox(dir string, s sink.Sink, policy RetryPolicy, batch BatchPolicy, dead DeadLetter, log *zap.Logger) (*Outbox, error) {
+	if err := os.MkdirAll(filepath.Join(dir, deadDir), 0o700); err != nil {
+		return nil, errors.Wrap(err, "create outbox dir")
+	}
+
+	o := &Outbox{
+		dir:      dir,
+		sink:     s,
+		policy:   policy,
+		batch:    batch,
+		wake:     make(chan struct{}, 1),
+		dead:     dead,
+		log:      log,
+		entries:  map[string]*entry{},
+		inFlight: map[string]bool{},
+	}
+	if batch.enabled() {
+		batcher, ok := s.(sink.BatchSink)
+		if !ok {
+			return nil, errors.New("sink does not support batches")
+		}
+		o.batcher = batcher
+	}
+	if observer, ok := s.(sink.StatusObserver); ok {
+		o.observers = append(o.observers, observer)
+	}
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Observe reports the delivery steps to observer too, it must be called
+// before the outbox is used.
+func (o *Outbox) Observe(observer sink.StatusObserver) {
+	o.observers = append(o.observers, observer)
+}
+
+// RequireAck keeps delivered entries until Ack is called with their
+// idempotency key, entries not acknowledged within timeout are sent again
+// and count as failed attempts. It must be called before the outbox is used.
+func (o *Outbox) RequireAck(timeout time.Duration) {
+	o.ackTimeout = timeout
+}
+
+// Ack removes the entries with the idempotency key and returns how many
+// there were. Entries acknowledged late, while being sent again or waiting
+// for it, are not sent again.
+func (o *Outbox) Ack(key string) (int, error) {
+	if o.ackTimeout <= 0 {
+		return 0, nil
+	}
+	o.mux.Lock()
+	var (
+		acked    []*entry
+		attempts []int
+	)
+	for id, e := range o.entries {
+		if e.Event.IdempotencyKey == key {
+			delete(o.entries, id)
+			acked = append(acked, e)
+			attempts = append(attempts, e.Attempts)
+		}
+	}
+	o.mux.Unlock()
+
+	for i, e := range acked {
+		metrics.Acknowledgments.WithLabelValues("acked").Inc()
+		o.report(context.Background(), e, sink.StatusAcknowledged, attempts[i]+1, "")
+		if err := os.Remove(o.path(e.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return len(acked), errors.Wrap(err, "remove outbox entry")
+		}
+	}
+	return len(acked), nil
+}
+
+// delivered removes an entry the sink accepted or, when acknowledgments are
+// required, keeps it until its ack deadline.
+func (o *Outbox) delivered(e *entry) error {
+	if o.ackTimeout <= 0 {
+		return o.remove(e)
+	}
+	o.mux.Lock()
+	e.AckDeadline = time.Now().Add(o.ackTimeout)
+	o.mux.Unlock()
+	return o.update(e)
+}
+
+// expireAcks counts the delivered entries whose ack deadline passed as
+// failed attempts, so they are sent again.
+func (o *Outbox) expireAcks(ctx context.Context) {
+	now := time.Now()
+	for _, e := range o.claim(func(e *entry) bool { return e.awaitingAck() && !e.AckDeadline.After(now) }) {
+		metrics.Acknowledgments.WithLabelValues("expired").Inc()
+		o.mux.Lock()
+		e.AckDeadline = time.Time{}
+		o.mux.Unlock()
+		err := o.fail(ctx, e, errNotAcknowledged)
+		o.release(e)
+		o.log.Warn("Delivered event not acknowledged", zap.String("id", e.ID), zap.String("key", e.Event.IdempotencyKey), zap.Int("attempts", e.Attempts), zap.Error(err))
+	}
+}
+
+var errNotAcknowledged = errors.New("not acknowledged in time")
+
+// report tells the observers about a step of the delivery of e.
+func (o *Outbox) report(ctx context.Context, e *entry, status sink.Status, attempt int, sendErr string) {
+	u := sink.NewStatusUpdate(status, e.ID, e.Event)
+	u.Attempt, u.Error = attempt, sendErr
+	u.Final = status == sink.StatusFailed && o.policy.MaxAttempts > 0 && attempt >= o.policy.MaxAttempts
+	o.notify(ctx, u)
+}
+
+func (o *Outbox) notify(ctx context.Context, u sink.StatusUpdate) {
+	for _, observer := range o.observers {
+		observer.DeliveryStatus(ctx, u)
+	}
+}
+
+// expire gives up on an entry older than the max age without sending it.
+func (o *Outbox) expire(ctx context.Context, e *entry) {
+	action := "dead_letter"
+	if o.policy.DropExpired {
+		action = "drop"
+	}
+	metrics.EventsExpired.WithLabelValues(action).Inc()
+	o.mux.Lock()
+	e.LastError = fmt.Sprintf("not delivered within %s", o.policy.MaxAge)
+	o.mux.Unlock()
+	u := sink.NewStatusUpdate(sink.StatusFailed, e.ID, e.Event)
+	u.Attempt, u.Error, u.Final = e.Attempts, e.LastError, true
+	o.notify(ctx, u)
+
+	var err error
+	if o.policy.DropExpired {
+		o.log.Warn("Dropped expired event", zap.String("id", e.ID), zap.String("target", e.Target), zap.Int("attempts", e.Attempts))
+		err = o.remove(e)
+	} else {
+		err = o.bury(ctx, e)
+	}
+	if err != nil {
+		o.log.Error("expire outbox entry", zap.String("id", e.ID), zap.Error(err))
+	}
+}
+
+// Depth returns the number of payloads that are not acknowledged yet.
+func (o *Outbox) Depth() int {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return len(o.entries)
+}
+
+// Deliver persists the event and tries to send it right away, with batching
+// it is sent by Run with the next batch.
+// On failure the event stays in the outbox for retries and the error is returned.
+func (o *Outbox) Deliver(ctx context.Context, target string, ev *event.Event) error {
+	e, err := o.put(ctx, target, ev, time.Time{})
+	if err != nil {
+		return errors.Wrap(err, "write outbox entry")
+	}
+	return o.first(ctx, e)
+}
+
+// first makes the first attempt of a new entry or hands it to the batch loop.
+func (o *Outbox) first(ctx context.Context, e *entry) error {
+	if o.batcher == nil {
+		return o.attempt(ctx, e)
+	}
+	o.release(e)
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run retries failed and left over payloads until ctx is done.
+func (o *Outbox) Run(ctx context.Context) error {
+	if n := o.Depth(); n > 0 {
+		o.log.Info("Redelivering outbox", zap.Int("count", n))
+	}
+	if o.batcher != nil {
+		return o.runBatches(ctx)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		o.expireAcks(ctx)
+		for _, e := range o.due(time.Now()) {
+			if ctx.Err() != nil {
+				o.release(e)
+				continue
+			}
+			if e.Attempts > 0 {
+				metrics.DeliveryRetries.Inc()
+			}
+			if err := o.attempt(ctx, e); err != nil {
+				o.log.Warn("Retry failed", zap.String("id", e.ID), zap.Int("attempts", e.Attempts), zap.Error(err))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain retries every pending entry, ignoring the backoff, until the outbox
+// is empty or ctx is done. Entries that are still pending or held stay on
+// disk and are delivered on the next start.
+func (o *Outbox) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		unheld := func(e *entry) bool { return !e.held(time.Now()) && !e.awaitingAck() }
+		if o.batcher != nil {
+			o.sendBatches(ctx, unheld, true)
+		} else {
+			for _, e := range o.claim(unheld) {
+				if ctx.Err() != nil {
+					o.release(e)
+					continue
+				}
+				if err := o.attempt(ctx, e); err != nil {
+					o.log.Warn("Drain attempt failed", zap.String("id", e.ID), zap.Int("attempts", e.Attempts), zap.Error(err))
+				}
+			}
+		}
+		if o.unheld() == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// unheld returns the number of pending entries that are neither held nor
+// waiting for an acknowledgment.
+func (o *Outbox) unheld() int {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	now := time.Now()
+	n := 0
+	for _, e := range o.entries {
+		if !e.held(now) && !e.awaitingAck() {
+			n++
+		}
+	}
+	return n
+}
+
+// due claims entries whose next attempt time has come, oldest first.
+func (o *Outbox) due(now time.Time) []*entry {
+	return o.claim(func(e *entry) bool { return !e.NextAttempt.After(now) && !e.held(now) && !e.awaitingAck() })
+}
+
+// claim marks entries accepted by ready as in flight and returns them oldest first.
+func (o *Outbox) claim(ready func(e *entry) bool) []*entry {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	var claimed []*entry
+	for id, e := range o.entries {
+		if o.inFlight[id] || !ready(e) {
+			continue
+		}
+		o.inFlight[id] = true
+		claimed = append(claimed, e)
+	}
+	sort.Slice(claimed, func(i, j int) bool { return claimed[i].ID < claimed[j].ID })
+	return claimed
+}
+
+func (o *Outbox) release(e *entry) {
+	o.mux.Lock()
+	delete(o.inFlight, e.ID)
+	o.mux.Unlock()
+}
+
+func (o *Outbox) attempt(ctx context.Context, e *entry) error {
+	defer o.release(e)
+
+	if e.expired(o.policy.MaxAge, time.Now()) {
+		o.expire(ctx, e)
+		return nil
+	}
+	o.report(ctx, e, sink.StatusDelivering, e.Attempts+1, "")
+	sendErr := o.sink.Send(ctx, e.Target, e.Event)
+	if sendErr == nil {
+		o.report(ctx, e, sink.StatusDelivered, e.Attempts+1, "")
+		return o.delivered(e)
+	}
+	return o.fail(ctx, e, sendErr)
+}
+
+// remove drops an acknowledged entry.
+func (o *Outbox) remove(e *entry) error {
+	o.mux.Lock()
+	delete(o.entries, e.ID)
+	o.mux.Unlock()
+
+	if err := os.Remove(o.path(e.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "remove outbox entry")
+	}
+	return nil
+}
+
+// fail records a failed attempt and schedules the next one or buries the
+// entry once its attempts are exhausted.
+func (o *Outbox) fail(ctx context.Context, e *entry, sendErr error) error {
+	metrics.WebhookFailures.Inc()
+	o.mux.Lock()
+	e.Attempts++
+	e.LastError = sendErr.Error()
+	o.mux.Unlock()
+	o.report(ctx, e, sink.StatusFailed, e.Attempts, e.LastError)
+	if o.policy.MaxAttempts > 0 && e.Attempts >= o.policy.MaxAttempts {
+		if err := o.bury(ctx, e); err != nil {
+			o.log.Error("move to dead letter", zap.String("id", e.ID), zap.Error(err))
+		}
+		return errors.Wrapf(sendErr, "gave up after %d attempts", e.Attempts)
+	}
+
+	o.mux.Lock()
+	e.NextAttempt = time.Now().Add(o.policy.backoff(e.Attempts))
+	o.mux.Unlock()
+	if err := o.update(e); err != nil {
+		o.log.Error("update outbox entry", zap.String("id", e.ID), zap.Error(err))
+	}
+	return sendErr
+}
+
+// bury hands an entry that ran out of attempts to the dead letter, falling
+// back to the dead-letter directory.
+func (o *Outbox) bury(ctx context.Context, e *entry) error {
+	o.mux.Lock()
+	_, pending := o.entries[e.ID]
+	delete(o.entries, e.ID)
+	o.mux.Unlock()
+	if !pending {
+		// Acknowledged in the meantime.
+		return nil
+	}
+
+	o.log.Error("Delivery failed permanently, moved to dead letter",
+		zap.String("id", e.ID),
+		zap.String("target", e.Target),
+		zap.Int("attempts", e.Attempts),
+		zap.String("error", e.LastError),
+	)
+
+	if o.dead != nil {
+		err := o.dead(ctx, &Letter{
+			ID:        e.ID,
+			Target:    e.Target,
+			Event:     e.Event,
+			Attempts:  e.Attempts,
+			Error:     e.LastError,
+			CreatedAt: e.CreatedAt,
+			FailedAt:  time.Now(),
+		})
+		if err == nil {
+			return os.Remove(o.path(e.ID))
+		}
+		o.log.Error("Dead letter failed, keeping the entry in the dead directory", zap.String("id", e.ID), zap.Error(err))
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(o.dir, deadDir, e.ID+entryExt), data); err != nil {
+		return err
+	}
+	return os.Remove(o.path(e.ID))
+}
+
+// put writes a new entry. It is in flight for its first attempt unless it
+// is held until heldUntil.
+func (o *Outbox) put(ctx context.Context, target string, ev *event.Event, heldUntil time.Time) (*entry, error) {
+	e := &entry{
+		ID:        fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), o.seq.Add(1)%1e6),
+		CreatedAt: time.Now(),
+		Target:    target,
+		Event:     ev,
+		HeldUntil: heldUntil,
+	}
+	if err := o.write(e); err != nil {
+		return nil, err
+	}
+
+	o.mux.Lock()
+	o.entries[e.ID] = e
+	if !e.held(e.CreatedAt) {
+		o.inFlight[e.ID] = true
+	}
+	o.mux.Unlock()
+	o.report(ctx, e, sink.StatusQueued, 0, "")
+	return e, nil
+}
+
+// update writes a changed entry unless it was acknowledged meanwhile.
+func (o *Outbox) update(e *entry) error {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if _, ok := o.entries[e.ID]; !ok {
+		return nil
+	}
+	return o.write(e)
+}
+
+func (o *Outbox) write(e *entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return writeFile(o.path(e.ID), data)
+}
+
+func (o *Outbox) load() error {
+	files, err := os.ReadDir(o.dir)
+	if err != nil {
+		return errors.Wrap(err, "list outbox")
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), entryExt) {
+			continue
+		}
+		id := strings.TrimSuffix(f.Name(), entryExt)
+
+		data, err := os.ReadFile(o.path(id))
+		if err != nil {
+			return errors.Wrap(err, "read outbox entry")
+		}
+		e := &entry{}
+		if err := json.Unmarshal(data, e); err != nil {
+			o.log.Error("Skip broken outbox entry", zap.String("id", id), zap.Error(err))
+			continue
+		}
+		e.ID = id
+		if e.Event == nil {
+			e.Event = &event.Event{}
+			if err := json.Unmarshal(e.Body, e.Event); err != nil {
+				o.log.Error("Skip broken outbox entry", zap.String("id", id), zap.Error(err))
+				continue
+			}
+			e.Body = nil
+		}
+		// Whatever was left from the previous run is retried right away,
+		// delivered entries once their ack deadline passed.
+		e.NextAttempt = time.Time{}
+		o.entries[id] = e
+	}
+	return nil
+}
+
+func (o *Outbox) path(id string) string {
+	return filepath.Join(o.dir, id+entryExt)
+}
+
+// writeFile writes data to a temp file, syncs it and renames it over path,
+// so readers never see a partially written entry.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
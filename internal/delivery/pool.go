@@ -0,0 +1,81 @@
+package delivery
+
+import (
+	"context"
+
+	"go-tg.com/internal/metrics"
+	"go-tg.com/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+)
+
+// PoolPolicy sizes the workers making first delivery attempts. When Queue
+// attempts are waiting, Deliver blocks or, with DropOldest, skips the first
+// attempt of the oldest waiting entry; it stays in the outbox for Run.
+type PoolPolicy struct {
+	Workers    int
+	Queue      int
+	DropOldest bool
+}
+
+type job struct {
+	ctx   context.Context
+	route Route
+	entry *entry
+}
+
+// startPool starts the workers, they live as long as the process.
+func (f *Fanout) startPool(policy PoolPolicy) {
+	f.pool = policy
+	f.jobs = make(chan job, max(policy.Queue, 1))
+	for range max(policy.Workers, 1) {
+		go func() {
+			for j := range f.jobs {
+				metrics.DeliveryQueueLength.Dec()
+				ctx, span := tracing.Start(j.ctx, "send", attribute.String("sink", j.route.Name))
+				err := j.route.Outbox.first(ctx, j.entry)
+				tracing.End(span, err)
+				if err != nil {
+					f.log.Warn("Delivery failed, will retry", zap.String("sink", j.route.Name), zap.String("id", j.entry.ID), zap.Error(err))
+				}
+				f.inFlight.Done()
+			}
+		}()
+	}
+}
+
+// submit queues the first attempt of an entry, applying the backpressure policy.
+func (f *Fanout) submit(ctx context.Context, j job) {
+	f.inFlight.Add(1)
+	metrics.DeliveryQueueLength.Inc()
+	for {
+		select {
+		case f.jobs <- j:
+			return
+		default:
+		}
+
+		if !f.pool.DropOldest {
+			select {
+			case f.jobs <- j:
+			case <-ctx.Done():
+				f.skip(j)
+			}
+			return
+		}
+		select {
+		case old := <-f.jobs:
+			f.skip(old)
+		default:
+		}
+	}
+}
+
+// skip leaves an entry to the retry loop of its outbox.
+func (f *Fanout) skip(j job) {
+	metrics.DeliveryQueueLength.Dec()
+	metrics.DeliveryQueueDropped.Inc()
+	f.log.Warn("Delivery queue full, leaving the event to retries", zap.String("sink", j.route.Name), zap.String("id", j.entry.ID))
+	j.route.Outbox.release(j.entry)
+	f.inFlight.Done()
+}
@@ -0,0 +1,84 @@
+package delivery
+
+import (
+	"unicode/utf8"
+
+	"go-tg.com/internal/event"
+)
+
+// Rule sends the events it matches to the routes named in Routes only, to
+// none when it is empty. Rules are tried in order and the first matching one
+// applies, events matching none go to every route.
+type Rule struct {
+	Name   string
+	Match  func(e *event.Event) bool
+	Routes []string
+}
+
+// RuleCondition is what an event must have for a rule to match it. Empty
+// lists and zero lengths accept everything, Media nil accepts events with
+// and without media. Text lengths are in runes of the full text.
+type RuleCondition struct {
+	Types         []string
+	Channels      []int64
+	Media         *bool
+	MediaTypes    []string
+	MinTextLength int
+	MaxTextLength int
+}
+
+// Match reports whether e meets all conditions.
+func (c RuleCondition) Match(e *event.Event) bool {
+	if len(c.Types) > 0 && !contains(c.Types, e.Type) {
+		return false
+	}
+	if len(c.Channels) > 0 && !contains(c.Channels, e.ChannelID) {
+		return false
+	}
+	hasMedia := e.Media != nil || len(e.Album) > 0 || e.MediaLink != ""
+	if c.Media != nil && *c.Media != hasMedia {
+		return false
+	}
+	if len(c.MediaTypes) > 0 && !c.hasMediaType(e) {
+		return false
+	}
+	if c.MinTextLength > 0 || c.MaxTextLength > 0 {
+		n := utf8.RuneCountInString(e.FullText())
+		if n < c.MinTextLength || (c.MaxTextLength > 0 && n > c.MaxTextLength) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c RuleCondition) hasMediaType(e *event.Event) bool {
+	if e.Media != nil && contains(c.MediaTypes, e.Media.Type) {
+		return true
+	}
+	for _, m := range e.Album {
+		if contains(c.MediaTypes, m.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRules replaces the routing rules, nil sends every event to all
+// matching routes.
+func (f *Fanout) SetRules(rules []Rule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = rules
+}
+
+// ruleOf returns the first rule matching e.
+func (f *Fanout) ruleOf(e *event.Event) (Rule, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, r := range f.rules {
+		if r.Match(e) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
@@ -0,0 +1,47 @@
+package event
+
+// ChannelInfo is the metadata of a channel as recorded by snapshots.
+type ChannelInfo struct {
+	Title        string `json:"title"`
+	Username     string `json:"username,omitempty"`
+	About        string `json:"about,omitempty"`
+	Participants int    `json:"participants"`
+}
+
+// Changes returns the names of the fields that differ from prev, the
+// participant count is left out as it changes all the time.
+func (c ChannelInfo) Changes(prev ChannelInfo) []string {
+	var changed []string
+	if c.Title != prev.Title {
+		changed = append(changed, "title")
+	}
+	if c.Username != prev.Username {
+		changed = append(changed, "username")
+	}
+	if c.About != prev.About {
+		changed = append(changed, "about")
+	}
+	return changed
+}
+
+// ChannelChange is the new metadata of a channel with the one recorded
+// before, Changed names the fields that differ.
+type ChannelChange struct {
+	ChannelInfo
+	Previous ChannelInfo `json:"previous"`
+	Changed  []string    `json:"changed"`
+}
+
+// ChannelChanged builds a channelChanged event with the new title as text.
+func ChannelChanged(chat Chat, change *ChannelChange) *Event {
+	return &Event{
+		SchemaVersion:   SchemaVersion,
+		Type:            "channelChanged",
+		Text:            change.Title,
+		ChatType:        chat.Type,
+		ChannelID:       chat.ID,
+		ChannelUsername: chat.Username,
+		ChannelTitle:    change.Title,
+		ChannelChange:   change,
+	}
+}
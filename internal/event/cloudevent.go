@@ -0,0 +1,57 @@
+package event
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// SchemaVersion is the version of the native payload. It is raised on changes
+// that break existing receivers, new optional fields keep it.
+const SchemaVersion = 1
+
+// CloudEvent is an event in the structured JSON mode of CloudEvents 1.0.
+type CloudEvent struct {
+	SpecVersion     string `json:"specversion"`
+	ID              string `json:"id"`
+	Source          string `json:"source"`
+	Type            string `json:"type"`
+	Subject         string `json:"subject,omitempty"`
+	Time            string `json:"time"`
+	DataContentType string `json:"datacontenttype"`
+	Data            *Event `json:"data"`
+}
+
+// CloudEvent wraps e with the native payload as data. The type is typePrefix
+// followed by the event type and the subject is the chat ID. The ID is derived
+// from the payload, so retries of an event keep it and receivers can dedupe.
+func (e *Event) CloudEvent(source, typePrefix string) (*CloudEvent, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+
+	at := time.Now()
+	if e.EditDate != 0 {
+		at = time.Unix(int64(e.EditDate), 0)
+	} else if e.Date != 0 {
+		at = time.Unix(int64(e.Date), 0)
+	}
+
+	ce := &CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              hex.EncodeToString(sum[:16]),
+		Source:          source,
+		Type:            typePrefix + e.Type,
+		Time:            at.UTC().Format(time.RFC3339),
+		DataContentType: "application/json",
+		Data:            e,
+	}
+	if e.ChannelID != 0 {
+		ce.Subject = strconv.FormatInt(e.ChannelID, 10)
+	}
+	return ce, nil
+}
@@ -0,0 +1,55 @@
+package event
+
+import (
+	"strconv"
+
+	"github.com/gotd/td/tg"
+)
+
+// Comment links a comment in the discussion group of a channel to the
+// channel post it was left under.
+type Comment struct {
+	ChannelID       int64  `json:"channel_id"`
+	ChannelUsername string `json:"channel_username,omitempty"`
+	PostID          int    `json:"post_id"`
+}
+
+// link opens the comment with the given ID under the post.
+func (c *Comment) link(commentID string) string {
+	post := "https://t.me/c/" + strconv.FormatInt(c.ChannelID, 10) + "/" + strconv.Itoa(c.PostID)
+	if c.ChannelUsername != "" {
+		post = "https://t.me/" + c.ChannelUsername + "/" + strconv.Itoa(c.PostID)
+	}
+	return post + "?comment=" + commentID
+}
+
+// ThreadOf returns the first message of the thread msg replies in, for
+// comments the copy of the channel post in the discussion group.
+func ThreadOf(msg *tg.Message) (int, bool) {
+	reply, ok := msg.GetReplyTo()
+	if !ok {
+		return 0, false
+	}
+	header, ok := reply.(*tg.MessageReplyHeader)
+	if !ok {
+		return 0, false
+	}
+	if top, ok := header.GetReplyToTopID(); ok {
+		return top, true
+	}
+	return header.GetReplyToMsgID()
+}
+
+// ChannelPostOf returns the post of the channel that msg is the copy of,
+// Telegram forwards every post into the discussion group of its channel.
+func ChannelPostOf(msg *tg.Message, channelID int64) (int, bool) {
+	fwd, ok := msg.GetFwdFrom()
+	if !ok {
+		return 0, false
+	}
+	from, ok := fwd.GetFromID()
+	if peer, isChannel := from.(*tg.PeerChannel); !ok || !isChannel || peer.ChannelID != channelID {
+		return 0, false
+	}
+	return fwd.GetChannelPost()
+}
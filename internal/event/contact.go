@@ -0,0 +1,40 @@
+package event
+
+import (
+	"strings"
+
+	"github.com/gotd/td/tg"
+)
+
+// Contact is a shared contact. UserID is 0 when the phone number doesn't
+// belong to a Telegram user.
+type Contact struct {
+	PhoneNumber string `json:"phone_number"`
+	FirstName   string `json:"first_name,omitempty"`
+	LastName    string `json:"last_name,omitempty"`
+	UserID      int64  `json:"user_id,omitempty"`
+	Vcard       string `json:"vcard,omitempty"`
+}
+
+// Name returns the full name of the contact.
+func (c *Contact) Name() string {
+	return strings.TrimSpace(c.FirstName + " " + c.LastName)
+}
+
+func contactOf(msg *tg.Message) *Contact {
+	media, ok := msg.GetMedia()
+	if !ok {
+		return nil
+	}
+	m, ok := media.(*tg.MessageMediaContact)
+	if !ok {
+		return nil
+	}
+	return &Contact{
+		PhoneNumber: m.PhoneNumber,
+		FirstName:   m.FirstName,
+		LastName:    m.LastName,
+		UserID:      m.UserID,
+		Vcard:       m.Vcard,
+	}
+}
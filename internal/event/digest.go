@@ -0,0 +1,122 @@
+package event
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Digest summarizes events that were not delivered one by one: how many,
+// between which unix times, the first of them and the most frequent words
+// of their texts.
+type Digest struct {
+	Count    int             `json:"count"`
+	Since    int             `json:"since"`
+	Until    int             `json:"until"`
+	Messages []DigestMessage `json:"messages"`
+	Keywords []string        `json:"keywords,omitempty"`
+
+	maxMessages int
+	words       map[string]int
+}
+
+// DigestMessage is an event listed in a digest.
+type DigestMessage struct {
+	Type      string `json:"type"`
+	ChannelID int64  `json:"channel_id"`
+	ID        string `json:"id,omitempty"`
+	Text      string `json:"text,omitempty"`
+	Link      string `json:"link,omitempty"`
+}
+
+// NewDigest builds an empty digest event of eventType listing up to
+// maxMessages events, for the chat of chat or, when nil, of several chats.
+func NewDigest(eventType string, chat *Event, maxMessages int) *Event {
+	e := &Event{
+		SchemaVersion: SchemaVersion,
+		Type:          eventType,
+		Digest:        &Digest{Messages: []DigestMessage{}, maxMessages: maxMessages, words: map[string]int{}},
+	}
+	if chat != nil {
+		e.ChatType = chat.ChatType
+		e.ChannelID = chat.ChannelID
+		e.ChannelUsername = chat.ChannelUsername
+		e.ChannelTitle = chat.ChannelTitle
+	}
+	return e
+}
+
+// Add counts e into the digest and lists it while there is room.
+func (d *Digest) Add(e *Event) {
+	now := int(time.Now().Unix())
+	if d.Count == 0 {
+		d.Since = now
+	}
+	d.Count++
+	d.Until = now
+	if len(d.Messages) < d.maxMessages {
+		d.Messages = append(d.Messages, DigestMessage{
+			Type:      e.Type,
+			ChannelID: e.ChannelID,
+			ID:        e.ExternalID,
+			Text:      e.Text,
+			Link:      e.Link(),
+		})
+	}
+	for _, word := range words(e.Text) {
+		d.words[word]++
+	}
+}
+
+// SetKeywords fills Keywords with the n most frequent words of the texts,
+// more frequent first.
+func (d *Digest) SetKeywords(n int) {
+	keywords := make([]string, 0, len(d.words))
+	for word, count := range d.words {
+		if count > 1 {
+			keywords = append(keywords, word)
+		}
+	}
+	sort.Slice(keywords, func(i, j int) bool {
+		a, b := d.words[keywords[i]], d.words[keywords[j]]
+		if a != b {
+			return a > b
+		}
+		return keywords[i] < keywords[j]
+	})
+	d.Keywords = keywords[:min(n, len(keywords))]
+}
+
+// minKeywordLength skips short words, they are mostly articles and
+// prepositions.
+const minKeywordLength = 4
+
+// stopWords are frequent words of English and Russian that say nothing
+// about the text.
+var stopWords = map[string]bool{
+	"about": true, "after": true, "also": true, "been": true, "from": true, "have": true,
+	"here": true, "into": true, "just": true, "more": true, "only": true, "over": true,
+	"some": true, "than": true, "that": true, "their": true, "them": true, "then": true,
+	"there": true, "they": true, "this": true, "today": true, "very": true, "were": true,
+	"what": true, "when": true, "which": true, "will": true, "with": true, "would": true,
+	"your": true, "http": true, "https": true,
+	"было": true, "были": true, "будет": true, "если": true, "есть": true, "еще": true,
+	"ещё": true, "когда": true, "который": true, "которые": true, "также": true, "тоже": true,
+	"только": true, "чтобы": true, "этого": true, "этот": true, "этом": true, "эти": true,
+}
+
+// words returns the lower-cased words of text that can be keywords.
+func words(text string) []string {
+	var res []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if utf8.RuneCountInString(word) < minKeywordLength || stopWords[word] || strings.IndexFunc(word, unicode.IsLetter) < 0 {
+			continue
+		}
+		res = append(res, word)
+	}
+	return res
+}
@@ -0,0 +1,140 @@
+package event
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is how many unchanged lines surround a change in a hunk.
+const diffContext = 3
+
+// Edit is what an edit changed: the text before and after it, untruncated,
+// and a unified diff of their lines. Diff is empty when only something other
+// than the text was edited.
+type Edit struct {
+	PreviousText string `json:"previous_text"`
+	Text         string `json:"text"`
+	Diff         string `json:"diff,omitempty"`
+}
+
+// EditOf describes an edit from previous to text.
+func EditOf(previous, text string) *Edit {
+	return &Edit{PreviousText: previous, Text: text, Diff: UnifiedDiff(previous, text)}
+}
+
+// UnifiedDiff compares the lines of a and b in the unified format without
+// file headers, empty when they are equal.
+func UnifiedDiff(a, b string) string {
+	if a == b {
+		return ""
+	}
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change and the end of its hunk, changes closer
+		// than two contexts apart share a hunk.
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				if i-last > 2*diffContext {
+					break
+				}
+				last = i
+			}
+		}
+		from := max(first-diffContext, start)
+		to := min(last+diffContext+1, len(ops))
+
+		hunk := ops[from:to]
+		aStart, bStart := ops[from].a, ops[from].b
+		var aLen, bLen int
+		for _, op := range hunk {
+			if op.kind != '+' {
+				aLen++
+			}
+			if op.kind != '-' {
+				bLen++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+		for _, op := range hunk {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+		start = to
+	}
+	return out.String()
+}
+
+// hunkRange formats the 0-based start and length of a hunk side, an empty
+// side is given as the line before it.
+func hunkRange(start, n int) string {
+	switch n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, n)
+	}
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+// diffOp is a line kept (' '), removed ('-') or added ('+') with the
+// positions it has in a and b.
+type diffOp struct {
+	kind byte
+	line string
+	a, b int
+}
+
+// diffLines builds the edit script of a longest common subsequence. Message
+// texts are short, the quadratic table is fine for them.
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{kind: ' ', line: a[i], a: i, b: j})
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', line: a[i], a: i, b: j})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', line: b[j], a: i, b: j})
+			j++
+		}
+	}
+	return ops
+}
@@ -0,0 +1,424 @@
+package event
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/media"
+)
+
+const (
+	FormatCompact = "compact"
+	FormatFull    = "full"
+)
+
+// Chat is where a message was posted: a channel or supergroup, a private
+// dialog with a user or a basic group.
+type Chat struct {
+	Type     string
+	ID       int64
+	Username string
+	Title    string
+	Forum    bool
+}
+
+func ChannelChat(channel *tg.Channel) Chat {
+	return Chat{Type: config.PeerChannel, ID: channel.GetID(), Username: channel.Username, Title: channel.Title, Forum: channel.Forum}
+}
+
+func UserChat(user *tg.User) Chat {
+	title := strings.TrimSpace(user.FirstName + " " + user.LastName)
+	return Chat{Type: config.PeerUser, ID: user.GetID(), Username: user.Username, Title: title}
+}
+
+func GroupChat(chat *tg.Chat) Chat {
+	return Chat{Type: config.PeerChat, ID: chat.GetID(), Title: chat.Title}
+}
+
+// Event is a single watcher event, serialized as JSON it is the payload sinks deliver.
+type Event struct {
+	SchemaVersion   int            `json:"schema_version"`
+	Text            string         `json:"text"`
+	Type            string         `json:"type"`
+	ExternalID      string         `json:"external_id"`
+	IdempotencyKey  string         `json:"idempotency_key,omitempty"`
+	ChatType        string         `json:"chat_type,omitempty"`
+	ChannelID       int64          `json:"channel_id"` // ID of the chat, named so for compatibility
+	ChannelUsername string         `json:"channel_username,omitempty"`
+	TopicID         int            `json:"topic_id,omitempty"`
+	Permalink       string         `json:"permalink,omitempty"`
+	ContentHash     string         `json:"content_hash,omitempty"`
+	Truncated       bool           `json:"truncated,omitempty"`
+	Part            *Part          `json:"part,omitempty"`
+	MessageIDs      []int          `json:"message_ids,omitempty"`
+	Media           *media.Media   `json:"media,omitempty"`
+	GroupedID       int64          `json:"grouped_id,omitempty"`
+	Album           []*media.Media `json:"album,omitempty"`
+	MediaLink       string         `json:"media_link,omitempty"` // replaces Media and Album in oversized webhook payloads
+	Reaction        string         `json:"reaction,omitempty"`
+	Reactions       []Reaction     `json:"reactions,omitempty"`
+	Poll            *Poll          `json:"poll,omitempty"`
+	Location        *Location      `json:"location,omitempty"`
+	Contact         *Contact       `json:"contact,omitempty"`
+	Invoice         *Invoice       `json:"invoice,omitempty"`
+	Stats           *Stats         `json:"stats,omitempty"`
+	ChannelChange   *ChannelChange `json:"channel_change,omitempty"`
+	Findings        []Finding      `json:"findings,omitempty"`
+	Extracted       *Extracted     `json:"extracted,omitempty"`
+	Forward         *Forward       `json:"forward,omitempty"`
+	ReplyTo         *Reply         `json:"reply_to,omitempty"`
+	Service         *Service       `json:"service,omitempty"`
+	Edit            *Edit          `json:"edit,omitempty"`
+	Comment         *Comment       `json:"comment,omitempty"`
+	Scheduled       *Scheduled     `json:"scheduled,omitempty"`
+	Links           []Link         `json:"links,omitempty"`
+	Digest          *Digest        `json:"digest,omitempty"`
+	Tags            []string       `json:"tags,omitempty"`
+	Language        string         `json:"language,omitempty"`
+	TranslatedText  string         `json:"translated_text,omitempty"`
+	Transcript      string         `json:"transcript,omitempty"`
+	Severity        string         `json:"severity,omitempty"`
+
+	// Filled only in the full payload format.
+	ChannelTitle string   `json:"channel_title,omitempty"`
+	Author       *Peer    `json:"author,omitempty"`
+	Date         int      `json:"date,omitempty"`
+	EditDate     int      `json:"edit_date,omitempty"`
+	ReplyToID    int      `json:"reply_to_id,omitempty"`
+	Views        int      `json:"views,omitempty"`
+	Forwards     int      `json:"forwards,omitempty"`
+	Entities     []Entity `json:"entities,omitempty"`
+
+	source *source
+	posted int
+}
+
+// Part numbers the events a long text was split into, Index from 1 to
+// Count.
+type Part struct {
+	Index int `json:"index"`
+	Count int `json:"count"`
+}
+
+type Peer struct {
+	ID        int64  `json:"id,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// Forward is where a forwarded message comes from: the original chat or
+// author, the ID of the original post and its original date. Title and
+// Username are filled when the source was seen in the updates.
+type Forward struct {
+	From          *Peer  `json:"from,omitempty"`
+	FromName      string `json:"from_name,omitempty"`
+	Title         string `json:"title,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Date          int    `json:"date"`
+	ChannelPostID int    `json:"channel_post_id,omitempty"`
+}
+
+// Entity describes formatting of the original text.
+// Offset and Length are in UTF-16 code units as sent by Telegram.
+type Entity struct {
+	Type   string `json:"type"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	URL    string `json:"url,omitempty"`
+	UserID int64  `json:"user_id,omitempty"`
+	Lang   string `json:"language,omitempty"`
+	// DocumentID is the sticker document of a customEmoji entity.
+	DocumentID int64 `json:"document_id,omitempty"`
+}
+
+// FromMessage builds an event of eventType for a message posted in chat.
+// A poll without text gets its question as the text, a sticker its emoji,
+// a contact its name and an invoice its title.
+func FromMessage(cfg config.PayloadConfig, chat Chat, msg *tg.Message, eventType string) *Event {
+	raw := msg.GetMessage()
+	poll := pollOf(msg)
+	m := media.Describe(msg)
+	contact, invoice := contactOf(msg), invoiceOf(msg)
+	if raw == "" {
+		switch {
+		case poll != nil:
+			raw = poll.Question
+		case m != nil && m.Sticker != nil:
+			raw = m.Sticker.Emoji
+		case contact != nil:
+			raw = contact.Name()
+		case invoice != nil:
+			raw = invoice.Title
+		}
+	}
+	text, truncated := prepareText(cfg, raw)
+
+	e := &Event{
+		SchemaVersion:   SchemaVersion,
+		Text:            text,
+		Type:            eventType,
+		ExternalID:      strconv.Itoa(msg.GetID()),
+		IdempotencyKey:  messageKey(eventType, chat.ID, msg.GetID(), msg.EditDate),
+		ChatType:        chat.Type,
+		ChannelID:       chat.ID,
+		ChannelUsername: chat.Username,
+		TopicID:         TopicOf(chat, msg),
+		Truncated:       truncated,
+		Media:           m,
+		Poll:            poll,
+		Location:        locationOf(msg),
+		Contact:         contact,
+		Invoice:         invoice,
+		Forward:         forwardOf(msg),
+		ContentHash:     ContentHash(msg),
+	}
+	if cfg.Format == FormatFull {
+		e.fillFull(chat, msg)
+	}
+	e.source = &source{text: raw, entities: entitiesOf(msg)}
+	e.Links = linksOf(raw, e.source.entities)
+	e.posted = max(msg.Date, msg.EditDate)
+	return e
+}
+
+// Posted returns when the message of the event was posted or last edited,
+// zero for events without a message and those read back from the outbox.
+func (e *Event) Posted() time.Time {
+	if e.posted == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(e.posted), 0)
+}
+
+// FromAlbum builds one event for all parts of an album. The first caption
+// is the base of the event, so its entities stay valid, other captions are
+// appended to it. Media of every part is listed in Album, the links of
+// all captions in Links.
+func FromAlbum(cfg config.PayloadConfig, chat Chat, msgs []*tg.Message, eventType string) *Event {
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].GetID() < msgs[j].GetID() })
+
+	base := msgs[0]
+	for _, msg := range msgs {
+		if msg.GetMessage() != "" {
+			base = msg
+			break
+		}
+	}
+	captions := []string{base.GetMessage()}
+	for _, msg := range msgs {
+		if msg != base && msg.GetMessage() != "" {
+			captions = append(captions, msg.GetMessage())
+		}
+	}
+
+	e := FromMessage(cfg, chat, base, eventType)
+	editDate := 0
+	for _, msg := range msgs {
+		editDate = max(editDate, msg.EditDate)
+	}
+	e.IdempotencyKey = messageKey(eventType, chat.ID, msgs[0].GetID(), editDate)
+	e.posted = max(e.posted, editDate)
+	e.ContentHash = ContentHash(msgs...)
+	e.Text, e.Truncated = prepareText(cfg, strings.Join(captions, "\n\n"))
+	e.source.text = strings.Join(captions, "\n\n")
+	e.Media = nil
+	e.GroupedID, _ = base.GetGroupedID()
+	e.Links = nil
+	for _, msg := range msgs {
+		e.MessageIDs = append(e.MessageIDs, msg.GetID())
+		for _, link := range linksOf(msg.GetMessage(), entitiesOf(msg)) {
+			if !slices.Contains(e.Links, link) {
+				e.Links = append(e.Links, link)
+			}
+		}
+		if m := media.Describe(msg); m != nil {
+			m.MessageID = msg.GetID()
+			e.Album = append(e.Album, m)
+		}
+	}
+	return e
+}
+
+func prepareText(cfg config.PayloadConfig, text string) (string, bool) {
+	if cfg.NormalizeWhitespace {
+		text = normalizeWhitespace(text)
+	}
+	return truncateText(text, cfg.MaxTextLength)
+}
+
+// Deleted builds a deleteMessage event for messages removed from a chat.
+func Deleted(chat Chat, messageIDs []int) *Event {
+	return &Event{
+		SchemaVersion:   SchemaVersion,
+		Type:            "deleteMessage",
+		ChatType:        chat.Type,
+		ChannelID:       chat.ID,
+		ChannelUsername: chat.Username,
+		MessageIDs:      messageIDs,
+	}
+}
+
+func (e *Event) fillFull(chat Chat, msg *tg.Message) {
+	e.ChannelTitle = chat.Title
+	e.Date = msg.Date
+	e.EditDate, _ = msg.GetEditDate()
+	e.Views, _ = msg.GetViews()
+	e.Forwards, _ = msg.GetForwards()
+
+	e.Author = authorOf(msg)
+
+	if reply, ok := msg.GetReplyTo(); ok {
+		if header, ok := reply.(*tg.MessageReplyHeader); ok {
+			e.ReplyToID, _ = replyToMsgID(header)
+		}
+	}
+
+	e.Entities = entitiesOf(msg)
+}
+
+func forwardOf(msg *tg.Message) *Forward {
+	fwd, ok := msg.GetFwdFrom()
+	if !ok {
+		return nil
+	}
+	forward := &Forward{Date: fwd.Date}
+	if from, ok := fwd.GetFromID(); ok {
+		forward.From = peerOf(from)
+	}
+	forward.FromName, _ = fwd.GetFromName()
+	forward.ChannelPostID, _ = fwd.GetChannelPost()
+	return forward
+}
+
+func entitiesOf(msg *tg.Message) []Entity {
+	var entities []Entity
+	for _, entity := range msg.Entities {
+		entities = append(entities, entityOf(entity))
+	}
+	return entities
+}
+
+func peerOf(peer tg.PeerClass) *Peer {
+	switch p := peer.(type) {
+	case *tg.PeerUser:
+		return &Peer{ID: p.UserID, Type: "user"}
+	case *tg.PeerChat:
+		return &Peer{ID: p.ChatID, Type: "chat"}
+	case *tg.PeerChannel:
+		return &Peer{ID: p.ChannelID, Type: "channel"}
+	default:
+		return nil
+	}
+}
+
+func entityOf(e tg.MessageEntityClass) Entity {
+	entity := Entity{
+		Type:   entityType(e),
+		Offset: e.GetOffset(),
+		Length: e.GetLength(),
+	}
+	switch v := e.(type) {
+	case *tg.MessageEntityTextURL:
+		entity.URL = v.URL
+	case *tg.MessageEntityMentionName:
+		entity.UserID = v.UserID
+	case *tg.MessageEntityPre:
+		entity.Lang = v.Language
+	case *tg.MessageEntityCustomEmoji:
+		entity.DocumentID = v.DocumentID
+	}
+	return entity
+}
+
+// entityType turns "messageEntityTextUrl" into "textUrl".
+func entityType(e tg.MessageEntityClass) string {
+	name := strings.TrimPrefix(e.TypeName(), "messageEntity")
+	if name == "" {
+		return e.TypeName()
+	}
+	r := []rune(name)
+	r[0] = unicode.ToLower(r[0])
+	return string(r)
+}
+
+// authorOf returns the sender of msg with the post signature, nil for
+// anonymous channel posts.
+func authorOf(msg *tg.Message) *Peer {
+	var author *Peer
+	if from, ok := msg.GetFromID(); ok {
+		author = peerOf(from)
+	}
+	if signature, ok := msg.GetPostAuthor(); ok {
+		if author == nil {
+			author = &Peer{}
+		}
+		author.Signature = signature
+	}
+	return author
+}
+
+// DedupKey identifies the event for the dedup window. New and old messages
+// are keyed by type, chat and message ID; other events also by a hash of the
+// payload, since one message can be edited or reacted to several times.
+func (e *Event) DedupKey() string {
+	key := e.Type + ":" + strconv.FormatInt(e.ChannelID, 10) + ":" + e.ExternalID
+	switch e.Type {
+	case "newMessage", "oldMessage":
+		return key
+	}
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return key + ":" + hex.EncodeToString(sum[:8])
+}
+
+// Key returns the idempotency key of the event: for events of a message the
+// type, chat, message ID and edit date, so the same version of a message
+// always has the same key, for others the dedup key.
+func (e *Event) Key() string {
+	if e.IdempotencyKey != "" {
+		return e.IdempotencyKey
+	}
+	return e.DedupKey()
+}
+
+func messageKey(eventType string, chatID int64, messageID, editDate int) string {
+	return eventType + ":" + strconv.FormatInt(chatID, 10) + ":" + strconv.Itoa(messageID) + ":" + strconv.Itoa(editDate)
+}
+
+// Link returns the t.me URL of the message in a channel, of comments under
+// their channel post, empty for private dialogs, basic groups and events
+// without a single message. Events carry it as Permalink.
+func (e *Event) Link() string {
+	if e.ChatType != config.PeerChannel || e.ExternalID == "" {
+		return ""
+	}
+	if e.Comment != nil {
+		return e.Comment.link(e.ExternalID)
+	}
+	if e.ChannelUsername != "" {
+		return "https://t.me/" + e.ChannelUsername + "/" + e.ExternalID
+	}
+	return "https://t.me/c/" + strconv.FormatInt(e.ChannelID, 10) + "/" + e.ExternalID
+}
+
+// ChatName names the chat for people: its title when known, else the
+// username or the ID.
+func (e *Event) ChatName() string {
+	switch {
+	case e.ChannelTitle != "":
+		return e.ChannelTitle
+	case e.ChannelUsername != "":
+		return "@" + e.ChannelUsername
+	default:
+		return strconv.FormatInt(e.ChannelID, 10)
+	}
+}
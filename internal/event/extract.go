@@ -0,0 +1,104 @@
+package event
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Chains of extracted addresses.
+const (
+	ChainEVM     = "evm"
+	ChainBitcoin = "btc"
+	ChainTron    = "tron"
+	ChainSolana  = "sol"
+)
+
+// Extracted are the crypto addresses, labelled contract addresses and
+// $TICKER symbols found in the text of a message, each once. Contract
+// addresses are not repeated in Addresses.
+type Extracted struct {
+	Addresses []Address `json:"addresses,omitempty"`
+	Contracts []Address `json:"contracts,omitempty"`
+	Tickers   []string  `json:"tickers,omitempty"`
+}
+
+// Address is a crypto address with the chain its format belongs to, evm for
+// Ethereum and compatible chains.
+type Address struct {
+	Chain   string `json:"chain"`
+	Address string `json:"address"`
+}
+
+// Extractors pick what Extract looks for.
+type Extractors struct {
+	Addresses bool
+	Contracts bool
+	Tickers   bool
+}
+
+var (
+	addressPatterns = []struct {
+		chain string
+		re    *regexp.Regexp
+	}{
+		{ChainEVM, regexp.MustCompile(`\b0x[0-9a-fA-F]{40}\b`)},
+		{ChainBitcoin, regexp.MustCompile(`\b(?:bc1[02-9ac-hj-np-z]{11,71}|[13][1-9A-HJ-NP-Za-km-z]{25,34})\b`)},
+		{ChainTron, regexp.MustCompile(`\bT[1-9A-HJ-NP-Za-km-z]{33}\b`)},
+	}
+	// contractAddress finds addresses after a label such as "CA:" or
+	// "contract address", Solana mints only with a label as they can't be
+	// told from other base58 strings.
+	contractAddress = regexp.MustCompile(`\b(?i:ca|contract(?:\s+address)?|token\s+address|mint)\s*[:：=-]?\s*(0x[0-9a-fA-F]{40}|T[1-9A-HJ-NP-Za-km-z]{33}|[1-9A-HJ-NP-Za-km-z]{32,44})\b`)
+	// ticker finds cashtags, a letter first so prices are left out.
+	ticker = regexp.MustCompile(`(?:^|[^\w$])\$([A-Za-z][A-Za-z0-9]{0,9})\b`)
+)
+
+// Extract returns what x looks for in text, nil when nothing is found.
+func Extract(text string, x Extractors) *Extracted {
+	var res Extracted
+	if x.Contracts {
+		for _, m := range contractAddress.FindAllStringSubmatch(text, -1) {
+			if !hasAddress(res.Contracts, m[1]) {
+				res.Contracts = append(res.Contracts, Address{Chain: contractChain(m[1]), Address: m[1]})
+			}
+		}
+	}
+	if x.Addresses {
+		for _, p := range addressPatterns {
+			for _, m := range p.re.FindAllString(text, -1) {
+				if !hasAddress(res.Addresses, m) && !hasAddress(res.Contracts, m) {
+					res.Addresses = append(res.Addresses, Address{Chain: p.chain, Address: m})
+				}
+			}
+		}
+	}
+	if x.Tickers {
+		for _, m := range ticker.FindAllStringSubmatch(text, -1) {
+			symbol := strings.ToUpper(m[1])
+			if !slices.Contains(res.Tickers, symbol) {
+				res.Tickers = append(res.Tickers, symbol)
+			}
+		}
+	}
+	if len(res.Addresses) == 0 && len(res.Contracts) == 0 && len(res.Tickers) == 0 {
+		return nil
+	}
+	return &res
+}
+
+func hasAddress(addresses []Address, address string) bool {
+	return slices.ContainsFunc(addresses, func(a Address) bool { return a.Address == address })
+}
+
+// contractChain returns the chain of a contract address by its format.
+func contractChain(address string) string {
+	switch {
+	case strings.HasPrefix(address, "0x"):
+		return ChainEVM
+	case strings.HasPrefix(address, "T") && len(address) == 34:
+		return ChainTron
+	default:
+		return ChainSolana
+	}
+}
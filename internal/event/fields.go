@@ -0,0 +1,73 @@
+package event
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// identityFields are sent whatever fields a sink selects, consumers need
+// them to tell events apart.
+var identityFields = []string{"schema_version", "type", "external_id", "idempotency_key", "channel_id"}
+
+// fieldGroups are sent along with the field they are named after.
+var fieldGroups = map[string][]string{
+	"text":  {"truncated", "part"},
+	"media": {"album", "media_link"},
+}
+
+// payloadFields maps the JSON names of the event fields to their index.
+var payloadFields = func() map[string]int {
+	fields := map[string]int{}
+	t := reflect.TypeOf(Event{})
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = i
+		}
+	}
+	return fields
+}()
+
+// CheckFields returns an error for the first name that is not a payload
+// field.
+func CheckFields(fields []string) error {
+	for _, name := range fields {
+		if _, ok := payloadFields[name]; !ok {
+			return fmt.Errorf("unknown payload field %q", name)
+		}
+	}
+	return nil
+}
+
+// Selected returns a copy of the event with only the payload fields named
+// in fields, by their JSON names, and those identifying the event. Empty
+// fields returns the event as it is.
+func (e *Event) Selected(fields []string) *Event {
+	if len(fields) == 0 {
+		return e
+	}
+	keep := map[string]bool{}
+	for _, name := range identityFields {
+		keep[name] = true
+	}
+	for _, name := range fields {
+		keep[name] = true
+		for _, related := range fieldGroups[name] {
+			keep[related] = true
+		}
+	}
+
+	c := *e
+	v := reflect.ValueOf(&c).Elem()
+	for name, i := range payloadFields {
+		if !keep[name] {
+			v.Field(i).SetZero()
+		}
+	}
+	if !keep["text"] || !keep["entities"] {
+		// Rendering would bring the text back.
+		c.source = nil
+	}
+	return &c
+}
@@ -0,0 +1,70 @@
+package event
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"strconv"
+
+	"github.com/gotd/td/tg"
+)
+
+// ContentHash returns a hash of the text, its formatting and the media of
+// messages, the parts of an album in order. Views, reactions, reply
+// markup and the edit date are left out, so edits that only changed them
+// keep the hash. Web page previews follow the links of the text and are
+// left out too, they load after the message was sent.
+func ContentHash(msgs ...*tg.Message) string {
+	h := sha256.New()
+	for _, msg := range msgs {
+		writeField(h, msg.GetMessage())
+		if entities := entitiesOf(msg); len(entities) > 0 {
+			data, _ := json.Marshal(entities)
+			writeField(h, string(data))
+		}
+		writeField(h, mediaRef(msg.Media))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// writeField writes s with its length, so fields can't run into each other.
+func writeField(h hash.Hash, s string) {
+	h.Write([]byte(strconv.Itoa(len(s)) + ":" + s))
+}
+
+// mediaRef identifies the media of a message: the photo or document ID,
+// the place or poll, the type for others.
+func mediaRef(m tg.MessageMediaClass) string {
+	switch m := m.(type) {
+	case nil, *tg.MessageMediaEmpty, *tg.MessageMediaWebPage:
+		return ""
+	case *tg.MessageMediaPhoto:
+		if photo, ok := m.Photo.(*tg.Photo); ok {
+			return "photo:" + strconv.FormatInt(photo.ID, 10)
+		}
+	case *tg.MessageMediaDocument:
+		if doc, ok := m.Document.(*tg.Document); ok {
+			return "document:" + strconv.FormatInt(doc.ID, 10)
+		}
+	case *tg.MessageMediaGeo:
+		return "geo:" + geoRef(m.Geo)
+	case *tg.MessageMediaGeoLive:
+		return "geo:" + geoRef(m.Geo)
+	case *tg.MessageMediaVenue:
+		return "venue:" + geoRef(m.Geo) + ":" + m.Title + ":" + m.Address
+	case *tg.MessageMediaPoll:
+		return "poll:" + strconv.FormatInt(m.Poll.ID, 10)
+	case *tg.MessageMediaContact:
+		return "contact:" + m.PhoneNumber + ":" + strconv.FormatInt(m.UserID, 10)
+	}
+	return m.TypeName()
+}
+
+func geoRef(geo tg.GeoPointClass) string {
+	point, ok := geo.(*tg.GeoPoint)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatFloat(point.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(point.Long, 'f', -1, 64)
+}
@@ -0,0 +1,43 @@
+package event
+
+import "github.com/gotd/td/tg"
+
+// Invoice is a product offered for payment. TotalAmount is in the smallest
+// units of the ISO 4217 Currency, e.g. cents; ReceiptMessageID is set once
+// the invoice was paid.
+type Invoice struct {
+	Title            string `json:"title"`
+	Description      string `json:"description,omitempty"`
+	Currency         string `json:"currency"`
+	TotalAmount      int64  `json:"total_amount"`
+	PhotoURL         string `json:"photo_url,omitempty"`
+	StartParam       string `json:"start_param,omitempty"`
+	ReceiptMessageID int    `json:"receipt_message_id,omitempty"`
+	Test             bool   `json:"test,omitempty"`
+	ShippingAddress  bool   `json:"shipping_address_requested,omitempty"`
+}
+
+func invoiceOf(msg *tg.Message) *Invoice {
+	media, ok := msg.GetMedia()
+	if !ok {
+		return nil
+	}
+	m, ok := media.(*tg.MessageMediaInvoice)
+	if !ok {
+		return nil
+	}
+	inv := &Invoice{
+		Title:           m.Title,
+		Description:     m.Description,
+		Currency:        m.Currency,
+		TotalAmount:     m.TotalAmount,
+		StartParam:      m.StartParam,
+		Test:            m.Test,
+		ShippingAddress: m.ShippingAddressRequested,
+	}
+	inv.ReceiptMessageID, _ = m.GetReceiptMsgID()
+	if photo, ok := m.GetPhoto(); ok {
+		inv.PhotoURL = photo.GetURL()
+	}
+	return inv
+}
@@ -0,0 +1,58 @@
+package event
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// Link is a URL found in the message text. Title, Description, Image and
+// SiteName are filled when the page is unfurled.
+type Link struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+}
+
+// bareURL finds URLs with a scheme in texts, for the ones Telegram sent no
+// entity for.
+var bareURL = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+
+// linksOf returns the URLs of the url and textUrl entities followed by URLs
+// in the text without an entity, each once. URLs Telegram recognized without
+// a scheme get http:// as clients open them so.
+func linksOf(text string, entities []Entity) []Link {
+	var links []Link
+	seen := map[string]bool{}
+	add := func(u string) {
+		if u == "" || seen[u] {
+			return
+		}
+		seen[u] = true
+		links = append(links, Link{URL: u})
+	}
+
+	units := utf16.Encode([]rune(text))
+	for _, entity := range entities {
+		switch entity.Type {
+		case "url":
+			start, end := entity.Offset, entity.Offset+entity.Length
+			if start < 0 || end > len(units) || start >= end {
+				continue
+			}
+			u := string(utf16.Decode(units[start:end]))
+			if !strings.Contains(u, "://") {
+				u = "http://" + u
+			}
+			add(u)
+		case "textUrl":
+			add(entity.URL)
+		}
+	}
+	for _, u := range bareURL.FindAllString(text, -1) {
+		add(strings.TrimRight(u, ".,;:!?)]}'"))
+	}
+	return links
+}
@@ -0,0 +1,73 @@
+package event
+
+import "github.com/gotd/td/tg"
+
+// Location is the point of a geo, venue or live location message with its
+// accuracy in meters. Venues add Title, Address and the venue ID and type
+// of their Provider, live locations Live with the Period they are shared
+// for in seconds, the Heading in degrees and the ProximityRadius alert in
+// meters.
+type Location struct {
+	Latitude        float64 `json:"latitude"`
+	Longitude       float64 `json:"longitude"`
+	Accuracy        int     `json:"accuracy,omitempty"`
+	Title           string  `json:"title,omitempty"`
+	Address         string  `json:"address,omitempty"`
+	Provider        string  `json:"provider,omitempty"`
+	VenueID         string  `json:"venue_id,omitempty"`
+	VenueType       string  `json:"venue_type,omitempty"`
+	Live            bool    `json:"live,omitempty"`
+	Period          int     `json:"period,omitempty"`
+	Heading         int     `json:"heading,omitempty"`
+	ProximityRadius int     `json:"proximity_radius,omitempty"`
+}
+
+// IsLiveLocation reports whether msg shares a live location, its edits
+// carry the new positions.
+func IsLiveLocation(msg *tg.Message) bool {
+	media, ok := msg.GetMedia()
+	if !ok {
+		return false
+	}
+	_, ok = media.(*tg.MessageMediaGeoLive)
+	return ok
+}
+
+func locationOf(msg *tg.Message) *Location {
+	media, ok := msg.GetMedia()
+	if !ok {
+		return nil
+	}
+	switch m := media.(type) {
+	case *tg.MessageMediaGeo:
+		return pointOf(m.Geo)
+	case *tg.MessageMediaVenue:
+		l := pointOf(m.Geo)
+		if l == nil {
+			return nil
+		}
+		l.Title, l.Address, l.Provider, l.VenueID, l.VenueType = m.Title, m.Address, m.Provider, m.VenueID, m.VenueType
+		return l
+	case *tg.MessageMediaGeoLive:
+		l := pointOf(m.Geo)
+		if l == nil {
+			return nil
+		}
+		l.Live, l.Period = true, m.Period
+		l.Heading, _ = m.GetHeading()
+		l.ProximityRadius, _ = m.GetProximityNotificationRadius()
+		return l
+	default:
+		return nil
+	}
+}
+
+func pointOf(geo tg.GeoPointClass) *Location {
+	point, ok := geo.(*tg.GeoPoint)
+	if !ok {
+		return nil
+	}
+	l := &Location{Latitude: point.Lat, Longitude: point.Long}
+	l.Accuracy, _ = point.GetAccuracyRadius()
+	return l
+}
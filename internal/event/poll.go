@@ -0,0 +1,79 @@
+package event
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/gotd/td/tg"
+)
+
+// Poll is a poll attached to a message with its latest results. Results of
+// a poll the account hasn't voted in are hidden by Telegram, voters are 0 then.
+type Poll struct {
+	ID             int64        `json:"id"`
+	Question       string       `json:"question"`
+	Options        []PollOption `json:"options"`
+	TotalVoters    int          `json:"total_voters"`
+	Closed         bool         `json:"closed,omitempty"`
+	Quiz           bool         `json:"quiz,omitempty"`
+	MultipleChoice bool         `json:"multiple_choice,omitempty"`
+	PublicVoters   bool         `json:"public_voters,omitempty"`
+	CloseDate      int          `json:"close_date,omitempty"`
+	Solution       string       `json:"solution,omitempty"`
+}
+
+type PollOption struct {
+	Text    string `json:"text"`
+	Voters  int    `json:"voters"`
+	Correct bool   `json:"correct,omitempty"`
+}
+
+// PollOf combines a poll with its results.
+func PollOf(poll tg.Poll, results tg.PollResults) *Poll {
+	p := &Poll{
+		ID:             poll.ID,
+		Question:       poll.Question,
+		TotalVoters:    results.TotalVoters,
+		Closed:         poll.Closed,
+		Quiz:           poll.Quiz,
+		MultipleChoice: poll.MultipleChoice,
+		PublicVoters:   poll.PublicVoters,
+		CloseDate:      poll.CloseDate,
+		Solution:       results.Solution,
+	}
+	for _, answer := range poll.Answers {
+		option := PollOption{Text: answer.Text}
+		for _, r := range results.Results {
+			if bytes.Equal(r.Option, answer.Option) {
+				option.Voters, option.Correct = r.Voters, r.Correct
+			}
+		}
+		p.Options = append(p.Options, option)
+	}
+	return p
+}
+
+func pollOf(msg *tg.Message) *Poll {
+	media, ok := msg.GetMedia()
+	if !ok {
+		return nil
+	}
+	if m, ok := media.(*tg.MessageMediaPoll); ok {
+		return PollOf(m.Poll, m.Results)
+	}
+	return nil
+}
+
+// PollUpdated builds a pollUpdated event with new results of the poll in a message.
+func PollUpdated(chat Chat, messageID int, poll *Poll) *Event {
+	return &Event{
+		SchemaVersion:   SchemaVersion,
+		Text:            poll.Question,
+		Type:            "pollUpdated",
+		ExternalID:      strconv.Itoa(messageID),
+		ChatType:        chat.Type,
+		ChannelID:       chat.ID,
+		ChannelUsername: chat.Username,
+		Poll:            poll,
+	}
+}
@@ -0,0 +1,57 @@
+package event
+
+import (
+	"strconv"
+
+	"github.com/gotd/td/tg"
+)
+
+// Reaction is how often one reaction was put on a message. Emoji is the
+// emoticon, custom emojis are "custom:<document id>".
+type Reaction struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// ReactionEmoji names a reaction, empty for an unknown kind.
+func ReactionEmoji(r tg.ReactionClass) string {
+	switch v := r.(type) {
+	case *tg.ReactionEmoji:
+		return v.Emoticon
+	case *tg.ReactionCustomEmoji:
+		return "custom:" + strconv.FormatInt(v.DocumentID, 10)
+	default:
+		return ""
+	}
+}
+
+// ReactionCounts converts the reaction results of a message.
+func ReactionCounts(results []tg.ReactionCount) []Reaction {
+	counts := make([]Reaction, 0, len(results))
+	for _, r := range results {
+		if emoji := ReactionEmoji(r.Reaction); emoji != "" {
+			counts = append(counts, Reaction{Emoji: emoji, Count: r.Count})
+		}
+	}
+	return counts
+}
+
+// ReactionChanged builds a reactionAdded or reactionRemoved event for emoji
+// on a message. counts are all reactions of the message after the change,
+// actor is who reacted when Telegram tells it.
+func ReactionChanged(chat Chat, messageID int, eventType, emoji string, counts []Reaction, actor tg.PeerClass) *Event {
+	e := &Event{
+		SchemaVersion:   SchemaVersion,
+		Type:            eventType,
+		ExternalID:      strconv.Itoa(messageID),
+		ChatType:        chat.Type,
+		ChannelID:       chat.ID,
+		ChannelUsername: chat.Username,
+		Reaction:        emoji,
+		Reactions:       counts,
+	}
+	if actor != nil {
+		e.Author = peerOf(actor)
+	}
+	return e
+}
@@ -0,0 +1,37 @@
+package event
+
+// Redact replaces the texts of e by redact, which has to keep their length
+// in UTF-16 units so the entities stay valid.
+func (e *Event) Redact(redact func(string) string) {
+	e.Text = redact(e.Text)
+	if e.source != nil {
+		e.source = &source{text: redact(e.source.text), entities: e.source.entities}
+	}
+	e.TranslatedText = redact(e.TranslatedText)
+	e.Transcript = redact(e.Transcript)
+	if e.Edit != nil {
+		e.Edit = EditOf(redact(e.Edit.PreviousText), redact(e.Edit.Text))
+	}
+	if e.ReplyTo != nil {
+		reply := *e.ReplyTo
+		reply.Text = redact(reply.Text)
+		e.ReplyTo = &reply
+	}
+	if len(e.Links) > 0 {
+		links := make([]Link, len(e.Links))
+		for i, l := range e.Links {
+			l.URL, l.Title, l.Description = redact(l.URL), redact(l.Title), redact(l.Description)
+			links[i] = l
+		}
+		e.Links = links
+	}
+	if e.Digest != nil {
+		digest := *e.Digest
+		digest.Messages = make([]DigestMessage, len(e.Digest.Messages))
+		for i, m := range e.Digest.Messages {
+			m.Text = redact(m.Text)
+			digest.Messages[i] = m
+		}
+		e.Digest = &digest
+	}
+}
@@ -0,0 +1,206 @@
+package event
+
+import (
+	"encoding/json"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	TextPlain    = "plain"
+	TextMarkdown = "markdown"
+	TextHTML     = "html"
+)
+
+// source is the message text as received together with its entities,
+// kept so the text can be rendered with formatting later.
+type source struct {
+	text     string
+	entities []Entity
+}
+
+type marker struct {
+	pos   int
+	open  bool
+	start int // offset of the entity, orders nested markers
+	end   int
+	s     string
+	code  bool
+}
+
+// Render returns the text of the event formatted as markdown or html.
+// Plain text and events without a source are returned as they are.
+func (e *Event) Render(format string) string {
+	if e.source == nil || format == "" || format == TextPlain {
+		return e.Text
+	}
+	return render(format, e.source.text, e.source.entities)
+}
+
+// FullText returns the text as received, before normalization and
+// truncation.
+func (e *Event) FullText() string {
+	if e.source == nil {
+		return e.Text
+	}
+	return e.source.text
+}
+
+// Decode returns the event encoded in data, e.g. by an external processor.
+// The source of e is kept while the text is unchanged, so the event can
+// still be rendered with its formatting.
+func (e *Event) Decode(data []byte) (*Event, error) {
+	var next Event
+	if err := json.Unmarshal(data, &next); err != nil {
+		return nil, err
+	}
+	if next.Text == e.Text {
+		next.source = e.source
+	}
+	return &next, nil
+}
+
+// Formatted returns a copy of the event with the text rendered in format.
+// The rendered text is neither normalized nor truncated, cutting it could break the markup.
+func (e *Event) Formatted(format string) *Event {
+	if e.source == nil || format == "" || format == TextPlain {
+		return e
+	}
+	c := *e
+	c.Text = e.Render(format)
+	c.Truncated = false
+	return &c
+}
+
+func render(format, text string, entities []Entity) string {
+	units := utf16.Encode([]rune(text))
+
+	var markers []marker
+	for _, entity := range entities {
+		open, closing, code := entityTags(format, entity, units)
+		if open == "" && closing == "" {
+			continue
+		}
+		start, end := entity.Offset, entity.Offset+entity.Length
+		if start < 0 || end > len(units) || start >= end {
+			continue
+		}
+		markers = append(markers,
+			marker{pos: start, open: true, start: start, end: end, s: open, code: code},
+			marker{pos: end, open: false, start: start, end: end, s: closing, code: code},
+		)
+	}
+	// At one position closing markers go first, inner ones before outer ones,
+	// then opening markers, outer ones before inner ones.
+	sort.SliceStable(markers, func(i, j int) bool {
+		a, b := markers[i], markers[j]
+		if a.pos != b.pos {
+			return a.pos < b.pos
+		}
+		if a.open != b.open {
+			return !a.open
+		}
+		if a.open {
+			return a.end > b.end
+		}
+		return a.start > b.start
+	})
+
+	var (
+		b    strings.Builder
+		pos  int
+		code int
+	)
+	write := func(to int) {
+		if to <= pos {
+			return
+		}
+		b.WriteString(escape(format, string(utf16.Decode(units[pos:to])), code > 0))
+		pos = to
+	}
+	for _, m := range markers {
+		write(m.pos)
+		b.WriteString(m.s)
+		if m.code {
+			if m.open {
+				code++
+			} else {
+				code--
+			}
+		}
+	}
+	write(len(units))
+	return b.String()
+}
+
+// entityTags returns the opening and closing markup of an entity and whether
+// its content is code. Entities without markup in the format return empty tags.
+func entityTags(format string, e Entity, units []uint16) (open, closing string, code bool) {
+	if format == TextHTML {
+		switch e.Type {
+		case "bold":
+			return "<b>", "</b>", false
+		case "italic":
+			return "<i>", "</i>", false
+		case "underline":
+			return "<u>", "</u>", false
+		case "strike":
+			return "<s>", "</s>", false
+		case "spoiler":
+			return `<span class="tg-spoiler">`, "</span>", false
+		case "code":
+			return "<code>", "</code>", true
+		case "pre":
+			if e.Lang != "" {
+				return `<pre><code class="language-` + html.EscapeString(e.Lang) + `">`, "</code></pre>", true
+			}
+			return "<pre>", "</pre>", true
+		case "blockquote":
+			return "<blockquote>", "</blockquote>", false
+		case "textUrl":
+			return `<a href="` + html.EscapeString(e.URL) + `">`, "</a>", false
+		case "mentionName":
+			return `<a href="tg://user?id=` + strconv.FormatInt(e.UserID, 10) + `">`, "</a>", false
+		case "url":
+			url := string(utf16.Decode(units[e.Offset : e.Offset+e.Length]))
+			return `<a href="` + html.EscapeString(url) + `">`, "</a>", false
+		}
+		return "", "", false
+	}
+
+	switch e.Type {
+	case "bold":
+		return "**", "**", false
+	case "italic":
+		return "_", "_", false
+	case "strike":
+		return "~~", "~~", false
+	case "code":
+		return "`", "`", true
+	case "pre":
+		return "```" + e.Lang + "\n", "\n```", true
+	case "textUrl":
+		return "[", "](" + e.URL + ")", false
+	case "mentionName":
+		return "[", "](tg://user?id=" + strconv.FormatInt(e.UserID, 10) + ")", false
+	}
+	return "", "", false
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "*", `\*`, "_", `\_`, "~", `\~`, "`", "\\`",
+	"[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "#", `\#`, ">", `\>`,
+)
+
+func escape(format, s string, code bool) string {
+	if format == TextHTML {
+		return html.EscapeString(s)
+	}
+	if code {
+		return s
+	}
+	return markdownEscaper.Replace(s)
+}
@@ -0,0 +1,42 @@
+package event
+
+import "github.com/gotd/td/tg"
+
+// Reply is the message another message replies to.
+type Reply struct {
+	ID     int    `json:"id"`
+	Text   string `json:"text"`
+	Author *Peer  `json:"author,omitempty"`
+	Date   int    `json:"date,omitempty"`
+}
+
+// ReplyOf describes msg as the target of a reply. Channel posts without
+// a sender are attributed to the channel with the post signature.
+func ReplyOf(msg *tg.Message) *Reply {
+	r := &Reply{ID: msg.GetID(), Text: msg.GetMessage(), Date: msg.Date, Author: authorOf(msg)}
+	if msg.Post && (r.Author == nil || r.Author.ID == 0) {
+		if channel := peerOf(msg.GetPeerID()); channel != nil {
+			if r.Author != nil {
+				channel.Signature = r.Author.Signature
+			}
+			r.Author = channel
+		}
+	}
+	return r
+}
+
+// ReplyToID returns the ID of the message msg replies to in the same chat.
+func ReplyToID(msg *tg.Message) (int, bool) {
+	reply, ok := msg.GetReplyTo()
+	if !ok {
+		return 0, false
+	}
+	header, ok := reply.(*tg.MessageReplyHeader)
+	if !ok {
+		return 0, false
+	}
+	if _, ok := header.GetReplyToPeerID(); ok {
+		return 0, false
+	}
+	return replyToMsgID(header)
+}
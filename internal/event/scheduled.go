@@ -0,0 +1,60 @@
+package event
+
+import (
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/config"
+)
+
+// Statuses of scheduled posts.
+const (
+	ScheduledPending   = "scheduled"
+	ScheduledEdited    = "edited"
+	ScheduledPublished = "published"
+	ScheduledDeleted   = "deleted"
+)
+
+// Scheduled describes a post scheduled in a channel: its status, its ID
+// among the scheduled messages of the channel and when it is to be
+// published, with the previous date of rescheduled posts. The scheduled
+// IDs are not message IDs, a published post has its message ID as
+// external_id.
+type Scheduled struct {
+	Status       string `json:"status"`
+	ScheduledID  int    `json:"scheduled_id,omitempty"`
+	Date         int    `json:"date,omitempty"`
+	PreviousDate int    `json:"previous_date,omitempty"`
+}
+
+// FromScheduled builds a scheduledMessage event for a scheduled post, msg is
+// the scheduled message with the publication date as its date.
+func FromScheduled(cfg config.PayloadConfig, chat Chat, msg *tg.Message, status string) *Event {
+	e := FromMessage(cfg, chat, msg, "scheduledMessage")
+	// The scheduled ID has no post behind it yet, and scheduled messages
+	// are edited without an edit date: the key hashes the payload.
+	e.ExternalID, e.IdempotencyKey = "", ""
+	e.Scheduled = &Scheduled{Status: status, ScheduledID: msg.GetID(), Date: msg.Date}
+	return e
+}
+
+// ScheduledPost builds a scheduledMessage event for msg, a scheduled post
+// that was just published. scheduledID is 0 when it was scheduled before
+// the watcher saw it.
+func ScheduledPost(cfg config.PayloadConfig, chat Chat, msg *tg.Message, scheduledID int) *Event {
+	e := FromMessage(cfg, chat, msg, "scheduledMessage")
+	e.Scheduled = &Scheduled{Status: ScheduledPublished, ScheduledID: scheduledID, Date: msg.Date}
+	return e
+}
+
+// ScheduledDeletion builds a scheduledMessage event for a scheduled post
+// deleted before its publication.
+func ScheduledDeletion(chat Chat, scheduledID int, text string, date int) *Event {
+	return &Event{
+		SchemaVersion:   SchemaVersion,
+		Type:            "scheduledMessage",
+		Text:            text,
+		ChatType:        chat.Type,
+		ChannelID:       chat.ID,
+		ChannelUsername: chat.Username,
+		Scheduled:       &Scheduled{Status: ScheduledDeleted, ScheduledID: scheduledID, Date: date},
+	}
+}
@@ -0,0 +1,111 @@
+package event
+
+import (
+	"strconv"
+
+	"github.com/gotd/td/tg"
+)
+
+// Service describes what a service message did in a chat. Only the fields
+// of the event type are set.
+type Service struct {
+	PinnedMessageID int     `json:"pinned_message_id,omitempty"`
+	Title           string  `json:"title,omitempty"`
+	PhotoRemoved    bool    `json:"photo_removed,omitempty"`
+	UserIDs         []int64 `json:"user_ids,omitempty"`
+	InviterID       int64   `json:"inviter_id,omitempty"`
+}
+
+// FromService builds an event for a service message: messagePinned,
+// titleChanged, photoChanged, memberJoined or memberLeft. Other actions are
+// not supported and return false. Author is who performed the action.
+func FromService(chat Chat, msg *tg.MessageService) (*Event, bool) {
+	var (
+		eventType string
+		s         Service
+	)
+	switch a := msg.Action.(type) {
+	case *tg.MessageActionPinMessage:
+		eventType = "messagePinned"
+		if reply, ok := msg.GetReplyTo(); ok {
+			if header, ok := reply.(*tg.MessageReplyHeader); ok {
+				s.PinnedMessageID, _ = header.GetReplyToMsgID()
+			}
+		}
+	case *tg.MessageActionChatEditTitle:
+		eventType = "titleChanged"
+		s.Title = a.Title
+	case *tg.MessageActionChatEditPhoto:
+		eventType = "photoChanged"
+	case *tg.MessageActionChatDeletePhoto:
+		eventType = "photoChanged"
+		s.PhotoRemoved = true
+	case *tg.MessageActionChatAddUser:
+		eventType = "memberJoined"
+		s.UserIDs = a.Users
+	case *tg.MessageActionChatJoinedByLink:
+		eventType = "memberJoined"
+		s.UserIDs = senderID(msg)
+		s.InviterID = a.InviterID
+	case *tg.MessageActionChatJoinedByRequest:
+		eventType = "memberJoined"
+		s.UserIDs = senderID(msg)
+	case *tg.MessageActionChatDeleteUser:
+		eventType = "memberLeft"
+		s.UserIDs = []int64{a.UserID}
+	default:
+		return nil, false
+	}
+
+	e := &Event{
+		SchemaVersion:   SchemaVersion,
+		Text:            s.Title,
+		Type:            eventType,
+		ExternalID:      strconv.Itoa(msg.GetID()),
+		ChatType:        chat.Type,
+		ChannelID:       chat.ID,
+		ChannelUsername: chat.Username,
+		Date:            msg.Date,
+		Service:         &s,
+	}
+	if from, ok := msg.GetFromID(); ok {
+		e.Author = peerOf(from)
+	}
+	return e, true
+}
+
+// Unpinned builds a messageUnpinned event for messages unpinned in a chat.
+// Unpinning leaves no service message, so there is no author or date.
+func Unpinned(chat Chat, messageIDs []int) *Event {
+	return &Event{
+		SchemaVersion:   SchemaVersion,
+		Type:            "messageUnpinned",
+		ChatType:        chat.Type,
+		ChannelID:       chat.ID,
+		ChannelUsername: chat.Username,
+		MessageIDs:      messageIDs,
+	}
+}
+
+// ChannelDiscovered builds a channelDiscovered event for a chat found by
+// discovery, its title is the text.
+func ChannelDiscovered(chat Chat) *Event {
+	return &Event{
+		SchemaVersion:   SchemaVersion,
+		Type:            "channelDiscovered",
+		Text:            chat.Title,
+		ChatType:        chat.Type,
+		ChannelID:       chat.ID,
+		ChannelUsername: chat.Username,
+		ChannelTitle:    chat.Title,
+	}
+}
+
+func senderID(msg *tg.MessageService) []int64 {
+	if from, ok := msg.GetFromID(); ok {
+		if user, ok := from.(*tg.PeerUser); ok {
+			return []int64{user.UserID}
+		}
+	}
+	return nil
+}
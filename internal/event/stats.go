@@ -0,0 +1,48 @@
+package event
+
+import (
+	"strconv"
+
+	"github.com/gotd/td/tg"
+)
+
+// Stats are the reach counters of a channel post: views, forwards to other
+// chats and replies in the discussion group.
+type Stats struct {
+	Views    int `json:"views"`
+	Forwards int `json:"forwards"`
+	Replies  int `json:"replies"`
+}
+
+// StatsOf returns the counters of a message.
+func StatsOf(msg *tg.Message) Stats {
+	s := Stats{Views: msg.Views, Forwards: msg.Forwards}
+	if replies, ok := msg.GetReplies(); ok {
+		s.Replies = replies.Replies
+	}
+	return s
+}
+
+// MessageViewsStats returns the counters of an entry of messages.getMessagesViews.
+func MessageViewsStats(v tg.MessageViews) Stats {
+	s := Stats{Views: v.Views, Forwards: v.Forwards}
+	if replies, ok := v.GetReplies(); ok {
+		s.Replies = replies.Replies
+	}
+	return s
+}
+
+// StatsUpdated builds a statsUpdated event with new counters of a post, text
+// is the text of the post.
+func StatsUpdated(chat Chat, messageID int, text string, stats *Stats) *Event {
+	return &Event{
+		SchemaVersion:   SchemaVersion,
+		Text:            text,
+		Type:            "statsUpdated",
+		ExternalID:      strconv.Itoa(messageID),
+		ChatType:        chat.Type,
+		ChannelID:       chat.ID,
+		ChannelUsername: chat.Username,
+		Stats:           stats,
+	}
+}
@@ -0,0 +1,27 @@
+package event
+
+import "slices"
+
+// Finding is a reason a message looks like a scam: lookalike_domain,
+// seed_phrase or wallet_address, with the part of the message that matched.
+// Resembles is the domain a lookalike domain imitates.
+type Finding struct {
+	Reason    string `json:"reason"`
+	Match     string `json:"match"`
+	Resembles string `json:"resembles,omitempty"`
+}
+
+// Suspicious builds a suspiciousMessage event for the message of e, a copy
+// of it with the findings, severity and the suspicious tag.
+func Suspicious(e *Event, findings []Finding, severity string) *Event {
+	s := *e
+	s.Type = "suspiciousMessage"
+	s.IdempotencyKey = ""
+	s.Findings = findings
+	s.Severity = severity
+	s.Tags = slices.Clone(e.Tags)
+	if !slices.Contains(s.Tags, "suspicious") {
+		s.Tags = append(s.Tags, "suspicious")
+	}
+	return &s
+}
@@ -0,0 +1,64 @@
+package event
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+// Template renders events into custom sink bodies with text/template. The
+// event is the data, its fields are used by their Go names: {{.Text}},
+// {{.ChannelID}}, {{.Media.URL}}.
+type Template struct {
+	t *template.Template
+}
+
+// templateFuncs help to build JSON bodies: json encodes any value, e.g. a
+// text as a quoted and escaped string, date formats unix seconds as RFC 3339.
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"date": func(unix int) string {
+		if unix == 0 {
+			return ""
+		}
+		return time.Unix(int64(unix), 0).UTC().Format(time.RFC3339)
+	},
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"trim":    strings.TrimSpace,
+	"replace": strings.ReplaceAll,
+}
+
+// ParseTemplate compiles a body template, name is used in error messages.
+func ParseTemplate(name, text string) (*Template, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse template")
+	}
+	return &Template{t: t}, nil
+}
+
+// Render executes the template for e, leading and trailing whitespace of
+// the output is dropped.
+func (t *Template) Render(e *Event) ([]byte, error) {
+	out, err := t.Execute(e)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimSpace(out)), nil
+}
+
+// Execute executes the template for e and returns the output as it is.
+func (t *Template) Execute(e *Event) (string, error) {
+	var buf strings.Builder
+	if err := t.t.Execute(&buf, e); err != nil {
+		return "", errors.Wrap(err, "render template")
+	}
+	return buf.String(), nil
+}
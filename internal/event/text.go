@@ -0,0 +1,148 @@
+package event
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const truncationMarker = "…"
+
+// normalizeWhitespace drops control characters, collapses runs of spaces
+// and trims trailing whitespace on every line. Line breaks are kept.
+func normalizeWhitespace(text string) string {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		var b strings.Builder
+		space := false
+		for _, r := range line {
+			switch {
+			case unicode.IsSpace(r):
+				space = true
+			case unicode.IsControl(r) || r == utf8.RuneError:
+				continue
+			default:
+				if space && b.Len() > 0 {
+					b.WriteByte(' ')
+				}
+				space = false
+				b.WriteRune(r)
+			}
+		}
+		lines[i] = b.String()
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// truncateText cuts text to at most maxLength runes including the marker,
+// never splitting a multibyte rune. maxLength <= 0 disables truncation.
+func truncateText(text string, maxLength int) (string, bool) {
+	if maxLength <= 0 || utf8.RuneCountInString(text) <= maxLength {
+		return text, false
+	}
+
+	keep := maxLength - utf8.RuneCountInString(truncationMarker)
+	if keep <= 0 {
+		return truncationMarker, true
+	}
+
+	n := 0
+	for i := range text {
+		if n == keep {
+			return text[:i] + truncationMarker, true
+		}
+		n++
+	}
+	return text, false
+}
+
+// Shortened returns a copy of the event with at least n bytes less text,
+// ending with the truncation marker, to fit a payload size limit. The copy
+// renders as the cut text.
+func (e *Event) Shortened(n int) *Event {
+	c := *e
+	c.source = nil
+	c.Truncated = true
+	keep := len(c.Text) - n - len(truncationMarker)
+	if keep <= 0 {
+		c.Text = ""
+		return &c
+	}
+	for keep > 0 && !utf8.RuneStart(c.Text[keep]) {
+		keep--
+	}
+	c.Text = c.Text[:keep] + truncationMarker
+	return &c
+}
+
+// Limited fits the text into maxLength runes: cut with the truncation
+// marker, or with split, into the parts of sequential events. Only the first
+// part has the media and no part has the entities, their idempotency keys
+// end with the part index. maxLength <= 0 returns the event as it is.
+func (e *Event) Limited(maxLength int, split bool) []*Event {
+	if maxLength <= 0 || utf8.RuneCountInString(e.Text) <= maxLength {
+		return []*Event{e}
+	}
+	if !split {
+		c := *e
+		c.source = nil
+		c.Text, c.Truncated = truncateText(e.Text, maxLength)
+		return []*Event{&c}
+	}
+
+	chunks := splitText(e.Text, maxLength)
+	parts := make([]*Event, len(chunks))
+	for i, chunk := range chunks {
+		c := *e
+		c.source = nil
+		c.Text = chunk
+		c.Entities = nil
+		c.Part = &Part{Index: i + 1, Count: len(chunks)}
+		if e.IdempotencyKey != "" {
+			c.IdempotencyKey = e.IdempotencyKey + "#" + strconv.Itoa(i+1)
+		}
+		if i > 0 {
+			c.Media, c.Album, c.MediaLink = nil, nil, ""
+		}
+		parts[i] = &c
+	}
+	return parts
+}
+
+// splitText cuts text into chunks of at most maxLength runes, after the
+// last line break or else space of a chunk when it is in its second half.
+func splitText(text string, maxLength int) []string {
+	runes := []rune(text)
+	var chunks []string
+	for len(runes) > maxLength {
+		cut := lastBreak(runes[:maxLength+1], maxLength/2)
+		if cut < 0 {
+			cut = maxLength
+		}
+		chunks = append(chunks, strings.TrimRightFunc(string(runes[:cut]), unicode.IsSpace))
+		runes = runes[cut:]
+		for len(runes) > 0 && unicode.IsSpace(runes[0]) {
+			runes = runes[1:]
+		}
+	}
+	if len(runes) > 0 {
+		chunks = append(chunks, string(runes))
+	}
+	return chunks
+}
+
+// lastBreak returns the index of the last line break in runes after from,
+// or else of the last space, -1 without either.
+func lastBreak(runes []rune, from int) int {
+	space := -1
+	for i := len(runes) - 1; i > from; i-- {
+		if runes[i] == '\n' {
+			return i
+		}
+		if space < 0 && unicode.IsSpace(runes[i]) {
+			space = i
+		}
+	}
+	return space
+}
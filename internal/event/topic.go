@@ -0,0 +1,39 @@
+package event
+
+import "github.com/gotd/td/tg"
+
+// GeneralTopic is the ID of the General topic of a forum, its messages
+// carry no topic in their reply header.
+const GeneralTopic = 1
+
+// TopicOf returns the forum topic msg was posted to, 0 outside of forums.
+func TopicOf(chat Chat, msg *tg.Message) int {
+	if !chat.Forum {
+		return 0
+	}
+	reply, ok := msg.GetReplyTo()
+	if !ok {
+		return GeneralTopic
+	}
+	header, ok := reply.(*tg.MessageReplyHeader)
+	if !ok || !header.ForumTopic {
+		return GeneralTopic
+	}
+	if top, ok := header.GetReplyToTopID(); ok {
+		return top
+	}
+	if id, ok := header.GetReplyToMsgID(); ok {
+		return id
+	}
+	return GeneralTopic
+}
+
+// replyToMsgID returns the message header replies to. In forum topics
+// without a top message the header points at the start of the topic, the
+// message is no reply then.
+func replyToMsgID(header *tg.MessageReplyHeader) (int, bool) {
+	if _, ok := header.GetReplyToTopID(); header.ForumTopic && !ok {
+		return 0, false
+	}
+	return header.GetReplyToMsgID()
+}
@@ -0,0 +1,160 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go-tg.com/internal/config"
+)
+
+// Filter decides whether a message text should be forwarded.
+type Filter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func New(cfg config.FilterConfig) (*Filter, error) {
+	include, err := compile(cfg.IncludePatterns, cfg.CaseSensitive)
+	if err != nil {
+		return nil, fmt.Errorf("include_patterns: %w", err)
+	}
+	exclude, err := compile(cfg.ExcludePatterns, cfg.CaseSensitive)
+	if err != nil {
+		return nil, fmt.Errorf("exclude_patterns: %w", err)
+	}
+	return &Filter{include: include, exclude: exclude}, nil
+}
+
+func compile(patterns []string, caseSensitive bool) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		if !caseSensitive {
+			p = "(?i)" + p
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// Match reports whether text matches at least one include pattern (if any are set)
+// and none of the exclude patterns.
+func (f *Filter) Match(text string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(text) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cache keeps compiled filters, taggers, spam and scam filters and
+// redactors so patterns are compiled once per distinct config, also after a
+// config reload.
+type Cache struct {
+	mux       sync.Mutex
+	filters   map[string]*Filter
+	taggers   map[string]*Tagger
+	spam      map[string]*Spam
+	scam      map[string]*Scam
+	redactors map[string]*Redactor
+}
+
+func NewCache() *Cache {
+	return &Cache{filters: map[string]*Filter{}, taggers: map[string]*Tagger{}, spam: map[string]*Spam{}, scam: map[string]*Scam{}, redactors: map[string]*Redactor{}}
+}
+
+func (c *Cache) Get(cfg config.FilterConfig) (*Filter, error) {
+	key := fmt.Sprintf("%t\x00%s\x00%s", cfg.CaseSensitive, strings.Join(cfg.IncludePatterns, "\x00"), strings.Join(cfg.ExcludePatterns, "\x00"))
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if f, ok := c.filters[key]; ok {
+		return f, nil
+	}
+	f, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.filters[key] = f
+	return f, nil
+}
+
+func (c *Cache) Tagger(rules []config.TagRule) (*Tagger, error) {
+	key := fmt.Sprintf("%#v", rules)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if t, ok := c.taggers[key]; ok {
+		return t, nil
+	}
+	t, err := NewTagger(rules)
+	if err != nil {
+		return nil, err
+	}
+	c.taggers[key] = t
+	return t, nil
+}
+
+func (c *Cache) Spam(cfg config.SpamConfig) *Spam {
+	key := fmt.Sprintf("%#v", cfg)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if s, ok := c.spam[key]; ok {
+		return s
+	}
+	s := NewSpam(cfg)
+	c.spam[key] = s
+	return s
+}
+
+func (c *Cache) Scam(cfg config.ScamConfig) (*Scam, error) {
+	key := fmt.Sprintf("%#v", cfg)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if s, ok := c.scam[key]; ok {
+		return s, nil
+	}
+	s, err := NewScam(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.scam[key] = s
+	return s, nil
+}
+
+func (c *Cache) Redactor(cfg config.RedactConfig) (*Redactor, error) {
+	key := fmt.Sprintf("%#v", cfg)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if r, ok := c.redactors[key]; ok {
+		return r, nil
+	}
+	r, err := NewRedactor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.redactors[key] = r
+	return r, nil
+}
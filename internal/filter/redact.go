@@ -0,0 +1,69 @@
+package filter
+
+import (
+	"regexp"
+	"strings"
+
+	"go-tg.com/internal/config"
+)
+
+// BuiltinRedactions are the patterns redact.builtin names.
+var BuiltinRedactions = map[string][]string{
+	config.RedactPhones: {
+		`\+\d[\d\s().-]{7,16}\d`,
+		`\(?\b\d{3}\)?[\s.-]?\d{3}[\s.-]?\d{2}[\s.-]?\d{2}\b`,
+	},
+	config.RedactEmails: {
+		`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	},
+	config.RedactAPIKeys: {
+		`\b[spr]k_(?:live|test)_[A-Za-z0-9]{16,}\b`, // Stripe
+		`\bAKIA[0-9A-Z]{16}\b`,                      // AWS access key IDs
+		`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,            // GitHub
+		`\bxox[abprs]-[A-Za-z0-9-]{10,}\b`,          // Slack
+		`\bAIza[0-9A-Za-z_-]{35}\b`,                 // Google
+		`\bsk-[A-Za-z0-9_-]{20,}\b`,                 // OpenAI and alike
+		`\b\d{8,10}:[A-Za-z0-9_-]{35}\b`,            // Telegram bot tokens
+	},
+}
+
+// Redactor masks sensitive data in texts.
+type Redactor struct {
+	patterns []*regexp.Regexp
+	mask     string
+}
+
+func NewRedactor(cfg config.RedactConfig) (*Redactor, error) {
+	var patterns []string
+	for _, name := range cfg.Builtin {
+		patterns = append(patterns, BuiltinRedactions[name]...)
+	}
+	patterns = append(patterns, cfg.Patterns...)
+	compiled, err := compile(patterns, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Redactor{patterns: compiled, mask: cfg.Mask}, nil
+}
+
+// Redact replaces every character of the matches in text by the mask. The
+// result has the length of text in UTF-16 units, so message entities stay
+// valid.
+func (r *Redactor) Redact(text string) string {
+	for _, re := range r.patterns {
+		text = re.ReplaceAllStringFunc(text, r.masked)
+	}
+	return text
+}
+
+func (r *Redactor) masked(match string) string {
+	var b strings.Builder
+	for _, c := range match {
+		b.WriteString(r.mask)
+		if c > 0xFFFF {
+			// Takes two UTF-16 units.
+			b.WriteString(r.mask)
+		}
+	}
+	return b.String()
+}
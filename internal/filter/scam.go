@@ -0,0 +1,194 @@
+package filter
+
+import (
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go-tg.com/internal/config"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Reasons a message looks like a scam.
+const (
+	ScamLookalikeDomain = "lookalike_domain"
+	ScamSeedPhrase      = "seed_phrase"
+	ScamWallet          = "wallet_address"
+)
+
+// DefaultSeedPhrasePatterns find requests for the secrets of wallets.
+var DefaultSeedPhrasePatterns = []string{
+	`(?i)\b(seed|recovery|secret|mnemonic|backup)[ -]?(phrase|words?)\b`,
+	`(?i)\b(12|24)[ -]words?\b`,
+	`(?i)\bprivate[ -]keys?\b`,
+}
+
+// DefaultWalletPatterns find EVM, Bitcoin and TRON addresses.
+var DefaultWalletPatterns = []string{
+	`\b0x[0-9a-fA-F]{40}\b`,
+	`\b(bc1[02-9ac-hj-np-z]{25,59}|[13][1-9A-HJ-NP-Za-km-z]{25,34})\b`,
+	`\bT[1-9A-HJ-NP-Za-km-z]{33}\b`,
+}
+
+// minLookalikeName is the shortest domain name compared by edits or looked
+// for in other domains, shorter ones resemble too many.
+const minLookalikeName = 4
+
+// homoglyphs maps characters that look like latin letters to them.
+var homoglyphs = strings.NewReplacer(
+	"а", "a", "е", "e", "о", "o", "р", "p", "с", "c", "х", "x", "у", "y", "к", "k", "м", "m", "т", "t", "н", "h",
+	"і", "i", "ј", "j", "ѕ", "s", "ԁ", "d", "ӏ", "l", "ɡ", "g", "ο", "o", "α", "a", "ν", "v", "ι", "i",
+	"0", "o", "1", "l", "rn", "m", "vv", "w",
+)
+
+// ScamFinding is a reason a message looks like a scam with the part of it
+// that matched. Resembles is the domain a lookalike domain imitates.
+type ScamFinding struct {
+	Reason    string
+	Match     string
+	Resembles string
+}
+
+// Scam finds lookalike domains, seed phrase requests and wallet addresses.
+type Scam struct {
+	domains        []protectedDomain
+	maxDistance    int
+	seedPhrase     []*regexp.Regexp
+	wallets        []*regexp.Regexp
+	allowedWallets []string
+}
+
+type protectedDomain struct {
+	domain   string
+	name     string // the domain without its public suffix
+	skeleton string
+}
+
+func NewScam(cfg config.ScamConfig) (*Scam, error) {
+	seedPhrase := cfg.SeedPhrasePatterns
+	if len(seedPhrase) == 0 {
+		seedPhrase = DefaultSeedPhrasePatterns
+	}
+	wallets := cfg.WalletPatterns
+	if len(wallets) == 0 {
+		wallets = DefaultWalletPatterns
+	}
+	s := &Scam{maxDistance: cfg.MaxDistance, allowedWallets: lower(cfg.AllowedWallets)}
+	var err error
+	if s.seedPhrase, err = compile(seedPhrase, true); err != nil {
+		return nil, err
+	}
+	if s.wallets, err = compile(wallets, true); err != nil {
+		return nil, err
+	}
+	for _, d := range cfg.Domains {
+		domain := normalizeHost(d)
+		name := domainName(domain)
+		s.domains = append(s.domains, protectedDomain{domain: domain, name: name, skeleton: homoglyphs.Replace(name)})
+	}
+	return s, nil
+}
+
+// Check returns the findings in text and the URLs of its links, nil for a
+// message that looks fine.
+func (s *Scam) Check(text string, urls []string) []ScamFinding {
+	var findings []ScamFinding
+	seen := map[string]bool{}
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		host := normalizeHost(u.Hostname())
+		if seen[host] {
+			continue
+		}
+		seen[host] = true
+		if resembles, ok := s.lookalike(host); ok {
+			findings = append(findings, ScamFinding{Reason: ScamLookalikeDomain, Match: host, Resembles: resembles})
+		}
+	}
+	for _, re := range s.seedPhrase {
+		if m := re.FindString(text); m != "" {
+			findings = append(findings, ScamFinding{Reason: ScamSeedPhrase, Match: m})
+			break
+		}
+	}
+	for _, re := range s.wallets {
+		for _, m := range re.FindAllString(text, -1) {
+			if !slices.Contains(s.allowedWallets, strings.ToLower(m)) {
+				findings = append(findings, ScamFinding{Reason: ScamWallet, Match: m})
+			}
+		}
+	}
+	return findings
+}
+
+// lookalike returns the protected domain host imitates, hosts of the
+// protected domains and their subdomains are fine.
+func (s *Scam) lookalike(host string) (string, bool) {
+	for _, d := range s.domains {
+		if host == d.domain || strings.HasSuffix(host, "."+d.domain) {
+			return "", false
+		}
+	}
+	name := domainName(host)
+	skeleton := homoglyphs.Replace(name)
+	for _, d := range s.domains {
+		switch {
+		case skeleton == d.skeleton:
+			// Another suffix or lookalike characters, e.g. binance.org or bіnance.com.
+		case len(d.name) >= minLookalikeName && strings.Contains(homoglyphs.Replace(host), d.skeleton):
+			// The name in another domain, e.g. binance-support.com or binance.com.example.io.
+		case len(d.name) >= minLookalikeName && distance(skeleton, d.skeleton) <= s.maxDistance:
+		default:
+			continue
+		}
+		return d.domain, true
+	}
+	return "", false
+}
+
+// normalizeHost lowercases host, decodes punycode and drops a www. prefix.
+func normalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if decoded, err := idna.ToUnicode(host); err == nil {
+		host = decoded
+	}
+	return strings.TrimPrefix(host, "www.")
+}
+
+// domainName returns the label of host right before its public suffix, e.g.
+// binance of api.binance.com.
+func domainName(host string) string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(host)
+	if err != nil {
+		domain = host
+	}
+	name, _, _ := strings.Cut(domain, ".")
+	return name
+}
+
+// distance is the Levenshtein distance of a and b in runes.
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
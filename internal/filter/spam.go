@@ -0,0 +1,89 @@
+package filter
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go-tg.com/internal/config"
+)
+
+// Reasons a message is suppressed as spam, the labels of the suppressed
+// messages metric.
+const (
+	SpamTooShort  = "too_short"
+	SpamEmoji     = "emoji"
+	SpamBlocked   = "blocked_phrase"
+	SpamSponsored = "sponsored"
+	SpamSticker   = "sticker"
+)
+
+// Spam recognizes spam and ads by simple heuristics.
+type Spam struct {
+	cfg       config.SpamConfig
+	blocked   []string
+	sponsored []string
+}
+
+func NewSpam(cfg config.SpamConfig) *Spam {
+	return &Spam{cfg: cfg, blocked: lower(cfg.BlockedPhrases), sponsored: lower(cfg.SponsoredMarkers)}
+}
+
+func lower(phrases []string) []string {
+	res := make([]string, 0, len(phrases))
+	for _, p := range phrases {
+		res = append(res, strings.ToLower(p))
+	}
+	return res
+}
+
+// Check returns why a message with text and media of mediaType, empty
+// without media, is spam, or an empty string when it is not. The minimal
+// length applies only to messages without media.
+func (s *Spam) Check(text, mediaType string) string {
+	if mediaType == "sticker" && s.cfg.DropStickers && strings.TrimSpace(text) == "" {
+		return SpamSticker
+	}
+	lowerText := strings.ToLower(text)
+	for _, p := range s.sponsored {
+		if strings.Contains(lowerText, p) {
+			return SpamSponsored
+		}
+	}
+	for _, p := range s.blocked {
+		if strings.Contains(lowerText, p) {
+			return SpamBlocked
+		}
+	}
+	if mediaType == "" && utf8.RuneCountInString(strings.TrimSpace(text)) < s.cfg.MinLength {
+		return SpamTooShort
+	}
+	if s.cfg.MaxEmojiRatio > 0 && emojiRatio(text) > s.cfg.MaxEmojiRatio {
+		return SpamEmoji
+	}
+	return ""
+}
+
+// emojiRatio is the share of emoji among the visible runes of text. Joiners,
+// variation selectors and skin tone modifiers are not counted.
+func emojiRatio(text string) float64 {
+	var emoji, total int
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r), unicode.Is(unicode.Mn, r), unicode.Is(unicode.Cf, r), unicode.Is(unicode.Sk, r):
+			continue
+		case isEmoji(r):
+			emoji++
+		}
+		total++
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(emoji) / float64(total)
+}
+
+func isEmoji(r rune) bool {
+	return r >= 0x1F000 && r <= 0x1FAFF || // pictographs, emoticons, transport, flags
+		r >= 0x2600 && r <= 0x27BF // miscellaneous symbols and dingbats
+}
@@ -0,0 +1,84 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go-tg.com/internal/config"
+)
+
+type tagRule struct {
+	category      string
+	severity      string
+	keywords      []string
+	caseSensitive bool
+	patterns      []*regexp.Regexp
+}
+
+// Tagger tags message texts with the category and severity of the tag
+// rules they match.
+type Tagger struct {
+	rules []tagRule
+}
+
+func NewTagger(rules []config.TagRule) (*Tagger, error) {
+	t := &Tagger{rules: make([]tagRule, 0, len(rules))}
+	for i, r := range rules {
+		patterns, err := compile(r.Patterns, r.CaseSensitive)
+		if err != nil {
+			return nil, fmt.Errorf("tags[%d]: %w", i, err)
+		}
+		keywords := r.Keywords
+		if !r.CaseSensitive {
+			keywords = make([]string, 0, len(r.Keywords))
+			for _, k := range r.Keywords {
+				keywords = append(keywords, strings.ToLower(k))
+			}
+		}
+		t.rules = append(t.rules, tagRule{
+			category:      r.Category,
+			severity:      r.Severity,
+			keywords:      keywords,
+			caseSensitive: r.CaseSensitive,
+			patterns:      patterns,
+		})
+	}
+	return t, nil
+}
+
+// Tag returns the categories of the matching rules in rule order, each
+// once, and the highest of their severities.
+func (t *Tagger) Tag(text string) (categories []string, severity string) {
+	lower := strings.ToLower(text)
+	for _, r := range t.rules {
+		if !r.match(text, lower) {
+			continue
+		}
+		if r.category != "" && !slices.Contains(categories, r.category) {
+			categories = append(categories, r.category)
+		}
+		if slices.Index(config.Severities, r.severity) > slices.Index(config.Severities, severity) {
+			severity = r.severity
+		}
+	}
+	return categories, severity
+}
+
+func (r tagRule) match(text, lower string) bool {
+	if !r.caseSensitive {
+		text = lower
+	}
+	for _, k := range r.keywords {
+		if strings.Contains(text, k) {
+			return true
+		}
+	}
+	for _, re := range r.patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"os"
+	"path"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Media is the metadata block attached to a payload. URL is set only
+// when the file was downloaded and stored.
+type Media struct {
+	Type     string `json:"type"`
+	FileName string `json:"file_name,omitempty"`
+	MimeType string `json:"mime_type,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	Width    int    `json:"width,omitempty"`
+	Height   int    `json:"height,omitempty"`
+	Duration int    `json:"duration,omitempty"`
+	URL      string `json:"url,omitempty"`
+
+	// MessageID is set for parts of an album.
+	MessageID int `json:"message_id,omitempty"`
+
+	// Sticker is set for stickers.
+	Sticker *Sticker `json:"sticker,omitempty"`
+
+	location tg.InputFileLocationClass
+}
+
+// Sticker describes a sticker: the emoji it stands for, its set and the
+// file format, webp, tgs (Lottie) or webm. FileID, AccessHash and
+// FileReference let clients with a session of their own fetch the file.
+// SetName is resolved by the watcher and left out when that fails.
+type Sticker struct {
+	Emoji         string `json:"emoji,omitempty"`
+	SetID         int64  `json:"set_id,omitempty"`
+	SetName       string `json:"set_name,omitempty"`
+	Format        string `json:"format"`
+	FileID        int64  `json:"file_id"`
+	AccessHash    int64  `json:"access_hash"`
+	FileReference []byte `json:"file_reference,omitempty"`
+
+	set tg.InputStickerSetClass
+}
+
+// Set returns the sticker set to resolve the name of, false when the name
+// is known already or the sticker has no set.
+func (s *Sticker) Set() (tg.InputStickerSetClass, bool) {
+	if s.SetName != "" || s.set == nil {
+		return nil, false
+	}
+	return s.set, true
+}
+
+// stickerFormat names the format of a sticker file by its MIME type.
+func stickerFormat(mimeType string) string {
+	switch mimeType {
+	case "application/x-tgsticker":
+		return "tgs"
+	case "video/webm":
+		return "webm"
+	default:
+		return "webp"
+	}
+}
+
+// Describe extracts metadata of a photo or document attached to msg.
+// It returns nil if the message has no downloadable media.
+func Describe(msg *tg.Message) *Media {
+	media, ok := msg.GetMedia()
+	if !ok {
+		return nil
+	}
+
+	switch m := media.(type) {
+	case *tg.MessageMediaPhoto:
+		photo, ok := m.Photo.(*tg.Photo)
+		if !ok {
+			return nil
+		}
+		return describePhoto(photo)
+	case *tg.MessageMediaDocument:
+		doc, ok := m.Document.(*tg.Document)
+		if !ok {
+			return nil
+		}
+		return describeDocument(doc)
+	default:
+		return nil
+	}
+}
+
+func describePhoto(photo *tg.Photo) *Media {
+	var (
+		best     string
+		bestSize int
+		w, h     int
+	)
+	for _, size := range photo.Sizes {
+		switch s := size.(type) {
+		case *tg.PhotoSize:
+			if s.Size > bestSize {
+				best, bestSize, w, h = s.Type, s.Size, s.W, s.H
+			}
+		case *tg.PhotoSizeProgressive:
+			if n := len(s.Sizes); n > 0 && s.Sizes[n-1] > bestSize {
+				best, bestSize, w, h = s.Type, s.Sizes[n-1], s.W, s.H
+			}
+		}
+	}
+	if best == "" {
+		return nil
+	}
+
+	return &Media{
+		Type:     "photo",
+		FileName: fmt.Sprintf("%d.jpg", photo.ID),
+		MimeType: "image/jpeg",
+		Size:     int64(bestSize),
+		Width:    w,
+		Height:   h,
+		location: &tg.InputPhotoFileLocation{
+			ID:            photo.ID,
+			AccessHash:    photo.AccessHash,
+			FileReference: photo.FileReference,
+			ThumbSize:     best,
+		},
+	}
+}
+
+func describeDocument(doc *tg.Document) *Media {
+	m := &Media{
+		Type:     "document",
+		MimeType: doc.MimeType,
+		Size:     doc.Size,
+		location: &tg.InputDocumentFileLocation{
+			ID:            doc.ID,
+			AccessHash:    doc.AccessHash,
+			FileReference: doc.FileReference,
+		},
+	}
+
+	for _, attr := range doc.Attributes {
+		switch a := attr.(type) {
+		case *tg.DocumentAttributeFilename:
+			m.FileName = a.FileName
+		case *tg.DocumentAttributeVideo:
+			m.Type = "video"
+			if a.RoundMessage {
+				m.Type = "videoNote"
+			}
+			m.Width, m.Height, m.Duration = a.W, a.H, int(a.Duration)
+		case *tg.DocumentAttributeAudio:
+			m.Type = "audio"
+			if a.Voice {
+				m.Type = "voice"
+			}
+			m.Duration = a.Duration
+		case *tg.DocumentAttributeAnimated:
+			m.Type = "animation"
+		case *tg.DocumentAttributeSticker:
+			m.Type = "sticker"
+			m.Sticker = &Sticker{
+				Emoji:         a.Alt,
+				Format:        stickerFormat(doc.MimeType),
+				FileID:        doc.ID,
+				AccessHash:    doc.AccessHash,
+				FileReference: doc.FileReference,
+			}
+			switch set := a.Stickerset.(type) {
+			case *tg.InputStickerSetID:
+				m.Sticker.SetID, m.Sticker.set = set.ID, set
+			case *tg.InputStickerSetShortName:
+				m.Sticker.SetName = set.ShortName
+			}
+		case *tg.DocumentAttributeImageSize:
+			m.Width, m.Height = a.W, a.H
+		}
+	}
+
+	if m.FileName == "" {
+		ext := ""
+		if exts, _ := mime.ExtensionsByType(doc.MimeType); len(exts) > 0 {
+			ext = exts[0]
+		}
+		m.FileName = fmt.Sprintf("%d%s", doc.ID, ext)
+	}
+	return m
+}
+
+// Downloader fetches media through the Telegram client and puts it into Storage.
+type Downloader struct {
+	api     *tg.Client
+	d       *downloader.Downloader
+	storage Storage
+	maxSize int64
+	tmpDir  string
+}
+
+// NewDownloader creates a Downloader. Files bigger than maxSize (if positive) are skipped.
+func NewDownloader(api *tg.Client, storage Storage, maxSize int64) *Downloader {
+	return &Downloader{
+		api:     api,
+		d:       downloader.NewDownloader(),
+		storage: storage,
+		maxSize: maxSize,
+		tmpDir:  os.TempDir(),
+	}
+}
+
+// Read returns the content of the media, for small files like voice notes.
+func (d *Downloader) Read(ctx context.Context, m *Media) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := d.d.Download(d.api, m.location).Stream(ctx, &buf); err != nil {
+		return nil, errors.Wrap(err, "download")
+	}
+	return buf.Bytes(), nil
+}
+
+// Download stores the media of a message under "<channelID>/<messageID>/<file name>"
+// and sets its URL. Media over the size limit is returned without URL.
+func (d *Downloader) Download(ctx context.Context, channelID int64, msgID int, m *Media) (err error) {
+	if d.maxSize > 0 && m.Size > d.maxSize {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "download media",
+		attribute.String("media.type", m.Type),
+		attribute.Int64("media.size", m.Size),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	tmp, err := os.CreateTemp(d.tmpDir, "tg-media-*")
+	if err != nil {
+		return errors.Wrap(err, "create temp file")
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if _, err := d.d.Download(d.api, m.location).Stream(ctx, tmp); err != nil {
+		return errors.Wrap(err, "download")
+	}
+	if _, err := tmp.Seek(0, 0); err != nil {
+		return err
+	}
+
+	key := path.Join(fmt.Sprint(channelID), fmt.Sprint(msgID), path.Base(m.FileName))
+	url, err := d.storage.Save(ctx, key, tmp, m.MimeType)
+	if err != nil {
+		return errors.Wrap(err, "save")
+	}
+	m.URL = url
+	return nil
+}
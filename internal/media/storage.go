@@ -0,0 +1,105 @@
+package media
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-faster/errors"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Storage keeps downloaded files and returns the URL they are reachable at.
+type Storage interface {
+	Save(ctx context.Context, key string, file *os.File, contentType string) (string, error)
+}
+
+// LocalStorage writes files to Dir. BaseURL is where Dir is served from,
+// e.g. by a reverse proxy; without it the absolute file path is returned.
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+func (s LocalStorage) Save(_ context.Context, key string, file *os.File, _ string) (string, error) {
+	dst := filepath.Join(s.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(out, file); err != nil {
+		_ = out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+
+	if s.BaseURL == "" {
+		return filepath.Abs(dst)
+	}
+	return url.JoinPath(s.BaseURL, key)
+}
+
+// S3Storage uploads files to an S3-compatible bucket.
+type S3Storage struct {
+	client  *minio.Client
+	bucket  string
+	baseURL string
+}
+
+type S3Options struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	// BaseURL is used to build public links, defaults to <endpoint>/<bucket>.
+	BaseURL string
+}
+
+func NewS3Storage(opts S3Options) (*S3Storage, error) {
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure: opts.UseSSL,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create s3 client")
+	}
+
+	baseURL := opts.BaseURL
+	if baseURL == "" {
+		scheme := "http"
+		if opts.UseSSL {
+			scheme = "https"
+		}
+		baseURL = scheme + "://" + strings.TrimSuffix(opts.Endpoint, "/") + "/" + opts.Bucket
+	}
+
+	return &S3Storage{client: client, bucket: opts.Bucket, baseURL: baseURL}, nil
+}
+
+func (s *S3Storage) Save(ctx context.Context, key string, file *os.File, contentType string) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	_, err = s.client.PutObject(ctx, s.bucket, key, file, info.Size(), minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return "", err
+	}
+	return url.JoinPath(s.baseURL, key)
+}
@@ -0,0 +1,240 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "tg_watcher"
+
+var (
+	MessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Messages received from watched channels by event type.",
+	}, []string{"type"})
+
+	UpdatesSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_skipped_total",
+		Help:      "Messages of updates skipped by reason: empty, service, peer or unknown.",
+	}, []string{"reason"})
+
+	MessagesForwarded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_forwarded_total",
+		Help:      "Messages delivered to the webhook on the first attempt by event type.",
+	}, []string{"type"})
+
+	MessagesSuppressed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_suppressed_total",
+		Help:      "Messages dropped by the spam filter, sampling, as outgoing or as unchanged edits by reason.",
+	}, []string{"reason"})
+
+	ScamFindings = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scam_findings_total",
+		Help:      "Messages sent as suspiciousMessage by reason: lookalike_domain, seed_phrase or wallet_address.",
+	}, []string{"reason"})
+
+	EventsRouted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_routed_total",
+		Help:      "Events sent to the sinks of a routing rule by rule name.",
+	}, []string{"rule"})
+
+	EventsThrottled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_throttled_total",
+		Help:      "Events over the rate limit by overflow policy.",
+	}, []string{"overflow"})
+
+	EventsHeld = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_held_total",
+		Help:      "Events held in the outbox until the schedule of their chat opens.",
+	})
+
+	EventsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_processed_total",
+		Help:      "Events passed to external processors by processor and result: passed, dropped or failed.",
+	}, []string{"processor", "result"})
+
+	WebhookActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_actions_total",
+		Help:      "Actions of webhook responses by action and result: done, denied or failed.",
+	}, []string{"action", "result"})
+
+	ChannelsDiscovered = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "channels_discovered_total",
+		Help:      "Joined channels and supergroups watched since their title matched a discovery pattern.",
+	})
+
+	WebhookFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_failures_total",
+		Help:      "Failed webhook delivery attempts.",
+	})
+
+	DeliveryRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "delivery_retries_total",
+		Help:      "Delivery attempts made by the outbox retry loop.",
+	})
+
+	DeliveryQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "delivery_queue_length",
+		Help:      "First delivery attempts waiting for a worker.",
+	})
+
+	DeliveryQueueDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "delivery_queue_dropped_total",
+		Help:      "First delivery attempts skipped on a full queue, the events are left to retries.",
+	})
+
+	EventsDeduplicated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_deduplicated_total",
+		Help:      "Events dropped as delivered before within the dedup window by event type.",
+	}, []string{"type"})
+
+	CallRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "call_retries_total",
+		Help:      "Telegram API calls made again after transient errors by method.",
+	}, []string{"method"})
+
+	FloodWaits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "flood_wait_total",
+		Help:      "FLOOD_WAIT errors returned by Telegram.",
+	})
+
+	ClientRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_restarts_total",
+		Help:      "Telegram clients restarted by the supervisor after they stopped, per account.",
+	}, []string{"account"})
+
+	SessionsInvalid = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sessions_invalid_total",
+		Help:      "Sessions found revoked or expired per account.",
+	}, []string{"account"})
+
+	StreamClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stream_clients",
+		Help:      "Clients connected to the event stream.",
+	})
+
+	StreamDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stream_dropped_total",
+		Help:      "Events not sent to stream clients that fell behind.",
+	})
+
+	StatusUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "status_updates_total",
+		Help:      "Delivery status updates for the status webhook by result: sent, failed or dropped.",
+	}, []string{"result"})
+
+	WebhookOversized = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_oversized_payloads_total",
+		Help:      "Webhook payloads over webhook.max_payload_size by the strategy that made them fit, or rejected.",
+	}, []string{"result"})
+
+	TenantDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tenant_deliveries_total",
+		Help:      "Delivery steps of the events of tenants by sink: queued, delivering, delivered, acknowledged or failed.",
+	}, []string{"tenant", "sink", "status"})
+
+	Acknowledgments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "acknowledgments_total",
+		Help:      "Delivered events acknowledged by consumers (acked) or sent again for want of it (expired).",
+	}, []string{"result"})
+
+	BotUpdates = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bot_updates_total",
+		Help:      "Updates received through the Bot API by result: handled or failed, and failed getUpdates calls as poll_failed.",
+	}, []string{"result"})
+
+	EventsExpired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_expired_total",
+		Help:      "Events not delivered within the max age of their sink, by what happened to them: dead_letter or drop.",
+	}, []string{"action"})
+
+	HandlerPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "handler_panics_total",
+		Help:      "Panics of update handlers recovered by update type.",
+	}, []string{"update"})
+
+	Alerts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "alerts_total",
+		Help:      "Ops alerts by type and whether they were sent or suppressed by the cooldown.",
+	}, []string{"type", "result"})
+
+	UpdatesReplayed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_replayed_total",
+		Help:      "Updates of the update log handled again after a restart.",
+	})
+
+	ChannelMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "channel_messages_total",
+		Help:      "Messages received per tenant and chat ID, new and edited ones.",
+	}, []string{"tenant", "channel"})
+
+	ChannelLastMessage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "channel_last_message_timestamp_seconds",
+		Help:      "When the latest message of a chat was received, per tenant and chat ID.",
+	}, []string{"tenant", "channel"})
+
+	DeliveryLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "delivery_latency_seconds",
+		Help:      "Time from the Telegram date of a message to its delivery to a sink, per tenant and chat ID.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600},
+	}, []string{"tenant", "channel"})
+
+	GapsState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "gaps_state",
+		Help:      "Last persisted updates state (pts, qts, seq, date) per account.",
+	}, []string{"account", "field"})
+)
+
+// RegisterTenantQueueDepth exposes the outbox depth of a tenant reported by depth.
+func RegisterTenantQueueDepth(tenant string, depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "tenant_queue_depth",
+		Help:        "Payloads of a tenant waiting in its outboxes.",
+		ConstLabels: prometheus.Labels{"tenant": tenant},
+	}, func() float64 { return float64(depth()) })
+}
+
+// RegisterQueueDepth exposes the outbox depth reported by depth.
+func RegisterQueueDepth(depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Payloads waiting in the outbox.",
+	}, func() float64 { return float64(depth()) })
+}
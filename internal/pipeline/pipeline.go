@@ -0,0 +1,126 @@
+// Package pipeline runs events through ordered stages on their way to the
+// sinks: filters first, then enrichment, transformation and delivery.
+package pipeline
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/event"
+)
+
+// Kind is the phase a stage runs in. Stages run phase by phase and, within
+// a phase, in the order they were registered.
+type Kind int
+
+const (
+	// Filter stages drop events, e.g. over the rate limit.
+	Filter Kind = iota
+	// Enrich stages add data to events, e.g. their language.
+	Enrich
+	// Transform stages change events, e.g. rewrite their text.
+	Transform
+	// Deliver stages hand events over, e.g. to the outbox.
+	Deliver
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Filter:
+		return "filter"
+	case Enrich:
+		return "enrich"
+	case Transform:
+		return "transform"
+	case Deliver:
+		return "deliver"
+	default:
+		return "unknown"
+	}
+}
+
+// Message is an event on its way to the sinks. Target is the optional
+// per-channel destination override, e.g. a webhook URL.
+type Message struct {
+	Target string
+	Event  *event.Event
+}
+
+// Stage is one step of the pipeline. Process returns false to stop the
+// message without an error, e.g. when it is filtered out.
+type Stage interface {
+	Name() string
+	Kind() Kind
+	Process(ctx context.Context, m *Message) (bool, error)
+}
+
+type funcStage struct {
+	kind Kind
+	name string
+	fn   func(ctx context.Context, m *Message) (bool, error)
+}
+
+func (s funcStage) Name() string { return s.name }
+func (s funcStage) Kind() Kind   { return s.kind }
+
+func (s funcStage) Process(ctx context.Context, m *Message) (bool, error) {
+	return s.fn(ctx, m)
+}
+
+// Func makes a stage of fn.
+func Func(kind Kind, name string, fn func(ctx context.Context, m *Message) (bool, error)) Stage {
+	return funcStage{kind: kind, name: name, fn: fn}
+}
+
+// Pipeline is an ordered list of stages. Stages are registered before the
+// first message is run, Run itself is safe for concurrent use.
+type Pipeline struct {
+	stages []Stage
+}
+
+func New(stages ...Stage) *Pipeline {
+	p := &Pipeline{}
+	p.Register(stages...)
+	return p
+}
+
+// Register adds stages after the registered ones of the same kind.
+func (p *Pipeline) Register(stages ...Stage) {
+	p.stages = append(p.stages, stages...)
+	sort.SliceStable(p.stages, func(i, j int) bool {
+		return p.stages[i].Kind() < p.stages[j].Kind()
+	})
+}
+
+// Stages returns the names of the stages in the order they run.
+func (p *Pipeline) Stages() []string {
+	names := make([]string, 0, len(p.stages))
+	for _, s := range p.stages {
+		names = append(names, s.Kind().String()+"/"+s.Name())
+	}
+	return names
+}
+
+// Run passes m through every stage until one stops it or fails.
+func (p *Pipeline) Run(ctx context.Context, m *Message) error {
+	return p.RunFrom(ctx, Filter, m)
+}
+
+// RunFrom passes m through the stages of kind from and the later ones,
+// e.g. for events that were filtered already.
+func (p *Pipeline) RunFrom(ctx context.Context, from Kind, m *Message) error {
+	for _, s := range p.stages {
+		if s.Kind() < from {
+			continue
+		}
+		next, err := s.Process(ctx, m)
+		if err != nil {
+			return errors.Wrapf(err, "%s stage %s", s.Kind(), s.Name())
+		}
+		if !next {
+			return nil
+		}
+	}
+	return nil
+}
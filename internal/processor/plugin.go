@@ -0,0 +1,48 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"plugin"
+
+	"github.com/go-faster/errors"
+)
+
+// PluginFunc is the Process function a Go plugin exports, either as a
+// function or as a variable of this type.
+type PluginFunc = func(in []byte) ([]byte, error)
+
+type goPlugin struct {
+	process PluginFunc
+}
+
+// OpenPlugin loads a Go plugin built with -buildmode=plugin against the same
+// Go version and dependencies as the watcher.
+func OpenPlugin(path string) (Processor, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "open plugin")
+	}
+	sym, err := p.Lookup("Process")
+	if err != nil {
+		return nil, errors.Wrap(err, "plugin")
+	}
+	switch fn := sym.(type) {
+	case PluginFunc:
+		return goPlugin{process: fn}, nil
+	case *PluginFunc:
+		return goPlugin{process: *fn}, nil
+	default:
+		return nil, fmt.Errorf("plugin: Process is %T, want func([]byte) ([]byte, error)", sym)
+	}
+}
+
+// Process calls the plugin, it runs to the end even when ctx is done.
+func (p goPlugin) Process(_ context.Context, in []byte) ([]byte, error) {
+	return p.process(in)
+}
+
+// Close does nothing, Go plugins can't be unloaded.
+func (goPlugin) Close() error {
+	return nil
+}
@@ -0,0 +1,30 @@
+// Package processor loads external event processors, Go plugins or
+// WebAssembly modules. A processor gets an event as JSON and returns it,
+// possibly modified, or nothing to drop it.
+package processor
+
+import (
+	"context"
+	"fmt"
+
+	"go-tg.com/internal/config"
+)
+
+// Processor is a loaded external processor.
+type Processor interface {
+	// Process returns the event in, possibly modified, or nil to drop it.
+	Process(ctx context.Context, in []byte) ([]byte, error)
+	Close() error
+}
+
+// Open loads the processor described by cfg.
+func Open(ctx context.Context, cfg config.ProcessorConfig) (Processor, error) {
+	switch cfg.Type {
+	case "plugin":
+		return OpenPlugin(cfg.Path)
+	case "wasm":
+		return OpenWASM(ctx, cfg.Path, cfg.Timeout)
+	default:
+		return nil, fmt.Errorf("unknown processor type %q", cfg.Type)
+	}
+}
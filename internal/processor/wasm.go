@@ -0,0 +1,122 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// wasmModule runs a WebAssembly module exporting its memory and
+//
+//	alloc(size i32) i32            memory for an input of size bytes
+//	process(ptr i32, size i32) i64 output as ptr<<32 | size, size 0 drops the event
+//
+// WASI is available, so modules built by TinyGo or Go with GOOS=wasip1 as
+// a reactor work. Calls are serialized, a module has a single memory.
+type wasmModule struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	timeout  time.Duration
+
+	mux    sync.Mutex
+	module api.Module
+}
+
+// OpenWASM compiles the module at path. A call running longer than a
+// positive timeout is aborted, the module is then instantiated again for
+// the next call.
+func OpenWASM(ctx context.Context, path string, timeout time.Duration) (Processor, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read wasm module")
+	}
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, errors.Wrap(err, "wasi")
+	}
+	compiled, err := runtime.CompileModule(ctx, code)
+	if err != nil {
+		_ = runtime.Close(ctx)
+		return nil, errors.Wrap(err, "compile wasm module")
+	}
+	m := &wasmModule{runtime: runtime, compiled: compiled, timeout: timeout}
+	if _, err := m.instance(ctx); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, err
+	}
+	return m, nil
+}
+
+// instance returns the module, instantiated again after it was closed by an
+// aborted call.
+func (m *wasmModule) instance(ctx context.Context) (api.Module, error) {
+	if m.module != nil && !m.module.IsClosed() {
+		return m.module, nil
+	}
+	module, err := m.runtime.InstantiateModule(ctx, m.compiled, wazero.NewModuleConfig().WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, errors.Wrap(err, "instantiate wasm module")
+	}
+	for _, name := range []string{"alloc", "process"} {
+		if module.ExportedFunction(name) == nil {
+			_ = module.Close(ctx)
+			return nil, fmt.Errorf("wasm module doesn't export %s", name)
+		}
+	}
+	if module.Memory() == nil {
+		_ = module.Close(ctx)
+		return nil, errors.New("wasm module doesn't export its memory")
+	}
+	m.module = module
+	return module, nil
+}
+
+func (m *wasmModule) Process(ctx context.Context, in []byte) ([]byte, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	module, err := m.instance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+
+	res, err := module.ExportedFunction("alloc").Call(ctx, uint64(len(in)))
+	if err != nil {
+		return nil, errors.Wrap(err, "alloc")
+	}
+	ptr := uint32(res[0])
+	if !module.Memory().Write(ptr, in) {
+		return nil, fmt.Errorf("input of %d bytes out of memory at %d", len(in), ptr)
+	}
+	res, err = module.ExportedFunction("process").Call(ctx, uint64(ptr), uint64(len(in)))
+	if err != nil {
+		return nil, errors.Wrap(err, "process")
+	}
+	outPtr, outSize := uint32(res[0]>>32), uint32(res[0])
+	if outSize == 0 {
+		return nil, nil
+	}
+	out, ok := module.Memory().Read(outPtr, outSize)
+	if !ok {
+		return nil, fmt.Errorf("output of %d bytes out of memory at %d", outSize, outPtr)
+	}
+	// The view is only valid until the next call into the module.
+	return append([]byte(nil), out...), nil
+}
+
+func (m *wasmModule) Close() error {
+	return m.runtime.Close(context.Background())
+}
@@ -0,0 +1,184 @@
+// Package rpc serves the event stream over gRPC, see api/watcher/v1.
+package rpc
+
+import (
+	"encoding/json"
+
+	watcherv1 "go-tg.com/api/watcher/v1"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/media"
+	"go-tg.com/internal/stream"
+)
+
+// Server implements watcherv1.EventsServer on top of the stream hub.
+type Server struct {
+	watcherv1.UnimplementedEventsServer
+	hub *stream.Hub
+}
+
+func NewServer(hub *stream.Hub) *Server {
+	return &Server{hub: hub}
+}
+
+func (s *Server) Subscribe(req *watcherv1.SubscribeRequest, srv watcherv1.Events_SubscribeServer) error {
+	events, cancel := s.hub.Subscribe(stream.Filter{Types: req.GetTypes(), Channels: req.GetChannelIds()})
+	defer cancel()
+
+	ctx := srv.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e := <-events:
+			msg, err := eventOf(e)
+			if err != nil {
+				return err
+			}
+			if err := srv.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func eventOf(e *event.Event) (*watcherv1.Event, error) {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	msg := &watcherv1.Event{
+		SchemaVersion:   int32(e.SchemaVersion),
+		Type:            e.Type,
+		Text:            e.Text,
+		ExternalId:      e.ExternalID,
+		ChatType:        e.ChatType,
+		ChannelId:       e.ChannelID,
+		ChannelUsername: e.ChannelUsername,
+		TopicId:         int32(e.TopicID),
+		Permalink:       e.Permalink,
+		ContentHash:     e.ContentHash,
+		Truncated:       e.Truncated,
+		Media:           mediaOf(e.Media),
+		GroupedId:       e.GroupedID,
+		Reaction:        e.Reaction,
+		ChannelTitle:    e.ChannelTitle,
+		Date:            int64(e.Date),
+		EditDate:        int64(e.EditDate),
+		Views:           int32(e.Views),
+		Forwards:        int32(e.Forwards),
+		Json:            string(raw),
+		Tags:            e.Tags,
+		Severity:        e.Severity,
+		Language:        e.Language,
+		TranslatedText:  e.TranslatedText,
+		Transcript:      e.Transcript,
+	}
+	for _, id := range e.MessageIDs {
+		msg.MessageIds = append(msg.MessageIds, int32(id))
+	}
+	for _, m := range e.Album {
+		msg.Album = append(msg.Album, mediaOf(m))
+	}
+	for _, r := range e.Reactions {
+		msg.Reactions = append(msg.Reactions, &watcherv1.Reaction{Emoji: r.Emoji, Count: int32(r.Count)})
+	}
+	for _, l := range e.Links {
+		msg.Links = append(msg.Links, &watcherv1.Link{Url: l.URL, Title: l.Title, Description: l.Description, Image: l.Image, SiteName: l.SiteName})
+	}
+	if e.Author != nil {
+		msg.Author = &watcherv1.Peer{Id: e.Author.ID, Type: e.Author.Type, Signature: e.Author.Signature}
+	}
+	if l := e.Location; l != nil {
+		msg.Location = &watcherv1.Location{
+			Latitude:        l.Latitude,
+			Longitude:       l.Longitude,
+			Accuracy:        int32(l.Accuracy),
+			Title:           l.Title,
+			Address:         l.Address,
+			Provider:        l.Provider,
+			VenueId:         l.VenueID,
+			VenueType:       l.VenueType,
+			Live:            l.Live,
+			Period:          int32(l.Period),
+			Heading:         int32(l.Heading),
+			ProximityRadius: int32(l.ProximityRadius),
+		}
+	}
+	if c := e.Contact; c != nil {
+		msg.Contact = &watcherv1.Contact{PhoneNumber: c.PhoneNumber, FirstName: c.FirstName, LastName: c.LastName, UserId: c.UserID, Vcard: c.Vcard}
+	}
+	if inv := e.Invoice; inv != nil {
+		msg.Invoice = &watcherv1.Invoice{
+			Title:                    inv.Title,
+			Description:              inv.Description,
+			Currency:                 inv.Currency,
+			TotalAmount:              inv.TotalAmount,
+			PhotoUrl:                 inv.PhotoURL,
+			StartParam:               inv.StartParam,
+			ReceiptMessageId:         int32(inv.ReceiptMessageID),
+			Test:                     inv.Test,
+			ShippingAddressRequested: inv.ShippingAddress,
+		}
+	}
+	if st := e.Stats; st != nil {
+		msg.Stats = &watcherv1.Stats{Views: int32(st.Views), Forwards: int32(st.Forwards), Replies: int32(st.Replies)}
+	}
+	for _, f := range e.Findings {
+		msg.Findings = append(msg.Findings, &watcherv1.Finding{Reason: f.Reason, Match: f.Match, Resembles: f.Resembles})
+	}
+	if x := e.Extracted; x != nil {
+		msg.Extracted = &watcherv1.Extracted{Addresses: addressesOf(x.Addresses), Contracts: addressesOf(x.Contracts), Tickers: x.Tickers}
+	}
+	if c := e.ChannelChange; c != nil {
+		msg.ChannelChange = &watcherv1.ChannelChange{
+			Current:  channelInfoOf(c.ChannelInfo),
+			Previous: channelInfoOf(c.Previous),
+			Changed:  c.Changed,
+		}
+	}
+	if e.Comment != nil {
+		msg.Comment = &watcherv1.Comment{ChannelId: e.Comment.ChannelID, ChannelUsername: e.Comment.ChannelUsername, PostId: int32(e.Comment.PostID)}
+	}
+	return msg, nil
+}
+
+func addressesOf(addresses []event.Address) []*watcherv1.Address {
+	res := make([]*watcherv1.Address, 0, len(addresses))
+	for _, a := range addresses {
+		res = append(res, &watcherv1.Address{Chain: a.Chain, Address: a.Address})
+	}
+	return res
+}
+
+func channelInfoOf(c event.ChannelInfo) *watcherv1.ChannelInfo {
+	return &watcherv1.ChannelInfo{Title: c.Title, Username: c.Username, About: c.About, Participants: int32(c.Participants)}
+}
+
+func mediaOf(m *media.Media) *watcherv1.Media {
+	if m == nil {
+		return nil
+	}
+	pb := &watcherv1.Media{
+		Type:      m.Type,
+		FileName:  m.FileName,
+		MimeType:  m.MimeType,
+		Size:      m.Size,
+		Width:     int32(m.Width),
+		Height:    int32(m.Height),
+		Duration:  int32(m.Duration),
+		Url:       m.URL,
+		MessageId: int32(m.MessageID),
+	}
+	if s := m.Sticker; s != nil {
+		pb.Sticker = &watcherv1.Sticker{
+			Emoji:         s.Emoji,
+			SetId:         s.SetID,
+			SetName:       s.SetName,
+			Format:        s.Format,
+			FileId:        s.FileID,
+			AccessHash:    s.AccessHash,
+			FileReference: s.FileReference,
+		}
+	}
+	return pb
+}
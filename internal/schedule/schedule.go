@@ -0,0 +1,211 @@
+// Package schedule decides when events of a channel are forwarded: within
+// its windows and outside its quiet hours.
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// horizon bounds the search for the next open minute, a week covers every
+// combination of weekdays and times.
+const horizon = 8 * 24 * time.Hour
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// window is a daily time range in minutes since midnight on the days set in
+// days. A range ending before it starts goes past midnight into the next day.
+type window struct {
+	days       [7]bool
+	start, end int
+}
+
+func (w window) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return w.days[t.Weekday()] && minute >= w.start && minute < w.end
+	}
+	if minute >= w.start {
+		return w.days[t.Weekday()]
+	}
+	return minute < w.end && w.days[(t.Weekday()+6)%7]
+}
+
+// Schedule forwards events within its windows, any time without windows,
+// and never in its quiet hours.
+type Schedule struct {
+	loc     *time.Location
+	windows []window
+	quiet   []window
+}
+
+// Parse reads windows and quiet hours in timezone, the local one when
+// empty. It returns nil when both are empty and events are always forwarded.
+func Parse(timezone string, windows, quiet []string) (*Schedule, error) {
+	if len(windows) == 0 && len(quiet) == 0 {
+		return nil, nil
+	}
+	s := &Schedule{loc: time.Local}
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("timezone: %w", err)
+		}
+		s.loc = loc
+	}
+	for _, spec := range windows {
+		w, err := parseWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %w", spec, err)
+		}
+		s.windows = append(s.windows, w)
+	}
+	for _, spec := range quiet {
+		w, err := parseWindow(spec)
+		if err != nil {
+			return nil, fmt.Errorf("quiet %q: %w", spec, err)
+		}
+		s.quiet = append(s.quiet, w)
+	}
+	return s, nil
+}
+
+// Open tells whether events are forwarded at t.
+func (s *Schedule) Open(t time.Time) bool {
+	t = t.In(s.loc)
+	for _, w := range s.quiet {
+		if w.contains(t) {
+			return false
+		}
+	}
+	if len(s.windows) == 0 {
+		return true
+	}
+	for _, w := range s.windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns t when the schedule is open at t, else the start of the next
+// open minute. It is the zero time when the schedule never opens.
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.Open(t) {
+		return t
+	}
+	next := t.Truncate(time.Minute)
+	for end := t.Add(horizon); next.Before(end); {
+		next = next.Add(time.Minute)
+		if s.Open(next) {
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// parseWindow reads "[days ]HH:MM-HH:MM" where days are comma separated
+// weekdays or ranges of them, e.g. "Mon-Fri 09:00-18:00" or
+// "Sat,Sun 22:00-02:00". Without days the window applies every day.
+func parseWindow(spec string) (window, error) {
+	var w window
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 1:
+		for d := range w.days {
+			w.days[d] = true
+		}
+	case 2:
+		if err := parseDays(fields[0], &w.days); err != nil {
+			return window{}, err
+		}
+		fields = fields[1:]
+	default:
+		return window{}, fmt.Errorf("want [days ]HH:MM-HH:MM")
+	}
+
+	from, to, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return window{}, fmt.Errorf("want HH:MM-HH:MM")
+	}
+	var err error
+	if w.start, err = parseClock(from); err != nil {
+		return window{}, err
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return window{}, err
+	}
+	if w.start == w.end {
+		return window{}, fmt.Errorf("empty range")
+	}
+	return w, nil
+}
+
+func parseDays(s string, days *[7]bool) error {
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return fmt.Errorf("unknown weekday %q", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return fmt.Errorf("unknown weekday %q", to)
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock reads HH:MM as minutes since midnight, 24:00 is the end of the day.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		if s == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Cache keeps parsed schedules, so time zones are loaded once per distinct
+// config, also after a config reload.
+type Cache struct {
+	mux       sync.Mutex
+	schedules map[string]*Schedule
+}
+
+func NewCache() *Cache {
+	return &Cache{schedules: map[string]*Schedule{}}
+}
+
+func (c *Cache) Get(timezone string, windows, quiet []string) (*Schedule, error) {
+	key := fmt.Sprintf("%q\x00%q\x00%q", timezone, windows, quiet)
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if s, ok := c.schedules[key]; ok {
+		return s, nil
+	}
+	s, err := Parse(timezone, windows, quiet)
+	if err != nil {
+		return nil, err
+	}
+	c.schedules[key] = s
+	return s, nil
+}
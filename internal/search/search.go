@@ -0,0 +1,216 @@
+// Package search is a local full-text index of the archived messages, kept
+// with Bleve next to the archive so months of history can be searched
+// across channels with a query syntax.
+package search
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/go-faster/errors"
+)
+
+// Message is an indexed message, Date is in unix seconds.
+type Message struct {
+	ChannelID int64
+	MessageID int
+	Text      string
+	Date      int
+}
+
+// document is what Bleve stores of a message.
+type document struct {
+	ChannelID float64   `json:"channel_id"`
+	MessageID float64   `json:"message_id"`
+	Text      string    `json:"text"`
+	Date      time.Time `json:"date"`
+}
+
+func docID(channelID int64, messageID int) string {
+	return strconv.FormatInt(channelID, 10) + ":" + strconv.Itoa(messageID)
+}
+
+// Index is the full-text index at a path, safe for concurrent use.
+type Index struct {
+	index bleve.Index
+}
+
+// Open opens the index at path or creates it, created tells it is new and
+// empty. Only one process can have it open for writing.
+func Open(path string) (idx *Index, created bool, err error) {
+	index, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		index, err = bleve.New(path, newMapping())
+		created = true
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &Index{index: index}, created, nil
+}
+
+// OpenReadOnly opens an existing index for searching only, failing within
+// a second when another process has it open for writing.
+func OpenReadOnly(path string) (*Index, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	index, err := bleve.OpenUsing(path, map[string]any{"read_only": true, "bolt_timeout": "1s"})
+	if err != nil {
+		return nil, err
+	}
+	return &Index{index: index}, nil
+}
+
+func newMapping() mapping.IndexMapping {
+	doc := bleve.NewDocumentStaticMapping()
+	numeric := bleve.NewNumericFieldMapping()
+	doc.AddFieldMappingsAt("channel_id", numeric)
+	doc.AddFieldMappingsAt("message_id", numeric)
+	text := bleve.NewTextFieldMapping()
+	text.IncludeTermVectors = true
+	doc.AddFieldMappingsAt("text", text)
+	doc.AddFieldMappingsAt("date", bleve.NewDateTimeFieldMapping())
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = doc
+	m.DefaultField = "text"
+	return m
+}
+
+func (i *Index) Close() error {
+	return i.index.Close()
+}
+
+// Add indexes the message or replaces its indexed version.
+func (i *Index) Add(m Message) error {
+	return i.index.Index(docID(m.ChannelID, m.MessageID), newDocument(m))
+}
+
+func newDocument(m Message) document {
+	return document{
+		ChannelID: float64(m.ChannelID),
+		MessageID: float64(m.MessageID),
+		Text:      m.Text,
+		Date:      time.Unix(int64(m.Date), 0).UTC(),
+	}
+}
+
+// Batch indexes messages added to it at once, which is much faster when
+// building the index.
+type Batch struct {
+	index *Index
+	batch *bleve.Batch
+}
+
+func (i *Index) NewBatch() *Batch {
+	return &Batch{index: i, batch: i.index.NewBatch()}
+}
+
+func (b *Batch) Add(m Message) error {
+	return b.batch.Index(docID(m.ChannelID, m.MessageID), newDocument(m))
+}
+
+func (b *Batch) Size() int {
+	return b.batch.Size()
+}
+
+// Flush indexes the messages added so far and empties the batch.
+func (b *Batch) Flush() error {
+	if err := b.index.index.Batch(b.batch); err != nil {
+		return err
+	}
+	b.batch.Reset()
+	return nil
+}
+
+// Query searches the texts with the Bleve query string syntax: words,
+// "phrases", +required and -excluded terms, prefix* and fuzzy~ matches.
+// ChannelID 0 searches all channels, Since and Until bound the post date in
+// unix seconds, Until excluded. Offset pages through the hits.
+type Query struct {
+	Text      string
+	ChannelID int64
+	Since     int
+	Until     int
+	Limit     int
+	Offset    int
+}
+
+// Hit is a found message, best match first, with the matching parts of the
+// text highlighted in Fragments.
+type Hit struct {
+	ChannelID int64    `json:"channel_id"`
+	MessageID int      `json:"message_id"`
+	Date      int      `json:"date"`
+	Text      string   `json:"text"`
+	Score     float64  `json:"score"`
+	Fragments []string `json:"fragments,omitempty"`
+}
+
+type Result struct {
+	Total uint64 `json:"total"`
+	Hits  []Hit  `json:"hits"`
+}
+
+// Search returns the messages matching q.
+func (i *Index) Search(q Query) (*Result, error) {
+	if q.Text == "" {
+		return nil, errors.New("empty search")
+	}
+	conjuncts := []query.Query{bleve.NewQueryStringQuery(q.Text)}
+	if q.ChannelID != 0 {
+		id := float64(q.ChannelID)
+		inclusive := true
+		channel := bleve.NewNumericRangeInclusiveQuery(&id, &id, &inclusive, &inclusive)
+		channel.SetField("channel_id")
+		conjuncts = append(conjuncts, channel)
+	}
+	if q.Since > 0 || q.Until > 0 {
+		var since, until time.Time
+		if q.Since > 0 {
+			since = time.Unix(int64(q.Since), 0).UTC()
+		}
+		if q.Until > 0 {
+			until = time.Unix(int64(q.Until), 0).UTC()
+		}
+		date := bleve.NewDateRangeQuery(since, until)
+		date.SetField("date")
+		conjuncts = append(conjuncts, date)
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(conjuncts...), q.Limit, q.Offset, false)
+	req.Fields = []string{"channel_id", "message_id", "text", "date"}
+	req.Highlight = bleve.NewHighlight()
+	req.Highlight.AddField("text")
+	res, err := i.index.Search(req)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+
+	result := &Result{Total: res.Total, Hits: make([]Hit, 0, len(res.Hits))}
+	for _, h := range res.Hits {
+		hit := Hit{Score: h.Score, Fragments: h.Fragments["text"]}
+		if v, ok := h.Fields["channel_id"].(float64); ok {
+			hit.ChannelID = int64(v)
+		}
+		if v, ok := h.Fields["message_id"].(float64); ok {
+			hit.MessageID = int(v)
+		}
+		if v, ok := h.Fields["text"].(string); ok {
+			hit.Text = v
+		}
+		if v, ok := h.Fields["date"].(string); ok {
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				hit.Date = int(t.Unix())
+			}
+		}
+		result.Hits = append(result.Hits, hit)
+	}
+	return result, nil
+}
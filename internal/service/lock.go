@@ -0,0 +1,66 @@
+// Package service integrates the watcher with the machine it runs on: a
+// lock file per session so a second watcher can't corrupt it, readiness
+// and watchdog notifications for systemd and running as a Windows service.
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-faster/errors"
+)
+
+// ErrLocked is returned by Lock when another process holds the lock.
+var ErrLocked = errors.New("locked by another process")
+
+// FileLock is an exclusive lock on a file held by this process. The file
+// keeps the PID of the holder, it is not removed on release.
+type FileLock struct {
+	f *os.File
+}
+
+// Lock takes the lock on the file at path, created when missing. It fails
+// right away with an error wrapping ErrLocked when another process holds
+// it, the lock of a crashed process is released by the OS.
+func Lock(path string) (*FileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		if !errors.Is(err, ErrLocked) {
+			return nil, errors.Wrapf(err, "lock %s", path)
+		}
+		if pid := lockHolder(path); pid != 0 {
+			return nil, fmt.Errorf("%s is %w, PID %d", path, ErrLocked, pid)
+		}
+		return nil, fmt.Errorf("%s is %w", path, ErrLocked)
+	}
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &FileLock{f: f}, nil
+}
+
+// lockHolder reads the PID of the holder, 0 when unknown.
+func lockHolder(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(string(bytes.TrimSpace(data)))
+	return pid
+}
+
+// Release gives up the lock.
+func (l *FileLock) Release() error {
+	_ = unlockFile(l.f)
+	return l.f.Close()
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package service
+
+import (
+	"os"
+
+	"github.com/go-faster/errors"
+	"golang.org/x/sys/windows"
+)
+
+// A byte past the end of the PID is locked, so the PID stays readable to
+// the processes that fail to take the lock.
+const lockOffset = 1 << 30
+
+func lockFile(f *os.File) error {
+	ol := windows.Overlapped{Offset: lockOffset}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, &ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return ErrLocked
+	}
+	return err
+}
+
+func unlockFile(f *os.File) error {
+	ol := windows.Overlapped{Offset: lockOffset}
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &ol)
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Ready tells the service manager that the watcher is up: systemd units of
+// Type=notify leave the activating state, the Windows service is running.
+func Ready() {
+	_ = notify("READY=1")
+	windowsReady()
+}
+
+// Stopping tells systemd that the watcher shuts down.
+func Stopping() {
+	_ = notify("STOPPING=1")
+}
+
+// Alive pets the systemd watchdog, see WatchdogInterval.
+func Alive() {
+	_ = notify("WATCHDOG=1")
+}
+
+// WatchdogInterval returns how often Alive has to be called, 0 without a
+// WatchdogSec= in the unit. Half the timeout leaves room for delays.
+func WatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// notify sends state to the socket systemd passes in NOTIFY_SOCKET, it does
+// nothing outside of systemd.
+func notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		// Abstract socket.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	_, err = conn.Write([]byte(state))
+	return err
+}
@@ -0,0 +1,13 @@
+//go:build !windows
+
+package service
+
+import "context"
+
+func windowsReady() {}
+
+// Run runs the watcher, as a Windows service when the service control
+// manager started the process.
+func Run(ctx context.Context, run func(ctx context.Context) error) error {
+	return run(ctx)
+}
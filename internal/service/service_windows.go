@@ -0,0 +1,99 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+const (
+	// startHint is how long the service control manager is told to wait for
+	// the next start progress.
+	startHint = 10 * time.Second
+	// readyTimeout reports the service running when it didn't get ready by
+	// then, e.g. without a connection to Telegram, so it can be stopped.
+	readyTimeout = 2 * time.Minute
+)
+
+var (
+	ready     = make(chan struct{})
+	readyOnce sync.Once
+)
+
+func windowsReady() {
+	readyOnce.Do(func() { close(ready) })
+}
+
+// Run runs the watcher, as a Windows service when the service control
+// manager started the process. Stop and shutdown requests cancel the
+// context of run.
+func Run(ctx context.Context, run func(ctx context.Context) error) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return run(ctx)
+	}
+	h := &handler{ctx: ctx, run: run}
+	if err := svc.Run("", h); err != nil {
+		return err
+	}
+	return h.err
+}
+
+type handler struct {
+	ctx context.Context
+	run func(ctx context.Context) error
+	err error
+}
+
+func (h *handler) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- h.run(ctx) }()
+
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	current := svc.Status{State: svc.StartPending, WaitHint: uint32(startHint / time.Millisecond)}
+	status <- current
+	progress := time.NewTicker(startHint / 2)
+	defer progress.Stop()
+	timeout := time.After(readyTimeout)
+	running := ready
+
+	for {
+		select {
+		case <-progress.C:
+			if current.State == svc.StartPending {
+				current.CheckPoint++
+				status <- current
+			}
+		case <-running:
+			running, timeout = nil, nil
+			current = svc.Status{State: svc.Running, Accepts: accepts}
+			status <- current
+		case <-timeout:
+			running, timeout = nil, nil
+			current = svc.Status{State: svc.Running, Accepts: accepts}
+			status <- current
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- current
+			case svc.Stop, svc.Shutdown:
+				current = svc.Status{State: svc.StopPending}
+				status <- current
+				cancel()
+			}
+		case err := <-done:
+			h.err = err
+			if err != nil && h.ctx.Err() == nil && ctx.Err() == nil {
+				// The service failed, recovery actions apply.
+				return true, 1
+			}
+			return false, 0
+		}
+	}
+}
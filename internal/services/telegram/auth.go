@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
-	"syscall"
+	"time"
 
 	"golang.org/x/term"
 
@@ -21,7 +21,8 @@ import (
 // This is only example implementation, you should not use it in your code.
 // Copy it and modify to fit your needs.
 type Terminal struct {
-	PhoneNumber string // optional, will be prompted if empty
+	PhoneNumber  string // optional, will be prompted if empty
+	PasswordText string // optional 2FA password, will be prompted if empty
 }
 
 func (Terminal) SignUp(ctx context.Context) (auth.UserInfo, error) {
@@ -53,11 +54,78 @@ func (a Terminal) Phone(_ context.Context) (string, error) {
 	return strings.TrimSpace(phone), nil
 }
 
-func (Terminal) Password(_ context.Context) (string, error) {
+func (a Terminal) Password(_ context.Context) (string, error) {
+	if a.PasswordText != "" {
+		return a.PasswordText, nil
+	}
 	fmt.Print("Enter 2FA password: ")
-	bytePwd, err := term.ReadPassword(syscall.Stdin)
+	bytePwd, err := term.ReadPassword(int(os.Stdin.Fd()))
 	if err != nil {
 		return "", err
 	}
 	return strings.TrimSpace(string(bytePwd)), nil
 }
+
+// NonInteractive implements auth.UserAuthenticator without a terminal.
+// The login code is taken from CodeText or, when empty, from CodeFile: the file
+// is polled until it appears and removed after reading, so the code can be
+// dropped into a container volume while the watcher waits for it.
+type NonInteractive struct {
+	PhoneNumber  string
+	PasswordText string
+	CodeText     string
+	CodeFile     string
+	PollInterval time.Duration
+}
+
+func (NonInteractive) SignUp(ctx context.Context) (auth.UserInfo, error) {
+	return auth.UserInfo{}, errors.New("signing up not implemented")
+}
+
+func (NonInteractive) AcceptTermsOfService(ctx context.Context, tos tg.HelpTermsOfService) error {
+	return &auth.SignUpRequired{TermsOfService: tos}
+}
+
+func (a NonInteractive) Phone(_ context.Context) (string, error) {
+	return a.PhoneNumber, nil
+}
+
+func (a NonInteractive) Password(_ context.Context) (string, error) {
+	if a.PasswordText == "" {
+		return "", errors.New("account has 2FA enabled, but no password is configured, set tg_app.auth.password or TG_PASSWORD")
+	}
+	return a.PasswordText, nil
+}
+
+func (a NonInteractive) Code(ctx context.Context, _ *tg.AuthSentCode) (string, error) {
+	if a.CodeText != "" {
+		return a.CodeText, nil
+	}
+	if a.CodeFile == "" {
+		return "", errors.New("login code required, set a code or a code file")
+	}
+
+	interval := a.PollInterval
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		data, err := os.ReadFile(a.CodeFile)
+		if code := strings.TrimSpace(string(data)); err == nil && code != "" {
+			_ = os.Remove(a.CodeFile)
+			return code, nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
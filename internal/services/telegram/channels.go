@@ -0,0 +1,193 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/tg"
+	"go-tg.com/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+type cachedChannel struct {
+	ID         int64  `json:"id"`
+	AccessHash int64  `json:"access_hash"`
+	Title      string `json:"title"`
+	Username   string `json:"username,omitempty"`
+	Broadcast  bool   `json:"broadcast,omitempty"`
+	Megagroup  bool   `json:"megagroup,omitempty"`
+}
+
+func (c cachedChannel) channel() *tg.Channel {
+	return &tg.Channel{
+		ID:         c.ID,
+		AccessHash: c.AccessHash,
+		Title:      c.Title,
+		Username:   c.Username,
+		Broadcast:  c.Broadcast,
+		Megagroup:  c.Megagroup,
+	}
+}
+
+// ChannelCache keeps channels with their access hashes so they are resolved
+// with ChannelsGetChannels only once. It is optionally persisted to a JSON file.
+type ChannelCache struct {
+	api  *tg.Client
+	path string
+
+	mux      sync.RWMutex
+	channels map[int64]*tg.Channel
+	saveMux  sync.Mutex
+}
+
+func NewChannelCache(api *tg.Client, path string) (*ChannelCache, error) {
+	c := &ChannelCache{
+		api:      api,
+		path:     path,
+		channels: map[int64]*tg.Channel{},
+	}
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read peer cache")
+	}
+
+	var stored []cachedChannel
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, errors.Wrap(err, "decode peer cache")
+	}
+	for _, ch := range stored {
+		c.channels[ch.ID] = ch.channel()
+	}
+	return c, nil
+}
+
+// Len returns the number of cached channels.
+func (c *ChannelCache) Len() int {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	return len(c.channels)
+}
+
+// Get returns the channel from cache or requests it from Telegram.
+func (c *ChannelCache) Get(ctx context.Context, channelID int64) (_ *tg.Channel, err error) {
+	ctx, span := tracing.Start(ctx, "resolve channel", attribute.Int64("channel_id", channelID))
+	defer func() { tracing.End(span, err) }()
+
+	c.mux.RLock()
+	channel, ok := c.channels[channelID]
+	c.mux.RUnlock()
+	span.SetAttributes(attribute.Bool("cached", ok))
+	if ok {
+		return channel, nil
+	}
+
+	inputChannel := &tg.InputChannel{
+		ChannelID:  channelID,
+		AccessHash: 0, // Works for channels the account has joined
+	}
+
+	channels, err := c.api.ChannelsGetChannels(ctx, []tg.InputChannelClass{inputChannel})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch channel: %w", err)
+	}
+
+	if len(channels.GetChats()) == 0 {
+		return nil, fmt.Errorf("no channels found")
+	}
+
+	channel, ok = channels.GetChats()[0].(*tg.Channel)
+	if !ok {
+		return nil, errors.New("unexpected chat type")
+	}
+
+	c.Put(channel)
+	return channel, nil
+}
+
+// Cached returns the channel if it is in the cache, without asking Telegram.
+func (c *ChannelCache) Cached(channelID int64) (*tg.Channel, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	channel, ok := c.channels[channelID]
+	return channel, ok
+}
+
+// Put stores channels, e.g. the ones delivered with update entities.
+// Channels without an access hash (min constructors) are ignored.
+func (c *ChannelCache) Put(channels ...*tg.Channel) {
+	changed := false
+
+	c.mux.Lock()
+	for _, channel := range channels {
+		if channel.Min {
+			continue
+		}
+		if prev, ok := c.channels[channel.ID]; ok && prev.AccessHash == channel.AccessHash && prev.Username == channel.Username && prev.Title == channel.Title {
+			continue
+		}
+		c.channels[channel.ID] = channel
+		changed = true
+	}
+	c.mux.Unlock()
+
+	if changed {
+		c.save()
+	}
+}
+
+// Invalidate drops a channel, the next Get resolves it again.
+func (c *ChannelCache) Invalidate(channelID int64) {
+	c.mux.Lock()
+	delete(c.channels, channelID)
+	c.mux.Unlock()
+
+	c.save()
+}
+
+// InvalidateOn drops the channel if err is CHANNEL_INVALID and returns err unchanged.
+func (c *ChannelCache) InvalidateOn(channelID int64, err error) error {
+	if tg.IsChannelInvalid(err) {
+		c.Invalidate(channelID)
+	}
+	return err
+}
+
+func (c *ChannelCache) save() {
+	if c.path == "" {
+		return
+	}
+
+	c.mux.RLock()
+	stored := make([]cachedChannel, 0, len(c.channels))
+	for _, ch := range c.channels {
+		stored = append(stored, cachedChannel{
+			ID:         ch.ID,
+			AccessHash: ch.AccessHash,
+			Title:      ch.Title,
+			Username:   ch.Username,
+			Broadcast:  ch.Broadcast,
+			Megagroup:  ch.Megagroup,
+		})
+	}
+	c.mux.RUnlock()
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return
+	}
+	// The cache is only an optimization, a failed write means one more RPC after restart.
+	c.saveMux.Lock()
+	_ = os.WriteFile(c.path, data, 0o600)
+	c.saveMux.Unlock()
+}
@@ -0,0 +1,82 @@
+package telegram
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-faster/errors"
+)
+
+// Checkpoints stores the last processed message ID per channel in a JSON file,
+// so a history fetch can continue where the previous run stopped.
+type Checkpoints struct {
+	path string
+	mux  sync.Mutex
+	last map[int64]int
+}
+
+func NewCheckpoints(path string) (*Checkpoints, error) {
+	c := &Checkpoints{
+		path: path,
+		last: map[int64]int{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read checkpoints file")
+	}
+	if len(data) == 0 {
+		return c, nil
+	}
+	if err := json.Unmarshal(data, &c.last); err != nil {
+		return nil, errors.Wrap(err, "decode checkpoints file")
+	}
+	return c, nil
+}
+
+// Last returns the last processed message ID of the channel, 0 if there is none.
+func (c *Checkpoints) Last(channelID int64) int {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.last[channelID]
+}
+
+// Advance moves the checkpoint of the channel forward to messageID.
+// Older IDs are ignored, so the checkpoint never goes back.
+func (c *Checkpoints) Advance(channelID int64, messageID int) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if messageID <= c.last[channelID] {
+		return nil
+	}
+	c.last[channelID] = messageID
+	return c.flush()
+}
+
+func (c *Checkpoints) flush() error {
+	data, err := json.Marshal(c.last)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp checkpoints file")
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "write checkpoints file")
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
@@ -0,0 +1,79 @@
+package telegram
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-faster/errors"
+)
+
+// Discovered stores the titles of channels found by discovery in a JSON
+// file by channel ID, so they stay watched and are announced only once.
+type Discovered struct {
+	path     string
+	mux      sync.Mutex
+	channels map[int64]string
+}
+
+func NewDiscovered(path string) (*Discovered, error) {
+	d := &Discovered{
+		path:     path,
+		channels: map[int64]string{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read discovered channels file")
+	}
+	if len(data) == 0 {
+		return d, nil
+	}
+	if err := json.Unmarshal(data, &d.channels); err != nil {
+		return nil, errors.Wrap(err, "decode discovered channels file")
+	}
+	return d, nil
+}
+
+// Has reports whether the channel was discovered before.
+func (d *Discovered) Has(channelID int64) bool {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	_, ok := d.channels[channelID]
+	return ok
+}
+
+// Add records a discovered channel with its title.
+func (d *Discovered) Add(channelID int64, title string) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	d.channels[channelID] = title
+	return d.flush()
+}
+
+func (d *Discovered) flush() error {
+	data, err := json.Marshal(d.channels)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp discovered channels file")
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "write discovered channels file")
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path)
+}
@@ -0,0 +1,82 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/session"
+)
+
+var encryptedMagic = []byte("tgw-aesgcm-v1:")
+
+// EncryptedStorage encrypts sessions of the wrapped storage with AES-256-GCM.
+// A plaintext session found in the storage is accepted once and written back
+// encrypted, so existing session files are migrated on the first start.
+type EncryptedStorage struct {
+	storage session.Storage
+	aead    cipher.AEAD
+}
+
+// NewEncryptedStorage wraps storage. key is a base64 encoded 32 byte key.
+func NewEncryptedStorage(storage session.Storage, key string) (*EncryptedStorage, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode session key")
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("session key must be 32 bytes, got %d", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedStorage{storage: storage, aead: aead}, nil
+}
+
+func (s *EncryptedStorage) LoadSession(ctx context.Context) ([]byte, error) {
+	data, err := s.storage.LoadSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		if err := s.StoreSession(ctx, data); err != nil {
+			return nil, errors.Wrap(err, "encrypt plaintext session")
+		}
+		return data, nil
+	}
+
+	data = data[len(encryptedMagic):]
+	size := s.aead.NonceSize()
+	if len(data) < size {
+		return nil, errors.New("encrypted session is truncated")
+	}
+	plain, err := s.aead.Open(nil, data[:size], data[size:], encryptedMagic)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt session, wrong key?")
+	}
+	return plain, nil
+}
+
+func (s *EncryptedStorage) StoreSession(ctx context.Context, data []byte) error {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	out := make([]byte, 0, len(encryptedMagic)+len(nonce)+len(data)+s.aead.Overhead())
+	out = append(out, encryptedMagic...)
+	out = append(out, nonce...)
+	out = s.aead.Seal(out, nonce, data, encryptedMagic)
+	return s.storage.StoreSession(ctx, out)
+}
@@ -0,0 +1,134 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/session"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisSessionStorage keeps the session in a single Redis key.
+type RedisSessionStorage struct {
+	client *redis.Client
+	key    string
+}
+
+func NewRedisSessionStorage(opts *redis.Options, key string) *RedisSessionStorage {
+	return &RedisSessionStorage{client: redis.NewClient(opts), key: key}
+}
+
+func (s *RedisSessionStorage) LoadSession(ctx context.Context) ([]byte, error) {
+	data, err := s.client.Get(ctx, s.key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, session.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "redis get")
+	}
+	return data, nil
+}
+
+func (s *RedisSessionStorage) StoreSession(ctx context.Context, data []byte) error {
+	return s.client.Set(ctx, s.key, data, 0).Err()
+}
+
+// PostgresSessionStorage keeps sessions in the tg_sessions table, one row per key.
+type PostgresSessionStorage struct {
+	db  *sql.DB
+	key string
+}
+
+func NewPostgresSessionStorage(ctx context.Context, dsn, key string) (*PostgresSessionStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "open postgres")
+	}
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS tg_sessions (
+		id         TEXT PRIMARY KEY,
+		data       BYTEA NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "create sessions table")
+	}
+	return &PostgresSessionStorage{db: db, key: key}, nil
+}
+
+func (s *PostgresSessionStorage) LoadSession(ctx context.Context) ([]byte, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT data FROM tg_sessions WHERE id = $1`, s.key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, session.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "select session")
+	}
+	return data, nil
+}
+
+func (s *PostgresSessionStorage) StoreSession(ctx context.Context, data []byte) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO tg_sessions (id, data, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (id) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`, s.key, data)
+	return err
+}
+
+// S3SessionStorage keeps the session as an object in an S3-compatible bucket.
+type S3SessionStorage struct {
+	client *minio.Client
+	bucket string
+	key    string
+}
+
+type S3SessionOptions struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	Key       string
+}
+
+func NewS3SessionStorage(opts S3SessionOptions) (*S3SessionStorage, error) {
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure: opts.UseSSL,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create s3 client")
+	}
+	return &S3SessionStorage{client: client, bucket: opts.Bucket, key: opts.Key}, nil
+}
+
+func (s *S3SessionStorage) LoadSession(ctx context.Context) ([]byte, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "get session object")
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, session.ErrNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read session object")
+	}
+	return data, nil
+}
+
+func (s *S3SessionStorage) StoreSession(ctx context.Context, data []byte) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
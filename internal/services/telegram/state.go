@@ -0,0 +1,222 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/updates"
+)
+
+var (
+	_ updates.StateStorage        = (*FileStateStorage)(nil)
+	_ updates.ChannelAccessHasher = (*FileStateStorage)(nil)
+)
+
+type userState struct {
+	State        updates.State   `json:"state"`
+	Channels     map[int64]int   `json:"channels"`
+	AccessHashes map[int64]int64 `json:"access_hashes,omitempty"`
+}
+
+// FileStateStorage implements updates.StateStorage persisting pts/qts/seq
+// to a JSON file, so gap recovery continues from the last known state after restart.
+// It also keeps the access hashes of channels, without them the gaps of a
+// channel can only be recovered once a new update brings its hash.
+type FileStateStorage struct {
+	path  string
+	mux   sync.Mutex
+	users map[int64]*userState
+
+	// OnChange is called with the new user state after every change (optional).
+	OnChange func(userID int64, state updates.State)
+}
+
+func NewFileStateStorage(path string) (*FileStateStorage, error) {
+	s := &FileStateStorage{
+		path:  path,
+		users: map[int64]*userState{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read state file")
+	}
+	if len(data) == 0 {
+		return s, nil
+	}
+	if err := json.Unmarshal(data, &s.users); err != nil {
+		return nil, errors.Wrap(err, "decode state file")
+	}
+	return s, nil
+}
+
+// flush writes the whole state to a temp file and renames it over the old one,
+// so a crash in the middle of writing never leaves a truncated file behind.
+func (s *FileStateStorage) flush() error {
+	data, err := json.Marshal(s.users)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "create temp state file")
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return errors.Wrap(err, "write state file")
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileStateStorage) update(userID int64, f func(u *userState)) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return errors.New("state not found")
+	}
+	f(u)
+	s.changed(userID, u.State)
+	return s.flush()
+}
+
+func (s *FileStateStorage) changed(userID int64, state updates.State) {
+	if s.OnChange != nil {
+		s.OnChange(userID, state)
+	}
+}
+
+func (s *FileStateStorage) GetState(_ context.Context, userID int64) (updates.State, bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return updates.State{}, false, nil
+	}
+	return u.State, true, nil
+}
+
+func (s *FileStateStorage) SetState(_ context.Context, userID int64, state updates.State) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var hashes map[int64]int64
+	if prev, ok := s.users[userID]; ok {
+		hashes = prev.AccessHashes
+	}
+	s.users[userID] = &userState{
+		State:        state,
+		Channels:     map[int64]int{},
+		AccessHashes: hashes,
+	}
+	s.changed(userID, state)
+	return s.flush()
+}
+
+func (s *FileStateStorage) SetPts(_ context.Context, userID int64, pts int) error {
+	return s.update(userID, func(u *userState) { u.State.Pts = pts })
+}
+
+func (s *FileStateStorage) SetQts(_ context.Context, userID int64, qts int) error {
+	return s.update(userID, func(u *userState) { u.State.Qts = qts })
+}
+
+func (s *FileStateStorage) SetDate(_ context.Context, userID int64, date int) error {
+	return s.update(userID, func(u *userState) { u.State.Date = date })
+}
+
+func (s *FileStateStorage) SetSeq(_ context.Context, userID int64, seq int) error {
+	return s.update(userID, func(u *userState) { u.State.Seq = seq })
+}
+
+func (s *FileStateStorage) SetDateSeq(_ context.Context, userID int64, date, seq int) error {
+	return s.update(userID, func(u *userState) {
+		u.State.Date = date
+		u.State.Seq = seq
+	})
+}
+
+func (s *FileStateStorage) GetChannelPts(_ context.Context, userID, channelID int64) (int, bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return 0, false, nil
+	}
+	pts, ok := u.Channels[channelID]
+	return pts, ok, nil
+}
+
+func (s *FileStateStorage) SetChannelPts(_ context.Context, userID, channelID int64, pts int) error {
+	return s.update(userID, func(u *userState) { u.Channels[channelID] = pts })
+}
+
+func (s *FileStateStorage) ForEachChannels(ctx context.Context, userID int64, f func(ctx context.Context, channelID int64, pts int) error) error {
+	s.mux.Lock()
+	u, ok := s.users[userID]
+	if !ok {
+		s.mux.Unlock()
+		return errors.New("state not found")
+	}
+	channels := make(map[int64]int, len(u.Channels))
+	for id, pts := range u.Channels {
+		channels[id] = pts
+	}
+	s.mux.Unlock()
+
+	for id, pts := range channels {
+		if err := f(ctx, id, pts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileStateStorage) GetChannelAccessHash(_ context.Context, userID, channelID int64) (int64, bool, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return 0, false, nil
+	}
+	hash, ok := u.AccessHashes[channelID]
+	return hash, ok, nil
+}
+
+// SetChannelAccessHash is called for every channel coming with updates, the
+// file is only written when the hash is new. Hashes arriving before the
+// state of the user is set are not kept.
+func (s *FileStateStorage) SetChannelAccessHash(_ context.Context, userID, channelID, accessHash int64) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	u, ok := s.users[userID]
+	if !ok {
+		return nil
+	}
+	if hash, ok := u.AccessHashes[channelID]; ok && hash == accessHash {
+		return nil
+	}
+	if u.AccessHashes == nil {
+		u.AccessHashes = map[int64]int64{}
+	}
+	u.AccessHashes[channelID] = accessHash
+	return s.flush()
+}
@@ -0,0 +1,97 @@
+package telegram
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-faster/errors"
+)
+
+const updateLogExt = ".bin"
+
+// UpdateLog is a write-ahead log of raw updates: every update is written to
+// its own file in a directory before it is handled and removed once it was.
+// Entries left by a crash are pending until they are handled on the next start.
+type UpdateLog struct {
+	dir string
+	seq atomic.Uint64
+}
+
+// LoggedUpdate is a pending entry of the update log.
+type LoggedUpdate struct {
+	ID   string
+	Data []byte
+}
+
+func NewUpdateLog(dir string) (*UpdateLog, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "create update log dir")
+	}
+	return &UpdateLog{dir: dir}, nil
+}
+
+// Append writes data synced to disk and returns its entry ID, IDs sort in
+// the order of the appends.
+func (l *UpdateLog) Append(data []byte) (string, error) {
+	id := fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), l.seq.Add(1)%1e6)
+	path := l.path(id)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", errors.Wrap(err, "create update log entry")
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return "", errors.Wrap(err, "write update log entry")
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return "", errors.Wrap(err, "sync update log entry")
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return "", err
+	}
+	return id, os.Rename(tmp, path)
+}
+
+// Ack removes a handled entry.
+func (l *UpdateLog) Ack(id string) error {
+	if err := os.Remove(l.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Wrap(err, "remove update log entry")
+	}
+	return nil
+}
+
+// Pending returns the entries not acknowledged yet, oldest first.
+func (l *UpdateLog) Pending() ([]LoggedUpdate, error) {
+	files, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "list update log")
+	}
+	var pending []LoggedUpdate
+	for _, f := range files {
+		id, ok := strings.CutSuffix(f.Name(), updateLogExt)
+		if f.IsDir() || !ok {
+			continue
+		}
+		data, err := os.ReadFile(l.path(id))
+		if err != nil {
+			return nil, errors.Wrap(err, "read update log entry")
+		}
+		pending = append(pending, LoggedUpdate{ID: id, Data: data})
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].ID < pending[j].ID })
+	return pending, nil
+}
+
+func (l *UpdateLog) path(id string) string {
+	return filepath.Join(l.dir, id+updateLogExt)
+}
@@ -0,0 +1,40 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+
+	"go-tg.com/internal/event"
+)
+
+// maxActionBody bounds the webhook response read for an action.
+const maxActionBody = 64 << 10
+
+// Action is what a webhook response asks the watcher to do with the message
+// of the event: "react" with Emoji or "reply" with Text.
+type Action struct {
+	Action string `json:"action"`
+	Emoji  string `json:"emoji,omitempty"`
+	Text   string `json:"text,omitempty"`
+}
+
+// Actor performs the actions of webhook responses. The event was delivered
+// either way, so failures are reported by the actor and not to the sink.
+type Actor interface {
+	Act(ctx context.Context, e *event.Event, a Action)
+}
+
+// readAction decodes the action of a JSON response body, ok is false for
+// other content types and bodies without an action.
+func readAction(contentType string, body io.Reader) (Action, bool) {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "application/json" {
+		return Action{}, false
+	}
+	var a Action
+	if err := json.NewDecoder(io.LimitReader(body, maxActionBody)).Decode(&a); err != nil || a.Action == "" {
+		return Action{}, false
+	}
+	return a, true
+}
@@ -0,0 +1,106 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+)
+
+// AMQP publishes events to an exchange in confirm mode. Send returns only
+// after the broker confirmed the message, a nack is reported as an error
+// so the outbox retries it.
+type AMQP struct {
+	encoder
+	cfg config.AMQPConfig
+
+	mu   sync.Mutex
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+func NewAMQP(cfg config.AMQPConfig) (*AMQP, error) {
+	if cfg.URL == "" || cfg.Exchange == "" {
+		return nil, fmt.Errorf("amqp url and exchange are required")
+	}
+	if cfg.RoutingKey == "" {
+		cfg.RoutingKey = "{channel_id}.{type}"
+	}
+	s := &AMQP{cfg: cfg}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *AMQP) connect() error {
+	conn, err := amqp.Dial(s.cfg.URL)
+	if err != nil {
+		return errors.Wrap(err, "dial amqp")
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return errors.Wrap(err, "open channel")
+	}
+	if err := ch.Confirm(false); err != nil {
+		_ = conn.Close()
+		return errors.Wrap(err, "enable confirms")
+	}
+	s.conn, s.ch = conn, ch
+	return nil
+}
+
+func (s *AMQP) Send(ctx context.Context, _ string, e *event.Event) error {
+	body, err := s.encode(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The channel is closed by the broker on errors, reconnect lazily.
+	if s.ch == nil || s.ch.IsClosed() {
+		if s.conn != nil {
+			_ = s.conn.Close()
+		}
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	confirm, err := s.ch.PublishWithDeferredConfirmWithContext(ctx, s.cfg.Exchange, expandKey(s.cfg.RoutingKey, e), false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Type:         e.Type,
+		Timestamp:    time.Now(),
+		Body:         body,
+	})
+	if err != nil {
+		return errors.Wrap(err, "publish")
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("message nacked by broker")
+	}
+	return nil
+}
+
+func (s *AMQP) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
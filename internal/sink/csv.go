@@ -0,0 +1,107 @@
+package sink
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/event"
+)
+
+var csvHeader = []string{
+	"message_id", "type", "date", "edit_date", "channel_id", "channel_username", "channel_title",
+	"author_id", "author_type", "text", "views", "forwards", "reply_to_id", "media_type", "media_file_name",
+}
+
+// CSV appends events as rows to a local CSV file, the header is written to
+// an empty file. Dates and authors are filled for the full payload format.
+type CSV struct {
+	mux  sync.Mutex
+	file *os.File
+	w    *csv.Writer
+}
+
+func NewCSV(path string) (*CSV, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "open csv file")
+	}
+	s := &CSV{file: f, w: csv.NewWriter(f)}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		if err := s.write(csvHeader); err != nil {
+			_ = f.Close()
+			return nil, errors.Wrap(err, "write csv header")
+		}
+	}
+	return s, nil
+}
+
+func (s *CSV) Send(_ context.Context, _ string, e *event.Event) error {
+	var authorID, authorType string
+	if e.Author != nil {
+		authorID, authorType = formatInt(e.Author.ID), e.Author.Type
+	}
+	var mediaType, mediaFile string
+	if e.Media != nil {
+		mediaType, mediaFile = e.Media.Type, e.Media.FileName
+	}
+	return s.write([]string{
+		e.ExternalID,
+		e.Type,
+		formatDate(e.Date),
+		formatDate(e.EditDate),
+		strconv.FormatInt(e.ChannelID, 10),
+		e.ChannelUsername,
+		e.ChannelTitle,
+		authorID,
+		authorType,
+		e.Text,
+		formatInt(int64(e.Views)),
+		formatInt(int64(e.Forwards)),
+		formatInt(int64(e.ReplyToID)),
+		mediaType,
+		mediaFile,
+	})
+}
+
+func (s *CSV) write(record []string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.w.Write(record); err != nil {
+		return err
+	}
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *CSV) Close() error {
+	return s.file.Close()
+}
+
+// formatInt leaves zero values empty.
+func formatInt(v int64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+func formatDate(unix int) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(int64(unix), 0).UTC().Format(time.RFC3339)
+}
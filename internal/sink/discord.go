@@ -0,0 +1,255 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/media"
+)
+
+const (
+	// discordTextLimit is the longest description of an embed.
+	discordTextLimit = 4096
+	// discordMaxFiles is how many attachments one webhook message can carry.
+	discordMaxFiles = 10
+	// discordMaxUpload is the size of an attachment webhooks accept without boosts.
+	discordMaxUpload = 8 << 20
+)
+
+// Discord posts events to Discord webhooks as embeds with the chat, author,
+// text and a link to the post. The webhook is picked per Telegram channel,
+// falling back to url. Downloaded media is linked in the embed or, with
+// upload, attached to the message.
+type Discord struct {
+	encoder
+	client   *http.Client
+	cfg      *config.Store
+	url      string
+	channels map[int64]string
+	upload   bool
+}
+
+func NewDiscord(cfg *config.Store, url string, dc config.DiscordConfig) (*Discord, error) {
+	client, err := newWebhookClient(cfg.Load().Webhook)
+	if err != nil {
+		return nil, err
+	}
+	return &Discord{client: client, cfg: cfg, url: url, channels: dc.Channels, upload: dc.Upload}, nil
+}
+
+type discordMessage struct {
+	Embeds      []discordEmbed      `json:"embeds"`
+	Attachments []discordAttachment `json:"attachments,omitempty"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title,omitempty"`
+	URL         string         `json:"url,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Timestamp   string         `json:"timestamp,omitempty"`
+	Author      *discordName   `json:"author,omitempty"`
+	Footer      *discordName   `json:"footer,omitempty"`
+	Image       *discordImage  `json:"image,omitempty"`
+	Fields      []discordField `json:"fields,omitempty"`
+}
+
+type discordName struct {
+	Name string `json:"name,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+type discordImage struct {
+	URL string `json:"url"`
+}
+
+type discordField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type discordAttachment struct {
+	ID       int    `json:"id"`
+	Filename string `json:"filename"`
+}
+
+// discordFile is an attachment uploaded with the message.
+type discordFile struct {
+	name string
+	data []byte
+}
+
+func (s *Discord) Send(ctx context.Context, _ string, e *event.Event) error {
+	url := s.url
+	if channelURL, ok := s.channels[e.ChannelID]; ok {
+		url = channelURL
+	}
+	if url == "" {
+		return fmt.Errorf("no discord webhook for channel %d", e.ChannelID)
+	}
+	timeout := s.cfg.Load().Webhook.Timeout
+
+	if s.template != nil {
+		body, err := s.template.Render(e)
+		if err != nil {
+			return err
+		}
+		return postJSON(ctx, s.client, timeout, url, body)
+	}
+
+	var files []discordFile
+	if s.upload {
+		var err error
+		if files, err = s.fetchMedia(ctx, eventMedia(e)); err != nil {
+			return err
+		}
+	}
+	msg := discordMessageOf(e, files)
+	if len(files) == 0 {
+		body, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return postJSON(ctx, s.client, timeout, url, body)
+	}
+	return s.postFiles(ctx, timeout, url, msg, files)
+}
+
+func (s *Discord) Close() error {
+	return nil
+}
+
+func discordMessageOf(e *event.Event, files []discordFile) discordMessage {
+	text, _ := truncate(e.Text, discordTextLimit)
+	embed := discordEmbed{
+		Title:       e.ChatName(),
+		URL:         e.Link(),
+		Description: text,
+	}
+	if e.Date > 0 {
+		embed.Timestamp = time.Unix(int64(e.Date), 0).UTC().Format(time.RFC3339)
+	}
+	if e.Author != nil && e.Author.Signature != "" {
+		embed.Author = &discordName{Name: e.Author.Signature}
+	}
+	if label := eventLabel(e); label != "" {
+		embed.Footer = &discordName{Text: label}
+	}
+
+	msg := discordMessage{}
+	uploaded := map[string]bool{}
+	for i, f := range files {
+		msg.Attachments = append(msg.Attachments, discordAttachment{ID: i, Filename: f.name})
+		uploaded[f.name] = true
+	}
+	for _, m := range eventMedia(e) {
+		name := path.Base(m.FileName)
+		switch {
+		case uploaded[name]:
+			if m.Type == "photo" && embed.Image == nil {
+				embed.Image = &discordImage{URL: "attachment://" + name}
+			}
+		case !isPublic(m.URL):
+		case m.Type == "photo" && embed.Image == nil:
+			embed.Image = &discordImage{URL: m.URL}
+		default:
+			embed.Fields = append(embed.Fields, discordField{Name: m.Type, Value: fmt.Sprintf("[%s](%s)", m.FileName, m.URL)})
+		}
+	}
+	msg.Embeds = []discordEmbed{embed}
+	return msg
+}
+
+// fetchMedia reads the stored media to attach it, files over the upload
+// limit or beyond the attachment count stay links.
+func (s *Discord) fetchMedia(ctx context.Context, list []*media.Media) ([]discordFile, error) {
+	var files []discordFile
+	for _, m := range list {
+		if m.URL == "" || m.Size > discordMaxUpload || len(files) == discordMaxFiles {
+			continue
+		}
+		data, err := s.readMedia(ctx, m.URL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fetch media %s", m.URL)
+		}
+		if len(data) > discordMaxUpload {
+			continue
+		}
+		files = append(files, discordFile{name: path.Base(m.FileName), data: data})
+	}
+	return files, nil
+}
+
+// readMedia reads up to one byte over the upload limit from the media
+// storage: a file of the local storage or a URL.
+func (s *Discord) readMedia(ctx context.Context, url string) ([]byte, error) {
+	if !isPublic(url) {
+		f, err := os.Open(url)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(io.LimitReader(f, discordMaxUpload+1))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, discordMaxUpload+1))
+}
+
+// postFiles sends the message with attachments as multipart/form-data.
+func (s *Discord) postFiles(ctx context.Context, timeout time.Duration, url string, msg discordMessage, files []discordFile) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := form.WriteField("payload_json", string(payload)); err != nil {
+		return err
+	}
+	for i, f := range files {
+		part, err := form.CreateFormFile(fmt.Sprintf("files[%d]", i), f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(f.data); err != nil {
+			return err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return doRequest(s.client, req)
+}
@@ -0,0 +1,40 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/event"
+	"go.uber.org/zap"
+)
+
+// DryRun logs the bodies a sink would send, rendered with its template,
+// instead of sending them.
+type DryRun struct {
+	encoder
+	name string
+	log  *zap.Logger
+}
+
+func NewDryRun(log *zap.Logger, name string) *DryRun {
+	return &DryRun{name: name, log: log}
+}
+
+func (d *DryRun) Send(_ context.Context, target string, e *event.Event) error {
+	body, err := d.encode(e)
+	if err != nil {
+		return errors.Wrap(err, "render event")
+	}
+	d.log.Info("Would send",
+		zap.String("sink", d.name),
+		zap.String("target", target),
+		zap.String("type", e.Type),
+		zap.Int64("chat_id", e.ChannelID),
+		zap.ByteString("body", body),
+	)
+	return nil
+}
+
+func (d *DryRun) Close() error {
+	return nil
+}
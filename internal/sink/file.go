@@ -0,0 +1,58 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/event"
+)
+
+// File appends events as JSON lines to a local file.
+type File struct {
+	encoder
+	mux  sync.Mutex
+	file *os.File
+}
+
+func NewFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "open sink file")
+	}
+	return &File{file: f}, nil
+}
+
+func (s *File) Send(_ context.Context, _ string, e *event.Event) error {
+	line, err := s.encode(e)
+	if err != nil {
+		return err
+	}
+	return s.write(line)
+}
+
+// Append writes v as one JSON line and syncs the file.
+func (s *File) Append(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.write(line)
+}
+
+func (s *File) write(line []byte) error {
+	line = append(line, '\n')
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, err := s.file.Write(line); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *File) Close() error {
+	return s.file.Close()
+}
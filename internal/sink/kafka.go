@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+)
+
+// Kafka produces events to a topic keyed by channel ID, so events of
+// one channel keep their order within a partition.
+type Kafka struct {
+	encoder
+	writer *kafka.Writer
+}
+
+func NewKafka(cfg config.KafkaConfig) (*Kafka, error) {
+	if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+		return nil, fmt.Errorf("kafka brokers and topic are required")
+	}
+
+	transport := &kafka.Transport{
+		ClientID: cfg.ClientID,
+	}
+	if cfg.TLS {
+		transport.TLS = &tls.Config{}
+	}
+	if cfg.SASL.Mechanism != "" {
+		mechanism, err := saslMechanism(cfg.SASL)
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+
+	return &Kafka{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 10 * time.Millisecond,
+			Transport:    transport,
+		},
+	}, nil
+}
+
+func saslMechanism(cfg config.SASLConfig) (sasl.Mechanism, error) {
+	switch strings.ToLower(cfg.Mechanism) {
+	case "plain":
+		return plain.Mechanism{Username: cfg.Username, Password: cfg.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, cfg.Username, cfg.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, cfg.Username, cfg.Password)
+	default:
+		return nil, fmt.Errorf("unknown SASL mechanism %q", cfg.Mechanism)
+	}
+}
+
+func (s *Kafka) Send(ctx context.Context, _ string, e *event.Event) error {
+	value, err := s.encode(e)
+	if err != nil {
+		return err
+	}
+	return s.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(strconv.FormatInt(e.ChannelID, 10)),
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: "type", Value: []byte(e.Type)},
+		},
+	})
+}
+
+func (s *Kafka) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,92 @@
+package sink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-faster/errors"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+)
+
+// NATS publishes events to JetStream and waits for the stream ack, so an
+// event leaves the outbox only after the server stored it.
+type NATS struct {
+	encoder
+	conn     *nats.Conn
+	js       jetstream.JetStream
+	subject  string
+	subjects map[string]string
+}
+
+func NewNATS(cfg config.NATSConfig) (*NATS, error) {
+	// Defaults from struct tags are not applied to entries of the sinks list.
+	if cfg.URL == "" {
+		cfg.URL = nats.DefaultURL
+	}
+	if cfg.Subject == "" {
+		cfg.Subject = "tg.{channel_id}.{type}"
+	}
+	opts := []nats.Option{nats.Name(cfg.Name)}
+	if cfg.CredentialsFile != "" {
+		opts = append(opts, nats.UserCredentials(cfg.CredentialsFile))
+	}
+
+	conn, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "connect to nats")
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "jetstream")
+	}
+
+	return &NATS{
+		conn:     conn,
+		js:       js,
+		subject:  cfg.Subject,
+		subjects: cfg.Subjects,
+	}, nil
+}
+
+func (s *NATS) Send(ctx context.Context, _ string, e *event.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	// Retries of the same event carry the same ID and are dropped by the
+	// stream's duplicate window.
+	sum := sha256.Sum256(data)
+	if s.template != nil {
+		if data, err = s.template.Render(e); err != nil {
+			return err
+		}
+	}
+	msg := nats.NewMsg(s.subjectFor(e))
+	msg.Data = data
+	msg.Header.Set("type", e.Type)
+
+	_, err = s.js.PublishMsg(ctx, msg, jetstream.WithMsgID(hex.EncodeToString(sum[:16])))
+	return err
+}
+
+func (s *NATS) subjectFor(e *event.Event) string {
+	if subject, ok := s.subjects[e.Type]; ok {
+		return expandKey(subject, e)
+	}
+	return expandKey(s.subject, e)
+}
+
+func (s *NATS) Close() error {
+	if err := s.conn.Drain(); err != nil {
+		return fmt.Errorf("drain nats connection: %w", err)
+	}
+	return nil
+}
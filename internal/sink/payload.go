@@ -0,0 +1,129 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/media"
+	"go-tg.com/internal/metrics"
+)
+
+// fit encodes events and shrinks payloads over webhook.max_payload_size with
+// the webhook.oversize strategies in order until one fits. Shrunk events are
+// copies, the events themselves stay as they are.
+func (s *Webhook) fit(ctx context.Context, cfg *config.Config, events []*event.Event, encode func([]*event.Event) ([]byte, error)) ([]byte, error) {
+	body, err := encode(events)
+	limit := cfg.Webhook.MaxPayloadSize
+	if err != nil || limit <= 0 || len(body) <= limit {
+		return body, err
+	}
+
+	size := len(body)
+	events = slices.Clone(events)
+	for _, strategy := range cfg.Webhook.Oversize {
+		switch strategy {
+		case config.OversizeExternalizeMedia:
+			if s.storage == nil {
+				continue
+			}
+			for i, e := range events {
+				if events[i], err = s.externalizeMedia(ctx, e); err != nil {
+					return nil, errors.Wrap(err, "externalize media")
+				}
+			}
+			body, err = encode(events)
+		case config.OversizeTruncateText:
+			// The longest text is cut first, in a batch several may have to be.
+			for err == nil && len(body) > limit {
+				i := longestText(events)
+				if i < 0 {
+					break
+				}
+				events[i] = events[i].Shortened(len(body) - limit)
+				body, err = encode(events)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(body) <= limit {
+			metrics.WebhookOversized.WithLabelValues(strategy).Inc()
+			return body, nil
+		}
+	}
+	metrics.WebhookOversized.WithLabelValues("rejected").Inc()
+	return nil, fmt.Errorf("payload of %d bytes exceeds webhook.max_payload_size of %d", size, limit)
+}
+
+// longestText returns the index of the event with the longest text, -1 when
+// all texts are empty.
+func longestText(events []*event.Event) int {
+	longest := -1
+	for i, e := range events {
+		if e.Text != "" && (longest < 0 || len(e.Text) > len(events[longest].Text)) {
+			longest = i
+		}
+	}
+	return longest
+}
+
+// externalizeMedia saves the media blocks of e as JSON to the media storage,
+// under "<channelID>/<external ID>/media-<type>.json" next to downloaded
+// files, and returns a copy of e linking to them instead.
+func (s *Webhook) externalizeMedia(ctx context.Context, e *event.Event) (*event.Event, error) {
+	if e.Media == nil && len(e.Album) == 0 {
+		return e, nil
+	}
+	data, err := json.Marshal(struct {
+		Media *media.Media   `json:"media,omitempty"`
+		Album []*media.Media `json:"album,omitempty"`
+	}{e.Media, e.Album})
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "tg-payload-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "create temp file")
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	key := path.Join(fmt.Sprint(e.ChannelID), path.Base(e.ExternalID), "media-"+e.Type+".json")
+	url, err := s.storage.Save(ctx, key, tmp, "application/json")
+	if err != nil {
+		return nil, errors.Wrap(err, "save")
+	}
+	c := *e
+	c.Media, c.Album, c.MediaLink = nil, nil, url
+	return &c, nil
+}
+
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,64 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"go-tg.com/internal/event"
+)
+
+// Sink delivers events to an external system.
+type Sink interface {
+	// Send delivers e. A nil error means the event was accepted.
+	// target is an optional per-channel destination override (e.g. a webhook URL);
+	// sinks without such a notion ignore it.
+	Send(ctx context.Context, target string, e *event.Event) error
+	Close() error
+}
+
+// BatchSink is a sink that can deliver several events in one request.
+type BatchSink interface {
+	Sink
+	// SendBatch delivers events to target in order, all of them or none.
+	SendBatch(ctx context.Context, target string, events []*event.Event) error
+}
+
+// Templater is a sink whose bodies can be rendered by a template.
+type Templater interface {
+	// SetTemplate makes the sink send the output of t instead of the event JSON.
+	SetTemplate(t *event.Template)
+}
+
+// encoder renders event bodies, the event JSON unless a template is set.
+// Sinks embed it to implement Templater.
+type encoder struct {
+	template *event.Template
+}
+
+func (c *encoder) SetTemplate(t *event.Template) {
+	c.template = t
+}
+
+func (c *encoder) encode(e *event.Event) ([]byte, error) {
+	if c.template != nil {
+		return c.template.Render(e)
+	}
+	return json.Marshal(e)
+}
+
+// expandKey fills {channel_id}, {channel_username}, {topic_id} and {type}
+// in a subject or routing key template.
+func expandKey(template string, e *event.Event) string {
+	username := e.ChannelUsername
+	if username == "" {
+		username = "_"
+	}
+	return strings.NewReplacer(
+		"{channel_id}", strconv.FormatInt(e.ChannelID, 10),
+		"{channel_username}", username,
+		"{topic_id}", strconv.Itoa(e.TopicID),
+		"{type}", e.Type,
+	).Replace(template)
+}
@@ -0,0 +1,201 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/media"
+)
+
+// slackTextLimit is the longest text of a Block Kit section.
+const slackTextLimit = 3000
+
+// Slack posts events to a Slack incoming webhook as Block Kit messages: the
+// chat and author, the text, photos as images and a link to the post.
+type Slack struct {
+	encoder
+	client *http.Client
+	cfg    *config.Store
+	url    string
+}
+
+func NewSlack(cfg *config.Store, url string) (*Slack, error) {
+	client, err := newWebhookClient(cfg.Load().Webhook)
+	if err != nil {
+		return nil, err
+	}
+	return &Slack{client: client, cfg: cfg, url: url}, nil
+}
+
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+	ImageURL string      `json:"image_url,omitempty"`
+	AltText  string      `json:"alt_text,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (s *Slack) Send(ctx context.Context, _ string, e *event.Event) error {
+	var (
+		body []byte
+		err  error
+	)
+	if s.template != nil {
+		body, err = s.template.Render(e)
+	} else {
+		body, err = json.Marshal(slackMessageOf(e))
+	}
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, s.client, s.cfg.Load().Webhook.Timeout, s.url, body)
+}
+
+func (s *Slack) Close() error {
+	return nil
+}
+
+func slackMessageOf(e *event.Event) slackMessage {
+	header := "*" + slackEscape(e.ChatName()) + "*"
+	if e.Author != nil && e.Author.Signature != "" {
+		header += " · " + slackEscape(e.Author.Signature)
+	}
+	if label := eventLabel(e); label != "" {
+		header += " · _" + label + "_"
+	}
+
+	msg := slackMessage{
+		Text: slackEscape(e.ChatName() + ": " + summary(e)),
+		Blocks: []slackBlock{
+			{Type: "context", Elements: []slackText{{Type: "mrkdwn", Text: header}}},
+		},
+	}
+	if e.Text != "" {
+		text, _ := truncate(e.Text, slackTextLimit)
+		msg.Blocks = append(msg.Blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: slackEscape(text)}})
+	}
+	for _, m := range eventMedia(e) {
+		switch {
+		case !isPublic(m.URL):
+			continue
+		case m.Type == "photo":
+			msg.Blocks = append(msg.Blocks, slackBlock{Type: "image", ImageURL: m.URL, AltText: m.FileName})
+		default:
+			link := fmt.Sprintf("<%s|%s>", m.URL, slackEscape(m.FileName))
+			msg.Blocks = append(msg.Blocks, slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: link}})
+		}
+	}
+	if link := e.Link(); link != "" {
+		msg.Blocks = append(msg.Blocks, slackBlock{
+			Type:     "context",
+			Elements: []slackText{{Type: "mrkdwn", Text: "<" + link + "|Open in Telegram>"}},
+		})
+	}
+	return msg
+}
+
+// slackEscape escapes the characters Slack treats as markup in mrkdwn.
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// eventLabel describes events other than new posts, empty for new and old messages.
+func eventLabel(e *event.Event) string {
+	switch e.Type {
+	case "newMessage", "oldMessage":
+		return ""
+	case "editMessage":
+		return "edited"
+	case "deleteMessage":
+		return fmt.Sprintf("%d message(s) deleted", len(e.MessageIDs))
+	default:
+		return e.Type
+	}
+}
+
+// summary is the plain notification text of an event.
+func summary(e *event.Event) string {
+	text, _ := truncate(e.Text, 200)
+	if text == "" {
+		text = eventLabel(e)
+	}
+	if text == "" && (e.Media != nil || len(e.Album) > 0) {
+		text = "media"
+	}
+	return text
+}
+
+// eventMedia lists the media of a single message or of an album.
+func eventMedia(e *event.Event) []*media.Media {
+	if len(e.Album) > 0 {
+		return e.Album
+	}
+	if e.Media != nil {
+		return []*media.Media{e.Media}
+	}
+	return nil
+}
+
+// isPublic tells URLs a chat service can open apart from empty ones and file
+// paths of the local media storage without base_url.
+func isPublic(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+// truncate cuts text to limit runes ending with "…".
+func truncate(text string, limit int) (string, bool) {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text, false
+	}
+	return string(runes[:limit-1]) + "…", true
+}
+
+// postJSON posts body to a chat service webhook within timeout.
+func postJSON(ctx context.Context, client *http.Client, timeout time.Duration, url string, body []byte) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return doRequest(client, req)
+}
+
+// doRequest sends req and fails unless the response is 2xx.
+func doRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code: %d, body: %q", resp.StatusCode, body)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+)
+
+// Status is a step of the delivery of an event to a sink.
+type Status string
+
+const (
+	// StatusQueued is reported once the event is written to the outbox.
+	StatusQueued Status = "queued"
+	// StatusDelivering is reported before every attempt.
+	StatusDelivering Status = "delivering"
+	// StatusDelivered is reported once the sink accepted the event.
+	StatusDelivered Status = "delivered"
+	// StatusAcknowledged is reported once the consumer acknowledged a
+	// delivered event, for sinks waiting for acknowledgments.
+	StatusAcknowledged Status = "acknowledged"
+	// StatusFailed is reported for every failed attempt, Final once the
+	// event was given up on.
+	StatusFailed Status = "failed"
+)
+
+// StatusUpdate tells where the delivery of an event to a sink stands.
+// DeliveryID is the outbox entry of the event, the same for all updates of
+// one delivery.
+type StatusUpdate struct {
+	Status     Status    `json:"status"`
+	Sink       string    `json:"sink,omitempty"`
+	DeliveryID string    `json:"delivery_id"`
+	Type       string    `json:"type"`
+	ExternalID string    `json:"external_id"`
+	ChannelID  int64     `json:"channel_id"`
+	Attempt    int       `json:"attempt,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Final      bool      `json:"final,omitempty"`
+	Time       time.Time `json:"time"`
+	// Posted is when the message of the event was posted, see
+	// event.Event.Posted.
+	Posted time.Time `json:"-"`
+}
+
+// NewStatusUpdate describes the delivery of e.
+func NewStatusUpdate(status Status, deliveryID string, e *event.Event) StatusUpdate {
+	return StatusUpdate{
+		Status:     status,
+		DeliveryID: deliveryID,
+		Type:       e.Type,
+		ExternalID: e.ExternalID,
+		ChannelID:  e.ChannelID,
+		Time:       time.Now(),
+		Posted:     e.Posted(),
+	}
+}
+
+// StatusObserver is told about the delivery of events. A sink implementing
+// it learns about its own events. Observers are called on the delivery path
+// and must not block.
+type StatusObserver interface {
+	DeliveryStatus(ctx context.Context, u StatusUpdate)
+}
+
+// statusCloseTimeout bounds how long Close posts the queued updates.
+const statusCloseTimeout = 5 * time.Second
+
+// StatusWebhook posts status updates to a URL in the background, one JSON
+// object per request signed like webhook payloads. Updates are dropped while
+// its queue is full, so deliveries never wait for it.
+type StatusWebhook struct {
+	webhook *Webhook
+	url     string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mux     sync.RWMutex
+	closed  bool
+	updates chan StatusUpdate
+}
+
+func NewStatusWebhook(cfg *config.Store, url string, queueSize int) (*StatusWebhook, error) {
+	webhook, err := NewWebhook(cfg, url)
+	if err != nil {
+		return nil, err
+	}
+	s := &StatusWebhook{webhook: webhook, url: url, done: make(chan struct{}), updates: make(chan StatusUpdate, queueSize)}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	go s.run()
+	return s, nil
+}
+
+func (s *StatusWebhook) DeliveryStatus(_ context.Context, u StatusUpdate) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.updates <- u:
+	default:
+		metrics.StatusUpdates.WithLabelValues("dropped").Inc()
+	}
+}
+
+func (s *StatusWebhook) run() {
+	defer close(s.done)
+	for u := range s.updates {
+		body, err := json.Marshal(u)
+		if err == nil {
+			err = s.webhook.Post(s.ctx, s.url, body)
+		}
+		if err != nil {
+			metrics.StatusUpdates.WithLabelValues("failed").Inc()
+			continue
+		}
+		metrics.StatusUpdates.WithLabelValues("sent").Inc()
+	}
+}
+
+// Close posts the queued updates for a few seconds, the rest is dropped.
+func (s *StatusWebhook) Close() error {
+	s.mux.Lock()
+	s.closed = true
+	close(s.updates)
+	s.mux.Unlock()
+
+	select {
+	case <-s.done:
+	case <-time.After(statusCloseTimeout):
+		s.cancel()
+		<-s.done
+	}
+	s.cancel()
+	return s.webhook.Close()
+}
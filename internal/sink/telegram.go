@@ -0,0 +1,266 @@
+package sink
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"reflect"
+	"sync"
+
+	"github.com/go-faster/errors"
+	"github.com/gotd/td/telegram/message/entity"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+)
+
+// captionLimit is the longest media caption in UTF-16 code units, longer
+// texts are posted as a message of their own after the media.
+const captionLimit = 1024
+
+// TelegramClient is the account the telegram sink posts with.
+type TelegramClient interface {
+	API() *tg.Client
+	// ResolvePeer resolves the destination chat.
+	ResolvePeer(ctx context.Context, target string) (tg.InputPeerClass, error)
+	// SourceMessages fetches the messages of an event, nil when they are gone.
+	SourceMessages(ctx context.Context, e *event.Event) ([]*tg.Message, error)
+	// Act performs the action of a webhook response on the message of e.
+	Act(ctx context.Context, e *event.Event, a Action) error
+}
+
+// TelegramClients are the accounts telegram sinks can post with. Sinks are
+// created before the clients, accounts register here once they are set up.
+type TelegramClients struct {
+	mux     sync.RWMutex
+	clients map[string]TelegramClient
+	first   string
+}
+
+func NewTelegramClients() *TelegramClients {
+	return &TelegramClients{clients: map[string]TelegramClient{}}
+}
+
+// Register makes the client of the named account available, the first one
+// registered is the default.
+func (c *TelegramClients) Register(name string, client TelegramClient) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if len(c.clients) == 0 {
+		c.first = name
+	}
+	c.clients[name] = client
+}
+
+// Get returns the client of the named account, the default one for an
+// empty name.
+func (c *TelegramClients) Get(name string) (TelegramClient, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	if name == "" {
+		name = c.first
+	}
+	client, ok := c.clients[name]
+	return client, ok
+}
+
+// Telegram copies messages into a chat of ours with a watcher account: the
+// text with its formatting and the photos and documents, which are reused
+// without downloading them. Events other than new, old and edited messages
+// are dropped.
+type Telegram struct {
+	clients *TelegramClients
+	cfg     config.TelegramSinkConfig
+	prefix  *event.Template
+
+	mux  sync.Mutex
+	peer tg.InputPeerClass
+}
+
+func NewTelegram(clients *TelegramClients, cfg config.TelegramSinkConfig) (*Telegram, error) {
+	s := &Telegram{clients: clients, cfg: cfg}
+	if cfg.Prefix != "" {
+		prefix, err := event.ParseTemplate("prefix", cfg.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		s.prefix = prefix
+	}
+	return s, nil
+}
+
+func (s *Telegram) Send(ctx context.Context, _ string, e *event.Event) error {
+	switch e.Type {
+	case "newMessage", "oldMessage", "editMessage":
+	default:
+		return nil
+	}
+
+	client, ok := s.clients.Get(s.cfg.Account)
+	if !ok {
+		return errors.New("telegram account is not connected yet")
+	}
+	peer, err := s.resolve(ctx, client)
+	if err != nil {
+		return errors.Wrapf(err, "resolve %s", s.cfg.Chat)
+	}
+	prefix := ""
+	if s.prefix != nil {
+		if prefix, err = s.prefix.Execute(e); err != nil {
+			return err
+		}
+	}
+	msgs, err := client.SourceMessages(ctx, e)
+	if err != nil {
+		return errors.Wrap(err, "get source message")
+	}
+
+	m := mirror{api: client.API(), peer: peer, randomID: randomID(e)}
+	if len(msgs) == 0 {
+		// The original is gone, post what the event has.
+		err = m.sendText(ctx, prefix+e.Text, nil)
+	} else {
+		err = m.send(ctx, prefix, msgs)
+	}
+	if tgerr.Is(err, "RANDOM_ID_DUPLICATE") {
+		// Posted by an earlier attempt whose answer got lost.
+		return nil
+	}
+	return err
+}
+
+func (s *Telegram) Close() error {
+	return nil
+}
+
+// resolve resolves the destination chat once.
+func (s *Telegram) resolve(ctx context.Context, client TelegramClient) (tg.InputPeerClass, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.peer != nil {
+		return s.peer, nil
+	}
+	peer, err := client.ResolvePeer(ctx, s.cfg.Chat)
+	if err != nil {
+		return nil, err
+	}
+	s.peer = peer
+	return peer, nil
+}
+
+// randomID is the same for every attempt to send an event, so Telegram
+// rejects repeated posts.
+func randomID(e *event.Event) int64 {
+	sum := sha256.Sum256([]byte(e.DedupKey()))
+	return int64(binary.BigEndian.Uint64(sum[:8]))
+}
+
+type mirror struct {
+	api      *tg.Client
+	peer     tg.InputPeerClass
+	randomID int64
+}
+
+// send posts the messages, an album as one, with prefix before the first text.
+func (m mirror) send(ctx context.Context, prefix string, msgs []*tg.Message) error {
+	var (
+		media    []tg.InputSingleMedia
+		text     string
+		entities []tg.MessageEntityClass
+	)
+	for _, msg := range msgs {
+		if text == "" && msg.Message != "" {
+			text, entities = prefix+msg.Message, shiftEntities(msg.Entities, entity.ComputeLength(prefix))
+		}
+		if input, ok := inputMedia(msg.Media); ok {
+			media = append(media, tg.InputSingleMedia{Media: input, RandomID: m.randomID + int64(len(media))})
+		}
+	}
+	if text == "" {
+		text = prefix
+	}
+
+	caption := text
+	if entity.ComputeLength(text) > captionLimit {
+		caption = ""
+	}
+	switch len(media) {
+	case 0:
+		return m.sendText(ctx, text, entities)
+	case 1:
+		req := &tg.MessagesSendMediaRequest{Peer: m.peer, Media: media[0].Media, RandomID: m.randomID}
+		if caption != "" {
+			req.Message, req.Entities = caption, entities
+		}
+		if _, err := m.api.MessagesSendMedia(ctx, req); err != nil {
+			return err
+		}
+	default:
+		if caption != "" {
+			media[0].Message, media[0].Entities = caption, entities
+		}
+		if _, err := m.api.MessagesSendMultiMedia(ctx, &tg.MessagesSendMultiMediaRequest{Peer: m.peer, MultiMedia: media}); err != nil {
+			return err
+		}
+	}
+	if caption == "" && text != "" {
+		m.randomID += int64(len(media))
+		return m.sendText(ctx, text, entities)
+	}
+	return nil
+}
+
+func (m mirror) sendText(ctx context.Context, text string, entities []tg.MessageEntityClass) error {
+	if text == "" {
+		return nil
+	}
+	_, err := m.api.MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+		Peer:     m.peer,
+		Message:  text,
+		Entities: entities,
+		RandomID: m.randomID,
+	})
+	return err
+}
+
+// inputMedia reuses photos and documents of a message, other media (polls,
+// link previews, locations) is left out.
+func inputMedia(media tg.MessageMediaClass) (tg.InputMediaClass, bool) {
+	switch media := media.(type) {
+	case *tg.MessageMediaPhoto:
+		if photo, ok := media.Photo.(*tg.Photo); ok {
+			return &tg.InputMediaPhoto{ID: photo.AsInput()}, true
+		}
+	case *tg.MessageMediaDocument:
+		if doc, ok := media.Document.(*tg.Document); ok {
+			return &tg.InputMediaDocument{ID: doc.AsInput()}, true
+		}
+	}
+	return nil, false
+}
+
+// shiftEntities moves entities behind a prefix of n UTF-16 code units. Every
+// entity type has an Offset field but no setter, so it is set by reflection
+// on copies of the originals.
+func shiftEntities(entities []tg.MessageEntityClass, n int) []tg.MessageEntityClass {
+	if n == 0 {
+		return entities
+	}
+	shifted := make([]tg.MessageEntityClass, 0, len(entities))
+	for _, e := range entities {
+		v := reflect.ValueOf(e)
+		if v.Kind() != reflect.Pointer {
+			continue
+		}
+		c := reflect.New(v.Elem().Type())
+		c.Elem().Set(v.Elem())
+		offset := c.Elem().FieldByName("Offset")
+		if !offset.IsValid() || !offset.CanSet() {
+			continue
+		}
+		offset.SetInt(offset.Int() + int64(n))
+		shifted = append(shifted, c.Interface().(tg.MessageEntityClass))
+	}
+	return shifted
+}
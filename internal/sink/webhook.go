@@ -0,0 +1,325 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-faster/errors"
+	"go-tg.com/internal/config"
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/media"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	signatureHeader = "X-Signature"
+	timestampHeader = "X-Timestamp"
+	// idempotencyHeader carries the idempotency key of single events.
+	idempotencyHeader = "Idempotency-Key"
+
+	cloudEventsContentType      = "application/cloudevents+json"
+	cloudEventsBatchContentType = "application/cloudevents-batch+json"
+)
+
+// signPayload returns "sha256=<hex>" of HMAC-SHA256 over "<timestamp>.<body>".
+// The timestamp is part of the signed data so a captured request can't be
+// replayed later with a fresh timestamp header.
+func signPayload(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// transports are shared by the webhook clients with the same TLS and pool
+// settings, so all sinks posting to a host reuse the same connections.
+var transports = struct {
+	sync.Mutex
+	m map[transportKey]*http.Transport
+}{m: map[transportKey]*http.Transport{}}
+
+type transportKey struct {
+	tls  config.TLSConfig
+	pool config.PoolConfig
+}
+
+// CloseIdleConnections closes the idle connections of the shared transports,
+// once all sinks are closed.
+func CloseIdleConnections() {
+	transports.Lock()
+	defer transports.Unlock()
+	for _, transport := range transports.m {
+		transport.CloseIdleConnections()
+	}
+}
+
+// newWebhookClient builds the HTTP client used for all webhook requests.
+// TLS and pool settings are applied once, timeout and headers are read per
+// request so they follow config reloads.
+func newWebhookClient(cfg config.WebhookConfig) (*http.Client, error) {
+	transport, err := sharedTransport(cfg.TLS, cfg.Pool)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+func sharedTransport(tlsCfg config.TLSConfig, pool config.PoolConfig) (*http.Transport, error) {
+	transports.Lock()
+	defer transports.Unlock()
+
+	key := transportKey{tls: tlsCfg, pool: pool}
+	if transport, ok := transports.m[key]; ok {
+		return transport, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: tlsCfg.InsecureSkipVerify,
+	}
+
+	if tlsCfg.CAFile != "" {
+		ca, err := os.ReadFile(tlsCfg.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read CA bundle")
+		}
+		certs := x509.NewCertPool()
+		if !certs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", tlsCfg.CAFile)
+		}
+		tlsConfig.RootCAs = certs
+	}
+
+	if tlsCfg.CertFile != "" || tlsCfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCfg.CertFile, tlsCfg.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxIdleConns = pool.MaxIdleConns
+	transport.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = pool.MaxConnsPerHost
+	transport.IdleConnTimeout = pool.IdleConnTimeout
+	transport.DisableKeepAlives = pool.DisableKeepAlives
+	if pool.DisableHTTP2 {
+		// A non-nil empty map keeps the transport from upgrading to HTTP/2.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	transports.m[key] = transport
+	return transport, nil
+}
+
+// Webhook POSTs events as JSON. Headers, secret, timeout, compression and
+// the payload size limit are read from the current config on every request.
+// A template replaces the event JSON as well as the cloudevents format.
+type Webhook struct {
+	encoder
+	client  *http.Client
+	cfg     *config.Store
+	url     string
+	actor   Actor
+	storage media.Storage
+}
+
+// NewWebhook creates a webhook sink. A non-empty url pins the destination,
+// otherwise the per-channel target or the global webhook_url is used.
+func NewWebhook(cfg *config.Store, url string) (*Webhook, error) {
+	client, err := newWebhookClient(cfg.Load().Webhook)
+	if err != nil {
+		return nil, err
+	}
+	return &Webhook{client: client, cfg: cfg, url: url}, nil
+}
+
+func (s *Webhook) Send(ctx context.Context, target string, e *event.Event) error {
+	cfg := s.cfg.Load()
+	target = s.target(cfg, target)
+	contentType := "application/json"
+	if s.template == nil && cfg.Webhook.Format == "cloudevents" {
+		contentType = cloudEventsContentType
+	}
+	body, err := s.fit(ctx, cfg, []*event.Event{e}, func(events []*event.Event) ([]byte, error) {
+		return s.encodeEvent(cfg, events[0])
+	})
+	if err != nil {
+		return err
+	}
+
+	wantAction := s.actor != nil && len(cfg.Webhook.Actions.Allow) > 0
+	action, ok, err := s.do(ctx, target, contentType, body, e.Key(), wantAction)
+	if err != nil {
+		return err
+	}
+	if ok {
+		s.actor.Act(ctx, e, action)
+	}
+	return nil
+}
+
+// SetActor makes the sink perform the actions of webhook responses to
+// single events, when webhook.actions allows any.
+func (s *Webhook) SetActor(actor Actor) {
+	s.actor = actor
+}
+
+// SetStorage gives the sink the media storage the externalize_media
+// strategy of webhook.oversize saves to, without it the strategy is skipped.
+func (s *Webhook) SetStorage(storage media.Storage) {
+	s.storage = storage
+}
+
+// SendBatch POSTs events as one JSON array, in the cloudevents format as a
+// CloudEvents batch. With a template the array holds the rendered bodies.
+func (s *Webhook) SendBatch(ctx context.Context, target string, events []*event.Event) error {
+	cfg := s.cfg.Load()
+	target = s.target(cfg, target)
+	contentType := "application/json"
+	if s.template == nil && cfg.Webhook.Format == "cloudevents" {
+		contentType = cloudEventsBatchContentType
+	}
+	body, err := s.fit(ctx, cfg, events, func(events []*event.Event) ([]byte, error) {
+		return s.encodeBatch(cfg, events)
+	})
+	if err != nil {
+		return err
+	}
+	return s.post(ctx, target, contentType, body)
+}
+
+// encodeEvent renders the body of a single event.
+func (s *Webhook) encodeEvent(cfg *config.Config, e *event.Event) ([]byte, error) {
+	switch {
+	case s.template != nil:
+		return s.template.Render(e)
+	case cfg.Webhook.Format == "cloudevents":
+		ce, err := e.CloudEvent(cfg.Webhook.CloudEvents.Source, cfg.Webhook.CloudEvents.TypePrefix)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(ce)
+	default:
+		return json.Marshal(e)
+	}
+}
+
+// encodeBatch renders the body of a batch.
+func (s *Webhook) encodeBatch(cfg *config.Config, events []*event.Event) ([]byte, error) {
+	if s.template == nil && cfg.Webhook.Format != "cloudevents" {
+		return json.Marshal(events)
+	}
+	batch := make([]json.RawMessage, 0, len(events))
+	for _, e := range events {
+		body, err := s.encodeEvent(cfg, e)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, body)
+	}
+	// Fails unless every rendered body is JSON.
+	return json.Marshal(batch)
+}
+
+// target picks the destination: the pinned url, the per-channel target or
+// the global webhook_url.
+func (s *Webhook) target(cfg *config.Config, target string) string {
+	if s.url != "" {
+		return s.url
+	}
+	if target == "" {
+		return cfg.TgApp.WebhookUrl
+	}
+	return target
+}
+
+// Close keeps the connections, the transport is shared with the other
+// sinks, see CloseIdleConnections.
+func (s *Webhook) Close() error {
+	return nil
+}
+
+// Post sends a raw JSON body to webHookUrl.
+func (s *Webhook) Post(ctx context.Context, webHookUrl string, postBody []byte) error {
+	return s.post(ctx, webHookUrl, "application/json", postBody)
+}
+
+func (s *Webhook) post(ctx context.Context, webHookUrl, contentType string, postBody []byte) error {
+	_, _, err := s.do(ctx, webHookUrl, contentType, postBody, "", false)
+	return err
+}
+
+// do POSTs the body with the idempotency key, if any, and, with wantAction,
+// reads the action of the response.
+func (s *Webhook) do(ctx context.Context, webHookUrl, contentType string, postBody []byte, key string, wantAction bool) (Action, bool, error) {
+	cfg := s.cfg.Load()
+	if cfg.Webhook.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Webhook.Timeout)
+		defer cancel()
+	}
+
+	// The signature covers the body before compression.
+	body := postBody
+	if cfg.Webhook.Compression == "gzip" {
+		var err error
+		if body, err = gzipBody(postBody); err != nil {
+			return Action{}, false, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webHookUrl, bytes.NewReader(body))
+	if err != nil {
+		return Action{}, false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if cfg.Webhook.Compression == "gzip" {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if key != "" {
+		req.Header.Set(idempotencyHeader, key)
+	}
+	for name, value := range cfg.Webhook.Headers {
+		req.Header.Set(name, value)
+	}
+	// Lets receivers continue the trace of the update, a no-op without tracing.
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	if secret := cfg.TgApp.WebhookSecret; secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(timestampHeader, timestamp)
+		req.Header.Set(signatureHeader, signPayload(secret, timestamp, postBody))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return Action{}, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Action{}, false, fmt.Errorf("unexpected status code: %d, body: %q", resp.StatusCode, body)
+	}
+	if !wantAction {
+		return Action{}, false, nil
+	}
+	action, ok := readAction(resp.Header.Get("Content-Type"), resp.Body)
+	return action, ok, nil
+}
@@ -0,0 +1,385 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-faster/errors"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+var schemas = map[string][]string{
+	DriverSQLite: {
+		`CREATE TABLE IF NOT EXISTS messages (
+			channel_id  INTEGER NOT NULL,
+			message_id  INTEGER NOT NULL,
+			text        TEXT NOT NULL,
+			date        INTEGER NOT NULL,
+			edit_date   INTEGER NOT NULL DEFAULT 0,
+			deleted_at  INTEGER,
+			raw         TEXT NOT NULL,
+			created_at  INTEGER NOT NULL,
+			updated_at  INTEGER NOT NULL,
+			PRIMARY KEY (channel_id, message_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS message_edits (
+			id          INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel_id  INTEGER NOT NULL,
+			message_id  INTEGER NOT NULL,
+			text        TEXT NOT NULL,
+			edit_date   INTEGER NOT NULL,
+			raw         TEXT NOT NULL,
+			replaced_at INTEGER NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS message_edits_message ON message_edits (channel_id, message_id)`,
+		`CREATE INDEX IF NOT EXISTS messages_date ON messages (channel_id, date)`,
+		// Full-text index of the texts, kept up to date by triggers.
+		`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(text, content='messages', content_rowid='rowid')`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_insert AFTER INSERT ON messages BEGIN
+			INSERT INTO messages_fts (rowid, text) VALUES (new.rowid, new.text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_delete AFTER DELETE ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, text) VALUES ('delete', old.rowid, old.text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS messages_fts_update AFTER UPDATE OF text ON messages BEGIN
+			INSERT INTO messages_fts (messages_fts, rowid, text) VALUES ('delete', old.rowid, old.text);
+			INSERT INTO messages_fts (rowid, text) VALUES (new.rowid, new.text);
+		END`,
+		`CREATE TABLE IF NOT EXISTS channel_snapshots (
+			id           INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel_id   INTEGER NOT NULL,
+			title        TEXT NOT NULL,
+			username     TEXT NOT NULL,
+			about        TEXT NOT NULL,
+			participants INTEGER NOT NULL,
+			taken_at     INTEGER NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS channel_snapshots_channel ON channel_snapshots (channel_id, id)`,
+	},
+	DriverPostgres: {
+		`CREATE TABLE IF NOT EXISTS messages (
+			channel_id  BIGINT NOT NULL,
+			message_id  BIGINT NOT NULL,
+			text        TEXT NOT NULL,
+			date        BIGINT NOT NULL,
+			edit_date   BIGINT NOT NULL DEFAULT 0,
+			deleted_at  BIGINT,
+			raw         JSONB NOT NULL,
+			created_at  BIGINT NOT NULL,
+			updated_at  BIGINT NOT NULL,
+			PRIMARY KEY (channel_id, message_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS message_edits (
+			id          BIGSERIAL PRIMARY KEY,
+			channel_id  BIGINT NOT NULL,
+			message_id  BIGINT NOT NULL,
+			text        TEXT NOT NULL,
+			edit_date   BIGINT NOT NULL,
+			raw         JSONB NOT NULL,
+			replaced_at BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS message_edits_message ON message_edits (channel_id, message_id)`,
+		`CREATE INDEX IF NOT EXISTS messages_date ON messages (channel_id, date)`,
+		`CREATE INDEX IF NOT EXISTS messages_text_search ON messages USING GIN (to_tsvector('simple', text))`,
+		`CREATE TABLE IF NOT EXISTS channel_snapshots (
+			id           BIGSERIAL PRIMARY KEY,
+			channel_id   BIGINT NOT NULL,
+			title        TEXT NOT NULL,
+			username     TEXT NOT NULL,
+			about        TEXT NOT NULL,
+			participants BIGINT NOT NULL,
+			taken_at     BIGINT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS channel_snapshots_channel ON channel_snapshots (channel_id, id)`,
+	},
+}
+
+// Message is an archived channel message. Raw is the message as received
+// from Telegram, encoded as JSON. DeletedAt is only filled by Query.
+type Message struct {
+	ChannelID int64
+	MessageID int
+	Text      string
+	Date      int
+	EditDate  int
+	DeletedAt int
+	Raw       []byte
+}
+
+// ChannelSnapshot is the metadata of a channel at TakenAt in unix seconds.
+type ChannelSnapshot struct {
+	ChannelID    int64
+	Title        string
+	Username     string
+	About        string
+	Participants int
+	TakenAt      int64
+}
+
+// Archive keeps every observed message with its edit history and the
+// channel snapshots in SQLite or PostgreSQL.
+type Archive struct {
+	db     *sql.DB
+	driver string
+}
+
+func Open(ctx context.Context, driver, dsn string) (*Archive, error) {
+	schema, ok := schemas[driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown archive driver %q", driver)
+	}
+	sqlDriver := driver
+	if driver == DriverPostgres {
+		sqlDriver = "pgx"
+	}
+
+	db, err := sql.Open(sqlDriver, dsn)
+	if err != nil {
+		return nil, errors.Wrap(err, "open archive")
+	}
+	if driver == DriverSQLite {
+		// SQLite allows a single writer, serialize access instead of failing with SQLITE_BUSY.
+		db.SetMaxOpenConns(1)
+	}
+	if err := migrate(ctx, db, driver, schema); err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, "migrate archive")
+	}
+	return &Archive{db: db, driver: driver}, nil
+}
+
+func migrate(ctx context.Context, db *sql.DB, driver string, schema []string) error {
+	indexed := true
+	if driver == DriverSQLite {
+		err := db.QueryRowContext(ctx, `SELECT 1 FROM sqlite_master WHERE name = 'messages_fts'`).Scan(new(int))
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			indexed = false
+		case err != nil:
+			return err
+		}
+	}
+	for _, stmt := range schema {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if !indexed {
+		// Index the messages archived before full-text search was added.
+		if _, err := db.ExecContext(ctx, `INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')`); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Archive) Close() error {
+	return a.db.Close()
+}
+
+// Save inserts the message or updates the stored one and returns the stored
+// version, nil for a message seen the first time. When the text or the edit
+// date changed, the previous version is kept in message_edits.
+func (a *Archive) Save(ctx context.Context, m Message) (*Message, error) {
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var (
+		prevText     string
+		prevDate     int
+		prevEditDate int
+		prevRaw      string
+	)
+	err = tx.QueryRowContext(ctx, a.rebind(`SELECT text, date, edit_date, raw FROM messages WHERE channel_id = ? AND message_id = ?`),
+		m.ChannelID, m.MessageID).Scan(&prevText, &prevDate, &prevEditDate, &prevRaw)
+	var prev *Message
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return nil, errors.Wrap(err, "select message")
+	default:
+		prev = &Message{ChannelID: m.ChannelID, MessageID: m.MessageID, Text: prevText, Date: prevDate, EditDate: prevEditDate, Raw: []byte(prevRaw)}
+		if prevText == m.Text && prevEditDate == m.EditDate {
+			// Seen already, e.g. during a backfill.
+			return prev, nil
+		}
+		_, err = tx.ExecContext(ctx, a.rebind(`INSERT INTO message_edits (channel_id, message_id, text, edit_date, raw, replaced_at) VALUES (?, ?, ?, ?, ?, ?)`),
+			m.ChannelID, m.MessageID, prevText, prevEditDate, prevRaw, time.Now().Unix())
+		if err != nil {
+			return nil, errors.Wrap(err, "insert edit")
+		}
+	}
+
+	now := time.Now().Unix()
+	_, err = tx.ExecContext(ctx, a.rebind(`INSERT INTO messages (channel_id, message_id, text, date, edit_date, raw, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (channel_id, message_id) DO UPDATE SET
+			text = excluded.text, edit_date = excluded.edit_date, raw = excluded.raw, updated_at = excluded.updated_at`),
+		m.ChannelID, m.MessageID, m.Text, m.Date, m.EditDate, string(m.Raw), now, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "upsert message")
+	}
+	return prev, tx.Commit()
+}
+
+// MarkDeleted records the deletion time of messages, their content is kept.
+func (a *Archive) MarkDeleted(ctx context.Context, channelID int64, messageIDs []int) error {
+	now := time.Now().Unix()
+	for _, id := range messageIDs {
+		_, err := a.db.ExecContext(ctx, a.rebind(`UPDATE messages SET deleted_at = ?, updated_at = ? WHERE channel_id = ? AND message_id = ?`),
+			now, now, channelID, id)
+		if err != nil {
+			return errors.Wrap(err, "mark deleted")
+		}
+	}
+	return nil
+}
+
+// SaveChannelSnapshot records s unless it equals the latest snapshot of the
+// channel and returns that one, nil for the first snapshot of a channel.
+func (a *Archive) SaveChannelSnapshot(ctx context.Context, s ChannelSnapshot) (*ChannelSnapshot, error) {
+	prev := &ChannelSnapshot{ChannelID: s.ChannelID}
+	err := a.db.QueryRowContext(ctx, a.rebind(`SELECT title, username, about, participants, taken_at FROM channel_snapshots
+		WHERE channel_id = ? ORDER BY id DESC LIMIT 1`), s.ChannelID).
+		Scan(&prev.Title, &prev.Username, &prev.About, &prev.Participants, &prev.TakenAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		prev = nil
+	case err != nil:
+		return nil, errors.Wrap(err, "select channel snapshot")
+	case prev.Title == s.Title && prev.Username == s.Username && prev.About == s.About && prev.Participants == s.Participants:
+		return prev, nil
+	}
+
+	_, err = a.db.ExecContext(ctx, a.rebind(`INSERT INTO channel_snapshots (channel_id, title, username, about, participants, taken_at) VALUES (?, ?, ?, ?, ?, ?)`),
+		s.ChannelID, s.Title, s.Username, s.About, s.Participants, s.TakenAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "insert channel snapshot")
+	}
+	return prev, nil
+}
+
+// MessageQuery selects archived messages of a channel, newest first. Since
+// and Until bound the post date in unix seconds, Until excluded, BeforeID
+// continues a page after its last message. Search matches all words of the
+// text.
+type MessageQuery struct {
+	ChannelID int64
+	Since     int
+	Until     int
+	BeforeID  int
+	Search    string
+	Limit     int
+}
+
+// Query returns the messages matching q, deleted ones included.
+func (a *Archive) Query(ctx context.Context, q MessageQuery) ([]Message, error) {
+	query := `SELECT m.channel_id, m.message_id, m.text, m.date, m.edit_date, COALESCE(m.deleted_at, 0), m.raw FROM messages m`
+	where := []string{"m.channel_id = ?"}
+	args := []any{q.ChannelID}
+	if q.Search != "" {
+		if a.driver == DriverPostgres {
+			where = append(where, "to_tsvector('simple', m.text) @@ plainto_tsquery('simple', ?)")
+			args = append(args, q.Search)
+		} else {
+			query += ` JOIN messages_fts f ON f.rowid = m.rowid`
+			where = append(where, "messages_fts MATCH ?")
+			args = append(args, ftsQuery(q.Search))
+		}
+	}
+	if q.Since > 0 {
+		where = append(where, "m.date >= ?")
+		args = append(args, q.Since)
+	}
+	if q.Until > 0 {
+		where = append(where, "m.date < ?")
+		args = append(args, q.Until)
+	}
+	if q.BeforeID > 0 {
+		where = append(where, "m.message_id < ?")
+		args = append(args, q.BeforeID)
+	}
+	query += " WHERE " + strings.Join(where, " AND ") + " ORDER BY m.message_id DESC LIMIT ?"
+	args = append(args, q.Limit)
+
+	rows, err := a.db.QueryContext(ctx, a.rebind(query), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "query messages")
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var (
+			m   Message
+			raw string
+		)
+		if err := rows.Scan(&m.ChannelID, &m.MessageID, &m.Text, &m.Date, &m.EditDate, &m.DeletedAt, &raw); err != nil {
+			return nil, errors.Wrap(err, "scan message")
+		}
+		m.Raw = []byte(raw)
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// Walk calls fn for every archived message in no particular order, without
+// Raw, until fn fails.
+func (a *Archive) Walk(ctx context.Context, fn func(Message) error) error {
+	rows, err := a.db.QueryContext(ctx, `SELECT channel_id, message_id, text, date, edit_date, COALESCE(deleted_at, 0) FROM messages`)
+	if err != nil {
+		return errors.Wrap(err, "query messages")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ChannelID, &m.MessageID, &m.Text, &m.Date, &m.EditDate, &m.DeletedAt); err != nil {
+			return errors.Wrap(err, "scan message")
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ftsQuery quotes every word of a search, so FTS5 looks for all of them
+// instead of parsing its query syntax.
+func ftsQuery(search string) string {
+	words := strings.Fields(search)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// rebind turns ? placeholders into $N for PostgreSQL.
+func (a *Archive) rebind(query string) string {
+	if a.driver != DriverPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
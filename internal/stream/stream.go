@@ -0,0 +1,154 @@
+// Package stream pushes events to connected clients as they are delivered,
+// for dashboards and local tools that can't receive webhooks.
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-tg.com/internal/event"
+	"go-tg.com/internal/metrics"
+)
+
+const (
+	// clientBuffer is how many events a slow client can lag behind before
+	// events are dropped for it.
+	clientBuffer = 256
+	// keepAlive is how often an idle connection gets a comment, so proxies
+	// don't close it.
+	keepAlive = 15 * time.Second
+)
+
+// Filter selects the events of a subscription, empty lists accept all.
+type Filter struct {
+	Types    []string
+	Channels []int64
+}
+
+func (f Filter) accepts(e *event.Event) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, e.Type) {
+		return false
+	}
+	return len(f.Channels) == 0 || slices.Contains(f.Channels, e.ChannelID)
+}
+
+type subscriber struct {
+	filter Filter
+	events chan *event.Event
+}
+
+// Hub fans events out to subscribers. Publishing never blocks, a subscriber
+// that doesn't keep up misses events.
+type Hub struct {
+	mux  sync.RWMutex
+	subs map[*subscriber]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[*subscriber]struct{}{}}
+}
+
+// Publish sends e to every subscriber it passes the filter of.
+func (h *Hub) Publish(e *event.Event) {
+	h.mux.RLock()
+	defer h.mux.RUnlock()
+	for s := range h.subs {
+		if !s.filter.accepts(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			metrics.StreamDropped.Inc()
+		}
+	}
+}
+
+// Subscribe returns the events passing f until cancel is called.
+func (h *Hub) Subscribe(f Filter) (events <-chan *event.Event, cancel func()) {
+	s := &subscriber{filter: f, events: make(chan *event.Event, clientBuffer)}
+	h.mux.Lock()
+	h.subs[s] = struct{}{}
+	h.mux.Unlock()
+	metrics.StreamClients.Inc()
+
+	var once sync.Once
+	return s.events, func() {
+		once.Do(func() {
+			h.mux.Lock()
+			delete(h.subs, s)
+			h.mux.Unlock()
+			metrics.StreamClients.Dec()
+		})
+	}
+}
+
+// ServeHTTP streams events as Server-Sent Events named after the event type
+// with the event JSON as data. The types and channels query parameters take
+// comma separated lists to filter on.
+func (h *Hub) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	f, err := parseFilter(r)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, cancel := h.Subscribe(f)
+	defer cancel()
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(rw, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case e := <-events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(rw, "event: %s\nid: %s\ndata: %s\n\n", e.Type, e.DedupKey(), data); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func parseFilter(r *http.Request) (Filter, error) {
+	var f Filter
+	if types := r.URL.Query().Get("types"); types != "" {
+		f.Types = strings.Split(types, ",")
+	}
+	if channels := r.URL.Query().Get("channels"); channels != "" {
+		for _, s := range strings.Split(channels, ",") {
+			id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				return Filter{}, fmt.Errorf("bad channel id %q", s)
+			}
+			f.Channels = append(f.Channels, id)
+		}
+	}
+	return f, nil
+}
@@ -0,0 +1,80 @@
+// Package transcribe turns voice notes into text with a Whisper-compatible
+// transcription API.
+package transcribe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"go-tg.com/internal/config"
+)
+
+// Transcriber posts audio files to the API of the config.
+type Transcriber struct {
+	cfg    config.TranscriptionConfig
+	client *http.Client
+}
+
+func New(cfg config.TranscriptionConfig) *Transcriber {
+	return &Transcriber{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+// Accepts tells whether a note of duration seconds is transcribed.
+func (t *Transcriber) Accepts(duration int) bool {
+	return t.cfg.MaxDuration == 0 || float64(duration) <= t.cfg.MaxDuration.Seconds()
+}
+
+// Transcribe returns the text spoken in audio, a file named fileName.
+func (t *Transcriber) Transcribe(ctx context.Context, fileName string, audio []byte) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", fileName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(audio); err != nil {
+		return "", err
+	}
+	fields := map[string]string{"model": t.cfg.Model, "response_format": "json"}
+	if t.cfg.Language != "" {
+		fields["language"] = t.cfg.Language
+	}
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			return "", err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.cfg.URL, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if t.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.cfg.APIKey)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("unexpected status code: %d, body: %q", resp.StatusCode, msg)
+	}
+	var out struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	return out.Text, nil
+}
@@ -0,0 +1,128 @@
+// Package translate detects the language of message texts and translates
+// them with a translation API.
+package translate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/abadojack/whatlanggo"
+	"go-tg.com/internal/config"
+)
+
+const defaultDeepLURL = "https://api-free.deepl.com"
+
+// Detect returns the ISO 639-1 code of the language of text, false when the
+// guess is not reliable, e.g. for short texts.
+func Detect(text string) (string, bool) {
+	info := whatlanggo.Detect(text)
+	if !info.IsReliable() {
+		return "", false
+	}
+	code := info.Lang.Iso6391()
+	return code, code != ""
+}
+
+// Translator translates texts into the target language of the config.
+type Translator struct {
+	cfg    config.TranslateConfig
+	client *http.Client
+}
+
+func New(cfg config.TranslateConfig) (*Translator, error) {
+	switch cfg.Provider {
+	case "libretranslate":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("libretranslate needs a url")
+		}
+	case "deepl":
+		if cfg.URL == "" {
+			cfg.URL = defaultDeepLURL
+		}
+	default:
+		return nil, fmt.Errorf("unknown translation provider %q", cfg.Provider)
+	}
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	return &Translator{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}, nil
+}
+
+// Needed tells whether a text in language, empty when unknown, is translated.
+func (t *Translator) Needed(language string) bool {
+	return language != t.cfg.Target && !slices.Contains(t.cfg.Skip, language)
+}
+
+// Translate translates text from source, empty to let the API detect it.
+func (t *Translator) Translate(ctx context.Context, text, source string) (string, error) {
+	if t.cfg.Provider == "deepl" {
+		return t.deepl(ctx, text, source)
+	}
+	return t.libreTranslate(ctx, text, source)
+}
+
+func (t *Translator) libreTranslate(ctx context.Context, text, source string) (string, error) {
+	if source == "" {
+		source = "auto"
+	}
+	req := map[string]string{"q": text, "source": source, "target": t.cfg.Target, "format": "text"}
+	if t.cfg.APIKey != "" {
+		req["api_key"] = t.cfg.APIKey
+	}
+	var resp struct {
+		TranslatedText string `json:"translatedText"`
+	}
+	if err := t.post(ctx, t.cfg.URL+"/translate", nil, req, &resp); err != nil {
+		return "", err
+	}
+	return resp.TranslatedText, nil
+}
+
+func (t *Translator) deepl(ctx context.Context, text, source string) (string, error) {
+	req := map[string]any{"text": []string{text}, "target_lang": strings.ToUpper(t.cfg.Target)}
+	if source != "" {
+		req["source_lang"] = strings.ToUpper(source)
+	}
+	headers := map[string]string{"Authorization": "DeepL-Auth-Key " + t.cfg.APIKey}
+	var resp struct {
+		Translations []struct {
+			Text string `json:"text"`
+		} `json:"translations"`
+	}
+	if err := t.post(ctx, t.cfg.URL+"/v2/translate", headers, req, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Translations) == 0 {
+		return "", fmt.Errorf("no translation returned")
+	}
+	return resp.Translations[0].Text, nil
+}
+
+func (t *Translator) post(ctx context.Context, url string, headers map[string]string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code: %d, body: %q", resp.StatusCode, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,164 @@
+// Package unfurl fetches the title and OpenGraph metadata of web pages
+// linked in messages.
+package unfurl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go-tg.com/internal/config"
+	"golang.org/x/net/html"
+)
+
+const (
+	// maxPageSize bounds how much of a page is read looking for its head.
+	maxPageSize  = 1 << 20
+	maxRedirects = 5
+)
+
+// Page is the metadata of a web page.
+type Page struct {
+	Title       string
+	Description string
+	Image       string
+	SiteName    string
+}
+
+// Allowed tells whether u is an http(s) URL on one of domains or their
+// subdomains.
+func Allowed(domains []string, u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.ToLower(d), ".")
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch reads the head of the HTML page at rawURL. Redirects are followed
+// only to allowed domains.
+func Fetch(ctx context.Context, cfg config.LinksConfig, rawURL string) (Page, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Page{}, err
+	}
+	if !Allowed(cfg.Domains, u) {
+		return Page{}, fmt.Errorf("domain %q is not allowed", u.Hostname())
+	}
+	client := &http.Client{
+		Timeout: cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if !Allowed(cfg.Domains, req.URL) {
+				return fmt.Errorf("redirect to %q is not allowed", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return Page{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "tg-message-watcher")
+	resp, err := client.Do(req)
+	if err != nil {
+		return Page{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Page{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return Page{}, fmt.Errorf("not an html page: %q", mediaType)
+	}
+	page := parseHead(io.LimitReader(resp.Body, maxPageSize))
+	if page.Image != "" {
+		if image, err := resp.Request.URL.Parse(page.Image); err == nil {
+			page.Image = image.String()
+		}
+	}
+	return page, nil
+}
+
+// parseHead reads the title and the og: meta tags, falling back to the
+// title element and the description meta tag.
+func parseHead(r io.Reader) Page {
+	var page, fallback Page
+	z := html.NewTokenizer(r)
+	inTitle := false
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return merge(page, fallback)
+		case html.TextToken:
+			if inTitle && fallback.Title == "" {
+				fallback.Title = strings.TrimSpace(string(z.Text()))
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = false
+			case "head":
+				return merge(page, fallback)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = true
+			case "body":
+				return merge(page, fallback)
+			case "meta":
+				var key, content string
+				for hasAttr {
+					var k, v []byte
+					k, v, hasAttr = z.TagAttr()
+					switch string(k) {
+					case "property", "name":
+						if key == "" || strings.HasPrefix(string(v), "og:") {
+							key = strings.ToLower(string(v))
+						}
+					case "content":
+						content = strings.TrimSpace(string(v))
+					}
+				}
+				switch key {
+				case "og:title":
+					page.Title = content
+				case "og:description":
+					page.Description = content
+				case "og:image":
+					page.Image = content
+				case "og:site_name":
+					page.SiteName = content
+				case "description":
+					fallback.Description = content
+				}
+			}
+		}
+	}
+}
+
+func merge(page, fallback Page) Page {
+	if page.Title == "" {
+		page.Title = fallback.Title
+	}
+	if page.Description == "" {
+		page.Description = fallback.Description
+	}
+	return page
+}